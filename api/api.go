@@ -10,19 +10,125 @@ type AuthRequest struct {
 
 //AuthResponse is a server->client response about authentication
 type AuthResponse struct {
-	SessionID string
-	Completed bool //if username exists in database
+	SessionID     string
+	Completed     bool     //if user has signed the active document version
+	Version       string   //active document version
+	PendingID     string   //set instead of SessionID when an admin must enter a TOTP code
+	TOTPEnroll    bool     //if the admin must enroll in TOTP before entering a code
+	RecoveryCodes []string //TOTP recovery codes; only set when enrollment completes
+}
+
+//TOTPLoginRequest is a client->server request to complete a pending admin login with a TOTP or recovery code
+type TOTPLoginRequest struct {
+	PendingID string
+	Code      string
+}
+
+//TOTPPendingEnrollRequest is a client->server request to start TOTP enrollment during a pending admin login
+type TOTPPendingEnrollRequest struct {
+	PendingID string
+}
+
+//TOTPEnrollResponse is a server->client response with a new TOTP secret to confirm with a code
+type TOTPEnrollResponse struct {
+	Secret string //base32 encoded
+	URI    string //otpauth URI for authenticator apps
+}
+
+//TOTPStatusResponse is a server->client response about the session admin's TOTP enrollment
+type TOTPStatusResponse struct {
+	Enabled       bool //if TOTP is configured
+	Required      bool //if admins must enroll
+	Enrolled      bool
+	RecoveryCodes int //unused recovery codes
+}
+
+//TOTPCodeRequest is a client->server request with a TOTP code for the session admin
+type TOTPCodeRequest struct {
+	Code string
+}
+
+//TOTPRecoveryCodesResponse is a server->client response with new TOTP recovery codes
+type TOTPRecoveryCodesResponse struct {
+	RecoveryCodes []string
+}
+
+//TOTPDisableResponse is a server->client response about removing the session admin's TOTP enrollment
+type TOTPDisableResponse struct {
+	Status bool
+}
+
+//TOTPResetRequest is a client->server request to remove another admin's TOTP enrollment
+type TOTPResetRequest struct {
+	Username string
+}
+
+//TOTPResetResponse is a server->client response about removing another admin's TOTP enrollment
+type TOTPResetResponse struct {
+	Status bool
+}
+
+//OIDCResponse is a server->client response about OIDC logins
+type OIDCResponse struct {
+	Enabled bool
+}
+
+//OIDCSessionRequest is a client->server request for a session for a completed OIDC login
+type OIDCSessionRequest struct {
+	Token string
+}
+
+//SAMLResponse is a server->client response about SAML logins
+type SAMLResponse struct {
+	Enabled bool
+}
+
+//SAMLSessionRequest is a client->server request for a session for a completed SAML login
+type SAMLSessionRequest struct {
+	Token string
+}
+
+//MagicLinkConfigResponse is a server->client response about magic link logins
+type MagicLinkConfigResponse struct {
+	Enabled bool
+}
+
+//MagicLinkRequest is a client->server request to email a login link
+type MagicLinkRequest struct {
+	Identifier string //email address or employee ID
+}
+
+//MagicLinkResponse is a server->client response to a MagicLinkRequest.
+//Status is true whether or not a staff member was found.
+type MagicLinkResponse struct {
+	Status bool
+}
+
+//MagicLinkSessionRequest is a client->server request for a session for an emailed login link
+type MagicLinkSessionRequest struct {
+	Token string
 }
 
 //SubmitRequest is a client->server request for submitting form information
 type SubmitRequest struct {
-	Campus string
-	Agree  bool
+	Campus      string
+	Agree       bool
+	Passwd      string //user's password, if step-up re-authentication is enabled
+	ReauthToken string //token from an OIDC, SAML, or emailed link re-authentication, instead of Passwd
+}
+
+//StepUpResponse is a server->client response about re-authenticating when signing
+type StepUpResponse struct {
+	Enabled bool
+	OIDC    bool //if users can re-authenticate with OIDC instead of a password
+	SAML    bool //if users can re-authenticate with SAML instead of a password
+	Email   bool //if users can re-authenticate with an emailed link instead of a password
 }
 
 //SubmitResponse is a server->client response about confirming a submission
 type SubmitResponse struct {
-	Status bool
+	Status  bool
+	Receipt string //signed receipt; empty if receipts are not configured
 }
 
 //ErrorResponse is a server-client response indicating some kind of error
@@ -33,14 +139,132 @@ type ErrorResponse struct {
 
 //Record represents a staff signing record
 type Record struct {
+	EmployeeID   string
 	FirstName    string
 	LastName     string
 	EmployeeType string
-	Location     string
+	Location     string //chosen campus if signed, otherwise the campus mapped from Building
+	Building     string //building from the staff database
+	Mismatch     bool   //if the chosen campus differs from the campus mapped from Building
 	SignTime     *time.Time
+	DocumentHash string //hash of the signed document's file
 }
 
 //ListResponse is a server->client response with a list of signing records
 type ListResponse struct {
-	List []*Record
+	Version string
+	List    []*Record
+}
+
+//DocumentListResponse is a server->client response with a list of handbook documents
+type DocumentListResponse struct {
+	Documents []*Document
+}
+
+//DocumentResponse is a server->client response with a single handbook document
+type DocumentResponse struct {
+	Document *Document
+}
+
+//PublishRequest is a client->server request to make a handbook document active
+type PublishRequest struct {
+	Version string
+}
+
+//PublishResponse is a server->client response about confirming a publish
+type PublishResponse struct {
+	Status bool
+}
+
+//VerifyRequest is a client->server request to verify a signed receipt
+type VerifyRequest struct {
+	Receipt string
+}
+
+//VerifyResponse is a server->client response about a receipt's validity
+type VerifyResponse struct {
+	Valid   bool
+	Receipt *Receipt
+}
+
+//TimestampResponse is a server->client response about a signature's timestamp
+type TimestampResponse struct {
+	Valid     bool
+	Timestamp *Timestamp
+	Error     string //why the timestamp is not valid
+}
+
+//CampusListResponse is a server->client response with a list of campuses
+type CampusListResponse struct {
+	Campuses []*Campus
+}
+
+//MeResponse is a server->client response with information about the session's user
+type MeResponse struct {
+	EmployeeID string
+	FirstName  string
+	LastName   string
+	Building   string //building from the staff database
+	Campus     string //active campus mapped from Building; empty if unmapped
+}
+
+//Mismatch represents a signature whose chosen campus differs from the staff database
+type Mismatch struct {
+	EmployeeID  string
+	FirstName   string
+	LastName    string
+	Building    string //building from the staff database
+	StaffCampus string //campus mapped from Building
+	Campus      string //chosen campus
+	SignTime    time.Time
+}
+
+//MismatchResponse is a server->client response with a list of campus mismatches
+type MismatchResponse struct {
+	Version           string
+	Mismatches        []*Mismatch
+	UnmappedBuildings []string //staff database buildings with no campus mapped, which can't be checked
+}
+
+//CampusDeleteRequest is a client->server request to delete a campus
+type CampusDeleteRequest struct {
+	Name string
+}
+
+//LogoutResponse is a server->client response about ending a session
+type LogoutResponse struct {
+	Status bool
+}
+
+//CampusDeleteResponse is a server->client response about deleting a campus
+type CampusDeleteResponse struct {
+	Status bool
+}
+
+//CampusResponse is a server->client response with a single campus
+type CampusResponse struct {
+	Campus *Campus
+}
+
+//ReceiptKeyResponse is a server->client response with the public key receipts are signed with
+type ReceiptKeyResponse struct {
+	KeyID     string
+	PublicKey []byte
+}
+
+//ThrottleListResponse is a server->client response with the identities blocked by login throttling
+type ThrottleListResponse struct {
+	Enabled bool
+	Blocked []*ThrottledIdentity
+}
+
+//ThrottleClearRequest is a client->server request to unblock an identity blocked by login throttling
+type ThrottleClearRequest struct {
+	Type     string //ThrottleUsername or ThrottleIP
+	Identity string
+}
+
+//ThrottleClearResponse is a server->client response about unblocking an identity
+type ThrottleClearResponse struct {
+	Status bool
 }
@@ -0,0 +1,264 @@
+package api
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+//Audit actions
+const (
+	AuditSubmit          = "submit"
+	AuditDocumentCreate  = "document_create"
+	AuditDocumentPublish = "document_publish"
+	AuditView            = "view"
+	AuditCampusCreate    = "campus_create"
+	AuditCampusUpdate    = "campus_update"
+	AuditCampusDelete    = "campus_delete"
+	AuditTOTPEnroll      = "totp_enroll"
+	AuditTOTPRemove      = "totp_remove"
+	AuditTOTPUse         = "totp_use"
+	AuditTOTPRecoveryUse = "totp_recovery_use"
+	AuditTOTPRecoveryNew = "totp_recovery_new"
+)
+
+//canonicalFields returns the encoding of fields that hashes and signatures are computed over.
+//A JSON array of strings is unambiguous, since no field can run into the next, and stable.
+func canonicalFields(fields []string) []byte {
+	p, err := json.Marshal(fields)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+//AuditEvent represents an event in the append-only, hash-chained audit log.
+//Each event's Hash covers its contents and the previous event's Hash,
+//so editing or deleting any event breaks the chain for every event after it.
+type AuditEvent struct {
+	Seq      int64
+	Time     time.Time
+	Action   string
+	Data     string //JSON encoded record the action applied to
+	PrevHash string
+	Hash     string
+}
+
+//ComputeHash returns the hex-encoded SHA-256 hash of the event's contents and PrevHash
+func (a *AuditEvent) ComputeHash() string {
+	h := sha256.Sum256(canonicalFields([]string{
+		strconv.FormatInt(a.Seq, 10),
+		strconv.FormatInt(a.Time.Unix(), 10),
+		a.Action,
+		a.Data,
+		a.PrevHash,
+	}))
+	return hex.EncodeToString(h[:])
+}
+
+//AuditReport is the result of verifying the audit log
+type AuditReport struct {
+	Events    int64
+	Valid     bool
+	BrokenSeq int64 //Seq of the first event that failed verification; 0 if Valid or the break isn't tied to an event
+	Reason    string
+}
+
+//transact runs f in a transaction, committing if f returns nil and rolling back otherwise.
+//Transactions are serialized within this process; audit serializes appends between processes.
+func (db *SQLDB) transact(f func(tx *sql.Tx) error) (err error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	return f(tx)
+}
+
+//audit appends an event for the given action and record to the audit log in tx.
+//Writing the audit_lock row first locks it until tx ends, so replicas sharing the database
+//can't read the same head of the chain and append conflicting events.
+func (db *SQLDB) audit(tx *sql.Tx, action string, record interface{}) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec("UPDATE audit_lock SET n=n+1 WHERE id=1;")
+	if err != nil {
+		return fmt.Errorf("Error locking audit log: %v", err)
+	}
+	if n, err := result.RowsAffected(); err != nil || n != 1 {
+		return errors.New("Error locking audit log: audit_lock row missing")
+	}
+
+	head := "SELECT seq, hash FROM audit_log ORDER BY seq DESC LIMIT 1"
+	if db.driver == "mysql" {
+		//read the latest committed head even if tx read from an older snapshot before taking the lock
+		head += " FOR UPDATE"
+	}
+
+	var seq int64
+	var prev string
+	err = tx.QueryRow(head+";").Scan(&seq, &prev)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	a := &AuditEvent{
+		Seq:      seq + 1,
+		Time:     time.Now().UTC().Truncate(time.Second),
+		Action:   action,
+		Data:     string(data),
+		PrevHash: prev,
+	}
+	a.Hash = a.ComputeHash()
+
+	_, err = tx.Exec("INSERT INTO audit_log(seq, time, action, data, prev_hash, hash) VALUES(?, ?, ?, ?, ?, ?);",
+		a.Seq,
+		a.Time,
+		a.Action,
+		a.Data,
+		a.PrevHash,
+		a.Hash,
+	)
+	return err
+}
+
+//auditKey identifies a signature in the audit log
+type auditKey struct {
+	employeeID string
+	version    string
+}
+
+//VerifyAudit walks the audit log and reports the first event that was altered, removed, or reordered.
+//It also checks that every audited submission still matches the signers table,
+//and that no signatures were added to the signers table without being audited.
+//VerifyAudit returns an error if one occurred.
+func (db *SQLDB) VerifyAudit() (report *AuditReport, err error) {
+	rows, err := db.db.Query("SELECT seq, time, action, data, prev_hash, hash FROM audit_log ORDER BY seq;")
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		e := rows.Close()
+		if err == nil {
+			err = e
+		}
+	}()
+
+	report = &AuditReport{}
+	submits := make(map[auditKey]*Entry)
+	var start time.Time
+	var prev string
+
+	for rows.Next() {
+		a := &AuditEvent{}
+		err = rows.Scan(&(a.Seq), &(a.Time), &(a.Action), &(a.Data), &(a.PrevHash), &(a.Hash))
+		if err != nil {
+			return nil, err
+		}
+
+		report.Events++
+		if report.BrokenSeq != 0 || report.Reason != "" {
+			continue
+		}
+
+		switch {
+		case a.Seq != report.Events:
+			report.BrokenSeq, report.Reason = a.Seq, fmt.Sprintf("Expected event %d, found %d", report.Events, a.Seq)
+		case a.PrevHash != prev:
+			report.BrokenSeq, report.Reason = a.Seq, "Previous hash does not match previous event"
+		case a.Hash != a.ComputeHash():
+			report.BrokenSeq, report.Reason = a.Seq, "Hash does not match event contents"
+		}
+		prev = a.Hash
+
+		if report.Events == 1 {
+			//allow for the signing time being set before the event time
+			start = a.Time.Add(-time.Minute)
+		}
+
+		if a.Action == AuditSubmit {
+			e := &Entry{}
+			if err = json.Unmarshal([]byte(a.Data), e); err != nil {
+				report.BrokenSeq, report.Reason = a.Seq, fmt.Sprintf("Error decoding event data: %v", err)
+				continue
+			}
+			submits[auditKey{e.EmployeeID, e.Version}] = e
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if report.Events > 0 && report.BrokenSeq == 0 && report.Reason == "" {
+		report.Reason = db.verifySigners(submits, start)
+	}
+	report.Valid = report.BrokenSeq == 0 && report.Reason == ""
+
+	return report, nil
+}
+
+//verifySigners compares the signers table to the audited submissions,
+//returning a description of the first difference found or an empty string if none are found.
+//Signatures from before start, when the audit log began, are not checked.
+func (db *SQLDB) verifySigners(submits map[auditKey]*Entry, start time.Time) string {
+	rows, err := db.db.Query("SELECT employee_id, version, document_hash, username, campus, time FROM signers;")
+	if err != nil {
+		return fmt.Sprintf("Error reading signers: %v", err)
+	}
+	defer rows.Close()
+
+	seen := make(map[auditKey]struct{})
+	for rows.Next() {
+		e := &Entry{}
+		if err = rows.Scan(&(e.EmployeeID), &(e.Version), &(e.DocumentHash), &(e.Username), &(e.Campus), &(e.Time)); err != nil {
+			return fmt.Sprintf("Error reading signers: %v", err)
+		}
+
+		key := auditKey{e.EmployeeID, e.Version}
+		a, ok := submits[key]
+		if !ok {
+			if !e.Time.Before(start) {
+				return fmt.Sprintf("Signature for %s (%s) is not in the audit log", e.EmployeeID, e.Version)
+			}
+			continue
+		}
+		seen[key] = struct{}{}
+
+		//the database may round the signing time to the second
+		if d := e.Time.Sub(a.Time); d <= -time.Second || d >= time.Second ||
+			e.DocumentHash != a.DocumentHash || e.Username != a.Username || e.Campus != a.Campus {
+			return fmt.Sprintf("Signature for %s (%s) does not match the audit log", e.EmployeeID, e.Version)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Sprintf("Error reading signers: %v", err)
+	}
+
+	for key := range submits {
+		if _, ok := seen[key]; !ok {
+			return fmt.Sprintf("Signature for %s (%s) was removed", key.employeeID, key.version)
+		}
+	}
+
+	return ""
+}
@@ -1,6 +1,14 @@
 package api
 
-import "github.com/korylprince/go-ad-auth"
+import (
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/ldap.v2"
+)
 
 //User represents a user's name
 type User struct {
@@ -9,6 +17,38 @@ type User struct {
 	FirstName  string
 	LastName   string
 	Admin      bool
+	Role       Role     //set for admins
+	Campuses   []string //campuses a campus-scoped admin can see; nil for district-wide admins
+}
+
+//Role represents an admin's permission level
+type Role string
+
+//Admin roles, from least to most privileged
+const (
+	RoleViewer     Role = "viewer"     //can view signatures and reports
+	RoleManager    Role = "manager"    //can also upload documents and manage campuses
+	RoleSuperadmin Role = "superadmin" //can also publish documents and verify the audit log
+)
+
+var roleRanks = map[Role]int{RoleViewer: 1, RoleManager: 2, RoleSuperadmin: 3}
+
+//Allows returns whether or not r has at least the permissions of required
+func (r Role) Allows(required Role) bool {
+	return roleRanks[r] > 0 && roleRanks[r] >= roleRanks[required]
+}
+
+//CanSee returns whether or not u can see records for the given campus
+func (u *User) CanSee(campus string) bool {
+	if u.Campuses == nil {
+		return true
+	}
+	for _, c := range u.Campuses {
+		if strings.EqualFold(c, campus) {
+			return true
+		}
+	}
+	return false
 }
 
 //Auth is an interface for an arbitrary authentication backend.
@@ -24,61 +64,363 @@ type Auth interface {
 	AdminLogin(username, password string) (user *User, err error)
 }
 
-//LDAPAuth represents an Auth that uses an Active Directory backend
+//AdminGroups maps directory groups to admin Roles and campuses.
+//Groups are names or distinguished names, matched against the groups a user is in with matchGroup.
+type AdminGroups struct {
+	Admin    string              //members are superadmins
+	Roles    map[string]Role     //group names to the Roles of their members
+	Campuses map[string][]string //group names to the campuses their members can view as campus-scoped viewers
+}
+
+//User returns an admin User with the given username and the most privileged Role of the given groups.
+//If none of groups are admin groups but some are campus groups, the User is a campus-scoped viewer.
+//If none of groups are admin or campus groups, user will be nil.
+func (a *AdminGroups) User(username string, groups []string) (user *User) {
+	var role Role
+	var campuses []string
+	for _, g := range groups {
+		if g == "" {
+			continue
+		}
+		if a.Admin != "" && matchGroup(a.Admin, g) {
+			role = RoleSuperadmin
+		}
+		for group, r := range a.Roles {
+			if matchGroup(group, g) && !role.Allows(r) {
+				role = r
+			}
+		}
+		for group, cs := range a.Campuses {
+			if matchGroup(group, g) {
+				campuses = append(campuses, cs...)
+			}
+		}
+	}
+
+	if role != "" {
+		return &User{Username: username, Admin: true, Role: role}
+	}
+
+	if campuses == nil {
+		return nil
+	}
+
+	return &User{Username: username, Admin: true, Role: RoleViewer, Campuses: campuses}
+}
+
+//LDAPConfig represents the configuration of an LDAPAuth
+type LDAPConfig struct {
+	Group  string       //distinguished name; if non-empty, only members of Group can log in
+	Admins *AdminGroups //distinguished names; admin logins are restricted to Admins.Admin if non-empty, or the groups in Admins
+
+	EmployeeIDAttribute string //attribute mapped to User.EmployeeID; default: employeeID
+	FirstNameAttribute  string //attribute mapped to User.FirstName; default: givenName
+	LastNameAttribute   string //attribute mapped to User.LastName; default: sn
+
+	UPNSuffix string   //suffix usernames are bound with; default: the domain of BaseDN
+	Domains   []string //other domains users may type after their username, e.g. an email domain
+
+	Servers  []string //host or host:port, in order of preference
+	Port     int      //port for Servers without one
+	BaseDN   string
+	Security LDAPSecurity
+	RootCAs  *x509.CertPool //certificate authorities trusted for TLS; nil for the system roots
+
+	BindDN       string //service account users are looked up with before binding as them; optional
+	BindPassword string
+
+	PoolSize int           //maximum idle connections kept open; default: 4
+	Timeout  time.Duration //default: 10 seconds
+	Debug    bool
+}
+
+//LDAPAuth represents an Auth that uses an Active Directory backend.
+//Connections are pooled, and fail over to the next server in Servers if a server fails.
 type LDAPAuth struct {
-	group      string
-	adminGroup string
-	config     *auth.Config
+	config  *LDAPConfig
+	domains []string
+	pool    *ldapPool
 }
 
-//NewLDAPAuth returns a new LDAPAuth with the given config, restricting logins to group and admins to to adminGroup if non-empty.
-func NewLDAPAuth(group, adminGroup string, config *auth.Config) *LDAPAuth {
+//NewLDAPAuth returns a new LDAPAuth with the given config
+func NewLDAPAuth(config *LDAPConfig) *LDAPAuth {
+	if config.EmployeeIDAttribute == "" {
+		config.EmployeeIDAttribute = "employeeID"
+	}
+	if config.FirstNameAttribute == "" {
+		config.FirstNameAttribute = "givenName"
+	}
+	if config.LastNameAttribute == "" {
+		config.LastNameAttribute = "sn"
+	}
+	if config.Admins == nil {
+		config.Admins = &AdminGroups{}
+	}
+
+	if config.PoolSize == 0 {
+		config.PoolSize = 4
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	if config.UPNSuffix == "" {
+		var dcs []string
+		for _, rdn := range strings.Split(config.BaseDN, ",") {
+			if rdn = strings.TrimSpace(rdn); len(rdn) > 3 && strings.EqualFold(rdn[:3], "DC=") {
+				dcs = append(dcs, rdn[3:])
+			}
+		}
+		config.UPNSuffix = strings.ToLower(strings.Join(dcs, "."))
+	}
+
 	return &LDAPAuth{
-		group:      group,
-		adminGroup: adminGroup,
-		config:     config,
+		config:  config,
+		domains: append([]string{config.UPNSuffix}, config.Domains...),
+		pool: newLDAPPool(config.Servers, config.Port, config.Security, config.RootCAs,
+			config.BindDN, config.BindPassword, config.PoolSize, config.Timeout, config.Debug),
+	}
+}
+
+//normalizeUsername returns the username typed as jdoe, jdoe@domain, or DOMAIN\jdoe as the lowercase jdoe,
+//or as the lowercase UPN if the domain isn't UPNSuffix or one of Domains.
+//If the username is empty or can't be a valid account name, "" is returned.
+func (a *LDAPAuth) normalizeUsername(username string) string {
+	u := strings.TrimSpace(username)
+	if i := strings.LastIndex(u, `\`); i != -1 {
+		u = u[i+1:]
+	}
+	if i := strings.LastIndex(u, "@"); i != -1 {
+		for _, d := range a.domains {
+			if strings.EqualFold(u[i+1:], d) {
+				u = u[:i]
+				break
+			}
+		}
+	}
+	u = strings.ToLower(u)
+
+	//account names can't contain these, and they'd change the meaning of the directory search for the user
+	if u == "" || strings.HasPrefix(u, "@") || strings.ContainsAny(u, "*()\\\x00") {
+		return ""
+	}
+	return u
+}
+
+//bindName returns the UPN to bind with for the given normalized username
+func (a *LDAPAuth) bindName(username string) string {
+	if strings.Contains(username, "@") {
+		return username
 	}
+	return username + "@" + a.config.UPNSuffix
+}
+
+//attr returns the first value of the attribute with the given name, ignoring case
+func attr(attrs map[string][]string, name string) string {
+	for k, v := range attrs {
+		if strings.EqualFold(k, name) && len(v) > 0 {
+			return v[0]
+		}
+	}
+	return ""
+}
+
+//setNames sets user's EmployeeID and name from attrs
+func (a *LDAPAuth) setNames(user *User, attrs map[string][]string) {
+	user.EmployeeID = attr(attrs, a.config.EmployeeIDAttribute)
+	user.FirstName = attr(attrs, a.config.FirstNameAttribute)
+	user.LastName = attr(attrs, a.config.LastNameAttribute)
+}
+
+//nameAttrs returns the attributes setNames uses
+func (a *LDAPAuth) nameAttrs() []string {
+	return []string{a.config.EmployeeIDAttribute, a.config.FirstNameAttribute, a.config.LastNameAttribute}
+}
+
+//normalizeDN returns dn with its attribute types and values lower cased and RDN attributes sorted,
+//so equal DNs have equal normal forms, or false if dn isn't a distinguished name
+func normalizeDN(dn string) (string, bool) {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 {
+		return "", false
+	}
+	rdns := make([]string, 0, len(parsed.RDNs))
+	for _, rdn := range parsed.RDNs {
+		attrs := make([]string, 0, len(rdn.Attributes))
+		for _, a := range rdn.Attributes {
+			attrs = append(attrs, strings.ToLower(a.Type)+"="+strings.ToLower(a.Value))
+		}
+		sort.Strings(attrs)
+		rdns = append(rdns, strings.Join(attrs, "+"))
+	}
+	return strings.Join(rdns, ","), true
+}
+
+//IsDN returns whether or not s is a distinguished name
+func IsDN(s string) bool {
+	_, ok := normalizeDN(s)
+	return ok
+}
+
+//matchGroup returns whether or not group, as sent by a directory or identity provider, is the configured group.
+//If configured is a distinguished name, group must be the same distinguished name, compared case-insensitively,
+//so a group with the same name elsewhere in the directory doesn't match. Otherwise the names are compared case-insensitively.
+func matchGroup(configured, group string) bool {
+	if c, ok := normalizeDN(configured); ok {
+		g, ok := normalizeDN(group)
+		return ok && c == g
+	}
+	return strings.EqualFold(configured, group)
+}
+
+//login returns the attributes of the user with the given normalized username and password, including memberOf.
+//If the service account is configured, the user is looked up with it and then bound by DN.
+//Otherwise the user is bound by UPN and looks up their own entry.
+//If the credentials are invalid, attrs will be nil.
+func (a *LDAPAuth) login(username, password string) (attrs map[string][]string, err error) {
+	//an empty password is an unauthenticated bind, which succeeds
+	if password == "" {
+		return nil, nil
+	}
+
+	upn := a.bindName(username)
+	sam := username
+	if i := strings.Index(sam, "@"); i != -1 {
+		sam = sam[:i]
+	}
+	filter := fmt.Sprintf("(&(objectClass=user)(|(userPrincipalName=%s)(sAMAccountName=%s)))", escapeFilter(upn), escapeFilter(sam))
+
+	search := func(c *ldapConn) (*ldap.Entry, error) {
+		req := ldap.NewSearchRequest(a.config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0,
+			int(a.config.Timeout/time.Second), false, filter, append(a.nameAttrs(), "memberOf"), nil)
+		result, err := c.Search(req)
+		if err != nil {
+			return nil, err
+		}
+		//usernames must match exactly one user
+		if len(result.Entries) != 1 {
+			return nil, nil
+		}
+		return result.Entries[0], nil
+	}
+
+	var entry *ldap.Entry
+	err = a.pool.do(func(c *ldapConn) error {
+		entry = nil
+
+		if a.config.BindDN != "" {
+			if err := a.pool.bindService(c); err != nil {
+				return err
+			}
+			e, err := search(c)
+			if err != nil || e == nil {
+				return err
+			}
+			c.serviceBound = false
+			if err = c.Bind(e.DN, password); err != nil {
+				if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+					return nil
+				}
+				return err
+			}
+			entry = e
+			return nil
+		}
+
+		c.serviceBound = false
+		if err := c.Bind(upn, password); err != nil {
+			if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+				return nil
+			}
+			return err
+		}
+		e, err := search(c)
+		entry = e
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error logging in to LDAP: %v", err)
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	attrs = make(map[string][]string)
+	for _, attr := range entry.Attributes {
+		attrs[attr.Name] = attr.Values
+	}
+	return attrs, nil
+}
+
+//groups returns the distinguished names of the groups in attrs' memberOf attribute
+func groups(attrs map[string][]string) []string {
+	var groups []string
+	for k, dns := range attrs {
+		if strings.EqualFold(k, "memberOf") {
+			groups = append(groups, dns...)
+		}
+	}
+	return groups
 }
 
 //Login returns whether or not the given username or password is valid.
 //If valid, user will be non-nil
 //If the backend malfunctions, user will be nil and error will be non-nil.
 func (a *LDAPAuth) Login(username, password string) (user *User, err error) {
-	ok, attrs, err := auth.LoginWithAttrs(username, password, a.group, a.config, []string{"employeeID", "givenName", "sn"})
-	if !ok {
-		return nil, err
+	username = a.normalizeUsername(username)
+	if username == "" {
+		return nil, nil
 	}
-	u := &User{Username: username}
 
-	if ids, ok := attrs["employeeID"]; ok {
-		if len(ids) > 0 {
-			u.EmployeeID = ids[0]
-		}
+	attrs, err := a.login(username, password)
+	if attrs == nil {
+		return nil, err
 	}
 
-	if gns, ok := attrs["givenName"]; ok {
-		if len(gns) > 0 {
-			u.FirstName = gns[0]
+	if a.config.Group != "" {
+		member := false
+		for _, g := range groups(attrs) {
+			if matchGroup(a.config.Group, g) {
+				member = true
+			}
 		}
-	}
-
-	if sns, ok := attrs["sn"]; ok {
-		if len(sns) > 0 {
-			u.LastName = sns[0]
+		if !member {
+			return nil, nil
 		}
 	}
 
-	return u, err
+	u := &User{Username: username}
+	a.setNames(u, attrs)
+
+	return u, nil
 }
 
 //AdminLogin returns whether or not the given username or password is valid admin login.
 //If valid, user will be non-nil
 //If the backend malfunctions, user will be nil and error will be non-nil.
+//user.Role and user.Campuses are set from the user's groups with AdminGroups.User.
 func (a *LDAPAuth) AdminLogin(username, password string) (user *User, err error) {
-	ok, err := auth.Login(username, password, a.adminGroup, a.config)
-	if !ok || err != nil {
+	username = a.normalizeUsername(username)
+	if username == "" {
+		return nil, nil
+	}
+
+	attrs, err := a.login(username, password)
+	if attrs == nil {
 		return nil, err
 	}
 
-	return &User{Username: username, Admin: true}, nil
+	admins := a.config.Admins
+	var u *User
+	if admins.Admin == "" && len(admins.Roles) == 0 && len(admins.Campuses) == 0 {
+		//without admin groups, any user can log in as an admin
+		u = &User{Username: username, Admin: true, Role: RoleSuperadmin}
+	} else {
+		u = admins.User(username, groups(attrs))
+	}
+	if u != nil {
+		a.setNames(u, attrs)
+	}
+
+	return u, nil
 }
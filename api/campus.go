@@ -0,0 +1,263 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//ErrCampusNotFound is returned when a Campus with the given name does not exist
+var ErrCampusNotFound = errors.New("Campus not found")
+
+//ErrCampusExists is returned when creating a Campus with the name of an existing Campus
+var ErrCampusExists = errors.New("Campus already exists")
+
+//ErrBuildingMapped is returned when a building is mapped to more than one Campus
+var ErrBuildingMapped = errors.New("Building is already mapped to another campus")
+
+//ErrCampusInUse is returned when deleting a Campus that signatures reference
+var ErrCampusInUse = errors.New("Campus has signatures; deactivate it instead")
+
+//Campus represents a campus or department staff can sign for.
+//Names are stored with signatures, so campuses can't be renamed;
+//inactive campuses can't be signed for but remain in historical records.
+type Campus struct {
+	Name      string
+	Position  int //display order
+	Active    bool
+	Buildings []string //staff database building descriptions that map to this Campus
+}
+
+//CampusForBuilding returns the Campus in campuses the given staff database building maps to,
+//or nil if the building isn't mapped
+func CampusForBuilding(campuses []*Campus, building string) *Campus {
+	for _, c := range campuses {
+		for _, b := range c.Buildings {
+			if strings.EqualFold(b, building) {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+//Campuses returns all Campuses in the database in display order
+func (db *SQLDB) Campuses() (list []*Campus, err error) {
+	rows, err := db.db.Query("SELECT name, position, active FROM campuses ORDER BY position, name;")
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		e := rows.Close()
+		if err == nil {
+			err = e
+		}
+	}()
+
+	var campuses []*Campus
+	for rows.Next() {
+		c := &Campus{}
+		err = rows.Scan(&(c.Name), &(c.Position), &(c.Active))
+		if err != nil {
+			return nil, err
+		}
+		campuses = append(campuses, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err = db.campusBuildings(campuses); err != nil {
+		return nil, err
+	}
+
+	return campuses, nil
+}
+
+//campusBuildings reads the Buildings of the given campuses
+func (db *SQLDB) campusBuildings(campuses []*Campus) (err error) {
+	rows, err := db.db.Query("SELECT building, campus FROM campus_buildings ORDER BY building;")
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		e := rows.Close()
+		if err == nil {
+			err = e
+		}
+	}()
+
+	names := make(map[string]*Campus)
+	for _, c := range campuses {
+		names[c.Name] = c
+	}
+
+	for rows.Next() {
+		var building, campus string
+		if err = rows.Scan(&building, &campus); err != nil {
+			return err
+		}
+		if c, ok := names[campus]; ok {
+			c.Buildings = append(c.Buildings, building)
+		}
+	}
+	return rows.Err()
+}
+
+//setBuildings replaces the Buildings mapped to c in tx.
+//If a building is mapped to another Campus, ErrBuildingMapped is returned.
+func setBuildings(tx *sql.Tx, c *Campus) (err error) {
+	rows, err := tx.Query("SELECT building FROM campus_buildings WHERE campus<>?;", c.Name)
+	if err != nil {
+		return err
+	}
+
+	var mapped []string
+	for rows.Next() {
+		var building string
+		if err = rows.Scan(&building); err != nil {
+			rows.Close()
+			return err
+		}
+		mapped = append(mapped, building)
+	}
+	if err = rows.Close(); err != nil {
+		return err
+	}
+
+	for _, b := range c.Buildings {
+		for _, m := range mapped {
+			if strings.EqualFold(b, m) {
+				return ErrBuildingMapped
+			}
+		}
+	}
+
+	if _, err = tx.Exec("DELETE FROM campus_buildings WHERE campus=?;", c.Name); err != nil {
+		return err
+	}
+
+	for _, b := range c.Buildings {
+		if _, err = tx.Exec("INSERT INTO campus_buildings(building, campus) VALUES(?, ?);", b, c.Name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//CreateCampus commits a new Campus to the database.
+//If a Campus with the same name exists, ErrCampusExists is returned.
+//If a building is mapped to another Campus, ErrBuildingMapped is returned.
+func (db *SQLDB) CreateCampus(c *Campus) error {
+	return db.transact(func(tx *sql.Tx) error {
+		s := new(string)
+		err := tx.QueryRow("SELECT name FROM campuses WHERE name=?;", c.Name).Scan(s)
+		if err == nil {
+			return ErrCampusExists
+		}
+		if err != sql.ErrNoRows {
+			return err
+		}
+
+		if err = db.audit(tx, AuditCampusCreate, c); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("INSERT INTO campuses(name, position, active) VALUES(?, ?, ?);", c.Name, c.Position, c.Active)
+		if err != nil {
+			return err
+		}
+
+		return setBuildings(tx, c)
+	})
+}
+
+//UpdateCampus updates the position, active status, and buildings of the Campus with the given name.
+//If the Campus doesn't exist, ErrCampusNotFound is returned.
+//If a building is mapped to another Campus, ErrBuildingMapped is returned.
+func (db *SQLDB) UpdateCampus(c *Campus) error {
+	return db.transact(func(tx *sql.Tx) error {
+		s := new(string)
+		err := tx.QueryRow("SELECT name FROM campuses WHERE name=?;", c.Name).Scan(s)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrCampusNotFound
+			}
+			return err
+		}
+
+		if err = db.audit(tx, AuditCampusUpdate, c); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("UPDATE campuses SET position=?, active=? WHERE name=?;", c.Position, c.Active, c.Name)
+		if err != nil {
+			return err
+		}
+
+		return setBuildings(tx, c)
+	})
+}
+
+//DeleteCampus removes the Campus with the given name from the database.
+//If the Campus doesn't exist, ErrCampusNotFound is returned.
+//If any signatures reference the Campus, ErrCampusInUse is returned.
+func (db *SQLDB) DeleteCampus(name string) error {
+	return db.transact(func(tx *sql.Tx) error {
+		s := new(string)
+		err := tx.QueryRow("SELECT name FROM campuses WHERE name=?;", name).Scan(s)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrCampusNotFound
+			}
+			return err
+		}
+
+		err = tx.QueryRow("SELECT campus FROM signers WHERE campus=? LIMIT 1;", name).Scan(s)
+		if err == nil {
+			return ErrCampusInUse
+		}
+		if err != sql.ErrNoRows {
+			return err
+		}
+
+		if err = db.audit(tx, AuditCampusDelete, &Campus{Name: name}); err != nil {
+			return err
+		}
+
+		if _, err = tx.Exec("DELETE FROM campus_buildings WHERE campus=?;", name); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("DELETE FROM campuses WHERE name=?;", name)
+		return err
+	})
+}
+
+//Validate makes sure the information in c is valid
+func (c *Campus) Validate() error {
+	if c.Name == "" {
+		return errors.New("Name empty")
+	}
+	if len(c.Name) > 255 {
+		return errors.New("Name > 255")
+	}
+	for i, b := range c.Buildings {
+		if b == "" {
+			return errors.New("Building empty")
+		}
+		if len(b) > 255 {
+			return errors.New("Building > 255")
+		}
+		for _, b2 := range c.Buildings[:i] {
+			if strings.EqualFold(b, b2) {
+				return fmt.Errorf("Duplicate Building: %s", b)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+//certificateTimeFormat is the format times are shown in on certificates
+const certificateTimeFormat = "January 2, 2006 3:04:05 PM MST"
+
+//certificateLines returns the lines of a signature certificate page for the given Entry.
+//If receipt is not empty, the signed receipt is included.
+func certificateLines(e *Entry, receipt string) []pdfLine {
+	lines := []pdfLine{
+		{Text: "Handbook Signature Certificate", Size: 18, Bold: true},
+		{Size: 8},
+	}
+
+	viewed := ""
+	if !e.ViewTime.IsZero() {
+		viewed = e.ViewTime.Format(certificateTimeFormat)
+	}
+
+	reauth := "No"
+	switch e.Reauth {
+	case ReauthPassword:
+		reauth = "Yes, with password"
+	case ReauthOIDC, ReauthSAML:
+		reauth = "Yes, with single sign-on"
+	case ReauthEmail:
+		reauth = "Yes, with an emailed link"
+	}
+
+	for _, l := range [][2]string{
+		{"Name", strings.TrimSpace(e.FirstName + " " + e.LastName)},
+		{"Employee ID", e.EmployeeID},
+		{"Username", e.Username},
+		{"Campus", e.Campus},
+		{"Signed", e.Time.Format(certificateTimeFormat)},
+		{"Handbook Viewed", viewed},
+		{"Identity Re-confirmed", reauth},
+		{"Handbook Version", e.Version},
+		{"Handbook SHA-256", e.DocumentHash},
+		{"IP Address", e.IP},
+		{"User Agent", e.Headers.Get("User-Agent")},
+	} {
+		lines = append(lines, pdfLabel(l[0], l[1])...)
+	}
+
+	if e.Timestamp != nil {
+		ts := "(invalid)"
+		if t, err := ParseTimestamp(e.Timestamp); err == nil {
+			ts = fmt.Sprintf("%s (serial %s)", t.Time.Format(certificateTimeFormat), t.SerialNumber)
+		}
+		lines = append(lines, pdfLabel("Trusted Timestamp", ts)...)
+	}
+
+	if receipt != "" {
+		lines = append(lines, pdfLabel("Signed Receipt", receipt)...)
+	}
+
+	return append(lines,
+		pdfLine{Size: 8},
+		pdfLine{Text: fmt.Sprintf("Generated %s", time.Now().Format(certificateTimeFormat)), Size: 8},
+	)
+}
+
+//WriteReceiptPDF writes a PDF confirming the given Entry to w.
+//If receipt is not empty, the signed receipt is included.
+func WriteReceiptPDF(w io.Writer, e *Entry, receipt string) error {
+	return writePDF(w, fmt.Sprintf("Handbook Signature Certificate - %s %s", e.FirstName, e.LastName), [][]pdfLine{certificateLines(e, receipt)})
+}
+
+//StampCertificate returns the given handbook PDF with a signature certificate page for the given Entry appended.
+//If receipt is not empty, the signed receipt is included.
+func StampCertificate(handbook []byte, e *Entry, receipt string) ([]byte, error) {
+	return AppendPDFPage(handbook, certificateLines(e, receipt))
+}
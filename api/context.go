@@ -1,6 +1,8 @@
 package api
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 )
 
@@ -10,17 +12,50 @@ type Context struct {
 	DB           DB
 	StaffDB      StaffDB
 	SessionStore SessionStore
+	Receipts     *ReceiptSigner   //optional
+	Timestamps   *TimestampClient //optional
+	OIDC         *OIDCAuth        //optional
+	SAML         *SAMLAuth        //optional
+	MagicLinks   *MagicLinkAuth   //optional
+	Throttle     *LoginThrottle   //optional
+	TOTP         *TOTPAuth        //optional
+	StepUp       bool             //if true, SubmitRequests must re-confirm the user's identity
 }
 
 type contextHandler struct {
 	HandleFunc func(*Context, http.ResponseWriter, *http.Request)
 	Context    *Context
+	Role       Role //if set, requests must have an admin session with at least Role
 }
 
+//sessionKey is the request context key for the Session authorized by contextHandler
+type sessionKey struct{}
+
 func (c contextHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if c.Role != "" {
+		w.Header().Set("Content-Type", "application/json")
+
+		sess := checkSession(true, c.Context, w, r)
+		if sess == nil {
+			return
+		}
+		if !sess.User.Role.Allows(c.Role) {
+			handleForbidden(w, fmt.Sprintf("%s role required", c.Role))
+			return
+		}
+
+		r = r.WithContext(context.WithValue(r.Context(), sessionKey{}, sess))
+	}
+
 	c.HandleFunc(c.Context, w, r)
 }
 
+//requestSession returns the Session authorized by contextHandler for r
+func requestSession(r *http.Request) *Session {
+	sess, _ := r.Context().Value(sessionKey{}).(*Session)
+	return sess
+}
+
 //AuthHandler returns an Authentcation http.Handler with the given context
 func AuthHandler(c *Context) http.Handler {
 	return contextHandler{HandleFunc: authNormalHandler, Context: c}
@@ -31,12 +66,242 @@ func AuthAdminHandler(c *Context) http.Handler {
 	return contextHandler{HandleFunc: authAdminHandler, Context: c}
 }
 
+//LogoutHandler returns an http.Handler with the given context that ends the request's session
+func LogoutHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: logoutHandler, Context: c}
+}
+
 //SubmitHandler returns a Submission http.Handler with the given context
 func SubmitHandler(c *Context) http.Handler {
 	return contextHandler{HandleFunc: submitHandler, Context: c}
 }
 
+//StepUpConfigHandler returns an http.Handler with the given context that returns whether or not users must re-authenticate when signing
+func StepUpConfigHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: stepUpConfigHandler, Context: c}
+}
+
+//StepUpEmailHandler returns an http.Handler with the given context that emails the signed in user a re-authentication link
+func StepUpEmailHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: stepUpEmailHandler, Context: c}
+}
+
 //ListHandler returns a dump of the given context's DB
 func ListHandler(c *Context) http.Handler {
-	return contextHandler{HandleFunc: listHandler, Context: c}
+	return contextHandler{HandleFunc: listHandler, Context: c, Role: RoleViewer}
+}
+
+//HandbookHandler returns the active handbook document from the given context's DB
+func HandbookHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: handbookHandler, Context: c}
+}
+
+//ViewHandler returns an http.Handler with the given context that records a view of the active handbook document and returns it
+func ViewHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: viewHandler, Context: c}
+}
+
+//DocumentListHandler returns a list of the given context's handbook documents
+func DocumentListHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: documentListHandler, Context: c, Role: RoleViewer}
+}
+
+//DocumentUploadHandler returns a handbook document upload http.Handler with the given context
+func DocumentUploadHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: documentUploadHandler, Context: c, Role: RoleManager}
+}
+
+//DocumentFileHandler returns a handbook document preview http.Handler with the given context
+func DocumentFileHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: documentFileHandler, Context: c, Role: RoleViewer}
+}
+
+//DocumentPublishHandler returns a handbook document publishing http.Handler with the given context
+func DocumentPublishHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: documentPublishHandler, Context: c, Role: RoleSuperadmin}
+}
+
+//VerifyHandler returns a receipt verification http.Handler with the given context
+func VerifyHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: verifyHandler, Context: c}
+}
+
+//ReceiptKeyHandler returns the public key of the given context's receipt signer
+func ReceiptKeyHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: receiptKeyHandler, Context: c}
+}
+
+//ReceiptPDFHandler returns a PDF receipt http.Handler for the session user's signature with the given context
+func ReceiptPDFHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: receiptPDFHandler, Context: c}
+}
+
+//AdminReceiptPDFHandler returns a PDF receipt http.Handler for any signature with the given context
+func AdminReceiptPDFHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: adminReceiptPDFHandler, Context: c, Role: RoleViewer}
+}
+
+//CertificateHandler returns an http.Handler with the given context that returns a signed handbook stamped with a signature certificate
+func CertificateHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: certificateHandler, Context: c, Role: RoleViewer}
+}
+
+//CertificateArchiveHandler returns an http.Handler with the given context that returns a zip file of stamped handbooks for a campus
+func CertificateArchiveHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: certificateArchiveHandler, Context: c, Role: RoleViewer}
+}
+
+//CampusesHandler returns an http.Handler with the given context that returns the active campuses
+func CampusesHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: campusesHandler, Context: c}
+}
+
+//CampusListHandler returns a list of all of the given context's campuses
+func CampusListHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: campusListHandler, Context: c, Role: RoleViewer}
+}
+
+//CampusCreateHandler returns a campus creation http.Handler with the given context
+func CampusCreateHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: campusCreateHandler, Context: c, Role: RoleManager}
+}
+
+//CampusUpdateHandler returns a campus update http.Handler with the given context
+func CampusUpdateHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: campusUpdateHandler, Context: c, Role: RoleManager}
+}
+
+//CampusDeleteHandler returns a campus deletion http.Handler with the given context
+func CampusDeleteHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: campusDeleteHandler, Context: c, Role: RoleManager}
+}
+
+//MeHandler returns an http.Handler with the given context that returns information about the session's user
+func MeHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: meHandler, Context: c}
+}
+
+//MismatchHandler returns a report of signers whose chosen campus differs from the given context's StaffDB
+func MismatchHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: mismatchHandler, Context: c, Role: RoleViewer}
+}
+
+//TimestampHandler returns a timestamp verification http.Handler with the given context
+func TimestampHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: timestampHandler, Context: c, Role: RoleViewer}
+}
+
+//AuditHandler returns a verification report of the given context's audit log
+func AuditHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: auditHandler, Context: c, Role: RoleSuperadmin}
+}
+
+//OIDCConfigHandler returns an http.Handler with the given context that returns whether or not OIDC logins are enabled
+func OIDCConfigHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: oidcConfigHandler, Context: c}
+}
+
+//OIDCLoginHandler returns an http.Handler with the given context that redirects to the OIDC identity provider
+func OIDCLoginHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: oidcLoginHandler, Context: c}
+}
+
+//OIDCCallbackHandler returns an http.Handler with the given context that completes OIDC logins
+func OIDCCallbackHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: oidcCallbackHandler, Context: c}
+}
+
+//OIDCSessionHandler returns an http.Handler with the given context that exchanges completed OIDC logins for sessions
+func OIDCSessionHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: oidcSessionHandler, Context: c}
+}
+
+//SAMLConfigHandler returns an http.Handler with the given context that returns whether or not SAML logins are enabled
+func SAMLConfigHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: samlConfigHandler, Context: c}
+}
+
+//SAMLMetadataHandler returns an http.Handler with the given context that returns the SAML service provider metadata
+func SAMLMetadataHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: samlMetadataHandler, Context: c}
+}
+
+//SAMLLoginHandler returns an http.Handler with the given context that redirects to the SAML identity provider
+func SAMLLoginHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: samlLoginHandler, Context: c}
+}
+
+//SAMLACSHandler returns an http.Handler with the given context that completes SAML logins
+func SAMLACSHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: samlACSHandler, Context: c}
+}
+
+//SAMLSessionHandler returns an http.Handler with the given context that exchanges completed SAML logins for sessions
+func SAMLSessionHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: samlSessionHandler, Context: c}
+}
+
+//MagicLinkConfigHandler returns an http.Handler with the given context that returns whether or not magic link logins are enabled
+func MagicLinkConfigHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: magicLinkConfigHandler, Context: c}
+}
+
+//MagicLinkHandler returns an http.Handler with the given context that emails login links
+func MagicLinkHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: magicLinkHandler, Context: c}
+}
+
+//MagicLinkSessionHandler returns an http.Handler with the given context that exchanges login links for sessions
+func MagicLinkSessionHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: magicLinkSessionHandler, Context: c}
+}
+
+//ThrottleListHandler returns an http.Handler with the given context that returns the identities blocked by login throttling
+func ThrottleListHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: throttleListHandler, Context: c, Role: RoleManager}
+}
+
+//ThrottleClearHandler returns an http.Handler with the given context that unblocks an identity blocked by login throttling
+func ThrottleClearHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: throttleClearHandler, Context: c, Role: RoleSuperadmin}
+}
+
+//TOTPLoginHandler returns an http.Handler with the given context that completes pending admin logins with TOTP codes
+func TOTPLoginHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: totpLoginHandler, Context: c}
+}
+
+//TOTPPendingEnrollHandler returns an http.Handler with the given context that starts TOTP enrollment during pending admin logins
+func TOTPPendingEnrollHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: totpPendingEnrollHandler, Context: c}
+}
+
+//TOTPStatusHandler returns an http.Handler with the given context that returns the session admin's TOTP enrollment status
+func TOTPStatusHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: totpStatusHandler, Context: c, Role: RoleViewer}
+}
+
+//TOTPEnrollHandler returns an http.Handler with the given context that starts TOTP enrollment for the session admin
+func TOTPEnrollHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: totpEnrollHandler, Context: c, Role: RoleViewer}
+}
+
+//TOTPConfirmHandler returns an http.Handler with the given context that completes TOTP enrollment for the session admin
+func TOTPConfirmHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: totpConfirmHandler, Context: c, Role: RoleViewer}
+}
+
+//TOTPRecoveryHandler returns an http.Handler with the given context that replaces the session admin's TOTP recovery codes
+func TOTPRecoveryHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: totpRecoveryHandler, Context: c, Role: RoleViewer}
+}
+
+//TOTPDisableHandler returns an http.Handler with the given context that removes the session admin's TOTP enrollment
+func TOTPDisableHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: totpDisableHandler, Context: c, Role: RoleViewer}
+}
+
+//TOTPResetHandler returns an http.Handler with the given context that removes another admin's TOTP enrollment
+func TOTPResetHandler(c *Context) http.Handler {
+	return contextHandler{HandleFunc: totpResetHandler, Context: c, Role: RoleSuperadmin}
 }
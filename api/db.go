@@ -1,11 +1,14 @@
 package api
 
 import (
+	"crypto/sha256"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 )
 
@@ -14,19 +17,99 @@ type DB interface {
 	//Submit commits an Entry to the database, and returns an error if one occurred
 	Submit(e *Entry) error
 
-	//Check returns whether or not the given employeeID is already in the database.
+	//Check returns whether or not the given employeeID has already signed the given document version.
 	//Check returns an error if one occurred.
-	Check(employeeID string) (bool, error)
+	Check(employeeID, version string) (bool, error)
 
-	//List returns all entries in the database
-	List() ([]*Entry, error)
+	//Get returns the Entry for the given employeeID and document version.
+	//If the Entry doesn't exist, entry will be nil.
+	//Get returns an error if one occurred.
+	Get(employeeID, version string) (entry *Entry, err error)
+
+	//List returns all entries in the database for the given document version
+	List(version string) ([]*Entry, error)
+
+	//ActiveDocument returns the Document staff are currently signing.
+	//If no Document is active, doc will be nil.
+	//ActiveDocument returns an error if one occurred.
+	ActiveDocument() (doc *Document, err error)
+
+	//Documents returns all Documents in the database, newest first
+	Documents() ([]*Document, error)
+
+	//CreateDocument commits a new, inactive Document with the given file content to the database
+	CreateDocument(d *Document, content []byte) error
+
+	//DocumentContent returns the file content of the Document with the given version.
+	//If the Document doesn't exist, ErrDocumentNotFound is returned.
+	DocumentContent(version string) ([]byte, error)
+
+	//PublishDocument makes the Document with the given version the only active Document.
+	//If the Document doesn't exist, ErrDocumentNotFound is returned.
+	PublishDocument(version string) error
+
+	//Campuses returns all Campuses in the database in display order
+	Campuses() ([]*Campus, error)
+
+	//CreateCampus commits a new Campus to the database.
+	//If a Campus with the same name exists, ErrCampusExists is returned.
+	//If a building is mapped to another Campus, ErrBuildingMapped is returned.
+	CreateCampus(c *Campus) error
+
+	//UpdateCampus updates the position, active status, and buildings of the Campus with the given name.
+	//If the Campus doesn't exist, ErrCampusNotFound is returned.
+	//If a building is mapped to another Campus, ErrBuildingMapped is returned.
+	UpdateCampus(c *Campus) error
+
+	//DeleteCampus removes the Campus with the given name from the database.
+	//If the Campus doesn't exist, ErrCampusNotFound is returned.
+	//If any signatures reference the Campus, ErrCampusInUse is returned.
+	DeleteCampus(name string) error
+
+	//RecordView commits a View to the database, and returns an error if one occurred
+	RecordView(v *View) error
+
+	//FirstView returns the first time the given employeeID viewed the given document version.
+	//If the document hasn't been viewed, viewTime will be nil.
+	//FirstView returns an error if one occurred.
+	FirstView(employeeID, version string) (viewTime *time.Time, err error)
+
+	//VerifyAudit walks the audit log and reports the first event that was altered, removed, or reordered.
+	//VerifyAudit returns an error if one occurred.
+	VerifyAudit() (*AuditReport, error)
+
+	//TOTP returns the TOTP enrollment for the given admin username.
+	//If the admin isn't enrolled, enrollment will be nil.
+	//TOTP returns an error if one occurred.
+	TOTP(username string) (enrollment *TOTPEnrollment, err error)
+
+	//SetTOTP commits a TOTP enrollment, replacing any existing enrollment for its Username
+	SetTOTP(t *TOTPEnrollment) error
+
+	//DeleteTOTP removes the TOTP enrollment for the given admin username.
+	//If the admin isn't enrolled, ErrTOTPNotFound is returned.
+	DeleteTOTP(username string) error
+
+	//UseTOTPCounter records that the TOTP code for the given time step was used.
+	//If the admin isn't enrolled or a code for the same or a later time step was already used, used will be false.
+	UseTOTPCounter(username string, counter int64) (used bool, err error)
+
+	//UseRecoveryCode removes the given recovery code hash from the admin's enrollment.
+	//If the admin isn't enrolled or the hash isn't one of their recovery codes, used will be false.
+	UseRecoveryCode(username, hash string) (used bool, err error)
+
+	//SetRecoveryCodes replaces the recovery code hashes of the given admin username.
+	//If the admin isn't enrolled, ErrTOTPNotFound is returned.
+	SetRecoveryCodes(username string, hashes []string) error
 }
 
-//SQLDB is a DB backed by a SQL database
+//SQLDB is a DB backed by a SQL database.
+//All changes are recorded in a hash-chained audit log.
 type SQLDB struct {
 	db     *sql.DB
 	driver string
 	dsn    string
+	mu     *sync.Mutex
 }
 
 //Submit commits an Entry to the database, and returns an error if one occurred
@@ -35,22 +118,33 @@ func (db *SQLDB) Submit(e *Entry) error {
 	if err != nil {
 		return err
 	}
-	_, err = db.db.Exec("INSERT INTO signers(employee_id, username, firstname, lastname, campus, headers, time) VALUES(?, ?, ?, ?, ?, ?, ?);",
-		e.EmployeeID,
-		e.Username,
-		e.FirstName,
-		e.LastName,
-		e.Campus,
-		j,
-		e.Time,
-	)
-	return err
+	return db.transact(func(tx *sql.Tx) error {
+		if err := db.audit(tx, AuditSubmit, e); err != nil {
+			return err
+		}
+		_, err := tx.Exec("INSERT INTO signers(employee_id, version, document_hash, username, firstname, lastname, campus, headers, ip, view_time, reauth, time, timestamp_token) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);",
+			e.EmployeeID,
+			e.Version,
+			e.DocumentHash,
+			e.Username,
+			e.FirstName,
+			e.LastName,
+			e.Campus,
+			j,
+			e.IP,
+			e.ViewTime,
+			e.Reauth,
+			e.Time,
+			e.Timestamp,
+		)
+		return err
+	})
 }
 
-//Check returns whether or not the given employeeID is already in the database
+//Check returns whether or not the given employeeID has already signed the given document version.
 //Check returns an error if one occurred.
-func (db *SQLDB) Check(employeeID string) (bool, error) {
-	row := db.db.QueryRow("SELECT employee_id FROM signers WHERE employee_id=?;", employeeID)
+func (db *SQLDB) Check(employeeID, version string) (bool, error) {
+	row := db.db.QueryRow("SELECT employee_id FROM signers WHERE employee_id=? AND version=?;", employeeID, version)
 
 	s := new(string)
 	err := row.Scan(s)
@@ -64,9 +158,60 @@ func (db *SQLDB) Check(employeeID string) (bool, error) {
 	return true, nil
 }
 
-//List returns all entries in the database
-func (db *SQLDB) List() (list []*Entry, err error) {
-	rows, err := db.db.Query("SELECT employee_id, username, firstname, lastname, campus, headers, time FROM signers;")
+//entryColumns are the signers columns read by scanEntry
+const entryColumns = "employee_id, version, document_hash, username, firstname, lastname, campus, headers, ip, view_time, reauth, time, timestamp_token"
+
+//scanner is a *sql.Row or *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+//scanEntry returns an Entry from a row of entryColumns
+func scanEntry(row scanner) (*Entry, error) {
+	e := &Entry{}
+	var j []byte
+	var viewTime sql.NullTime
+	var ip, reauth sql.NullString
+
+	err := row.Scan(&(e.EmployeeID), &(e.Version), &(e.DocumentHash), &(e.Username), &(e.FirstName), &(e.LastName), &(e.Campus), &j, &ip, &viewTime, &reauth, &(e.Time), &(e.Timestamp))
+	if err != nil {
+		return nil, err
+	}
+	//signatures from before views were recorded have no view time
+	e.ViewTime = viewTime.Time
+	//signatures from before re-authentication was recorded have no method
+	e.Reauth = reauth.String
+	//signatures from before IP addresses were recorded have none
+	e.IP = ip.String
+
+	err = json.Unmarshal(j, &(e.Headers))
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+//Get returns the Entry for the given employeeID and document version.
+//If the Entry doesn't exist, entry will be nil.
+//Get returns an error if one occurred.
+func (db *SQLDB) Get(employeeID, version string) (entry *Entry, err error) {
+	row := db.db.QueryRow("SELECT "+entryColumns+" FROM signers WHERE employee_id=? AND version=?;", employeeID, version)
+
+	e, err := scanEntry(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return e, nil
+}
+
+//List returns all entries in the database for the given document version
+func (db *SQLDB) List(version string) (list []*Entry, err error) {
+	rows, err := db.db.Query("SELECT "+entryColumns+" FROM signers WHERE version=?;", version)
 	if err != nil {
 		return nil, err
 	}
@@ -80,15 +225,7 @@ func (db *SQLDB) List() (list []*Entry, err error) {
 
 	var entries []*Entry
 	for rows.Next() {
-		e := &Entry{}
-		var j []byte
-
-		err = rows.Scan(&(e.EmployeeID), &(e.Username), &(e.FirstName), &(e.LastName), &(e.Campus), &j, &(e.Time))
-		if err != nil {
-			return nil, err
-		}
-
-		err = json.Unmarshal(j, &(e.Headers))
+		e, err := scanEntry(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -111,22 +248,54 @@ func NewSQLDB(driver, dsn string) (*SQLDB, error) {
 		db:     db,
 		driver: driver,
 		dsn:    dsn,
+		mu:     new(sync.Mutex),
 	}, nil
 }
 
 //Entry represents a database entry
 type Entry struct {
-	EmployeeID string
-	Username   string
-	FirstName  string
-	LastName   string
-	Campus     string
-	Headers    http.Header
-	Time       time.Time
+	EmployeeID   string
+	Version      string //version of the signed Document
+	DocumentHash string //hash of the signed Document's file
+	Username     string
+	FirstName    string
+	LastName     string
+	Campus       string
+	Headers      http.Header
+	IP           string    //client IP address the Entry was submitted from; empty if not recorded
+	ViewTime     time.Time //when the Document was first viewed; zero if not recorded
+	Reauth       string    //how the user re-confirmed their identity when signing, one of the Reauth methods; empty if they didn't
+	Time         time.Time
+	Timestamp    []byte //RFC 3161 timestamp token over SignatureHash; nil if not timestamped
+}
+
+//Entry.Reauth methods
+const (
+	ReauthPassword = "password"
+	ReauthOIDC     = "oidc"
+	ReauthSAML     = "saml"
+	ReauthEmail    = "email"
+)
+
+//SignatureHash returns the SHA-256 hash of the signature's contents, which timestamps are issued over
+func (e *Entry) SignatureHash() []byte {
+	h := sha256.Sum256(canonicalFields([]string{
+		"handbook-signature-v1",
+		e.EmployeeID,
+		e.Version,
+		e.DocumentHash,
+		e.Username,
+		e.FirstName,
+		e.LastName,
+		e.Campus,
+		strconv.FormatInt(e.Time.Unix(), 10),
+	}))
+	return h[:]
 }
 
-//Validate makes sure the information in e is valid
-func (e *Entry) Validate() error {
+//Validate makes sure the information in e is valid.
+//e.Campus must be an active Campus in campuses.
+func (e *Entry) Validate(campuses []*Campus) error {
 	if len(e.Username) > 255 {
 		return errors.New("Username > 255")
 	}
@@ -136,23 +305,26 @@ func (e *Entry) Validate() error {
 	if len(e.LastName) > 255 {
 		return errors.New("LastName > 255")
 	}
-	switch e.Campus {
-	case "Alternative Education Center", "Early Childhood School", "Primary School", "Elementary School", "Intermediate School", "Middle School", "High School", "Central Office", "Maintenance", "Teaching and Learning", "Technology", "Transportation":
-	default:
-		return fmt.Errorf("Undefined Campus: %s", e.Campus)
+	for _, c := range campuses {
+		if c.Active && c.Name == e.Campus {
+			return nil
+		}
 	}
-	return nil
+	return fmt.Errorf("Undefined Campus: %s", e.Campus)
 }
 
-//NewEntry creates a new Entry with the given information
-func NewEntry(u *User, s *SubmitRequest, h http.Header) *Entry {
+//NewEntry creates a new Entry with the given information, submitted with request r
+func NewEntry(u *User, d *Document, s *SubmitRequest, r *http.Request) *Entry {
 	return &Entry{
-		EmployeeID: u.EmployeeID,
-		Username:   u.Username,
-		FirstName:  u.FirstName,
-		LastName:   u.LastName,
-		Campus:     s.Campus,
-		Headers:    h,
-		Time:       time.Now(),
+		EmployeeID:   u.EmployeeID,
+		Version:      d.Version,
+		DocumentHash: d.Hash,
+		Username:     u.Username,
+		FirstName:    u.FirstName,
+		LastName:     u.LastName,
+		Campus:       s.Campus,
+		Headers:      r.Header,
+		IP:           clientIP(r),
+		Time:         time.Now().Truncate(time.Second), //the database may not store fractional seconds
 	}
 }
@@ -0,0 +1,168 @@
+package api
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+//ErrDocumentNotFound is returned when a Document with the given version does not exist
+var ErrDocumentNotFound = errors.New("Document not found")
+
+//Document represents a version of the handbook that staff sign
+type Document struct {
+	Version  string
+	Title    string
+	Filename string
+	Size     int64
+	Hash     string //hex-encoded SHA-256 hash of the document file
+	Active   bool
+	Created  time.Time
+}
+
+//ActiveDocument returns the Document staff are currently signing.
+//If no Document is active, doc will be nil.
+//ActiveDocument returns an error if one occurred.
+func (db *SQLDB) ActiveDocument() (doc *Document, err error) {
+	row := db.db.QueryRow("SELECT version, title, filename, COALESCE(LENGTH(content), 0), hash, active, created FROM documents WHERE active=?;", true)
+
+	d := &Document{}
+	err = row.Scan(&(d.Version), &(d.Title), &(d.Filename), &(d.Size), &(d.Hash), &(d.Active), &(d.Created))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return d, nil
+}
+
+//Documents returns all Documents in the database, newest first
+func (db *SQLDB) Documents() (list []*Document, err error) {
+	rows, err := db.db.Query("SELECT version, title, filename, COALESCE(LENGTH(content), 0), hash, active, created FROM documents ORDER BY created DESC;")
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		e := rows.Close()
+		if err == nil {
+			err = e
+		}
+	}()
+
+	var docs []*Document
+	for rows.Next() {
+		d := &Document{}
+		err = rows.Scan(&(d.Version), &(d.Title), &(d.Filename), &(d.Size), &(d.Hash), &(d.Active), &(d.Created))
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+//CreateDocument commits a new, inactive Document with the given file content to the database
+func (db *SQLDB) CreateDocument(d *Document, content []byte) error {
+	return db.transact(func(tx *sql.Tx) error {
+		if err := db.audit(tx, AuditDocumentCreate, d); err != nil {
+			return err
+		}
+		_, err := tx.Exec("INSERT INTO documents(version, title, filename, content, hash, active, created) VALUES(?, ?, ?, ?, ?, ?, ?);",
+			d.Version,
+			d.Title,
+			d.Filename,
+			content,
+			d.Hash,
+			false,
+			d.Created,
+		)
+		return err
+	})
+}
+
+//DocumentContent returns the file content of the Document with the given version.
+//If the Document doesn't exist, ErrDocumentNotFound is returned.
+func (db *SQLDB) DocumentContent(version string) ([]byte, error) {
+	row := db.db.QueryRow("SELECT content FROM documents WHERE version=?;", version)
+
+	var content []byte
+	err := row.Scan(&content)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrDocumentNotFound
+		}
+		return nil, err
+	}
+
+	return content, nil
+}
+
+//PublishDocument makes the Document with the given version the only active Document.
+//If the Document doesn't exist, ErrDocumentNotFound is returned.
+func (db *SQLDB) PublishDocument(version string) error {
+	return db.transact(func(tx *sql.Tx) error {
+		s := new(string)
+		err := tx.QueryRow("SELECT version FROM documents WHERE version=?;", version).Scan(s)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrDocumentNotFound
+			}
+			return err
+		}
+
+		if err = db.audit(tx, AuditDocumentPublish, &PublishRequest{Version: version}); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("UPDATE documents SET active=? WHERE version<>?;", false, version)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("UPDATE documents SET active=? WHERE version=?;", true, version)
+		return err
+	})
+}
+
+//HashDocument returns the hex-encoded SHA-256 hash of the given document file content
+func HashDocument(content []byte) string {
+	h := sha256.Sum256(content)
+	return hex.EncodeToString(h[:])
+}
+
+//NewDocument creates a new Document with the given information and file content
+func NewDocument(version, title, filename string, content []byte) *Document {
+	return &Document{
+		Version:  version,
+		Title:    title,
+		Filename: filename,
+		Size:     int64(len(content)),
+		Hash:     HashDocument(content),
+		Created:  time.Now(),
+	}
+}
+
+//Validate makes sure the information in d is valid
+func (d *Document) Validate() error {
+	if d.Version == "" {
+		return errors.New("Version empty")
+	}
+	if len(d.Version) > 20 {
+		return errors.New("Version > 20")
+	}
+	if len(d.Title) > 255 {
+		return errors.New("Title > 255")
+	}
+	if len(d.Filename) > 255 {
+		return errors.New("Filename > 255")
+	}
+	return nil
+}
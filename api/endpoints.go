@@ -5,7 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
@@ -20,12 +22,74 @@ func handleError(w http.ResponseWriter, code int, err error) {
 	}
 }
 
+//handleForbidden returns a json 403 response with the given reason and logs it
+func handleForbidden(w http.ResponseWriter, reason string) {
+	log.Println("Forbidden:", reason)
+	w.WriteHeader(http.StatusForbidden)
+	e := json.NewEncoder(w)
+	encErr := e.Encode(ErrorResponse{Code: http.StatusForbidden, Error: fmt.Sprintf("%s: %s", http.StatusText(http.StatusForbidden), reason)})
+	if encErr != nil {
+		panic(encErr)
+	}
+}
+
 //NotFoundHandler returns a json 401 response
 func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	handleError(w, http.StatusNotFound, errors.New("handler not found"))
 }
 
+//checkSession returns the Session for the X-Session-Key header of r.
+//If admin is true, the Session's User must be an admin.
+//If the session is invalid, an error response is written to w and session will be nil.
+func checkSession(admin bool, c *Context, w http.ResponseWriter, r *http.Request) (session *Session) {
+	key := r.Header.Get("X-Session-Key")
+	if key == "" {
+		handleError(w, http.StatusBadRequest, errors.New("X-Session-Key header empty"))
+		return nil
+	}
+
+	sess, err := c.SessionStore.Check(key)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error checking session key: %v", err))
+		return nil
+	}
+	if sess == nil || sess.User == nil || (admin && !sess.User.Admin) {
+		handleError(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return nil
+	}
+
+	return sess
+}
+
+//canSeeEntry returns whether or not the given User can see the given Entry.
+//Campus-scoped admins can see entries signed for their campuses
+//and entries from staff whose building maps to their campuses.
+func canSeeEntry(c *Context, u *User, e *Entry) (bool, error) {
+	if u.CanSee(e.Campus) {
+		return true, nil
+	}
+
+	s, err := c.StaffDB.Get(e.EmployeeID)
+	if err != nil {
+		return false, fmt.Errorf("Error getting staff member: %v", err)
+	}
+	if s == nil {
+		return false, nil
+	}
+
+	campuses, err := c.DB.Campuses()
+	if err != nil {
+		return false, fmt.Errorf("Error getting campuses: %v", err)
+	}
+
+	campus := CampusForBuilding(campuses, s.Location)
+	return campus != nil && u.CanSee(campus.Name), nil
+}
+
+//errNoActiveDocument is returned when an operation requires an active Document but none exists
+var errNoActiveDocument = errors.New("No active document")
+
 //authHandler will return a sessionID if the credentials are valid
 //or an HTTP 401 Error if not.
 //The admin flag specifies whether to use Login or AdminLogin functions
@@ -40,6 +104,19 @@ func authHandler(admin bool, c *Context, w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if c.Throttle != nil {
+		wait, err := c.Throttle.Attempt(aReq.User, r)
+		if err != nil {
+			handleError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			handleError(w, http.StatusTooManyRequests, fmt.Errorf("Login throttled for %s from %s", aReq.User, throttleIP(r)))
+			return
+		}
+	}
+
 	var user *User
 	if admin {
 		user, err = c.Auth.AdminLogin(aReq.User, aReq.Passwd)
@@ -51,26 +128,51 @@ func authHandler(admin bool, c *Context, w http.ResponseWriter, r *http.Request)
 		return
 	}
 	if user != nil {
-		sessionID, err := c.SessionStore.Create(user)
-		if err != nil {
-			handleError(w, http.StatusInternalServerError, fmt.Errorf("Error creating session key: %v", err))
-			return
+		if c.Throttle != nil {
+			if err := c.Throttle.Succeed(aReq.User, r); err != nil {
+				log.Println(err)
+			}
 		}
+		writeLoginResponse(c, w, user)
+		return
+	}
+	handleError(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+}
+
+//writeAuthResponse creates a session for the given User and writes an AuthResponse for it
+func writeAuthResponse(c *Context, w http.ResponseWriter, user *User) {
+	writeSessionResponse(c, w, user, nil)
+}
+
+//writeSessionResponse creates a session for the given User and writes an AuthResponse for it with the given recovery codes
+func writeSessionResponse(c *Context, w http.ResponseWriter, user *User, recoveryCodes []string) {
+	sessionID, err := c.SessionStore.Create(user)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error creating session key: %v", err))
+		return
+	}
+
+	doc, err := c.DB.ActiveDocument()
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error getting active document: %v", err))
+		return
+	}
 
-		completed, err := c.DB.Check(user.EmployeeID)
+	aResp := AuthResponse{SessionID: sessionID, RecoveryCodes: recoveryCodes}
+	if doc != nil {
+		aResp.Version = doc.Version
+		aResp.Completed, err = c.DB.Check(user.EmployeeID, doc.Version)
 		if err != nil {
 			handleError(w, http.StatusInternalServerError, fmt.Errorf("Error checking database for username: %v", err))
 			return
 		}
+	}
 
-		e := json.NewEncoder(w)
-		err = e.Encode(AuthResponse{SessionID: sessionID, Completed: completed})
-		if err != nil {
-			handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
-		}
-		return
+	e := json.NewEncoder(w)
+	err = e.Encode(aResp)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
 	}
-	handleError(w, http.StatusUnauthorized, errors.New("Unauthorized"))
 }
 
 //authNormalHandler will return a sessionID if the credentials are a valid login
@@ -85,6 +187,28 @@ func authAdminHandler(c *Context, w http.ResponseWriter, r *http.Request) {
 	authHandler(true, c, w, r)
 }
 
+//logoutHandler will end the session for the X-Session-Key header. Invalid sessions are ignored.
+func logoutHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	key := r.Header.Get("X-Session-Key")
+	if key == "" {
+		handleError(w, http.StatusBadRequest, errors.New("X-Session-Key header empty"))
+		return
+	}
+
+	if err := c.SessionStore.Delete(key); err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error deleting session: %v", err))
+		return
+	}
+
+	e := json.NewEncoder(w)
+	err := e.Encode(LogoutResponse{Status: true})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
+	}
+}
+
 //submitHandler will submit information to the database if the sessionID is valid
 //or an HTTP 401 Error if not.
 func submitHandler(c *Context, w http.ResponseWriter, r *http.Request) {
@@ -115,22 +239,72 @@ func submitHandler(c *Context, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if sess != nil {
-		entry := NewEntry(sess.User, &sReq, r.Header)
+		doc, err := c.DB.ActiveDocument()
+		if err != nil {
+			handleError(w, http.StatusInternalServerError, fmt.Errorf("Error getting active document: %v", err))
+			return
+		}
+		if doc == nil {
+			handleError(w, http.StatusInternalServerError, errNoActiveDocument)
+			return
+		}
+
+		viewTime, err := c.DB.FirstView(sess.User.EmployeeID, doc.Version)
+		if err != nil {
+			handleError(w, http.StatusInternalServerError, fmt.Errorf("Error checking document views: %v", err))
+			return
+		}
+		if viewTime == nil {
+			handleError(w, http.StatusBadRequest, errors.New("Handbook has not been viewed"))
+			return
+		}
+
+		entry := NewEntry(sess.User, doc, &sReq, r)
+		entry.ViewTime = *viewTime
+
+		campuses, err := c.DB.Campuses()
+		if err != nil {
+			handleError(w, http.StatusInternalServerError, fmt.Errorf("Error getting campuses: %v", err))
+			return
+		}
 
-		err = entry.Validate()
+		err = entry.Validate(campuses)
 		if err != nil {
 			handleError(w, http.StatusBadRequest, fmt.Errorf("Error validating entry: %v", err))
 			return
 		}
 
-		err = c.DB.Submit(NewEntry(sess.User, &sReq, r.Header))
+		var ok bool
+		entry.Reauth, ok = reauthenticate(c, w, r, sess.User, &sReq)
+		if !ok {
+			return
+		}
+
+		if c.Timestamps != nil {
+			entry.Timestamp, err = c.Timestamps.Timestamp(entry.SignatureHash())
+			if err != nil {
+				//signatures are still accepted if the timestamp authority is unavailable
+				log.Println("Error getting timestamp:", err)
+			}
+		}
+
+		err = c.DB.Submit(entry)
 		if err != nil {
 			handleError(w, http.StatusInternalServerError, fmt.Errorf("Error submitting entry to database: %v", err))
 			return
 		}
 
+		sResp := SubmitResponse{Status: true}
+		if c.Receipts != nil {
+			sResp.Receipt, err = c.Receipts.Sign(entry)
+			if err != nil {
+				handleError(w, http.StatusInternalServerError, fmt.Errorf("Error signing receipt: %v", err))
+				return
+			}
+		}
+
 		e := json.NewEncoder(w)
-		err = e.Encode(SubmitResponse{Status: true})
+		err = e.Encode(sResp)
 		if err != nil {
 			handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
 		}
@@ -139,65 +313,114 @@ func submitHandler(c *Context, w http.ResponseWriter, r *http.Request) {
 	handleError(w, http.StatusUnauthorized, errors.New("Unauthorized"))
 }
 
+//queryVersion returns the document version given in the "version" query parameter of r,
+//or the active document's version if none is given.
+//If there is no active document, an error response is written to w and version will be empty.
+func queryVersion(c *Context, w http.ResponseWriter, r *http.Request) (version string) {
+	if version = r.URL.Query().Get("version"); version != "" {
+		return version
+	}
+
+	doc, err := c.DB.ActiveDocument()
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error getting active document: %v", err))
+		return ""
+	}
+	if doc == nil {
+		handleError(w, http.StatusInternalServerError, errNoActiveDocument)
+		return ""
+	}
+
+	return doc.Version
+}
+
 //listHandler will return a list of signing records if the sessionID is valid
 //or an HTTP 401 Error if not.
+//Campus-scoped admins only see records for their campuses.
+//Records are for the document version given in the "version" query parameter, or the active document if not given.
 func listHandler(c *Context, w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
-	key := r.Header.Get("X-Session-Key")
-	if key == "" {
-		handleError(w, http.StatusBadRequest, errors.New("X-Session-Key header empty"))
+	sess := requestSession(r)
+
+	staffList, err := c.StaffDB.List()
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error getting list from staff database: %v", err))
 		return
 	}
 
-	sess, err := c.SessionStore.Check(key)
+	version := queryVersion(c, w, r)
+	if version == "" {
+		return
+	}
+
+	dbList, err := c.DB.List(version)
 	if err != nil {
-		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error checking session key: %v", err))
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error getting list from database: %v", err))
 		return
 	}
-	if sess != nil && sess.User != nil && sess.User.Admin {
 
-		staffList, err := c.StaffDB.List()
-		if err != nil {
-			handleError(w, http.StatusInternalServerError, fmt.Errorf("Error getting list from staff database: %v", err))
-			return
-		}
+	campuses, err := c.DB.Campuses()
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error getting campuses from database: %v", err))
+		return
+	}
 
-		dbList, err := c.DB.List()
-		if err != nil {
-			handleError(w, http.StatusInternalServerError, fmt.Errorf("Error getting list from database: %v", err))
-			return
-		}
+	// map EmployeeID to DB field
+	dbMap := make(map[string]*Entry)
+	for _, e := range dbList {
+		dbMap[e.EmployeeID] = e
+	}
+
+	var records []*Record
 
-		// map EmployeeID to DB field
-		dbMap := make(map[string]*Entry)
-		for _, e := range dbList {
-			dbMap[e.EmployeeID] = e
+	for _, s := range staffList {
+		r := &Record{
+			EmployeeID:   s.EmployeeID,
+			FirstName:    s.FirstName,
+			LastName:     s.LastName,
+			EmployeeType: strings.Title(s.Type),
+			Location:     strings.Title(s.Location),
+			Building:     s.Location,
+		}
+		staffCampus := CampusForBuilding(campuses, s.Location)
+		if staffCampus != nil {
+			r.Location = staffCampus.Name
+		}
+		if e, ok := dbMap[s.EmployeeID]; ok {
+			//replace StaffDB location with one given
+			r.Location = e.Campus
+			r.Mismatch = staffCampus != nil && staffCampus.Name != e.Campus
+			r.SignTime = &(e.Time)
+			r.DocumentHash = e.DocumentHash
+		}
+		if !sess.User.CanSee(r.Location) && (staffCampus == nil || !sess.User.CanSee(staffCampus.Name)) {
+			continue
 		}
+		records = append(records, r)
+	}
 
-		var records []*Record
+	e := json.NewEncoder(w)
+	err = e.Encode(ListResponse{Version: version, List: records})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
+	}
+}
 
-		for _, s := range staffList {
-			r := &Record{
-				FirstName:    s.FirstName,
-				LastName:     s.LastName,
-				EmployeeType: strings.Title(s.Type),
-				Location:     strings.Title(s.Location),
-			}
-			if e, ok := dbMap[s.EmployeeID]; ok {
-				//replace StaffDB location with one given
-				r.Location = e.Campus
-				r.SignTime = &(e.Time)
-			}
-			records = append(records, r)
-		}
+//auditHandler will return a verification report of the audit log if the sessionID is a valid superadmin session
+//or an HTTP 401 or 403 Error if not.
+func auditHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
 
-		e := json.NewEncoder(w)
-		err = e.Encode(ListResponse{List: records})
-		if err != nil {
-			handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
-		}
+	report, err := c.DB.VerifyAudit()
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error verifying audit log: %v", err))
 		return
 	}
-	handleError(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+
+	e := json.NewEncoder(w)
+	err = e.Encode(report)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
+	}
 }
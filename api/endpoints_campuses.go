@@ -0,0 +1,279 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+//campusesHandler will return a list of active campuses
+func campusesHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	campuses, err := c.DB.Campuses()
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error getting campuses from database: %v", err))
+		return
+	}
+
+	active := make([]*Campus, 0, len(campuses))
+	for _, campus := range campuses {
+		if campus.Active {
+			active = append(active, campus)
+		}
+	}
+
+	e := json.NewEncoder(w)
+	err = e.Encode(CampusListResponse{Campuses: active})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
+	}
+}
+
+//campusListHandler will return a list of all campuses, including inactive ones,
+//if the sessionID is a valid admin session or an HTTP 401 Error if not.
+func campusListHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	campuses, err := c.DB.Campuses()
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error getting campuses from database: %v", err))
+		return
+	}
+
+	e := json.NewEncoder(w)
+	err = e.Encode(CampusListResponse{Campuses: campuses})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
+	}
+}
+
+//decodeCampus returns a valid Campus decoded from the body of r.
+//If the Campus is not valid, an error response is written to w and campus will be nil.
+func decodeCampus(w http.ResponseWriter, r *http.Request) (campus *Campus) {
+	campus = &Campus{}
+	d := json.NewDecoder(r.Body)
+	err := d.Decode(campus)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, fmt.Errorf("Error decoding json: %v", err))
+		return nil
+	}
+
+	err = campus.Validate()
+	if err != nil {
+		handleError(w, http.StatusBadRequest, fmt.Errorf("Error validating campus: %v", err))
+		return nil
+	}
+
+	return campus
+}
+
+//campusCreateHandler will create a new campus if the sessionID is a valid manager session
+//or an HTTP 401 or 403 Error if not.
+func campusCreateHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	campus := decodeCampus(w, r)
+	if campus == nil {
+		return
+	}
+
+	err := c.DB.CreateCampus(campus)
+	if err == ErrCampusExists || err == ErrBuildingMapped {
+		handleError(w, http.StatusConflict, fmt.Errorf("Error creating campus %s: %v", campus.Name, err))
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error creating campus in database: %v", err))
+		return
+	}
+
+	e := json.NewEncoder(w)
+	err = e.Encode(CampusResponse{Campus: campus})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
+	}
+}
+
+//campusUpdateHandler will update the position, active status, and buildings of a campus if the sessionID is a valid manager session
+//or an HTTP 401 or 403 Error if not.
+func campusUpdateHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	campus := decodeCampus(w, r)
+	if campus == nil {
+		return
+	}
+
+	err := c.DB.UpdateCampus(campus)
+	if err == ErrCampusNotFound {
+		handleError(w, http.StatusNotFound, fmt.Errorf("Error updating campus %s: %v", campus.Name, err))
+		return
+	}
+	if err == ErrBuildingMapped {
+		handleError(w, http.StatusConflict, fmt.Errorf("Error updating campus %s: %v", campus.Name, err))
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error updating campus in database: %v", err))
+		return
+	}
+
+	e := json.NewEncoder(w)
+	err = e.Encode(CampusResponse{Campus: campus})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
+	}
+}
+
+//campusDeleteHandler will delete a campus no signatures reference if the sessionID is a valid manager session
+//or an HTTP 401 or 403 Error if not.
+func campusDeleteHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var dReq CampusDeleteRequest
+	d := json.NewDecoder(r.Body)
+	err := d.Decode(&dReq)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, fmt.Errorf("Error decoding json: %v", err))
+		return
+	}
+
+	err = c.DB.DeleteCampus(dReq.Name)
+	switch err {
+	case nil:
+	case ErrCampusNotFound:
+		handleError(w, http.StatusNotFound, fmt.Errorf("Error deleting campus %s: %v", dReq.Name, err))
+		return
+	case ErrCampusInUse:
+		handleError(w, http.StatusConflict, fmt.Errorf("Error deleting campus %s: %v", dReq.Name, err))
+		return
+	default:
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error deleting campus from database: %v", err))
+		return
+	}
+
+	e := json.NewEncoder(w)
+	err = e.Encode(CampusDeleteResponse{Status: true})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
+	}
+}
+
+//meHandler will return information about the session's user, including the campus mapped from their building,
+//if the sessionID is valid or an HTTP 401 Error if not.
+func meHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	sess := checkSession(false, c, w, r)
+	if sess == nil {
+		return
+	}
+
+	mResp := MeResponse{EmployeeID: sess.User.EmployeeID, FirstName: sess.User.FirstName, LastName: sess.User.LastName}
+
+	staff, err := c.StaffDB.Get(sess.User.EmployeeID)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error getting staff member from staff database: %v", err))
+		return
+	}
+
+	if staff != nil {
+		campuses, err := c.DB.Campuses()
+		if err != nil {
+			handleError(w, http.StatusInternalServerError, fmt.Errorf("Error getting campuses from database: %v", err))
+			return
+		}
+
+		mResp.Building = staff.Location
+		if campus := CampusForBuilding(campuses, staff.Location); campus != nil && campus.Active {
+			mResp.Campus = campus.Name
+		}
+	}
+
+	e := json.NewEncoder(w)
+	err = e.Encode(mResp)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
+	}
+}
+
+//mismatchHandler will return a list of signers of the version given by the "version" query parameter,
+//or the active document if no version is given, whose chosen campus differs from the campus mapped from their staff database building,
+//if the sessionID is a valid admin session or an HTTP 401 Error if not.
+//Campus-scoped admins only see mismatches involving their campuses.
+func mismatchHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	sess := requestSession(r)
+
+	version := queryVersion(c, w, r)
+	if version == "" {
+		return
+	}
+
+	staffList, err := c.StaffDB.List()
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error getting list from staff database: %v", err))
+		return
+	}
+
+	dbList, err := c.DB.List(version)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error getting list from database: %v", err))
+		return
+	}
+
+	campuses, err := c.DB.Campuses()
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error getting campuses from database: %v", err))
+		return
+	}
+
+	staffMap := make(map[string]*StaffMember)
+	for _, s := range staffList {
+		staffMap[s.EmployeeID] = s
+	}
+
+	mResp := MismatchResponse{Version: version, Mismatches: []*Mismatch{}, UnmappedBuildings: []string{}}
+	unmapped := make(map[string]struct{})
+
+	for _, e := range dbList {
+		s, ok := staffMap[e.EmployeeID]
+		if !ok {
+			continue
+		}
+
+		campus := CampusForBuilding(campuses, s.Location)
+		if campus == nil {
+			if sess.User.Campuses != nil {
+				continue
+			}
+			if _, ok := unmapped[s.Location]; !ok {
+				unmapped[s.Location] = struct{}{}
+				mResp.UnmappedBuildings = append(mResp.UnmappedBuildings, s.Location)
+			}
+			continue
+		}
+
+		if campus.Name != e.Campus && (sess.User.CanSee(campus.Name) || sess.User.CanSee(e.Campus)) {
+			mResp.Mismatches = append(mResp.Mismatches, &Mismatch{
+				EmployeeID:  e.EmployeeID,
+				FirstName:   e.FirstName,
+				LastName:    e.LastName,
+				Building:    s.Location,
+				StaffCampus: campus.Name,
+				Campus:      e.Campus,
+				SignTime:    e.Time,
+			})
+		}
+	}
+	sort.Strings(mResp.UnmappedBuildings)
+
+	e := json.NewEncoder(w)
+	err = e.Encode(mResp)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
+	}
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+//sanitizeFilename returns name with characters that aren't allowed in file names removed
+func sanitizeFilename(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return -1
+		}
+		return r
+	}, name)
+}
+
+//certificateFilename returns a file name for the given Entry's certificate
+func certificateFilename(e *Entry) string {
+	return sanitizeFilename(fmt.Sprintf("%s, %s (%s).pdf", e.LastName, e.FirstName, e.EmployeeID))
+}
+
+//stampEntry returns the given handbook file with a signature certificate page for the given Entry appended
+func stampEntry(c *Context, content []byte, e *Entry) ([]byte, error) {
+	receipt, err := signReceipt(c, e)
+	if err != nil {
+		return nil, fmt.Errorf("Error signing receipt: %v", err)
+	}
+
+	return StampCertificate(content, e, receipt)
+}
+
+//documentContent returns the content of the given document version.
+//If the document doesn't exist or has no file, an error response is written to w and content will be nil.
+func documentContent(c *Context, w http.ResponseWriter, version string) (content []byte) {
+	content, err := c.DB.DocumentContent(version)
+	if err == ErrDocumentNotFound {
+		handleError(w, http.StatusNotFound, fmt.Errorf("Error getting document %s: %v", version, err))
+		return nil
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error getting document content: %v", err))
+		return nil
+	}
+	if len(content) == 0 {
+		handleError(w, http.StatusNotFound, fmt.Errorf("Document %s has no file", version))
+		return nil
+	}
+
+	return content
+}
+
+//certificateHandler will return the signed handbook with a signature certificate page appended
+//for the entry given by the "employee_id" and "version" query parameters
+//if the sessionID is a valid admin session or an HTTP 401 Error if not.
+func certificateHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	sess := requestSession(r)
+
+	employeeID, version := r.URL.Query().Get("employee_id"), r.URL.Query().Get("version")
+
+	entry, err := c.DB.Get(employeeID, version)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error getting entry: %v", err))
+		return
+	}
+	if entry == nil {
+		handleError(w, http.StatusNotFound, fmt.Errorf("Entry not found for %s (%s)", employeeID, version))
+		return
+	}
+
+	ok, err := canSeeEntry(c, sess.User, entry)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !ok {
+		handleError(w, http.StatusNotFound, fmt.Errorf("Entry not found for %s (%s)", employeeID, version))
+		return
+	}
+
+	content := documentContent(c, w, version)
+	if content == nil {
+		return
+	}
+
+	stamped, err := stampEntry(c, content, entry)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error stamping certificate: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"certificate-%s-%s.pdf\"", entry.EmployeeID, entry.Version))
+	w.Write(stamped)
+}
+
+//certificateArchiveHandler will return a zip file of signed handbooks with signature certificate pages
+//for every entry for the campus given by the "campus" query parameter and the version given by the "version" query parameter,
+//or the active document if no version is given,
+//if the sessionID is a valid admin session for the campus or an HTTP 401 or 403 Error if not.
+func certificateArchiveHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	sess := requestSession(r)
+
+	campus := r.URL.Query().Get("campus")
+	if campus == "" {
+		handleError(w, http.StatusBadRequest, errors.New("campus query parameter empty"))
+		return
+	}
+	if !sess.User.CanSee(campus) {
+		handleForbidden(w, fmt.Sprintf("not an admin for %s", campus))
+		return
+	}
+
+	version := queryVersion(c, w, r)
+	if version == "" {
+		return
+	}
+
+	list, err := c.DB.List(version)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error getting list from database: %v", err))
+		return
+	}
+
+	var entries []*Entry
+	for _, e := range list {
+		if strings.EqualFold(e.Campus, campus) {
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) == 0 {
+		handleError(w, http.StatusNotFound, fmt.Errorf("No entries found for %s (%s)", campus, version))
+		return
+	}
+
+	content := documentContent(c, w, version)
+	if content == nil {
+		return
+	}
+
+	//stamp the first certificate before writing headers so unsupported files return an error response
+	stamped, err := stampEntry(c, content, entries[0])
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error stamping certificate: %v", err))
+		return
+	}
+
+	//archives can be large, so they're streamed; errors after this point can only be logged
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", sanitizeFilename(fmt.Sprintf("certificates-%s-%s.zip", campus, version))))
+
+	z := zip.NewWriter(w)
+	for i, e := range entries {
+		if i > 0 {
+			if stamped, err = stampEntry(c, content, e); err != nil {
+				log.Printf("Error stamping certificate for %s (%s): %v\n", e.EmployeeID, e.Version, err)
+				return
+			}
+		}
+
+		f, err := z.Create(certificateFilename(e))
+		if err != nil {
+			log.Println("Error writing archive:", err)
+			return
+		}
+		if _, err = f.Write(stamped); err != nil {
+			log.Println("Error writing archive:", err)
+			return
+		}
+	}
+
+	if err = z.Close(); err != nil {
+		log.Println("Error writing archive:", err)
+	}
+}
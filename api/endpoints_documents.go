@@ -0,0 +1,206 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+//maxDocumentSize is the largest handbook file that can be uploaded
+const maxDocumentSize = 32 << 20
+
+//serveDocument writes the given document content to w as an inline PDF
+func serveDocument(w http.ResponseWriter, r *http.Request, doc *Document, content []byte) {
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"handbook-%s.pdf\"", doc.Version))
+	http.ServeContent(w, r, "", doc.Created, bytes.NewReader(content))
+}
+
+//handbookHandler will return the active document's file
+//or an HTTP 404 Error if there is no active document.
+func handbookHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	doc, err := c.DB.ActiveDocument()
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error getting active document: %v", err))
+		return
+	}
+	if doc == nil {
+		w.Header().Set("Content-Type", "application/json")
+		handleError(w, http.StatusNotFound, errNoActiveDocument)
+		return
+	}
+
+	content, err := c.DB.DocumentContent(doc.Version)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error getting document content: %v", err))
+		return
+	}
+
+	serveDocument(w, r, doc, content)
+}
+
+//viewHandler will record a view and return the active document's file if the sessionID is valid
+//or an HTTP 401 Error if not.
+func viewHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	sess := checkSession(false, c, w, r)
+	if sess == nil {
+		return
+	}
+
+	doc, err := c.DB.ActiveDocument()
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error getting active document: %v", err))
+		return
+	}
+	if doc == nil {
+		handleError(w, http.StatusNotFound, errNoActiveDocument)
+		return
+	}
+
+	content, err := c.DB.DocumentContent(doc.Version)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error getting document content: %v", err))
+		return
+	}
+
+	err = c.DB.RecordView(&View{EmployeeID: sess.User.EmployeeID, Version: doc.Version, Time: time.Now()})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error recording view: %v", err))
+		return
+	}
+
+	serveDocument(w, r, doc, content)
+}
+
+//documentListHandler will return a list of all documents if the sessionID is a valid admin session
+//or an HTTP 401 Error if not.
+func documentListHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	docs, err := c.DB.Documents()
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error getting documents from database: %v", err))
+		return
+	}
+
+	e := json.NewEncoder(w)
+	err = e.Encode(DocumentListResponse{Documents: docs})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
+	}
+}
+
+//documentUploadHandler will store a new, unpublished document from a multipart form
+//with Version, Title, and file fields if the sessionID is a valid manager session
+//or an HTTP 401 or 403 Error if not.
+func documentUploadHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxDocumentSize)
+	err := r.ParseMultipartForm(maxDocumentSize)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, fmt.Errorf("Error parsing form: %v", err))
+		return
+	}
+
+	f, header, err := r.FormFile("file")
+	if err != nil {
+		handleError(w, http.StatusBadRequest, fmt.Errorf("Error reading file: %v", err))
+		return
+	}
+	defer f.Close()
+
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, fmt.Errorf("Error reading file: %v", err))
+		return
+	}
+	if !bytes.HasPrefix(content, []byte("%PDF-")) {
+		handleError(w, http.StatusBadRequest, errors.New("File is not a PDF"))
+		return
+	}
+
+	//signature certificates are appended to the document, so make sure that will work before it's published
+	if _, err = AppendPDFPage(content, nil); err != nil {
+		handleError(w, http.StatusBadRequest, fmt.Errorf("File is not a supported PDF: %v", err))
+		return
+	}
+
+	doc := NewDocument(r.FormValue("Version"), r.FormValue("Title"), header.Filename, content)
+
+	err = doc.Validate()
+	if err != nil {
+		handleError(w, http.StatusBadRequest, fmt.Errorf("Error validating document: %v", err))
+		return
+	}
+
+	err = c.DB.CreateDocument(doc, content)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error creating document in database: %v", err))
+		return
+	}
+
+	e := json.NewEncoder(w)
+	err = e.Encode(DocumentResponse{Document: doc})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
+	}
+}
+
+//documentFileHandler will return the file of the document given in the "version" query parameter
+//if the sessionID is a valid admin session or an HTTP 401 Error if not.
+func documentFileHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	version := r.URL.Query().Get("version")
+
+	content, err := c.DB.DocumentContent(version)
+	if err == ErrDocumentNotFound {
+		handleError(w, http.StatusNotFound, fmt.Errorf("Error getting document %s: %v", version, err))
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error getting document content: %v", err))
+		return
+	}
+
+	serveDocument(w, r, &Document{Version: version}, content)
+}
+
+//documentPublishHandler will make the given document active if the sessionID is a valid superadmin session
+//or an HTTP 401 or 403 Error if not.
+func documentPublishHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var pReq PublishRequest
+	d := json.NewDecoder(r.Body)
+	err := d.Decode(&pReq)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, fmt.Errorf("Error decoding json: %v", err))
+		return
+	}
+
+	err = c.DB.PublishDocument(pReq.Version)
+	if err == ErrDocumentNotFound {
+		handleError(w, http.StatusNotFound, fmt.Errorf("Error publishing document %s: %v", pReq.Version, err))
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error publishing document: %v", err))
+		return
+	}
+
+	e := json.NewEncoder(w)
+	err = e.Encode(PublishResponse{Status: true})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
+	}
+}
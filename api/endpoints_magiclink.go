@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+//magicLinkConfigHandler will return whether or not magic link logins are enabled
+func magicLinkConfigHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	e := json.NewEncoder(w)
+	err := e.Encode(MagicLinkConfigResponse{Enabled: c.MagicLinks != nil})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
+	}
+}
+
+//magicLinkHandler will email a login link to the staff member with the given email address or employee ID.
+//It succeeds whether or not the staff member was found, or returns an HTTP 429 Error if the identifier or client is throttled.
+func magicLinkHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if c.MagicLinks == nil {
+		handleError(w, http.StatusNotFound, errors.New("Magic links not configured"))
+		return
+	}
+
+	var mReq MagicLinkRequest
+	d := json.NewDecoder(r.Body)
+	err := d.Decode(&mReq)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, fmt.Errorf("Error decoding json: %v", err))
+		return
+	}
+
+	//every request counts as a failed attempt, so a client can't flood staff inboxes or probe for staff identifiers
+	if c.Throttle != nil {
+		wait, err := c.Throttle.Attempt(mReq.Identifier, r)
+		if err != nil {
+			handleError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			handleError(w, http.StatusTooManyRequests, fmt.Errorf("Magic link throttled for %s from %s", mReq.Identifier, throttleIP(r)))
+			return
+		}
+	}
+
+	if err = c.MagicLinks.Send(mReq.Identifier); err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error sending magic link: %v", err))
+		return
+	}
+
+	e := json.NewEncoder(w)
+	err = e.Encode(MagicLinkResponse{Status: true})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
+	}
+}
+
+//magicLinkSessionHandler will return a sessionID for an emailed login link's token
+//or an HTTP 401 Error if the token is invalid
+func magicLinkSessionHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if c.MagicLinks == nil {
+		handleError(w, http.StatusNotFound, errors.New("Magic links not configured"))
+		return
+	}
+
+	var mReq MagicLinkSessionRequest
+	d := json.NewDecoder(r.Body)
+	err := d.Decode(&mReq)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, fmt.Errorf("Error decoding json: %v", err))
+		return
+	}
+
+	user, err := c.MagicLinks.Claim(mReq.Token)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error checking magic link: %v", err))
+		return
+	}
+	if user == nil {
+		handleError(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+
+	writeLoginResponse(c, w, user)
+}
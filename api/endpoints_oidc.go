@@ -0,0 +1,139 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+//oidcConfigHandler will return whether or not OIDC logins are enabled
+func oidcConfigHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	e := json.NewEncoder(w)
+	err := e.Encode(OIDCResponse{Enabled: c.OIDC != nil})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
+	}
+}
+
+//oidcLoginHandler will redirect to the identity provider to start a login,
+//an admin login if the "admin" query parameter is "true",
+//or a re-authentication before signing if the "reauth" query parameter is "true"
+func oidcLoginHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if c.OIDC == nil {
+		handleError(w, http.StatusNotFound, errors.New("OIDC not configured"))
+		return
+	}
+
+	var u string
+	var err error
+	if r.URL.Query().Get("reauth") == "true" {
+		u, err = c.OIDC.ReauthCodeURL()
+	} else {
+		u, err = c.OIDC.AuthCodeURL(r.URL.Query().Get("admin") == "true")
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error starting OIDC login: %v", err))
+		return
+	}
+
+	http.Redirect(w, r, u, http.StatusFound)
+}
+
+//oidcCallbackHandler will complete a login when the identity provider redirects back
+//and redirect to the client app with a token it can exchange for a session,
+//or to the login page with an error if the login failed.
+//Re-authentications redirect to the form with a token to sign with instead.
+func oidcCallbackHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if c.OIDC == nil {
+		handleError(w, http.StatusNotFound, errors.New("OIDC not configured"))
+		return
+	}
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		log.Printf("OIDC login error: %s: %s\n", e, q.Get("error_description"))
+		appRedirect(w, "/login?error=failed")
+		return
+	}
+
+	user, admin, reauth, err := c.OIDC.Exchange(q.Get("state"), q.Get("code"))
+	login := "/login"
+	if admin {
+		login = "/admin/login"
+	} else if reauth {
+		login = "/form"
+	}
+	if err != nil {
+		log.Println("Error completing OIDC login:", err)
+		appRedirect(w, login+"?error=failed")
+		return
+	}
+	if user == nil {
+		appRedirect(w, login+"?error=unauthorized")
+		return
+	}
+
+	if reauth {
+		token, err := c.OIDC.reauths.add(user)
+		if err != nil {
+			log.Println("Error completing OIDC login:", err)
+			appRedirect(w, login+"?error=failed")
+			return
+		}
+		appRedirect(w, "/form?reauth="+url.QueryEscape(token))
+		return
+	}
+
+	token, err := c.OIDC.handoffs.add(user)
+	if err != nil {
+		log.Println("Error completing OIDC login:", err)
+		appRedirect(w, login+"?error=failed")
+		return
+	}
+
+	route := "/oidc/"
+	if admin {
+		route = "/admin/oidc/"
+	}
+	appRedirect(w, route+url.PathEscape(token))
+}
+
+//oidcSessionHandler will return a sessionID for a completed OIDC login's token
+//or an HTTP 401 Error if the token is invalid
+func oidcSessionHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if c.OIDC == nil {
+		handleError(w, http.StatusNotFound, errors.New("OIDC not configured"))
+		return
+	}
+
+	var oReq OIDCSessionRequest
+	d := json.NewDecoder(r.Body)
+	err := d.Decode(&oReq)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, fmt.Errorf("Error decoding json: %v", err))
+		return
+	}
+
+	user, err := c.OIDC.handoffs.claim(oReq.Token)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if user == nil {
+		handleError(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+
+	writeLoginResponse(c, w, user)
+}
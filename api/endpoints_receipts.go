@@ -0,0 +1,155 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+//errNoReceipts is returned when receipts are requested but no ReceiptSigner is configured
+var errNoReceipts = errors.New("Receipts not configured")
+
+//verifyHandler will return whether or not the given receipt has a valid signature
+//and the verified receipt contents if it does.
+func verifyHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if c.Receipts == nil {
+		handleError(w, http.StatusNotFound, errNoReceipts)
+		return
+	}
+
+	var vReq VerifyRequest
+	d := json.NewDecoder(r.Body)
+	err := d.Decode(&vReq)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, fmt.Errorf("Error decoding json: %v", err))
+		return
+	}
+
+	var vResp VerifyResponse
+	receipt, err := c.Receipts.Verify(vReq.Receipt)
+	if err == nil {
+		vResp = VerifyResponse{Valid: true, Receipt: receipt}
+	}
+
+	e := json.NewEncoder(w)
+	err = e.Encode(vResp)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
+	}
+}
+
+//receiptKeyHandler will return the public key receipts are currently signed with
+func receiptKeyHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if c.Receipts == nil {
+		handleError(w, http.StatusNotFound, errNoReceipts)
+		return
+	}
+
+	pub := c.Receipts.PublicKey()
+
+	e := json.NewEncoder(w)
+	err := e.Encode(ReceiptKeyResponse{KeyID: KeyID(pub), PublicKey: pub})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
+	}
+}
+
+//signReceipt returns a signed receipt for the given Entry,
+//or an empty string if no ReceiptSigner is configured
+func signReceipt(c *Context, e *Entry) (string, error) {
+	if c.Receipts == nil {
+		return "", nil
+	}
+	return c.Receipts.Sign(e)
+}
+
+//writeReceipt writes a PDF receipt for the given Entry to w
+func writeReceipt(c *Context, w http.ResponseWriter, e *Entry) {
+	receipt, err := signReceipt(c, e)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error signing receipt: %v", err))
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	err = WriteReceiptPDF(buf, e, receipt)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error writing receipt: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"receipt-%s-%s.pdf\"", e.EmployeeID, e.Version))
+	w.Write(buf.Bytes())
+}
+
+//receiptPDFHandler will return a PDF receipt for the session's signature of the active document
+//if the sessionID is valid or an HTTP 401 Error if not.
+func receiptPDFHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	sess := checkSession(false, c, w, r)
+	if sess == nil {
+		return
+	}
+
+	doc, err := c.DB.ActiveDocument()
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error getting active document: %v", err))
+		return
+	}
+	if doc == nil {
+		handleError(w, http.StatusNotFound, errNoActiveDocument)
+		return
+	}
+
+	entry, err := c.DB.Get(sess.User.EmployeeID, doc.Version)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error getting entry: %v", err))
+		return
+	}
+	if entry == nil {
+		handleError(w, http.StatusNotFound, errors.New("Entry not found"))
+		return
+	}
+
+	writeReceipt(c, w, entry)
+}
+
+//adminReceiptPDFHandler will return a PDF receipt for the entry given by the "employee_id" and "version" query parameters
+//if the sessionID is a valid admin session or an HTTP 401 Error if not.
+func adminReceiptPDFHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	sess := requestSession(r)
+
+	employeeID, version := r.URL.Query().Get("employee_id"), r.URL.Query().Get("version")
+
+	entry, err := c.DB.Get(employeeID, version)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error getting entry: %v", err))
+		return
+	}
+	if entry == nil {
+		handleError(w, http.StatusNotFound, fmt.Errorf("Entry not found for %s (%s)", employeeID, version))
+		return
+	}
+
+	ok, err := canSeeEntry(c, sess.User, entry)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !ok {
+		handleError(w, http.StatusNotFound, fmt.Errorf("Entry not found for %s (%s)", employeeID, version))
+		return
+	}
+
+	writeReceipt(c, w, entry)
+}
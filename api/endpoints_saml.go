@@ -0,0 +1,156 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+//samlConfigHandler will return whether or not SAML logins are enabled
+func samlConfigHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	e := json.NewEncoder(w)
+	err := e.Encode(SAMLResponse{Enabled: c.SAML != nil})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
+	}
+}
+
+//samlMetadataHandler will return the service provider metadata to register with the identity provider
+func samlMetadataHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if c.SAML == nil {
+		handleError(w, http.StatusNotFound, errors.New("SAML not configured"))
+		return
+	}
+
+	buf, err := c.SAML.Metadata()
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error generating SAML metadata: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.Write(buf)
+}
+
+//samlLoginHandler will redirect to the identity provider to start a login,
+//an admin login if the "admin" query parameter is "true",
+//or a re-authentication before signing if the "reauth" query parameter is "true"
+func samlLoginHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if c.SAML == nil {
+		handleError(w, http.StatusNotFound, errors.New("SAML not configured"))
+		return
+	}
+
+	var u string
+	var err error
+	if r.URL.Query().Get("reauth") == "true" {
+		u, err = c.SAML.ReauthRequestURL()
+	} else {
+		u, err = c.SAML.AuthnRequestURL(r.URL.Query().Get("admin") == "true")
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error starting SAML login: %v", err))
+		return
+	}
+
+	http.Redirect(w, r, u, http.StatusFound)
+}
+
+//samlACSHandler will complete a login when the identity provider posts a Response
+//and redirect to the client app with a token it can exchange for a session,
+//or to the login page with an error if the login failed.
+//Re-authentications redirect to the form with a token to sign with instead.
+func samlACSHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if c.SAML == nil {
+		handleError(w, http.StatusNotFound, errors.New("SAML not configured"))
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		handleError(w, http.StatusBadRequest, fmt.Errorf("Error parsing form: %v", err))
+		return
+	}
+
+	user, admin, reauth, err := c.SAML.ParseResponse(r.PostForm.Get("SAMLResponse"), r.PostForm.Get("RelayState"))
+	login := "/login"
+	if admin {
+		login = "/admin/login"
+	} else if reauth {
+		login = "/form"
+	}
+	if err != nil {
+		log.Println("Error completing SAML login:", err)
+		appRedirect(w, login+"?error=failed")
+		return
+	}
+	if user == nil {
+		appRedirect(w, login+"?error=unauthorized")
+		return
+	}
+
+	if reauth {
+		token, err := c.SAML.reauths.add(user)
+		if err != nil {
+			log.Println("Error completing SAML login:", err)
+			appRedirect(w, login+"?error=failed")
+			return
+		}
+		appRedirect(w, "/form?reauth="+url.QueryEscape(token))
+		return
+	}
+
+	token, err := c.SAML.handoffs.add(user)
+	if err != nil {
+		log.Println("Error completing SAML login:", err)
+		appRedirect(w, login+"?error=failed")
+		return
+	}
+
+	route := "/saml/"
+	if admin {
+		route = "/admin/saml/"
+	}
+	appRedirect(w, route+url.PathEscape(token))
+}
+
+//samlSessionHandler will return a sessionID for a completed SAML login's token
+//or an HTTP 401 Error if the token is invalid
+func samlSessionHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if c.SAML == nil {
+		handleError(w, http.StatusNotFound, errors.New("SAML not configured"))
+		return
+	}
+
+	var sReq SAMLSessionRequest
+	d := json.NewDecoder(r.Body)
+	err := d.Decode(&sReq)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, fmt.Errorf("Error decoding json: %v", err))
+		return
+	}
+
+	user, err := c.SAML.handoffs.claim(sReq.Token)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if user == nil {
+		handleError(w, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+
+	writeLoginResponse(c, w, user)
+}
@@ -0,0 +1,143 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+//stepUpConfigHandler will return whether or not users must re-authenticate when signing, and how they can
+func stepUpConfigHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	e := json.NewEncoder(w)
+	err := e.Encode(StepUpResponse{
+		Enabled: c.StepUp,
+		OIDC:    c.StepUp && c.OIDC != nil,
+		SAML:    c.StepUp && c.SAML != nil,
+		Email:   c.StepUp && c.MagicLinks != nil,
+	})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
+	}
+}
+
+//stepUpEmailHandler will email the signed in user a link that re-confirms their identity before signing,
+//or return an HTTP 409 Error if they don't have an email address or were sent a link too recently
+func stepUpEmailHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if !c.StepUp || c.MagicLinks == nil {
+		handleError(w, http.StatusNotFound, errors.New("Emailed re-authentication not configured"))
+		return
+	}
+
+	sess := checkSession(false, c, w, r)
+	if sess == nil {
+		return
+	}
+
+	sent, err := c.MagicLinks.SendReauth(sess.User.EmployeeID)
+	if err == ErrNoEmail {
+		handleError(w, http.StatusConflict, fmt.Errorf("Error sending re-authentication link to %s: %v", sess.User.Username, err))
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error sending re-authentication link: %v", err))
+		return
+	}
+	if !sent {
+		handleError(w, http.StatusConflict, fmt.Errorf("Re-authentication link sent to %s too recently", sess.User.Username))
+		return
+	}
+
+	e := json.NewEncoder(w)
+	err = e.Encode(MagicLinkResponse{Status: true})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
+	}
+}
+
+//claimReauth returns the user re-authenticated by the given token from OIDC, SAML, or an emailed link,
+//and the method used for Entry.Reauth. If the token is unknown, already used, or expired, user will be nil.
+func claimReauth(c *Context, token string) (user *User, method string, err error) {
+	if c.OIDC != nil {
+		if user, err = c.OIDC.reauths.claim(token); user != nil || err != nil {
+			return user, ReauthOIDC, err
+		}
+	}
+	if c.SAML != nil {
+		if user, err = c.SAML.reauths.claim(token); user != nil || err != nil {
+			return user, ReauthSAML, err
+		}
+	}
+	if c.MagicLinks != nil {
+		if user, err = c.MagicLinks.ClaimReauth(token); user != nil || err != nil {
+			return user, ReauthEmail, err
+		}
+	}
+	return nil, "", nil
+}
+
+//reauthenticate re-confirms the identity of the given signing user with the password or re-authentication token in sReq
+//and returns the method used for Entry.Reauth. If step-up re-authentication is disabled, method will be empty.
+//If the user can't be re-authenticated, an error response is written to w and ok will be false.
+func reauthenticate(c *Context, w http.ResponseWriter, r *http.Request, user *User, sReq *SubmitRequest) (method string, ok bool) {
+	if !c.StepUp {
+		return "", true
+	}
+
+	if sReq.ReauthToken != "" {
+		//EmployeeID is the only identifier all backends are guaranteed to agree on
+		u, method, err := claimReauth(c, sReq.ReauthToken)
+		if err != nil {
+			handleError(w, http.StatusInternalServerError, err)
+			return "", false
+		}
+		if u == nil || u.EmployeeID == "" || u.EmployeeID != user.EmployeeID {
+			handleForbidden(w, fmt.Sprintf("invalid or expired re-authentication for %s", user.Username))
+			return "", false
+		}
+		return method, true
+	}
+
+	if sReq.Passwd == "" {
+		handleForbidden(w, fmt.Sprintf("password required to sign for %s", user.Username))
+		return "", false
+	}
+
+	if c.Throttle != nil {
+		wait, err := c.Throttle.Attempt(user.Username, r)
+		if err != nil {
+			handleError(w, http.StatusInternalServerError, err)
+			return "", false
+		}
+		if wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			handleError(w, http.StatusTooManyRequests, fmt.Errorf("Re-authentication throttled for %s from %s", user.Username, throttleIP(r)))
+			return "", false
+		}
+	}
+
+	u, err := c.Auth.Login(user.Username, sReq.Passwd)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error re-authenticating: %v", err))
+		return "", false
+	}
+	if u == nil || u.EmployeeID != user.EmployeeID {
+		handleForbidden(w, fmt.Sprintf("invalid password for %s", user.Username))
+		return "", false
+	}
+
+	if c.Throttle != nil {
+		if err := c.Throttle.Succeed(user.Username, r); err != nil {
+			log.Println(err)
+		}
+	}
+
+	return ReauthPassword, true
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+//throttleListHandler will return the usernames and IP addresses blocked by login throttling
+//if the sessionID is a valid manager session or an HTTP 401 or 403 Error if not.
+func throttleListHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	tResp := ThrottleListResponse{Blocked: make([]*ThrottledIdentity, 0)}
+	if c.Throttle != nil {
+		tResp.Enabled = true
+		blocked, err := c.Throttle.Blocked()
+		if err != nil {
+			handleError(w, http.StatusInternalServerError, err)
+			return
+		}
+		tResp.Blocked = blocked
+	}
+
+	e := json.NewEncoder(w)
+	err := e.Encode(tResp)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
+	}
+}
+
+//throttleClearHandler will clear the failed login attempts of a username or IP address
+//if the sessionID is a valid superadmin session or an HTTP 401 or 403 Error if not.
+func throttleClearHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var cReq ThrottleClearRequest
+	d := json.NewDecoder(r.Body)
+	err := d.Decode(&cReq)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, fmt.Errorf("Error decoding json: %v", err))
+		return
+	}
+
+	if cReq.Type != ThrottleUsername && cReq.Type != ThrottleIP {
+		handleError(w, http.StatusBadRequest, fmt.Errorf("Invalid throttle type: %s", cReq.Type))
+		return
+	}
+
+	if c.Throttle == nil {
+		handleError(w, http.StatusNotFound, errors.New("Error clearing throttle: identity not found"))
+		return
+	}
+	found, err := c.Throttle.Clear(cReq.Type, cReq.Identity)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !found {
+		handleError(w, http.StatusNotFound, errors.New("Error clearing throttle: identity not found"))
+		return
+	}
+
+	log.Printf("Login throttle for %s %s cleared by %s\n", cReq.Type, cReq.Identity, requestSession(r).User.Username)
+
+	e := json.NewEncoder(w)
+	err = e.Encode(ThrottleClearResponse{Status: true})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
+	}
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+//timestampHandler will return whether or not the timestamp of the entry given by the "employee_id" and "version" query parameters
+//is valid if the sessionID is a valid admin session or an HTTP 401 Error if not.
+func timestampHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	sess := requestSession(r)
+
+	employeeID, version := r.URL.Query().Get("employee_id"), r.URL.Query().Get("version")
+
+	entry, err := c.DB.Get(employeeID, version)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error getting entry: %v", err))
+		return
+	}
+	if entry == nil {
+		handleError(w, http.StatusNotFound, fmt.Errorf("Entry not found for %s (%s)", employeeID, version))
+		return
+	}
+
+	ok, err := canSeeEntry(c, sess.User, entry)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !ok {
+		handleError(w, http.StatusNotFound, fmt.Errorf("Entry not found for %s (%s)", employeeID, version))
+		return
+	}
+
+	var tResp TimestampResponse
+	if entry.Timestamp == nil {
+		tResp.Error = "Signature was not timestamped"
+	} else {
+		//without a configured client, tokens are verified against the system roots
+		verify := func(token, digest []byte) (*Timestamp, error) { return VerifyTimestamp(token, digest, nil) }
+		if c.Timestamps != nil {
+			verify = c.Timestamps.Verify
+		}
+
+		tResp.Timestamp, err = verify(entry.Timestamp, entry.SignatureHash())
+		if err != nil {
+			tResp.Error = err.Error()
+		}
+		tResp.Valid = err == nil
+	}
+
+	e := json.NewEncoder(w)
+	err = e.Encode(tResp)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
+	}
+}
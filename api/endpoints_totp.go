@@ -0,0 +1,401 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+//errTOTPDisabled is returned when TOTP endpoints are used without TOTP configured
+var errTOTPDisabled = errors.New("TOTP is not enabled")
+
+//writeLoginResponse writes an AuthResponse for the given User after any login.
+//Admins must enter a TOTP code first if TOTP is enabled, whichever way they logged in.
+func writeLoginResponse(c *Context, w http.ResponseWriter, user *User) {
+	if user.Admin && c.TOTP != nil {
+		writePendingResponse(c, w, user)
+		return
+	}
+	writeAuthResponse(c, w, user)
+}
+
+//writePendingResponse writes an AuthResponse with a pending login ID if the given admin must enter a TOTP code,
+//or creates a session for them if they aren't enrolled and enrollment isn't required
+func writePendingResponse(c *Context, w http.ResponseWriter, user *User) {
+	enrolled, err := c.TOTP.Enrolled(user.Username)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if !enrolled && !c.TOTP.Required() {
+		writeAuthResponse(c, w, user)
+		return
+	}
+
+	id, err := c.TOTP.AddPending(user, !enrolled)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	e := json.NewEncoder(w)
+	err = e.Encode(AuthResponse{PendingID: id, TOTPEnroll: !enrolled})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
+	}
+}
+
+//totpLoginHandler will return a sessionID if the pending login ID and TOTP or recovery code are valid
+//or an HTTP 401 Error if not. If the admin is enrolling, the code confirms the enrollment
+//and the response includes their new recovery codes.
+func totpLoginHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if c.TOTP == nil {
+		handleError(w, http.StatusNotFound, errTOTPDisabled)
+		return
+	}
+
+	var tReq TOTPLoginRequest
+	d := json.NewDecoder(r.Body)
+	err := d.Decode(&tReq)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, fmt.Errorf("Error decoding json: %v", err))
+		return
+	}
+
+	user, enroll, err := c.TOTP.Pending(tReq.PendingID)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if user == nil {
+		handleError(w, http.StatusUnauthorized, errors.New("Unauthorized: unknown or expired pending login"))
+		return
+	}
+
+	if c.Throttle != nil {
+		wait, err := c.Throttle.Attempt(user.Username, r)
+		if err != nil {
+			handleError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			handleError(w, http.StatusTooManyRequests, fmt.Errorf("TOTP login throttled for %s from %s", user.Username, throttleIP(r)))
+			return
+		}
+	}
+
+	var codes []string
+	ok := false
+	if enroll {
+		codes, err = c.TOTP.Confirm(user.Username, tReq.Code)
+		ok = codes != nil
+	} else {
+		ok, err = c.TOTP.Verify(user.Username, tReq.Code)
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !ok {
+		if err = c.TOTP.Fail(tReq.PendingID); err != nil {
+			handleError(w, http.StatusInternalServerError, err)
+			return
+		}
+		handleError(w, http.StatusUnauthorized, fmt.Errorf("Unauthorized: invalid TOTP code for %s", user.Username))
+		return
+	}
+
+	if err = c.TOTP.Complete(tReq.PendingID); err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if c.Throttle != nil {
+		if err := c.Throttle.Succeed(user.Username, r); err != nil {
+			log.Println(err)
+		}
+	}
+	if enroll {
+		log.Printf("TOTP enrolled for %s\n", user.Username)
+	}
+
+	writeSessionResponse(c, w, user, codes)
+}
+
+//totpPendingEnrollHandler will return a new TOTP secret if the pending login ID is valid and the admin must enroll
+//or an HTTP 401 Error if not.
+func totpPendingEnrollHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if c.TOTP == nil {
+		handleError(w, http.StatusNotFound, errTOTPDisabled)
+		return
+	}
+
+	var eReq TOTPPendingEnrollRequest
+	d := json.NewDecoder(r.Body)
+	err := d.Decode(&eReq)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, fmt.Errorf("Error decoding json: %v", err))
+		return
+	}
+
+	user, enroll, err := c.TOTP.Pending(eReq.PendingID)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if user == nil {
+		handleError(w, http.StatusUnauthorized, errors.New("Unauthorized: unknown or expired pending login"))
+		return
+	}
+	if !enroll {
+		handleError(w, http.StatusConflict, fmt.Errorf("Error enrolling %s in TOTP: already enrolled", user.Username))
+		return
+	}
+
+	writeEnrollResponse(c, w, user.Username)
+}
+
+//writeEnrollResponse starts TOTP enrollment for the given admin and writes a TOTPEnrollResponse for it
+func writeEnrollResponse(c *Context, w http.ResponseWriter, username string) {
+	secret, uri, err := c.TOTP.Begin(username)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	e := json.NewEncoder(w)
+	err = e.Encode(TOTPEnrollResponse{Secret: secret, URI: uri})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
+	}
+}
+
+//totpStatusHandler will return the session admin's TOTP enrollment status
+//if the sessionID is a valid admin session or an HTTP 401 Error if not.
+func totpStatusHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var tResp TOTPStatusResponse
+	if c.TOTP != nil {
+		username := requestSession(r).User.Username
+		tResp.Enabled = true
+		tResp.Required = c.TOTP.Required()
+
+		var err error
+		tResp.Enrolled, err = c.TOTP.Enrolled(username)
+		if err != nil {
+			handleError(w, http.StatusInternalServerError, err)
+			return
+		}
+		tResp.RecoveryCodes, err = c.TOTP.RecoveryCodesLeft(username)
+		if err != nil {
+			handleError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	e := json.NewEncoder(w)
+	err := e.Encode(tResp)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
+	}
+}
+
+//totpEnrollHandler will return a new TOTP secret for the session admin if they aren't enrolled
+//if the sessionID is a valid admin session or an HTTP 401 Error if not.
+func totpEnrollHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if c.TOTP == nil {
+		handleError(w, http.StatusNotFound, errTOTPDisabled)
+		return
+	}
+
+	username := requestSession(r).User.Username
+	enrolled, err := c.TOTP.Enrolled(username)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if enrolled {
+		handleError(w, http.StatusConflict, fmt.Errorf("Error enrolling %s in TOTP: already enrolled", username))
+		return
+	}
+
+	writeEnrollResponse(c, w, username)
+}
+
+//decodeCode returns the code decoded from the body of r.
+//If the body can't be decoded, an error response is written to w and ok will be false.
+func decodeCode(w http.ResponseWriter, r *http.Request) (code string, ok bool) {
+	var cReq TOTPCodeRequest
+	d := json.NewDecoder(r.Body)
+	err := d.Decode(&cReq)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, fmt.Errorf("Error decoding json: %v", err))
+		return "", false
+	}
+	return cReq.Code, true
+}
+
+//totpConfirmHandler will complete the session admin's TOTP enrollment and return their recovery codes if the code is valid
+//if the sessionID is a valid admin session or an HTTP 401 Error if not.
+func totpConfirmHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if c.TOTP == nil {
+		handleError(w, http.StatusNotFound, errTOTPDisabled)
+		return
+	}
+
+	code, ok := decodeCode(w, r)
+	if !ok {
+		return
+	}
+
+	username := requestSession(r).User.Username
+	codes, err := c.TOTP.Confirm(username, code)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if codes == nil {
+		handleForbidden(w, "invalid TOTP code")
+		return
+	}
+
+	log.Printf("TOTP enrolled for %s\n", username)
+
+	e := json.NewEncoder(w)
+	err = e.Encode(TOTPRecoveryCodesResponse{RecoveryCodes: codes})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
+	}
+}
+
+//verifySessionCode returns whether or not the TOTP or recovery code in the body of r is valid for the session admin.
+//If not, an error response is written to w.
+func verifySessionCode(c *Context, w http.ResponseWriter, r *http.Request) (username string, ok bool) {
+	if c.TOTP == nil {
+		handleError(w, http.StatusNotFound, errTOTPDisabled)
+		return "", false
+	}
+
+	code, ok := decodeCode(w, r)
+	if !ok {
+		return "", false
+	}
+
+	username = requestSession(r).User.Username
+	ok, err := c.TOTP.Verify(username, code)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, err)
+		return "", false
+	}
+	if !ok {
+		handleForbidden(w, "invalid TOTP code")
+		return "", false
+	}
+
+	return username, true
+}
+
+//totpRecoveryHandler will replace the session admin's recovery codes and return the new codes if the code is valid
+//if the sessionID is a valid admin session or an HTTP 401 Error if not.
+func totpRecoveryHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	username, ok := verifySessionCode(c, w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := c.TOTP.RegenerateRecoveryCodes(username)
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error replacing recovery codes: %v", err))
+		return
+	}
+
+	log.Printf("TOTP recovery codes replaced for %s\n", username)
+
+	e := json.NewEncoder(w)
+	err = e.Encode(TOTPRecoveryCodesResponse{RecoveryCodes: codes})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
+	}
+}
+
+//totpDisableHandler will remove the session admin's TOTP enrollment if the code is valid
+//if the sessionID is a valid admin session or an HTTP 401 Error if not.
+func totpDisableHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if c.TOTP != nil && c.TOTP.Required() {
+		handleForbidden(w, "TOTP is required for admins")
+		return
+	}
+
+	username, ok := verifySessionCode(c, w, r)
+	if !ok {
+		return
+	}
+
+	if err := c.TOTP.Remove(username); err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error removing TOTP enrollment: %v", err))
+		return
+	}
+
+	log.Printf("TOTP removed for %s\n", username)
+
+	e := json.NewEncoder(w)
+	err := e.Encode(TOTPDisableResponse{Status: true})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
+	}
+}
+
+//totpResetHandler will remove another admin's TOTP enrollment, e.g. when they've lost their device and recovery codes,
+//if the sessionID is a valid superadmin session or an HTTP 401 or 403 Error if not.
+func totpResetHandler(c *Context, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if c.TOTP == nil {
+		handleError(w, http.StatusNotFound, errTOTPDisabled)
+		return
+	}
+
+	var rReq TOTPResetRequest
+	d := json.NewDecoder(r.Body)
+	err := d.Decode(&rReq)
+	if err != nil {
+		handleError(w, http.StatusBadRequest, fmt.Errorf("Error decoding json: %v", err))
+		return
+	}
+
+	err = c.TOTP.Remove(rReq.Username)
+	if err == ErrTOTPNotFound {
+		handleError(w, http.StatusNotFound, fmt.Errorf("Error resetting TOTP for %s: %v", rReq.Username, err))
+		return
+	}
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error removing TOTP enrollment: %v", err))
+		return
+	}
+
+	log.Printf("TOTP reset for %s by %s\n", rReq.Username, requestSession(r).User.Username)
+
+	e := json.NewEncoder(w)
+	err = e.Encode(TOTPResetResponse{Status: true})
+	if err != nil {
+		handleError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding json: %v", err))
+	}
+}
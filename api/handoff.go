@@ -0,0 +1,56 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+//handoffDuration is how long the client has to claim a completed login
+const handoffDuration = time.Minute
+
+//handoffAppURL is the client app's URL relative to the endpoints identity providers return users to
+const handoffAppURL = "../../../"
+
+//appRedirect redirects to the given client app route.
+//The redirect is relative so it works under any url prefix.
+func appRedirect(w http.ResponseWriter, route string) {
+	w.Header().Set("Location", handoffAppURL+"#"+route)
+	w.WriteHeader(http.StatusFound)
+}
+
+//loginHandoffs holds logins completed by redirect-based backends until the client claims them with a single-use token,
+//so session IDs never appear in URLs
+type loginHandoffs struct {
+	state    StateStore
+	kind     string
+	duration time.Duration
+}
+
+//newLoginHandoffs returns a new loginHandoffs that keeps logins in state with the given kind, and whose tokens expire after duration
+func newLoginHandoffs(state StateStore, kind string, duration time.Duration) *loginHandoffs {
+	return &loginHandoffs{state: state, kind: kind, duration: duration}
+}
+
+//add returns a single-use token the client can claim the given User with
+func (h *loginHandoffs) add(user *User) (string, error) {
+	token := randString(64)
+	if err := putState(h.state, h.kind, token, user, time.Now().Add(h.duration)); err != nil {
+		return "", fmt.Errorf("Error storing login: %v", err)
+	}
+	return token, nil
+}
+
+//claim returns the User for the given token.
+//If the token is unknown, already claimed, or expired, user will be nil.
+func (h *loginHandoffs) claim(token string) (user *User, err error) {
+	user = new(User)
+	ok, err := takeState(h.state, h.kind, token, user)
+	if err != nil {
+		return nil, fmt.Errorf("Error claiming login: %v", err)
+	}
+	if !ok {
+		return nil, nil
+	}
+	return user, nil
+}
@@ -0,0 +1,260 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/ldap.v2"
+)
+
+//LDAPSecurity represents the transport security of LDAP connections
+type LDAPSecurity int
+
+//LDAP transport security types
+const (
+	LDAPSecurityNone     LDAPSecurity = iota //plain text
+	LDAPSecurityTLS                          //TLS from the start of the connection (LDAPS)
+	LDAPSecurityStartTLS                     //upgraded to TLS with the StartTLS operation
+)
+
+//ldapServerRetry is how long a server that failed is skipped before it's tried again
+const ldapServerRetry = 30 * time.Second
+
+//ldapConn represents a pooled LDAP connection
+type ldapConn struct {
+	*ldap.Conn
+	server       string
+	reused       bool //whether or not the connection came from the idle pool
+	serviceBound bool //whether or not the connection is bound as the service account
+}
+
+//ldapPool represents a pool of connections to an ordered list of LDAP servers.
+//New connections are made to the first server that hasn't failed recently.
+type ldapPool struct {
+	servers      []string //host:port
+	security     LDAPSecurity
+	tlsConfig    *tls.Config
+	bindDN       string
+	bindPassword string
+	size         int
+	timeout      time.Duration
+	debug        bool
+
+	mu   *sync.Mutex
+	idle []*ldapConn
+	down map[string]time.Time //servers to when they failed
+}
+
+//newLDAPPool returns a new ldapPool for the given servers (host or host:port) in order of preference.
+//port is used for servers without a port. If rootCAs is nil, the system roots are used for TLS.
+//If bindDN is non-empty, connections are bound as it before bindService returns.
+//At most size idle connections are kept, and operations time out after timeout.
+func newLDAPPool(servers []string, port int, security LDAPSecurity, rootCAs *x509.CertPool, bindDN, bindPassword string, size int, timeout time.Duration, debug bool) *ldapPool {
+	p := &ldapPool{
+		security:     security,
+		bindDN:       bindDN,
+		bindPassword: bindPassword,
+		size:         size,
+		timeout:      timeout,
+		debug:        debug,
+		mu:           new(sync.Mutex),
+		down:         make(map[string]time.Time),
+	}
+
+	for _, s := range servers {
+		if _, _, err := net.SplitHostPort(s); err != nil {
+			s = net.JoinHostPort(s, strconv.Itoa(port))
+		}
+		p.servers = append(p.servers, s)
+	}
+
+	if security != LDAPSecurityNone {
+		p.tlsConfig = &tls.Config{RootCAs: rootCAs}
+	}
+
+	return p
+}
+
+//dial returns a new connection to server
+func (p *ldapPool) dial(server string) (*ldapConn, error) {
+	host, _, err := net.SplitHostPort(server)
+	if err != nil {
+		return nil, err
+	}
+
+	nc, err := net.DialTimeout("tcp", server, p.timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var tlsConfig *tls.Config
+	if p.tlsConfig != nil {
+		tlsConfig = p.tlsConfig.Clone()
+		tlsConfig.ServerName = host
+	}
+
+	//bound the TLS handshake, which isn't covered by the request timeout
+	nc.SetDeadline(time.Now().Add(p.timeout))
+
+	if p.security == LDAPSecurityTLS {
+		tc := tls.Client(nc, tlsConfig)
+		if err = tc.Handshake(); err != nil {
+			nc.Close()
+			return nil, fmt.Errorf("TLS handshake failed: %v", err)
+		}
+		nc = tc
+	}
+
+	conn := ldap.NewConn(nc, p.security == LDAPSecurityTLS)
+	if p.debug {
+		conn.Debug = true
+	}
+	conn.Start()
+	conn.SetTimeout(p.timeout)
+
+	if p.security == LDAPSecurityStartTLS {
+		if err = conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	nc.SetDeadline(time.Time{})
+
+	return &ldapConn{Conn: conn, server: server}, nil
+}
+
+//get returns an idle connection, or a new connection to the first server that hasn't failed recently.
+//If all servers have failed recently, they're all tried again.
+func (p *ldapPool) get() (*ldapConn, error) {
+	p.mu.Lock()
+	if n := len(p.idle); n > 0 {
+		c := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		c.reused = true
+		return c, nil
+	}
+
+	now := time.Now()
+	var healthy, failed []string
+	for _, s := range p.servers {
+		if t, ok := p.down[s]; ok && now.Sub(t) < ldapServerRetry {
+			failed = append(failed, s)
+		} else {
+			healthy = append(healthy, s)
+		}
+	}
+	p.mu.Unlock()
+
+	var errs []string
+	for _, s := range append(healthy, failed...) {
+		c, err := p.dial(s)
+		if err == nil {
+			p.mu.Lock()
+			delete(p.down, s)
+			p.mu.Unlock()
+			return c, nil
+		}
+
+		log.Printf("Error connecting to LDAP server %s: %v\n", s, err)
+		errs = append(errs, fmt.Sprintf("%s: %v", s, err))
+		p.markDown(s)
+	}
+
+	return nil, fmt.Errorf("No LDAP servers available: %s", strings.Join(errs, "; "))
+}
+
+//markDown marks server as failed so it's skipped for ldapServerRetry
+func (p *ldapPool) markDown(server string) {
+	p.mu.Lock()
+	p.down[server] = time.Now()
+	p.mu.Unlock()
+}
+
+//put returns c to the pool, or closes it if the pool is full
+func (p *ldapPool) put(c *ldapConn) {
+	p.mu.Lock()
+	if len(p.idle) < p.size {
+		p.idle = append(p.idle, c)
+		p.mu.Unlock()
+		return
+	}
+	p.mu.Unlock()
+	c.Close()
+}
+
+//isConnError returns whether or not err means the connection or server is unusable,
+//as opposed to an LDAP result like invalid credentials
+func isConnError(err error) bool {
+	var e *ldap.Error
+	if !errors.As(err, &e) {
+		return true
+	}
+	switch e.ResultCode {
+	case ldap.LDAPResultBusy, ldap.LDAPResultUnavailable, ldap.LDAPResultUnwillingToPerform:
+		return true
+	}
+	return e.ResultCode >= ldap.ErrorNetwork
+}
+
+//bindService binds c as the service account if it isn't already
+func (p *ldapPool) bindService(c *ldapConn) error {
+	if p.bindDN == "" || c.serviceBound {
+		return nil
+	}
+	if err := c.Bind(p.bindDN, p.bindPassword); err != nil {
+		return err
+	}
+	c.serviceBound = true
+	return nil
+}
+
+//do calls f with a pooled connection. If f fails because of the connection, the connection is discarded
+//and f is retried with another connection, failing over to the next server if the server failed.
+func (p *ldapPool) do(f func(c *ldapConn) error) error {
+	var err error
+	for attempt := 0; attempt <= len(p.servers); attempt++ {
+		var c *ldapConn
+		c, err = p.get()
+		if err != nil {
+			return err
+		}
+
+		err = f(c)
+		if err == nil || !isConnError(err) {
+			p.put(c)
+			return err
+		}
+
+		c.Close()
+		//idle connections can be closed by the server without it failing
+		if !c.reused {
+			log.Printf("Error with LDAP server %s: %v\n", c.server, err)
+			p.markDown(c.server)
+		}
+	}
+	return err
+}
+
+//escapeFilter escapes s for use as a value in an LDAP search filter (RFC 4515)
+func escapeFilter(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\', '*', '(', ')', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,365 @@
+package api
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/korylprince/handbook/api/ldaptest"
+	"gopkg.in/ldap.v2"
+)
+
+//testLDAPServiceDN is the service account in ldaptest.SampleEntries
+const testLDAPServiceDN = "CN=Handbook Service,OU=Service Accounts,DC=example,DC=com"
+
+//startLDAP starts an ldaptest.Server serving ldaptest.SampleEntries. The caller must Close the server.
+func startLDAP(t *testing.T) *ldaptest.Server {
+	srv, err := ldaptest.Start(ldaptest.SampleEntries())
+	if err != nil {
+		t.Fatalf("Error starting LDAP server: %v", err)
+	}
+	return srv
+}
+
+//refusedAddr returns a loopback address nothing is listening on
+func refusedAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error finding unused port: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+//newTestLDAPAuth returns an LDAPAuth for the given servers, looking users up as bindDN if it's non-empty
+func newTestLDAPAuth(bindDN string, servers ...string) *LDAPAuth {
+	return NewLDAPAuth(&LDAPConfig{
+		Group:        "cn=staff, ou=groups, dc=example, dc=com",
+		Admins:       &AdminGroups{Admin: "CN=Handbook Admins,OU=Groups,DC=example,DC=com"},
+		Servers:      servers,
+		BaseDN:       "DC=example,DC=com",
+		BindDN:       bindDN,
+		BindPassword: ldaptest.SamplePassword,
+		PoolSize:     2,
+		Timeout:      2 * time.Second,
+	})
+}
+
+//isDown returns whether or not p is skipping server because it failed
+func isDown(p *ldapPool, server string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.down[server]
+	return ok
+}
+
+//closeIdle closes p's idle connections, so the next operation makes a new connection
+func closeIdle(p *ldapPool) {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+	for _, c := range idle {
+		c.Close()
+	}
+}
+
+func TestLDAPPoolFailover(t *testing.T) {
+	tests := []struct {
+		name  string
+		first func(t *testing.T) (addr string, close func())
+	}{
+		{"refused", func(t *testing.T) (string, func()) {
+			return refusedAddr(t), func() {}
+		}},
+		{"unavailable", func(t *testing.T) (string, func()) {
+			srv := startLDAP(t)
+			srv.SetUnavailable(true)
+			return srv.Addr(), func() { srv.Close() }
+		}},
+	}
+
+	for _, test := range tests {
+		first, closeFirst := test.first(t)
+		second := startLDAP(t)
+		a := newTestLDAPAuth("", first, second.Addr())
+
+		user, err := a.Login("jdoe", ldaptest.SamplePassword)
+		if err != nil || user == nil {
+			t.Errorf("%s: expected login to fail over to second server, got %v, %v", test.name, user, err)
+		}
+		if !isDown(a.pool, first) {
+			t.Errorf("%s: expected first server to be marked down", test.name)
+		}
+		if isDown(a.pool, second.Addr()) {
+			t.Errorf("%s: expected second server not to be marked down", test.name)
+		}
+
+		//new connections skip the failed server
+		closeIdle(a.pool)
+		if user, err = a.Login("jdoe", ldaptest.SamplePassword); err != nil || user == nil {
+			t.Errorf("%s: expected second login to succeed, got %v, %v", test.name, user, err)
+		}
+		if accepted, binds := second.Accepted(), second.Binds(); accepted != 2 || len(binds) != 2 {
+			t.Errorf("%s: expected both logins on second server, got %d connections and binds %v", test.name, accepted, binds)
+		}
+
+		closeFirst()
+		second.Close()
+	}
+}
+
+func TestLDAPPoolRecovery(t *testing.T) {
+	first, second := startLDAP(t), startLDAP(t)
+	defer first.Close()
+	defer second.Close()
+	a := newTestLDAPAuth("", first.Addr(), second.Addr())
+
+	first.SetUnavailable(true)
+	if user, err := a.Login("jdoe", ldaptest.SamplePassword); err != nil || user == nil {
+		t.Fatalf("Expected login to fail over to second server, got %v, %v", user, err)
+	}
+
+	//the first server is skipped until ldapServerRetry has passed, even if it's healthy
+	first.SetUnavailable(false)
+	closeIdle(a.pool)
+	if user, err := a.Login("jdoe", ldaptest.SamplePassword); err != nil || user == nil {
+		t.Fatalf("Expected login to succeed, got %v, %v", user, err)
+	}
+	if accepted := first.Accepted(); accepted != 1 {
+		t.Errorf("Expected failed server to be skipped, got %d connections", accepted)
+	}
+
+	a.pool.mu.Lock()
+	a.pool.down[first.Addr()] = time.Now().Add(-ldapServerRetry)
+	a.pool.mu.Unlock()
+	closeIdle(a.pool)
+	if user, err := a.Login("jdoe", ldaptest.SamplePassword); err != nil || user == nil {
+		t.Fatalf("Expected login to succeed, got %v, %v", user, err)
+	}
+	if accepted, binds := first.Accepted(), first.Binds(); accepted != 2 || !reflect.DeepEqual(binds, []string{"jdoe@example.com"}) {
+		t.Errorf("Expected login on recovered first server, got %d connections and binds %v", accepted, binds)
+	}
+	if isDown(a.pool, first.Addr()) {
+		t.Error("Expected recovered server not to be marked down")
+	}
+}
+
+func TestLDAPPoolAllDown(t *testing.T) {
+	first, second := startLDAP(t), startLDAP(t)
+	defer first.Close()
+	defer second.Close()
+	a := newTestLDAPAuth("", first.Addr(), second.Addr())
+
+	first.SetUnavailable(true)
+	second.SetUnavailable(true)
+	if user, err := a.Login("jdoe", ldaptest.SamplePassword); user != nil || err == nil {
+		t.Fatalf("Expected login to fail with all servers unavailable, got %v, %v", user, err)
+	}
+	if !isDown(a.pool, first.Addr()) || !isDown(a.pool, second.Addr()) {
+		t.Error("Expected all servers to be marked down")
+	}
+
+	//when every server has failed recently, they're all tried again in order
+	first.SetUnavailable(false)
+	if user, err := a.Login("jdoe", ldaptest.SamplePassword); err != nil || user == nil {
+		t.Fatalf("Expected login to succeed, got %v, %v", user, err)
+	}
+	if binds := first.Binds(); len(binds) != 1 {
+		t.Errorf("Expected login on first server, got binds %v", binds)
+	}
+}
+
+func TestLDAPServiceAccount(t *testing.T) {
+	srv := startLDAP(t)
+	defer srv.Close()
+	a := newTestLDAPAuth(testLDAPServiceDN, srv.Addr())
+
+	user, err := a.Login("JDoe@example.com", ldaptest.SamplePassword)
+	if err != nil {
+		t.Fatalf("Error logging in: %v", err)
+	}
+	expected := &User{EmployeeID: "1001", Username: "jdoe", FirstName: "Jane", LastName: "Doe"}
+	if !reflect.DeepEqual(user, expected) {
+		t.Errorf("Expected user %+v, got %+v", expected, user)
+	}
+
+	if expected := []string{testLDAPServiceDN, "CN=Jane Doe,OU=Staff,DC=example,DC=com"}; !reflect.DeepEqual(srv.Binds(), expected) {
+		t.Errorf("Expected binds %v, got %v", expected, srv.Binds())
+	}
+	if expected := []string{"(&(objectClass=user)(|(userPrincipalName=jdoe@example.com)(sAMAccountName=jdoe)))"}; !reflect.DeepEqual(srv.Searches(), expected) {
+		t.Errorf("Expected searches %v, got %v", expected, srv.Searches())
+	}
+
+	if user, err = a.AdminLogin("jsmith", ldaptest.SamplePassword); err != nil {
+		t.Fatalf("Error logging in: %v", err)
+	}
+	expected = &User{EmployeeID: "1002", Username: "jsmith", FirstName: "John", LastName: "Smith", Admin: true, Role: RoleSuperadmin}
+	if !reflect.DeepEqual(user, expected) {
+		t.Errorf("Expected admin user %+v, got %+v", expected, user)
+	}
+
+	tests := []struct {
+		name     string
+		admin    bool
+		username string
+		password string
+	}{
+		{"wrong password", false, "jdoe", "wrong"},
+		{"empty password", false, "jdoe", ""},
+		{"unknown user", false, "nobody", ldaptest.SamplePassword},
+		{"not in group", false, "plee", ldaptest.SamplePassword},
+		{"not an admin", true, "jdoe", ldaptest.SamplePassword},
+		{"same-named group elsewhere", true, "kwu", ldaptest.SamplePassword},
+	}
+	for _, test := range tests {
+		login := a.Login
+		if test.admin {
+			login = a.AdminLogin
+		}
+		if user, err := login(test.username, test.password); user != nil || err != nil {
+			t.Errorf("%s: expected no user, got %v, %v", test.name, user, err)
+		}
+	}
+
+	//the connection is bound as the service account again after each user
+	if user, err = a.Login("jdoe", ldaptest.SamplePassword); err != nil || user == nil {
+		t.Fatalf("Expected login to succeed, got %v, %v", user, err)
+	}
+	if binds := srv.Binds(); binds[len(binds)-2] != testLDAPServiceDN || binds[len(binds)-1] != "CN=Jane Doe,OU=Staff,DC=example,DC=com" {
+		t.Errorf("Expected service account bind before user bind, got %v", binds)
+	}
+
+	bad := newTestLDAPAuth(testLDAPServiceDN, srv.Addr())
+	bad.pool.bindPassword = "wrong"
+	if user, err := bad.Login("jdoe", ldaptest.SamplePassword); user != nil || err == nil {
+		t.Errorf("Expected wrong service account password to fail, got %v, %v", user, err)
+	}
+}
+
+func TestLDAPUserBind(t *testing.T) {
+	srv := startLDAP(t)
+	defer srv.Close()
+	a := newTestLDAPAuth("", srv.Addr())
+
+	user, err := a.Login(`EXAMPLE\jdoe`, ldaptest.SamplePassword)
+	if err != nil {
+		t.Fatalf("Error logging in: %v", err)
+	}
+	expected := &User{EmployeeID: "1001", Username: "jdoe", FirstName: "Jane", LastName: "Doe"}
+	if !reflect.DeepEqual(user, expected) {
+		t.Errorf("Expected user %+v, got %+v", expected, user)
+	}
+	if binds, searches := srv.Binds(), srv.Searches(); !reflect.DeepEqual(binds, []string{"jdoe@example.com"}) || len(searches) != 1 {
+		t.Errorf("Expected user to bind by UPN and search, got binds %v and searches %v", binds, searches)
+	}
+
+	if user, err = a.Login("jdoe", "wrong"); user != nil || err != nil {
+		t.Errorf("Expected wrong password to fail, got %v, %v", user, err)
+	}
+}
+
+func TestEscapeFilter(t *testing.T) {
+	tests := []struct {
+		value   string
+		escaped string
+	}{
+		{"jdoe", "jdoe"},
+		{"*", `\2a`},
+		{"jdoe)(objectClass=*", `jdoe\29\28objectClass=\2a`},
+		{"*)(|(sAMAccountName=*", `\2a\29\28|\28sAMAccountName=\2a`},
+		{`admin\`, `admin\5c`},
+		{"jdoe\x00", `jdoe\00`},
+		{"é", "é"},
+	}
+
+	for _, test := range tests {
+		escaped := escapeFilter(test.value)
+		if escaped != test.escaped {
+			t.Errorf("%q: expected %q, got %q", test.value, test.escaped, escaped)
+		}
+
+		//the escaped value must compile to a single equality match of the original value
+		filter, err := ldap.CompileFilter("(sAMAccountName=" + escaped + ")")
+		if err != nil {
+			t.Errorf("%q: Error compiling filter: %v", test.value, err)
+			continue
+		}
+		if filter.Tag != ldap.FilterEqualityMatch || len(filter.Children) != 2 || filter.Children[1].Value != test.value {
+			t.Errorf("%q: expected equality match, got %s", test.value, ldap.FilterMap[uint64(filter.Tag)])
+		}
+	}
+}
+
+func TestLDAPFilterInjection(t *testing.T) {
+	srv := startLDAP(t)
+	defer srv.Close()
+	a := newTestLDAPAuth(testLDAPServiceDN, srv.Addr())
+
+	for _, username := range []string{"*", "j*", "jdoe)(objectClass=*", "*)(|(sAMAccountName=*", `jdoe*`, "jdoe\x00"} {
+		if user, err := a.Login(username, ldaptest.SamplePassword); user != nil || err != nil {
+			t.Errorf("%q: expected no user, got %v, %v", username, user, err)
+		}
+	}
+	if searches := srv.Searches(); len(searches) != 0 {
+		t.Errorf("Expected no searches, got %v", searches)
+	}
+}
+
+func TestLDAPPoolReuse(t *testing.T) {
+	srv, backup := startLDAP(t), startLDAP(t)
+	defer srv.Close()
+	defer backup.Close()
+	a := newTestLDAPAuth(testLDAPServiceDN, srv.Addr(), backup.Addr())
+
+	for i := 0; i < 3; i++ {
+		if user, err := a.Login("jdoe", ldaptest.SamplePassword); err != nil || user == nil {
+			t.Fatalf("Expected login to succeed, got %v, %v", user, err)
+		}
+	}
+	if user, err := a.Login("jdoe", "wrong"); err != nil || user != nil {
+		t.Fatalf("Expected wrong password to fail, got %v, %v", user, err)
+	}
+	if accepted := srv.Accepted(); accepted != 1 {
+		t.Errorf("Expected logins to share 1 connection, got %d", accepted)
+	}
+	if n := len(a.pool.idle); n != 1 {
+		t.Errorf("Expected connection to be returned to the pool, got %d idle", n)
+	}
+
+	//an idle connection closed by the server is replaced without failing the server
+	srv.CloseClients()
+	if user, err := a.Login("jdoe", ldaptest.SamplePassword); err != nil || user == nil {
+		t.Fatalf("Expected login to succeed, got %v, %v", user, err)
+	}
+	if accepted := srv.Accepted(); accepted != 2 {
+		t.Errorf("Expected a new connection, got %d connections", accepted)
+	}
+	if accepted := backup.Accepted(); accepted != 0 || isDown(a.pool, srv.Addr()) {
+		t.Errorf("Expected server not to be marked down, got %d connections to backup server", accepted)
+	}
+
+	//at most PoolSize connections are kept
+	var conns []*ldapConn
+	for i := 0; i < 3; i++ {
+		c, err := a.pool.get()
+		if err != nil {
+			t.Fatalf("Error getting connection: %v", err)
+		}
+		conns = append(conns, c)
+	}
+	if !conns[0].reused || conns[1].reused || conns[2].reused {
+		t.Errorf("Expected only the first connection to be reused, got %v, %v, %v", conns[0].reused, conns[1].reused, conns[2].reused)
+	}
+	for _, c := range conns {
+		a.pool.put(c)
+	}
+	if n := len(a.pool.idle); n != 2 {
+		t.Errorf("Expected 2 idle connections, got %d", n)
+	}
+	if err := conns[2].Bind(testLDAPServiceDN, ldaptest.SamplePassword); err == nil {
+		t.Error("Expected connection put in a full pool to be closed")
+	}
+}
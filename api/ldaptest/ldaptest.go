@@ -0,0 +1,587 @@
+//Package ldaptest provides an in-process LDAP directory server for developing and testing LDAP logins.
+//It supports just enough of the protocol for handbook: simple binds by DN or userPrincipalName,
+//searches with and, or, not, equality, and present filters, StartTLS, and LDAPS.
+//Searches require an authenticated bind, and an empty password is an unauthenticated bind, as in Active Directory.
+//Data is only kept in memory.
+package ldaptest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/asn1-ber.v1"
+	"gopkg.in/ldap.v2"
+)
+
+//LDAP protocol operations (RFC 4511)
+const (
+	opBindRequest      ber.Tag = 0
+	opBindResponse     ber.Tag = 1
+	opUnbindRequest    ber.Tag = 2
+	opSearchRequest    ber.Tag = 3
+	opSearchEntry      ber.Tag = 4
+	opSearchDone       ber.Tag = 5
+	opExtendedRequest  ber.Tag = 23
+	opExtendedResponse ber.Tag = 24
+)
+
+//LDAP result codes
+const (
+	resultSuccess                = 0
+	resultOperationsError        = 1
+	resultProtocolError          = 2
+	resultAuthMethodNotSupported = 7
+	resultNoSuchObject           = 32
+	resultInvalidCredentials     = 49
+	resultUnavailable            = 52
+	resultUnwillingToPerform     = 53
+)
+
+//oidStartTLS is the StartTLS extended operation
+const oidStartTLS = "1.3.6.1.4.1.1466.20037"
+
+//SamplePassword is the password of every entry in SampleEntries
+const SamplePassword = "password"
+
+//Entry represents a directory entry
+type Entry struct {
+	DN         string
+	Password   string `json:",omitempty"`
+	Attributes map[string][]string
+}
+
+//SampleEntries returns a directory under dc=example,dc=com, where every password is SamplePassword:
+//
+//	cn=Handbook Service,ou=Service Accounts  the service account
+//	jdoe (1001)                               member of Staff
+//	jsmith (1002)                             member of Staff and Handbook Admins
+//	plee (1003)                               member of Handbook Viewers
+//	kwu (1004)                                member of Staff and a Handbook Admins group in ou=Student Clubs
+func SampleEntries() []*Entry {
+	group := func(cn string) string { return "CN=" + cn + ",OU=Groups,DC=example,DC=com" }
+	user := func(cn, username, id, first, last string, groups ...string) *Entry {
+		return &Entry{
+			DN:       "CN=" + cn + ",OU=Staff,DC=example,DC=com",
+			Password: SamplePassword,
+			Attributes: map[string][]string{
+				"objectClass":       {"top", "person", "organizationalPerson", "user"},
+				"cn":                {cn},
+				"sAMAccountName":    {username},
+				"userPrincipalName": {username + "@example.com"},
+				"employeeID":        {id},
+				"givenName":         {first},
+				"sn":                {last},
+				"mail":              {username + "@example.com"},
+				"memberOf":          groups,
+			},
+		}
+	}
+
+	return []*Entry{
+		{
+			DN:       "CN=Handbook Service,OU=Service Accounts,DC=example,DC=com",
+			Password: SamplePassword,
+			Attributes: map[string][]string{
+				"objectClass":    {"top", "person", "organizationalPerson", "user"},
+				"cn":             {"Handbook Service"},
+				"sAMAccountName": {"handbook-svc"},
+			},
+		},
+		user("Jane Doe", "jdoe", "1001", "Jane", "Doe", group("Staff")),
+		user("John Smith", "jsmith", "1002", "John", "Smith", group("Staff"), group("Handbook Admins")),
+		user("Pat Lee", "plee", "1003", "Pat", "Lee", group("Handbook Viewers")),
+		user("Kim Wu", "kwu", "1004", "Kim", "Wu", group("Staff"), "CN=Handbook Admins,OU=Student Clubs,DC=example,DC=com"),
+	}
+}
+
+//normalizeDN returns dn in a form that can be compared
+func normalizeDN(dn string) string {
+	rdns := strings.Split(dn, ",")
+	for i, rdn := range rdns {
+		rdns[i] = strings.ToLower(strings.TrimSpace(rdn))
+	}
+	return strings.Join(rdns, ",")
+}
+
+//values returns the values of the attribute with the given name, ignoring case
+func (e *Entry) values(name string) (string, []string) {
+	for k, v := range e.Attributes {
+		if strings.EqualFold(k, name) {
+			return k, v
+		}
+	}
+	return "", nil
+}
+
+//Server represents an in-memory LDAP server
+type Server struct {
+	Debug bool //if true, connections, binds, and searches are logged
+
+	entries   []*Entry
+	tlsConfig *tls.Config
+
+	mu          *sync.Mutex
+	l           net.Listener
+	conns       map[net.Conn]bool
+	accepted    int
+	binds       []string
+	searches    []string
+	unavailable bool
+}
+
+//NewServer returns a new Server with the given entries. tlsConfig is used for StartTLS and may be nil.
+//Call Serve or Start to accept connections.
+func NewServer(entries []*Entry, tlsConfig *tls.Config) *Server {
+	return &Server{entries: entries, tlsConfig: tlsConfig, mu: new(sync.Mutex), conns: make(map[net.Conn]bool)}
+}
+
+//Start starts a new Server with the given entries listening on a random local port, like httptest.NewServer
+func Start(entries []*Entry) (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := NewServer(entries, nil)
+	s.l = l
+	go s.Serve(l)
+	return s, nil
+}
+
+//Addr returns the address the Server is listening on, or an empty string if it isn't
+func (s *Server) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.l == nil {
+		return ""
+	}
+	return s.l.Addr().String()
+}
+
+//Serve accepts connections on l until it's closed
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	s.l = l
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.accepted++
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+//Close stops the Server and closes all client connections
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	if s.l == nil {
+		return nil
+	}
+	return s.l.Close()
+}
+
+//CloseClients closes all client connections, as if they had been idle too long, but keeps listening
+func (s *Server) CloseClients() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+//SetUnavailable sets whether or not every request fails with the unavailable result code,
+//as a server that's shutting down or can't reach its database does
+func (s *Server) SetUnavailable(unavailable bool) {
+	s.mu.Lock()
+	s.unavailable = unavailable
+	s.mu.Unlock()
+}
+
+//Accepted returns the number of connections the Server has accepted
+func (s *Server) Accepted() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.accepted
+}
+
+//Binds returns the names of the successful authenticated binds, as sent by clients
+func (s *Server) Binds() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.binds...)
+}
+
+//Searches returns the filters of the searches made by bound clients
+func (s *Server) Searches() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.searches...)
+}
+
+//logf logs if s.Debug is true
+func (s *Server) logf(format string, v ...interface{}) {
+	if s.Debug {
+		log.Printf(format, v...)
+	}
+}
+
+//session represents the state of a client connection
+type session struct {
+	conn  net.Conn
+	bound *Entry //nil if not authenticated
+}
+
+//handle serves conn until the client unbinds or disconnects
+func (s *Server) handle(conn net.Conn) {
+	s.logf("Connection from %v\n", conn.RemoteAddr())
+	sess := &session{conn: conn}
+
+	defer func() {
+		sess.conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	for {
+		//read directly from the connection so nothing is buffered across a StartTLS
+		packet, err := ber.ReadPacket(sess.conn)
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				log.Println("Error reading request:", err)
+			}
+			return
+		}
+		if len(packet.Children) < 2 {
+			log.Println("Invalid request")
+			return
+		}
+		id, ok := packet.Children[0].Value.(int64)
+		if !ok {
+			log.Println("Invalid message ID")
+			return
+		}
+
+		op := packet.Children[1]
+		if op.ClassType != ber.ClassApplication {
+			log.Println("Invalid operation")
+			return
+		}
+
+		s.mu.Lock()
+		unavailable := s.unavailable
+		s.mu.Unlock()
+
+		switch {
+		case op.Tag == opUnbindRequest:
+			return
+		case unavailable && op.Tag == opBindRequest:
+			err = s.write(sess, id, result(opBindResponse, resultUnavailable, "server unavailable"))
+		case unavailable && op.Tag == opSearchRequest:
+			err = s.write(sess, id, result(opSearchDone, resultUnavailable, "server unavailable"))
+		case unavailable:
+			err = s.write(sess, id, result(opExtendedResponse, resultUnavailable, "server unavailable"))
+		case op.Tag == opBindRequest:
+			err = s.bind(sess, id, op)
+		case op.Tag == opSearchRequest:
+			err = s.search(sess, id, op)
+		case op.Tag == opExtendedRequest:
+			err = s.extended(sess, id, op)
+		default:
+			err = s.write(sess, id, result(opExtendedResponse, resultProtocolError, "unsupported operation"))
+		}
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Println("Error handling request:", err)
+			}
+			return
+		}
+	}
+}
+
+//result returns an LDAPResult for the given operation
+func result(tag ber.Tag, code int, message string) *ber.Packet {
+	p := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Response")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "resultCode"))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, message, "diagnosticMessage"))
+	return p
+}
+
+//write writes op to the session with the given message ID
+func (s *Server) write(sess *session, id int64, op *ber.Packet) error {
+	p := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+	p.AppendChild(op)
+	_, err := sess.conn.Write(p.Bytes())
+	return err
+}
+
+//stringValue returns the string value of p, which may be context-specific
+func stringValue(p *ber.Packet) string {
+	if s, ok := p.Value.(string); ok {
+		return s
+	}
+	return p.Data.String()
+}
+
+//bind handles a simple bind by DN or userPrincipalName
+func (s *Server) bind(sess *session, id int64, op *ber.Packet) error {
+	sess.bound = nil
+	if len(op.Children) < 3 {
+		return s.write(sess, id, result(opBindResponse, resultProtocolError, "invalid bind request"))
+	}
+	name := stringValue(op.Children[1])
+	auth := op.Children[2]
+	if auth.ClassType != ber.ClassContext || auth.Tag != 0 {
+		return s.write(sess, id, result(opBindResponse, resultAuthMethodNotSupported, "only simple binds are supported"))
+	}
+	password := stringValue(auth)
+
+	//unauthenticated bind
+	if password == "" {
+		s.logf("Unauthenticated bind as %q\n", name)
+		return s.write(sess, id, result(opBindResponse, resultSuccess, ""))
+	}
+
+	for _, e := range s.entries {
+		_, upns := e.values("userPrincipalName")
+		match := normalizeDN(e.DN) == normalizeDN(name)
+		for _, upn := range upns {
+			if strings.EqualFold(upn, name) {
+				match = true
+			}
+		}
+		if match && e.Password != "" && e.Password == password {
+			s.logf("Bind as %s\n", e.DN)
+			s.mu.Lock()
+			s.binds = append(s.binds, name)
+			s.mu.Unlock()
+			sess.bound = e
+			return s.write(sess, id, result(opBindResponse, resultSuccess, ""))
+		}
+	}
+
+	s.logf("Invalid credentials for %q\n", name)
+	return s.write(sess, id, result(opBindResponse, resultInvalidCredentials, "invalid credentials"))
+}
+
+//inScope returns whether or not dn is within base with the given scope
+func inScope(dn, base string, scope int64) bool {
+	dn, base = normalizeDN(dn), normalizeDN(base)
+	switch scope {
+	case 0:
+		return dn == base
+	case 1:
+		i := strings.Index(dn, ",")
+		return i != -1 && dn[i+1:] == base
+	default:
+		return dn == base || base == "" || strings.HasSuffix(dn, ","+base)
+	}
+}
+
+//matches returns whether or not e matches filter
+func (e *Entry) matches(filter *ber.Packet) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, f := range filter.Children {
+			if !e.matches(f) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, f := range filter.Children {
+			if e.matches(f) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return len(filter.Children) == 1 && !e.matches(filter.Children[0])
+	case ldap.FilterEqualityMatch:
+		if len(filter.Children) != 2 {
+			return false
+		}
+		_, vals := e.values(stringValue(filter.Children[0]))
+		for _, v := range vals {
+			if strings.EqualFold(v, stringValue(filter.Children[1])) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterPresent:
+		name := stringValue(filter)
+		if strings.EqualFold(name, "objectClass") {
+			return true
+		}
+		_, vals := e.values(name)
+		return len(vals) > 0
+	}
+	//substrings, ordering, and approximate filters aren't supported
+	return false
+}
+
+//search handles a search request
+func (s *Server) search(sess *session, id int64, op *ber.Packet) error {
+	if sess.bound == nil {
+		return s.write(sess, id, result(opSearchDone, resultOperationsError, "a successful bind must be completed to perform this operation"))
+	}
+	if len(op.Children) < 8 {
+		return s.write(sess, id, result(opSearchDone, resultProtocolError, "invalid search request"))
+	}
+
+	base := stringValue(op.Children[0])
+	scope, _ := op.Children[1].Value.(int64)
+	filter := op.Children[6]
+
+	f, _ := ldap.DecompileFilter(filter)
+	s.logf("Search for %s in %q\n", f, base)
+	s.mu.Lock()
+	s.searches = append(s.searches, f)
+	s.mu.Unlock()
+
+	var attrs []string
+	for _, a := range op.Children[7].Children {
+		attrs = append(attrs, stringValue(a))
+	}
+
+	found := false
+	for _, e := range s.entries {
+		if !inScope(e.DN, base, scope) || !e.matches(filter) {
+			continue
+		}
+
+		entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, opSearchEntry, nil, "Search Result Entry")
+		entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.DN, "objectName"))
+		list := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attributes")
+		for name, vals := range e.Attributes {
+			if !wanted(attrs, name) || len(vals) == 0 {
+				continue
+			}
+			attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attribute")
+			attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "type"))
+			set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "vals")
+			for _, v := range vals {
+				set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "value"))
+			}
+			attr.AppendChild(set)
+			list.AppendChild(attr)
+		}
+		entry.AppendChild(list)
+
+		if err := s.write(sess, id, entry); err != nil {
+			return err
+		}
+	}
+
+	//the base may be a suffix without its own entry
+	for _, e := range s.entries {
+		if inScope(e.DN, base, 2) {
+			found = true
+		}
+	}
+	if !found {
+		return s.write(sess, id, result(opSearchDone, resultNoSuchObject, "no such object"))
+	}
+
+	return s.write(sess, id, result(opSearchDone, resultSuccess, ""))
+}
+
+//wanted returns whether or not the attribute name was requested
+func wanted(attrs []string, name string) bool {
+	if len(attrs) == 0 {
+		return true
+	}
+	for _, a := range attrs {
+		if a == "*" || strings.EqualFold(a, name) {
+			return true
+		}
+	}
+	return false
+}
+
+//extended handles an extended request. Only StartTLS is supported.
+func (s *Server) extended(sess *session, id int64, op *ber.Packet) error {
+	if len(op.Children) < 1 || stringValue(op.Children[0]) != oidStartTLS {
+		return s.write(sess, id, result(opExtendedResponse, resultProtocolError, "unsupported extended operation"))
+	}
+	if s.tlsConfig == nil {
+		return s.write(sess, id, result(opExtendedResponse, resultUnwillingToPerform, "TLS isn't configured"))
+	}
+	if _, ok := sess.conn.(*tls.Conn); ok {
+		return s.write(sess, id, result(opExtendedResponse, resultOperationsError, "TLS is already started"))
+	}
+
+	if err := s.write(sess, id, result(opExtendedResponse, resultSuccess, "")); err != nil {
+		return err
+	}
+
+	tc := tls.Server(sess.conn, s.tlsConfig)
+	if err := tc.Handshake(); err != nil {
+		return err
+	}
+	sess.conn = tc
+	s.logf("Started TLS for %v\n", tc.RemoteAddr())
+	return nil
+}
+
+//NewTLSConfig returns a tls.Config with a new self-signed certificate for the given hosts, and the certificate as PEM
+func NewTLSConfig(hosts []string) (*tls.Config, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "handbook-ldap"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	cert, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	config := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{cert}, PrivateKey: key}}}
+	return config, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), nil
+}
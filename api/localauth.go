@@ -0,0 +1,255 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+//passwordHashCost is the bcrypt cost for new hashes; existing hashes keep the cost they were created with
+const passwordHashCost = 12
+
+//ErrInvalidPasswordHash is returned when a password hash is malformed
+var ErrInvalidPasswordHash = errors.New("Invalid password hash")
+
+//ErrPasswordTooLong is returned when a password is too long to hash. bcrypt only uses the first 72 bytes.
+var ErrPasswordTooLong = errors.New("Password too long: must be at most 72 bytes")
+
+//HashPassword returns a salted bcrypt hash of password in the standard $2b$<cost>$<salt and hash> form
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), passwordHashCost)
+	if err == bcrypt.ErrPasswordTooLong {
+		return "", ErrPasswordTooLong
+	}
+	if err != nil {
+		return "", fmt.Errorf("Error hashing password: %v", err)
+	}
+	//bcrypt writes the older $2a$ prefix; $2a$ and $2b$ hashes are computed the same for passwords it accepts
+	return "$2b$" + strings.TrimPrefix(string(hash), "$2a$"), nil
+}
+
+//checkPassword returns whether or not password matches hash
+func checkPassword(hash, password string) (bool, error) {
+	if !strings.HasPrefix(hash, "$2a$") && !strings.HasPrefix(hash, "$2b$") && !strings.HasPrefix(hash, "$2y$") {
+		return false, ErrInvalidPasswordHash
+	}
+
+	//longer passwords would only be compared by their first 72 bytes
+	tooLong := len(password) > 72
+	if tooLong {
+		password = password[:72]
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	if err != nil {
+		return false, ErrInvalidPasswordHash
+	}
+
+	return !tooLong, nil
+}
+
+//LocalUser represents a user in a local users file
+type LocalUser struct {
+	Username   string
+	Hash       string //from HashPassword
+	EmployeeID string
+	FirstName  string
+	LastName   string
+	Admin      bool
+	Role       Role     `json:",omitempty"` //admin Role; default: superadmin
+	Campuses   []string `json:",omitempty"` //campuses a campus-scoped admin can see; empty for district-wide admins
+}
+
+//ReadLocalUsers returns the users in the local users file at path
+func ReadLocalUsers(path string) ([]*LocalUser, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []*LocalUser
+	if err = json.Unmarshal(buf, &users); err != nil {
+		return nil, fmt.Errorf("Error decoding users file: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, u := range users {
+		name := strings.ToLower(u.Username)
+		if name == "" {
+			return nil, errors.New("Invalid users file: user with empty Username")
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("Invalid users file: duplicate Username %s", u.Username)
+		}
+		seen[name] = true
+		if u.Role != "" && roleRanks[u.Role] == 0 {
+			return nil, fmt.Errorf("Invalid users file: invalid Role %s for %s", u.Role, u.Username)
+		}
+	}
+
+	return users, nil
+}
+
+//WriteLocalUsers replaces the local users file at path with users.
+//The file is replaced atomically and is only readable by its owner.
+func WriteLocalUsers(path string, users []*LocalUser) error {
+	if users == nil {
+		users = make([]*LocalUser, 0)
+	}
+	buf, err := json.MarshalIndent(users, "", "    ")
+	if err != nil {
+		return fmt.Errorf("Error encoding users file: %v", err)
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), ".users")
+	if err != nil {
+		return fmt.Errorf("Error creating temporary file: %v", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err = f.Write(append(buf, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("Error writing temporary file: %v", err)
+	}
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("Error writing temporary file: %v", err)
+	}
+
+	if err = os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("Error replacing users file: %v", err)
+	}
+
+	return nil
+}
+
+//LocalAuth represents an Auth that uses a local users file managed with WriteLocalUsers.
+//The file is reloaded when it changes, so users can be added or removed without a restart.
+type LocalAuth struct {
+	path string
+
+	mu      *sync.Mutex
+	users   map[string]*LocalUser
+	modTime time.Time
+	size    int64
+
+	//dummyHash is checked for unknown users so they take as long as known users
+	dummyHash string
+}
+
+//NewLocalAuth returns a new LocalAuth using the local users file at path
+func NewLocalAuth(path string) (*LocalAuth, error) {
+	dummy, err := HashPassword(randString(16))
+	if err != nil {
+		return nil, err
+	}
+
+	a := &LocalAuth{path: path, mu: new(sync.Mutex), dummyHash: dummy}
+	if _, err = a.user(""); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+//user returns the LocalUser with the given username, reloading the users file if it's changed.
+//If the user doesn't exist, user will be nil.
+func (a *LocalAuth) user(username string) (user *LocalUser, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	info, err := os.Stat(a.path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading users file: %v", err)
+	}
+
+	if a.users == nil || !info.ModTime().Equal(a.modTime) || info.Size() != a.size {
+		list, err := ReadLocalUsers(a.path)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading users file: %v", err)
+		}
+		users := make(map[string]*LocalUser)
+		for _, u := range list {
+			users[strings.ToLower(u.Username)] = u
+		}
+		a.users, a.modTime, a.size = users, info.ModTime(), info.Size()
+	}
+
+	return a.users[strings.ToLower(username)], nil
+}
+
+//login returns the LocalUser for the given username and password.
+//If the credentials are invalid, user will be nil.
+func (a *LocalAuth) login(username, password string) (user *LocalUser, err error) {
+	user, err = a.user(username)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := a.dummyHash
+	if user != nil {
+		hash = user.Hash
+	}
+
+	ok, err := checkPassword(hash, password)
+	if err != nil {
+		return nil, fmt.Errorf("Error checking password for %s: %v", username, err)
+	}
+	if !ok || user == nil {
+		return nil, nil
+	}
+
+	return user, nil
+}
+
+//Login returns whether or not the given username or password is valid.
+//If valid, user will be non-nil
+//If the backend malfunctions, user will be nil and error will be non-nil.
+func (a *LocalAuth) Login(username, password string) (user *User, err error) {
+	u, err := a.login(username, password)
+	if u == nil {
+		return nil, err
+	}
+
+	return &User{EmployeeID: u.EmployeeID, Username: u.Username, FirstName: u.FirstName, LastName: u.LastName}, nil
+}
+
+//AdminLogin returns whether or not the given username or password is valid admin login.
+//If valid, user will be non-nil
+//If the backend malfunctions, user will be nil and error will be non-nil.
+func (a *LocalAuth) AdminLogin(username, password string) (user *User, err error) {
+	u, err := a.login(username, password)
+	if u == nil || !u.Admin {
+		return nil, err
+	}
+
+	role := u.Role
+	if role == "" {
+		role = RoleSuperadmin
+	}
+
+	var campuses []string
+	if len(u.Campuses) > 0 {
+		campuses = u.Campuses
+	}
+
+	return &User{
+		EmployeeID: u.EmployeeID,
+		Username:   u.Username,
+		FirstName:  u.FirstName,
+		LastName:   u.LastName,
+		Admin:      true,
+		Role:       role,
+		Campuses:   campuses,
+	}, nil
+}
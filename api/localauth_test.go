@@ -0,0 +1,58 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatalf("Error hashing password: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$2b$12$") {
+		t.Errorf("Expected $2b$ hash with cost 12, got %s", hash)
+	}
+	if ok, err := checkPassword(hash, "correct horse"); err != nil || !ok {
+		t.Errorf("Expected password to match its hash, got %v, %v", ok, err)
+	}
+
+	if _, err = HashPassword(strings.Repeat("a", 73)); err != ErrPasswordTooLong {
+		t.Errorf("Expected %v for a 73 byte password, got %v", ErrPasswordTooLong, err)
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	max := strings.Repeat("a", 72)
+	buf, err := bcrypt.GenerateFromPassword([]byte(max), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("Error hashing password: %v", err)
+	}
+	hash := string(buf)
+
+	tests := []struct {
+		name     string
+		hash     string
+		password string
+		expected bool
+		err      error
+	}{
+		{"72 bytes", hash, max, true, nil},
+		{"$2b$ prefix", "$2b$" + strings.TrimPrefix(hash, "$2a$"), max, true, nil},
+		{"$2y$ prefix", "$2y$" + strings.TrimPrefix(hash, "$2a$"), max, true, nil},
+		{"73 bytes sharing the first 72", hash, max + "a", false, nil},
+		{"71 bytes", hash, max[:71], false, nil},
+		{"wrong password", hash, "b" + max[1:], false, nil},
+		{"unsupported hash", "$1$abc$def", max, false, ErrInvalidPasswordHash},
+		{"truncated hash", hash[:20], max, false, ErrInvalidPasswordHash},
+	}
+
+	for _, test := range tests {
+		ok, err := checkPassword(test.hash, test.password)
+		if ok != test.expected || err != test.err {
+			t.Errorf("%s: expected %v, %v, got %v, %v", test.name, test.expected, test.err, ok, err)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+//Mailer is an interface for an arbitrary email delivery backend
+type Mailer interface {
+	//Send sends a plain text email with the given subject and body to the given address
+	Send(to, subject, body string) error
+}
+
+//SMTPMailer represents a Mailer that delivers to an SMTP server.
+//STARTTLS is used if the server supports it.
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+//NewSMTPMailer returns a new SMTPMailer that sends from the given address through the SMTP server at addr (host:port).
+//If username is non-empty, PLAIN authentication is used, which requires TLS unless the server is localhost.
+func NewSMTPMailer(addr, from, username, password string) *SMTPMailer {
+	m := &SMTPMailer{addr: addr, from: from}
+	if username != "" {
+		host := addr
+		if i := strings.LastIndex(addr, ":"); i != -1 {
+			host = addr[:i]
+		}
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+//headerSafe removes characters that could start a new header
+var headerSafe = strings.NewReplacer("\r", "", "\n", "")
+
+//Send sends a plain text email with the given subject and body to the given address
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	to = headerSafe.Replace(to)
+	msg := "From: " + headerSafe.Replace(m.from) + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + mime.QEncoding.Encode("utf-8", headerSafe.Replace(subject)) + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" +
+		strings.Replace(body, "\n", "\r\n", -1)
+
+	if err := smtp.SendMail(m.addr, m.auth, m.from, []string{to}, []byte(msg)); err != nil {
+		return fmt.Errorf("Error sending email: %v", err)
+	}
+	return nil
+}
+
+//magicLinkCooldown is how long a staff member must wait before another link is sent
+const magicLinkCooldown = time.Minute
+
+//magicReauthDuration is how long a re-authentication link is valid for
+const magicReauthDuration = 5 * time.Minute
+
+//ErrNoEmail is returned when a re-authentication link is requested for a staff member without an email address
+var ErrNoEmail = errors.New("No email address on file")
+
+//magicLink represents an emailed login link waiting to be used
+type magicLink struct {
+	EmployeeID string
+}
+
+//MagicLinkAuth represents a passwordless login where staff members found in the StaffDB
+//by email address or employee ID are emailed a single-use, time-limited login link.
+//It's meant for staff without directory accounts, and never allows admin logins.
+type MagicLinkAuth struct {
+	staffDB  StaffDB
+	mailer   Mailer
+	state    StateStore //links are keyed by the SHA-256 hash of the link token, and cooldowns by employee ID
+	appURL   string
+	duration time.Duration
+}
+
+//NewMagicLinkAuth returns a new MagicLinkAuth that looks up staff in staffDB, sends links with mailer, and keeps links in state.
+//appURL is the full URL of the client app, and links are valid for duration.
+func NewMagicLinkAuth(staffDB StaffDB, mailer Mailer, state StateStore, appURL string, duration time.Duration) *MagicLinkAuth {
+	if !strings.HasSuffix(appURL, "/") {
+		appURL += "/"
+	}
+	return &MagicLinkAuth{
+		staffDB:  staffDB,
+		mailer:   mailer,
+		state:    state,
+		appURL:   appURL,
+		duration: duration,
+	}
+}
+
+//hashToken returns the key a token is stored with, so tokens can't be recovered from memory or the database
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+//coolDown starts the cooldown for sending the staff member with the given employee ID another link.
+//If they were sent one too recently, cooling will be true.
+func (a *MagicLinkAuth) coolDown(employeeID string) (cooling bool, err error) {
+	err = a.state.Update("magic_link_sent", []string{employeeID}, func(entries []*StateEntry) error {
+		cooling = entries[0] != nil
+		if !cooling {
+			entries[0] = &StateEntry{Value: []byte("{}"), Expires: time.Now().Add(magicLinkCooldown)}
+		}
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("Error checking magic link cooldown: %v", err)
+	}
+	return cooling, nil
+}
+
+//Send emails a login link to the staff member with the given email address or employee ID.
+//To avoid revealing who is on staff, no error is returned if the staff member isn't found,
+//doesn't have an email address, or was sent a link too recently.
+func (a *MagicLinkAuth) Send(identifier string) error {
+	identifier = strings.TrimSpace(identifier)
+	if identifier == "" {
+		return nil
+	}
+
+	list, err := a.staffDB.List()
+	if err != nil {
+		return fmt.Errorf("Error listing staff: %v", err)
+	}
+
+	var staff *StaffMember
+	for _, s := range list {
+		if strings.EqualFold(s.EmployeeID, identifier) || (s.Email != "" && strings.EqualFold(s.Email, identifier)) {
+			staff = s
+			break
+		}
+	}
+	if staff == nil {
+		return nil
+	}
+	if staff.Email == "" {
+		log.Printf("Magic link requested for %s without an email address\n", staff.EmployeeID)
+		return nil
+	}
+
+	cooling, err := a.coolDown(staff.EmployeeID)
+	if err != nil {
+		return err
+	}
+	if cooling {
+		return nil
+	}
+
+	token := randString(48)
+	if err = putState(a.state, "magic_link", hashToken(token), &magicLink{EmployeeID: staff.EmployeeID}, time.Now().Add(a.duration)); err != nil {
+		return fmt.Errorf("Error storing magic link: %v", err)
+	}
+
+	body := fmt.Sprintf("Hello %s,\n\n"+
+		"Use the link below to sign in and sign the employee handbook. "+
+		"The link can only be used once and expires in %d minutes.\n\n"+
+		"%s#/magic/%s\n\n"+
+		"If you didn't ask for this link, you can ignore this email.\n",
+		staff.FirstName, int(a.duration/time.Minute), a.appURL, token)
+
+	return a.mailer.Send(staff.Email, "Employee Handbook Sign-In Link", body)
+}
+
+//SendReauth emails a link to the staff member with the given employee ID that re-confirms their identity before signing.
+//It's sent even if they didn't sign in with a link, so any staff member with an email address can re-authenticate.
+//If they were sent a link too recently, sent will be false.
+func (a *MagicLinkAuth) SendReauth(employeeID string) (sent bool, err error) {
+	staff, err := a.staffDB.Get(employeeID)
+	if err != nil {
+		return false, fmt.Errorf("Error getting staff member: %v", err)
+	}
+	if staff == nil || staff.Email == "" {
+		return false, ErrNoEmail
+	}
+
+	cooling, err := a.coolDown(staff.EmployeeID)
+	if err != nil {
+		return false, err
+	}
+	if cooling {
+		return false, nil
+	}
+
+	token := randString(48)
+	if err = putState(a.state, "magic_reauth", hashToken(token), &magicLink{EmployeeID: staff.EmployeeID}, time.Now().Add(magicReauthDuration)); err != nil {
+		return false, fmt.Errorf("Error storing magic link: %v", err)
+	}
+
+	body := fmt.Sprintf("Hello %s,\n\n"+
+		"Use the link below to confirm it's you and finish signing the employee handbook. "+
+		"Open it in the browser you're signing in. "+
+		"The link can only be used once and expires in %d minutes.\n\n"+
+		"%s#/form?reauth=%s\n\n"+
+		"If you aren't signing the handbook right now, you can ignore this email.\n",
+		staff.FirstName, int(magicReauthDuration/time.Minute), a.appURL, token)
+
+	if err = a.mailer.Send(staff.Email, "Employee Handbook Signature Confirmation", body); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+//Claim returns the User for the given link token.
+//If the token is unknown, already used, or expired, user will be nil.
+func (a *MagicLinkAuth) Claim(token string) (user *User, err error) {
+	return a.claim("magic_link", token)
+}
+
+//ClaimReauth returns the User for the given token from a SendReauth link.
+//If the token is unknown, already used, or expired, user will be nil.
+func (a *MagicLinkAuth) ClaimReauth(token string) (user *User, err error) {
+	return a.claim("magic_reauth", token)
+}
+
+//claim returns the User for the given token of the given kind
+func (a *MagicLinkAuth) claim(kind, token string) (user *User, err error) {
+	l := new(magicLink)
+	ok, err := takeState(a.state, kind, hashToken(token), l)
+	if err != nil {
+		return nil, fmt.Errorf("Error claiming magic link: %v", err)
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	//get current information in case the staff member was removed since the link was sent
+	staff, err := a.staffDB.Get(l.EmployeeID)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting staff member: %v", err)
+	}
+	if staff == nil {
+		return nil, nil
+	}
+
+	username := staff.Email
+	if username == "" {
+		username = staff.EmployeeID
+	}
+
+	return &User{EmployeeID: staff.EmployeeID, Username: username, FirstName: staff.FirstName, LastName: staff.LastName}, nil
+}
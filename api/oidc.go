@@ -0,0 +1,584 @@
+package api
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512" //SHA-384 and SHA-512 for RS384, RS512, ES384, and ES512
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+//ErrInvalidIDToken is returned when an ID Token fails verification
+var ErrInvalidIDToken = errors.New("Invalid ID Token")
+
+//oidcStateDuration is how long a login has to complete at the identity provider
+const oidcStateDuration = 10 * time.Minute
+
+//oidcClockSkew is the allowed difference between the identity provider's and the server's clocks
+const oidcClockSkew = 2 * time.Minute
+
+//oidcReauthDuration is how long a re-authentication is valid for, both since the user authenticated
+//at the identity provider and until the client uses it to sign
+const oidcReauthDuration = 5 * time.Minute
+
+//OIDCConfig represents the configuration of an OpenID Connect relying party
+type OIDCConfig struct {
+	Issuer       string //issuer URL; the provider configuration is discovered from Issuer + "/.well-known/openid-configuration"
+	ClientID     string
+	ClientSecret string //optional for public clients
+	RedirectURL  string //URL of the callback endpoint registered with the identity provider
+
+	EmployeeIDClaim string //claim mapped to User.EmployeeID; default: employee_id
+	UsernameClaim   string //claim mapped to User.Username; default: preferred_username
+	GroupsClaim     string //claim listing the user's groups; default: groups
+
+	Group  string       //if non-empty, only members of Group can log in
+	Admins *AdminGroups //groups mapped to admin Roles and campuses
+
+	Timeout time.Duration
+}
+
+//oidcProvider represents an identity provider's discovered configuration
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+//oidcPending represents a login waiting on the identity provider
+type oidcPending struct {
+	Verifier string
+	Nonce    string
+	Admin    bool
+	Reauth   bool //if the login re-confirms the identity of a user who is signing
+}
+
+//OIDCAuth represents an Auth that uses an OpenID Connect identity provider with the authorization code flow and PKCE.
+//Users are redirected to the identity provider instead of sending a password,
+//so Login and AdminLogin always fail; use AuthCodeURL and Exchange instead.
+type OIDCAuth struct {
+	config *OIDCConfig
+	client *http.Client
+
+	mu       *sync.Mutex
+	provider *oidcProvider
+	keys     map[string]crypto.PublicKey
+	state    StateStore
+	handoffs *loginHandoffs
+	reauths  *loginHandoffs
+}
+
+//NewOIDCAuth returns a new OIDCAuth with the given config that keeps pending logins in state.
+//The identity provider's configuration is discovered on first use.
+func NewOIDCAuth(config *OIDCConfig, state StateStore) *OIDCAuth {
+	if config.EmployeeIDClaim == "" {
+		config.EmployeeIDClaim = "employee_id"
+	}
+	if config.UsernameClaim == "" {
+		config.UsernameClaim = "preferred_username"
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+	if config.Admins == nil {
+		config.Admins = &AdminGroups{}
+	}
+
+	return &OIDCAuth{
+		config:   config,
+		client:   &http.Client{Timeout: config.Timeout},
+		mu:       new(sync.Mutex),
+		keys:     make(map[string]crypto.PublicKey),
+		state:    state,
+		handoffs: newLoginHandoffs(state, "oidc_handoff", handoffDuration),
+		reauths:  newLoginHandoffs(state, "oidc_reauth", oidcReauthDuration),
+	}
+}
+
+//Login always returns a nil user since OIDCAuth doesn't accept passwords
+func (a *OIDCAuth) Login(username, password string) (user *User, err error) {
+	return nil, nil
+}
+
+//AdminLogin always returns a nil user since OIDCAuth doesn't accept passwords
+func (a *OIDCAuth) AdminLogin(username, password string) (user *User, err error) {
+	return nil, nil
+}
+
+//getJSON decodes the JSON response from a GET request to u into v
+func (a *OIDCAuth) getJSON(u string, v interface{}) error {
+	resp, err := a.client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected status from %s: %s", u, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+//discover returns the identity provider's configuration, fetching it if it hasn't been yet
+func (a *OIDCAuth) discover() (*oidcProvider, error) {
+	a.mu.Lock()
+	p := a.provider
+	a.mu.Unlock()
+	if p != nil {
+		return p, nil
+	}
+
+	p = new(oidcProvider)
+	if err := a.getJSON(strings.TrimSuffix(a.config.Issuer, "/")+"/.well-known/openid-configuration", p); err != nil {
+		return nil, fmt.Errorf("Error discovering provider configuration: %v", err)
+	}
+	if p.Issuer != a.config.Issuer {
+		return nil, fmt.Errorf("Provider issuer %s doesn't match configured issuer %s", p.Issuer, a.config.Issuer)
+	}
+	if p.AuthorizationEndpoint == "" || p.TokenEndpoint == "" || p.JWKSURI == "" {
+		return nil, errors.New("Provider configuration missing endpoints")
+	}
+
+	a.mu.Lock()
+	a.provider = p
+	a.mu.Unlock()
+
+	return p, nil
+}
+
+//jwk represents a JSON Web Key
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+//publicKey returns the public key k represents
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	b64 := base64.RawURLEncoding
+	switch k.Kty {
+	case "RSA":
+		n, err := b64.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("Error decoding modulus: %v", err)
+		}
+		e, err := b64.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("Invalid exponent")
+		}
+		exp := 0
+		for _, b := range e {
+			exp = exp<<8 | int(b)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("Unsupported curve: %s", k.Crv)
+		}
+		x, err := b64.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("Error decoding x: %v", err)
+		}
+		y, err := b64.DecodeString(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("Error decoding y: %v", err)
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("Point not on curve")
+		}
+		return pub, nil
+	default:
+		return nil, fmt.Errorf("Unsupported key type: %s", k.Kty)
+	}
+}
+
+//key returns the identity provider's signing key with the given ID.
+//If the key isn't known, the provider's keys are refetched in case they were rotated.
+func (a *OIDCAuth) key(p *oidcProvider, kid string) (crypto.PublicKey, error) {
+	a.mu.Lock()
+	k, ok := a.keys[kid]
+	a.mu.Unlock()
+	if ok {
+		return k, nil
+	}
+
+	var set struct {
+		Keys []*jwk `json:"keys"`
+	}
+	if err := a.getJSON(p.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("Error getting provider keys: %v", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, j := range set.Keys {
+		if j.Use != "" && j.Use != "sig" {
+			continue
+		}
+		pub, err := j.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[j.Kid] = pub
+	}
+
+	a.mu.Lock()
+	a.keys = keys
+	a.mu.Unlock()
+
+	if k, ok = keys[kid]; !ok {
+		return nil, fmt.Errorf("Unknown signing key: %q", kid)
+	}
+	return k, nil
+}
+
+//verifySignature verifies the JWS signature sig over signed with the given algorithm and key
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("Unsupported algorithm: %s", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch alg[:2] {
+	case "RS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("Key type doesn't match algorithm")
+		}
+		return rsa.VerifyPKCS1v15(pub, hash, digest, sig)
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("Key type doesn't match algorithm")
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("Invalid signature length")
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("Signature verification failed")
+		}
+		return nil
+	default:
+		return fmt.Errorf("Unsupported algorithm: %s", alg)
+	}
+}
+
+//claimString returns the given claim as a string, or an empty string if it isn't a string or number
+func claimString(claims map[string]interface{}, name string) string {
+	switch v := claims[name].(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	}
+	return ""
+}
+
+//claimStrings returns the given claim as a list of strings
+func claimStrings(claims map[string]interface{}, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var list []string
+		for _, s := range v {
+			if str, ok := s.(string); ok {
+				list = append(list, str)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+//claimTime returns the given NumericDate claim as a time.Time, or the zero time if it's missing or invalid
+func claimTime(claims map[string]interface{}, name string) time.Time {
+	n, ok := claims[name].(json.Number)
+	if !ok {
+		return time.Time{}
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(int64(f), 0)
+}
+
+//verifyIDToken verifies the given ID Token's signature and standard claims and returns its claims
+func (a *OIDCAuth) verifyIDToken(p *oidcProvider, token, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%v: malformed token", ErrInvalidIDToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%v: malformed header", ErrInvalidIDToken)
+	}
+	if err = json.Unmarshal(buf, &header); err != nil {
+		return nil, fmt.Errorf("%v: malformed header", ErrInvalidIDToken)
+	}
+	if len(header.Alg) != 5 {
+		return nil, fmt.Errorf("%v: unsupported algorithm %q", ErrInvalidIDToken, header.Alg)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%v: malformed signature", ErrInvalidIDToken)
+	}
+
+	key, err := a.key(p, header.Kid)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", ErrInvalidIDToken, err)
+	}
+
+	if err = verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, fmt.Errorf("%v: %v", ErrInvalidIDToken, err)
+	}
+
+	buf, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%v: malformed claims", ErrInvalidIDToken)
+	}
+	claims := make(map[string]interface{})
+	d := json.NewDecoder(bytes.NewReader(buf))
+	d.UseNumber()
+	if err = d.Decode(&claims); err != nil {
+		return nil, fmt.Errorf("%v: malformed claims", ErrInvalidIDToken)
+	}
+
+	if iss := claimString(claims, "iss"); iss != p.Issuer {
+		return nil, fmt.Errorf("%v: unexpected issuer %q", ErrInvalidIDToken, iss)
+	}
+
+	aud := claimStrings(claims, "aud")
+	found := false
+	for _, s := range aud {
+		if s == a.config.ClientID {
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("%v: not issued for this client", ErrInvalidIDToken)
+	}
+	if azp := claimString(claims, "azp"); len(aud) > 1 && azp != a.config.ClientID {
+		return nil, fmt.Errorf("%v: unexpected authorized party %q", ErrInvalidIDToken, azp)
+	}
+
+	now := time.Now()
+	exp := claimTime(claims, "exp")
+	if exp.IsZero() || now.After(exp.Add(oidcClockSkew)) {
+		return nil, fmt.Errorf("%v: expired", ErrInvalidIDToken)
+	}
+	if iat := claimTime(claims, "iat"); iat.IsZero() || iat.After(now.Add(oidcClockSkew)) {
+		return nil, fmt.Errorf("%v: invalid issue time", ErrInvalidIDToken)
+	}
+
+	if claimString(claims, "nonce") != nonce {
+		return nil, fmt.Errorf("%v: nonce mismatch", ErrInvalidIDToken)
+	}
+
+	if claimString(claims, "sub") == "" {
+		return nil, fmt.Errorf("%v: missing subject", ErrInvalidIDToken)
+	}
+
+	return claims, nil
+}
+
+//AuthCodeURL returns the identity provider URL to redirect the user to for login.
+//If admin is true, the login must be a valid admin login.
+func (a *OIDCAuth) AuthCodeURL(admin bool) (string, error) {
+	return a.authCodeURL(admin, false)
+}
+
+//ReauthCodeURL returns the identity provider URL to redirect a user to so they re-confirm their identity before signing.
+//The identity provider is asked to authenticate the user again even if they have a session there.
+func (a *OIDCAuth) ReauthCodeURL() (string, error) {
+	return a.authCodeURL(false, true)
+}
+
+//authCodeURL returns the identity provider URL for a login with the given admin and reauth modes
+func (a *OIDCAuth) authCodeURL(admin, reauth bool) (string, error) {
+	p, err := a.discover()
+	if err != nil {
+		return "", err
+	}
+
+	state, nonce, verifier := randString(32), randString(32), randString(64)
+	challenge := sha256.Sum256([]byte(verifier))
+
+	pending := &oidcPending{Verifier: verifier, Nonce: nonce, Admin: admin, Reauth: reauth}
+	if err = putState(a.state, "oidc_pending", state, pending, time.Now().Add(oidcStateDuration)); err != nil {
+		return "", fmt.Errorf("Error storing login state: %v", err)
+	}
+
+	u, err := url.Parse(p.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("Error parsing authorization endpoint: %v", err)
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", a.config.ClientID)
+	q.Set("redirect_uri", a.config.RedirectURL)
+	q.Set("scope", "openid profile")
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	q.Set("code_challenge_method", "S256")
+	if reauth {
+		q.Set("prompt", "login")
+		q.Set("max_age", "0")
+	}
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+//Exchange exchanges the authorization code from the identity provider's redirect for the logged in User.
+//admin is whether the login was started as an admin login, and reauth whether it was started with ReauthCodeURL.
+//If the login is valid, user will be non-nil.
+//If the state is unknown or the code or ID Token is invalid, user will be nil and error will be non-nil.
+func (a *OIDCAuth) Exchange(state, code string) (user *User, admin, reauth bool, err error) {
+	pending := new(oidcPending)
+	ok, err := takeState(a.state, "oidc_pending", state, pending)
+	if err != nil {
+		return nil, false, false, fmt.Errorf("Error loading login state: %v", err)
+	}
+	if !ok {
+		return nil, false, false, errors.New("Unknown or expired login state")
+	}
+	user, err = a.exchange(pending, code)
+	return user, pending.Admin, pending.Reauth, err
+}
+
+//exchange exchanges the authorization code for the User logged in by the given pending login
+func (a *OIDCAuth) exchange(pending *oidcPending, code string) (user *User, err error) {
+
+	p, err := a.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", a.config.RedirectURL)
+	form.Set("code_verifier", pending.Verifier)
+	form.Set("client_id", a.config.ClientID)
+
+	req, err := http.NewRequest("POST", p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("Error creating token request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if a.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(a.config.ClientID), url.QueryEscape(a.config.ClientSecret))
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Error requesting token: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var tResp struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&tResp); err != nil {
+		return nil, fmt.Errorf("Error decoding token response (%s): %v", resp.Status, err)
+	}
+	if tResp.Error != "" {
+		return nil, fmt.Errorf("Token error: %s: %s", tResp.Error, tResp.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK || tResp.IDToken == "" {
+		return nil, fmt.Errorf("Unexpected token response: %s", resp.Status)
+	}
+
+	claims, err := a.verifyIDToken(p, tResp.IDToken, pending.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	if pending.Reauth {
+		//max_age=0 requires the identity provider to return auth_time
+		authTime := claimTime(claims, "auth_time")
+		if authTime.IsZero() || time.Since(authTime) > oidcReauthDuration+oidcClockSkew {
+			return nil, fmt.Errorf("%v: user was not re-authenticated", ErrInvalidIDToken)
+		}
+	}
+
+	username := claimString(claims, a.config.UsernameClaim)
+	if username == "" {
+		username = claimString(claims, "sub")
+	}
+	groups := claimStrings(claims, a.config.GroupsClaim)
+
+	if pending.Admin {
+		user = a.config.Admins.User(username, groups)
+	} else {
+		user = &User{Username: username}
+		if a.config.Group != "" {
+			user = nil
+			for _, g := range groups {
+				if matchGroup(a.config.Group, g) {
+					user = &User{Username: username}
+				}
+			}
+		}
+	}
+	if user == nil {
+		return nil, nil
+	}
+
+	user.EmployeeID = claimString(claims, a.config.EmployeeIDClaim)
+	user.FirstName = claimString(claims, "given_name")
+	user.LastName = claimString(claims, "family_name")
+
+	return user, nil
+}
@@ -0,0 +1,327 @@
+package api
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//testOIDCCode represents an authorization code issued by testOP
+type testOIDCCode struct {
+	challenge string
+	idToken   string
+}
+
+//testOP represents an OpenID Connect provider for testing OIDCAuth.
+//Codes are issued by login instead of through the authorization endpoint.
+type testOP struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+	kid string
+
+	mu    *sync.Mutex
+	codes map[string]*testOIDCCode
+}
+
+//newTestOP starts a new testOP. The caller must Close it.
+func newTestOP(t *testing.T) *testOP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	op := &testOP{key: key, kid: "test-key", mu: new(sync.Mutex), codes: make(map[string]*testOIDCCode)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 op.URL,
+			"authorization_endpoint": op.URL + "/authorize",
+			"token_endpoint":         op.URL + "/token",
+			"jwks_uri":               op.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		b64 := base64.RawURLEncoding
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": op.kid,
+			"use": "sig",
+			"n":   b64.EncodeToString(op.key.N.Bytes()),
+			"e":   b64.EncodeToString(big.NewInt(int64(op.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", op.token)
+	op.Server = httptest.NewServer(mux)
+
+	return op
+}
+
+//token exchanges a code for its ID Token if the PKCE verifier matches its challenge
+func (op *testOP) token(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	r.ParseForm()
+
+	op.mu.Lock()
+	code, ok := op.codes[r.PostForm.Get("code")]
+	delete(op.codes, r.PostForm.Get("code"))
+	op.mu.Unlock()
+
+	h := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	switch {
+	case !ok:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": "unknown code"})
+	case base64.RawURLEncoding.EncodeToString(h[:]) != code.challenge:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": "code_verifier doesn't match code_challenge"})
+	default:
+		json.NewEncoder(w).Encode(map[string]string{"id_token": code.idToken, "token_type": "Bearer"})
+	}
+}
+
+//sign returns a JWT with the given claims and kid header, signed by key with RS256
+func (op *testOP) sign(t *testing.T, key *rsa.PrivateKey, claims map[string]interface{}, kid string) string {
+	b64 := base64.RawURLEncoding
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	if err != nil {
+		t.Fatalf("Error encoding header: %v", err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("Error encoding claims: %v", err)
+	}
+	signed := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("Error signing token: %v", err)
+	}
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+//testOIDCLogin represents the changes a test makes to a login
+type testOIDCLogin struct {
+	claims    map[string]interface{} //claims added to or replacing the defaults; nil values remove the claim
+	kid       string                 //kid of the ID Token; default: the provider's key
+	key       *rsa.PrivateKey        //key the ID Token is signed with; default: the provider's key
+	challenge string                 //PKCE challenge the provider checks; default: the one from AuthCodeURL
+	state     string                 //state passed to Exchange; default: the one from AuthCodeURL
+}
+
+//login starts a login with a, has the provider issue a code for it, and exchanges the code
+func (op *testOP) login(t *testing.T, a *OIDCAuth, admin bool, l *testOIDCLogin) (*User, error) {
+	u, err := a.AuthCodeURL(admin)
+	if err != nil {
+		t.Fatalf("Error starting login: %v", err)
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		t.Fatalf("Error parsing authorization URL: %v", err)
+	}
+	q := parsed.Query()
+	if q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("Expected S256 code challenge, got %q", q.Get("code_challenge_method"))
+	}
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":                op.URL,
+		"aud":                "handbook",
+		"sub":                "24400320",
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"iat":                now.Unix(),
+		"nonce":              q.Get("nonce"),
+		"preferred_username": "jdoe",
+		"given_name":         "Jane",
+		"family_name":        "Doe",
+		"employee_id":        "123",
+		"groups":             []string{"Staff"},
+	}
+	for k, v := range l.claims {
+		if v == nil {
+			delete(claims, k)
+		} else {
+			claims[k] = v
+		}
+	}
+
+	kid, key, challenge, state := op.kid, op.key, q.Get("code_challenge"), q.Get("state")
+	if l.kid != "" {
+		kid = l.kid
+	}
+	if l.key != nil {
+		key = l.key
+	}
+	if l.challenge != "" {
+		challenge = l.challenge
+	}
+	if l.state != "" {
+		state = l.state
+	}
+
+	code := randString(32)
+	op.mu.Lock()
+	op.codes[code] = &testOIDCCode{challenge: challenge, idToken: op.sign(t, key, claims, kid)}
+	op.mu.Unlock()
+
+	user, _, _, err := a.Exchange(state, code)
+	return user, err
+}
+
+//newTestOIDCAuth returns a new OIDCAuth for op that only allows members of Staff to log in
+func newTestOIDCAuth(op *testOP) *OIDCAuth {
+	return NewOIDCAuth(&OIDCConfig{
+		Issuer:      op.URL,
+		ClientID:    "handbook",
+		RedirectURL: "https://handbook.example.com/api/1.0/oidc/callback",
+		Group:       "Staff",
+		Admins: &AdminGroups{
+			Admin:    "Handbook Admins",
+			Roles:    map[string]Role{"Handbook Viewers": RoleViewer},
+			Campuses: map[string][]string{"HS Principals": {"High School"}},
+		},
+		Timeout: 5 * time.Second,
+	}, NewMemoryStateStore())
+}
+
+func TestOIDCLogin(t *testing.T) {
+	op := newTestOP(t)
+	defer op.Close()
+	a := newTestOIDCAuth(op)
+
+	user, err := op.login(t, a, false, &testOIDCLogin{})
+	if err != nil {
+		t.Fatalf("Error logging in: %v", err)
+	}
+	expected := &User{EmployeeID: "123", Username: "jdoe", FirstName: "Jane", LastName: "Doe"}
+	if !reflect.DeepEqual(user, expected) {
+		t.Errorf("Expected user %+v, got %+v", expected, user)
+	}
+}
+
+func TestOIDCLoginRejected(t *testing.T) {
+	op := newTestOP(t)
+	defer op.Close()
+	a := newTestOIDCAuth(op)
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	wrongChallenge := sha256.Sum256([]byte("another login's verifier"))
+
+	tests := []struct {
+		name  string
+		login *testOIDCLogin
+		err   string
+	}{
+		{"PKCE verifier mismatch", &testOIDCLogin{challenge: base64.RawURLEncoding.EncodeToString(wrongChallenge[:])}, "code_verifier doesn't match"},
+		{"unknown state", &testOIDCLogin{state: "forged"}, "Unknown or expired login state"},
+		{"wrong nonce", &testOIDCLogin{claims: map[string]interface{}{"nonce": "replayed"}}, "nonce mismatch"},
+		{"missing nonce", &testOIDCLogin{claims: map[string]interface{}{"nonce": nil}}, "nonce mismatch"},
+		{"wrong audience", &testOIDCLogin{claims: map[string]interface{}{"aud": "another-client"}}, "not issued for this client"},
+		{"wrong authorized party", &testOIDCLogin{claims: map[string]interface{}{"aud": []string{"handbook", "another-client"}, "azp": "another-client"}}, "unexpected authorized party"},
+		{"wrong issuer", &testOIDCLogin{claims: map[string]interface{}{"iss": "https://evil.example.com"}}, "unexpected issuer"},
+		{"expired", &testOIDCLogin{claims: map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}}, "expired"},
+		{"issued in the future", &testOIDCLogin{claims: map[string]interface{}{"iat": time.Now().Add(time.Hour).Unix()}}, "invalid issue time"},
+		{"unknown kid", &testOIDCLogin{kid: "rotated-away"}, "Unknown signing key"},
+	}
+
+	for _, test := range tests {
+		user, err := op.login(t, a, false, test.login)
+		if user != nil {
+			t.Errorf("%s: expected no user, got %+v", test.name, user)
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing %q, got %v", test.name, test.err, err)
+		}
+	}
+
+	//a token signed by another key with the provider's kid
+	user, err := op.login(t, a, false, &testOIDCLogin{key: other})
+	if user != nil || err == nil || !strings.Contains(err.Error(), "verification") {
+		t.Errorf("forged signature: expected verification error, got %+v, %v", user, err)
+	}
+}
+
+func TestOIDCStateSingleUse(t *testing.T) {
+	op := newTestOP(t)
+	defer op.Close()
+	a := newTestOIDCAuth(op)
+
+	u, err := a.AuthCodeURL(false)
+	if err != nil {
+		t.Fatalf("Error starting login: %v", err)
+	}
+	parsed, _ := url.Parse(u)
+	state := parsed.Query().Get("state")
+
+	//the first exchange fails at the provider, but still uses the state
+	if _, _, _, err = a.Exchange(state, "unknown"); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Fatalf("Expected invalid_grant error, got %v", err)
+	}
+	if _, _, _, err = a.Exchange(state, "unknown"); err == nil || !strings.Contains(err.Error(), "Unknown or expired login state") {
+		t.Errorf("Expected reused state to be rejected, got %v", err)
+	}
+}
+
+func TestOIDCClaimMapping(t *testing.T) {
+	op := newTestOP(t)
+	defer op.Close()
+	a := newTestOIDCAuth(op)
+
+	tests := []struct {
+		name     string
+		admin    bool
+		claims   map[string]interface{}
+		expected *User
+	}{
+		{"not in group", false, map[string]interface{}{"groups": []string{"Students"}}, nil},
+		{"group matched case-insensitively", false, map[string]interface{}{"groups": "staff"},
+			&User{EmployeeID: "123", Username: "jdoe", FirstName: "Jane", LastName: "Doe"}},
+		{"username defaults to subject", false, map[string]interface{}{"preferred_username": nil},
+			&User{EmployeeID: "123", Username: "24400320", FirstName: "Jane", LastName: "Doe"}},
+		{"numeric employee ID", false, map[string]interface{}{"employee_id": 456},
+			&User{EmployeeID: "456", Username: "jdoe", FirstName: "Jane", LastName: "Doe"}},
+		{"staff as admin", true, nil, nil},
+		{"superadmin", true, map[string]interface{}{"groups": []string{"Staff", "Handbook Admins"}},
+			&User{EmployeeID: "123", Username: "jdoe", FirstName: "Jane", LastName: "Doe", Admin: true, Role: RoleSuperadmin}},
+		{"viewer", true, map[string]interface{}{"groups": []string{"Handbook Viewers"}},
+			&User{EmployeeID: "123", Username: "jdoe", FirstName: "Jane", LastName: "Doe", Admin: true, Role: RoleViewer}},
+		{"campus viewer", true, map[string]interface{}{"groups": []string{"HS Principals"}},
+			&User{EmployeeID: "123", Username: "jdoe", FirstName: "Jane", LastName: "Doe", Admin: true, Role: RoleViewer, Campuses: []string{"High School"}}},
+	}
+
+	for _, test := range tests {
+		user, err := op.login(t, a, test.admin, &testOIDCLogin{claims: test.claims})
+		if err != nil {
+			t.Errorf("%s: error logging in: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(user, test.expected) {
+			t.Errorf("%s: expected user %+v, got %+v", test.name, test.expected, user)
+		}
+	}
+
+	//a configured claim replaces employee_id
+	a.config.EmployeeIDClaim = "employeeNumber"
+	user, err := op.login(t, a, false, &testOIDCLogin{claims: map[string]interface{}{"employeeNumber": "789"}})
+	if err != nil {
+		t.Fatalf("Error logging in: %v", err)
+	}
+	if user == nil || user.EmployeeID != "789" {
+		t.Errorf("Expected EmployeeID from employeeNumber claim, got %+v", user)
+	}
+}
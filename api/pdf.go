@@ -0,0 +1,313 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+//pdfLine is a line of text on a generated PDF page
+type pdfLine struct {
+	Text string
+	Size float64
+	Bold bool
+}
+
+//pdfLabel returns lines for a bold label followed by its value,
+//wrapped to fit the page
+func pdfLabel(label, value string) []pdfLine {
+	lines := []pdfLine{{Text: label, Size: 10, Bold: true}}
+	if value == "" {
+		value = "(none)"
+	}
+	for _, l := range pdfWrap(value, 90) {
+		lines = append(lines, pdfLine{Text: l, Size: 11})
+	}
+	return append(lines, pdfLine{Size: 6})
+}
+
+//pdfWrap splits s into lines of at most width characters, breaking at spaces when possible
+func pdfWrap(s string, width int) []string {
+	var lines []string
+	for utf8.RuneCountInString(s) > width {
+		r := []rune(s)
+		i := strings.LastIndex(string(r[:width]), " ")
+		if i <= 0 {
+			lines = append(lines, string(r[:width]))
+			s = string(r[width:])
+			continue
+		}
+		lines = append(lines, s[:i])
+		s = s[i+1:]
+	}
+	return append(lines, s)
+}
+
+//pdfString returns s as an escaped PDF literal string in WinAnsiEncoding.
+//Characters that can't be encoded are replaced with "?".
+func pdfString(s string) string {
+	b := &bytes.Buffer{}
+	b.WriteByte('(')
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+//pdfContent returns a page content stream drawing lines top to bottom on a US Letter page.
+//Fonts /F1 and /F2 must be Helvetica and Helvetica-Bold.
+func pdfContent(lines []pdfLine) []byte {
+	b := &bytes.Buffer{}
+	y := 720.0
+	for _, l := range lines {
+		size := l.Size
+		if size == 0 {
+			size = 11
+		}
+		y -= size * 1.3
+		if l.Text == "" {
+			continue
+		}
+		font := "F1"
+		if l.Bold {
+			font = "F2"
+		}
+		fmt.Fprintf(b, "BT /%s %g Tf 72 %g Td %s Tj ET\n", font, size, y, pdfString(l.Text))
+	}
+	return b.Bytes()
+}
+
+//pdfWriter writes numbered PDF objects and tracks their offsets for the cross-reference table
+type pdfWriter struct {
+	w       io.Writer
+	base    int //offset of w in the final file
+	n       int //bytes written
+	offsets map[int]int
+	err     error
+}
+
+//newPDFWriter returns a new pdfWriter writing to w, which starts at offset base in the final file
+func newPDFWriter(w io.Writer, base int) *pdfWriter {
+	return &pdfWriter{w: w, base: base, offsets: make(map[int]int)}
+}
+
+func (p *pdfWriter) printf(format string, a ...interface{}) {
+	if p.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(p.w, format, a...)
+	p.n += n
+	p.err = err
+}
+
+//object writes object num with the given body
+func (p *pdfWriter) object(num int, body string) {
+	p.offsets[num] = p.base + p.n
+	p.printf("%d 0 obj\n%s\nendobj\n", num, body)
+}
+
+//stream writes object num as a stream with the given content
+func (p *pdfWriter) stream(num int, content []byte) {
+	p.offsets[num] = p.base + p.n
+	p.printf("%d 0 obj\n<< /Length %d >>\nstream\n%s\nendstream\nendobj\n", num, len(content), content)
+}
+
+//xref writes the cross-reference table and trailer for the written objects.
+//size is the total number of objects in the file and trailer is extra trailer entries.
+func (p *pdfWriter) xref(size int, trailer string) {
+	start := p.base + p.n
+	p.printf("xref\n")
+	//write subsections of consecutive object numbers
+	for num := 0; num < size; num++ {
+		if _, ok := p.offsets[num]; !ok && num != 0 {
+			continue
+		}
+		count := 1
+		for _, ok := p.offsets[num+count]; ok; _, ok = p.offsets[num+count] {
+			count++
+		}
+		p.printf("%d %d\n", num, count)
+		for i := num; i < num+count; i++ {
+			if i == 0 {
+				p.printf("0000000000 65535 f \n")
+				continue
+			}
+			p.printf("%010d 00000 n \n", p.offsets[i])
+		}
+		num += count - 1
+	}
+	p.printf("trailer\n<< /Size %d %s >>\nstartxref\n%d\n%%%%EOF\n", size, trailer, start)
+}
+
+//pdfFonts returns the font resource dictionary for the given Helvetica and Helvetica-Bold object numbers
+func pdfFonts(regular, bold int) string {
+	return fmt.Sprintf("<< /Font << /F1 %d 0 R /F2 %d 0 R >> >>", regular, bold)
+}
+
+//pdfFont returns a standard font object body with the given base font
+func pdfFont(name string) string {
+	return fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", name)
+}
+
+//writePDF writes a new PDF to w with a page for each list of lines
+func writePDF(w io.Writer, title string, pages [][]pdfLine) error {
+	p := newPDFWriter(w, 0)
+	p.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+
+	//1: catalog, 2: pages, 3-4: fonts, 5: info, then a page and content stream per page
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 6+2*i)
+	}
+
+	p.object(1, "<< /Type /Catalog /Pages 2 0 R >>")
+	p.object(2, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	p.object(3, pdfFont("Helvetica"))
+	p.object(4, pdfFont("Helvetica-Bold"))
+	p.object(5, fmt.Sprintf("<< /Title %s /Producer (handbook) >>", pdfString(title)))
+	for i, lines := range pages {
+		num := 6 + 2*i
+		p.object(num, fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Resources %s /Contents %d 0 R >>", pdfFonts(3, 4), num+1))
+		p.stream(num+1, pdfContent(lines))
+	}
+	p.xref(6+2*len(pages), "/Root 1 0 R /Info 5 0 R")
+
+	return p.err
+}
+
+//xrefStream writes a cross-reference stream as object num for the written objects.
+//size is the total number of objects in the file, including num, and trailer is extra trailer entries.
+func (p *pdfWriter) xrefStream(num, size int, trailer string) {
+	p.offsets[num] = p.base + p.n
+
+	nums := make([]int, 0, len(p.offsets))
+	for n := range p.offsets {
+		nums = append(nums, n)
+	}
+	sort.Ints(nums)
+
+	//index is pairs of first object number and count for runs of consecutive numbers
+	var index []string
+	data := &bytes.Buffer{}
+	for i := 0; i < len(nums); {
+		j := i + 1
+		for j < len(nums) && nums[j] == nums[j-1]+1 {
+			j++
+		}
+		index = append(index, fmt.Sprintf("%d %d", nums[i], j-i))
+
+		for _, n := range nums[i:j] {
+			off := p.offsets[n]
+			data.Write([]byte{1, byte(off >> 24), byte(off >> 16), byte(off >> 8), byte(off), 0, 0})
+		}
+		i = j
+	}
+
+	p.printf("%d 0 obj\n<< /Type /XRef /Size %d /W [1 4 2] /Index [%s] /Length %d %s >>\nstream\n%s\nendstream\nendobj\nstartxref\n%d\n%%%%EOF\n",
+		num, size, strings.Join(index, " "), data.Len(), trailer, data.Bytes(), p.offsets[num])
+}
+
+//AppendPDFPage returns the given PDF with a page of lines appended as an incremental update.
+//The original file is left byte-for-byte intact at the start of the returned PDF,
+//so its hash can still be verified.
+func AppendPDFPage(orig []byte, lines []pdfLine) ([]byte, error) {
+	r, err := newPDFReader(orig)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading PDF: %v", err)
+	}
+
+	rootNum, _, ok := pdfDictRef(r.trailer, "Root")
+	if !ok {
+		return nil, errors.New("Error reading PDF: Root not found")
+	}
+	size, ok := pdfDictInt(r.trailer, "Size")
+	if !ok {
+		return nil, errors.New("Error reading PDF: Size not found")
+	}
+
+	catalog, err := r.object(rootNum)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading PDF catalog: %v", err)
+	}
+	pagesNum, pagesGen, ok := pdfDictRef(catalog, "Pages")
+	if !ok || pagesGen != 0 {
+		return nil, errPDFUnsupported
+	}
+	pages, err := r.object(pagesNum)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading PDF pages: %v", err)
+	}
+
+	//new objects: page, content, regular font, bold font
+	pageNum := size
+
+	kids := regexp.MustCompile(`/Kids\s*\[([^\]]*)\]`)
+	count := regexp.MustCompile(`/Count\s+(\d+)`)
+	m := count.FindStringSubmatch(pages)
+	if m == nil || !kids.MatchString(pages) {
+		return nil, errPDFUnsupported
+	}
+	n, _ := strconv.Atoi(m[1])
+	pages = kids.ReplaceAllString(pages, fmt.Sprintf("/Kids [$1 %d 0 R]", pageNum))
+	pages = count.ReplaceAllString(pages, fmt.Sprintf("/Count %d", n+1))
+
+	buf := bytes.NewBuffer(append([]byte(nil), orig...))
+	if !bytes.HasSuffix(orig, []byte("\n")) {
+		buf.WriteByte('\n')
+	}
+
+	p := newPDFWriter(buf, buf.Len())
+	p.object(pagesNum, pages)
+	p.object(pageNum, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 612 792] /Resources %s /Contents %d 0 R >>", pagesNum, pdfFonts(pageNum+2, pageNum+3), pageNum+1))
+	p.stream(pageNum+1, pdfContent(lines))
+	p.object(pageNum+2, pdfFont("Helvetica"))
+	p.object(pageNum+3, pdfFont("Helvetica-Bold"))
+
+	trailer := fmt.Sprintf("/Root %d 0 R /Prev %d", rootNum, r.startxref)
+	for _, key := range []string{"Info", "ID"} {
+		if v, ok := pdfDictValue(r.trailer, key); ok {
+			trailer += fmt.Sprintf(" /%s %s", key, pdfValue(v))
+		}
+	}
+
+	if r.xrefStream {
+		p.xrefStream(pageNum+4, pageNum+5, trailer)
+	} else {
+		p.xref(pageNum+4, trailer)
+	}
+
+	if p.err != nil {
+		return nil, p.err
+	}
+	return buf.Bytes(), nil
+}
+
+//pdfValue returns the first value of text returned by pdfDictValue.
+//Only indirect references, arrays without nested arrays, and simple values are supported.
+func pdfValue(v string) string {
+	if m := pdfRefRegexp.FindString(v); m != "" {
+		return strings.TrimSpace(m)
+	}
+	if m := pdfArrayRegexp.FindString(v); m != "" {
+		return strings.TrimSpace(m)
+	}
+	t := &pdfTokenizer{data: []byte(v)}
+	return t.next()
+}
@@ -0,0 +1,602 @@
+package api
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+)
+
+//errPDFUnsupported is returned when a PDF uses features pdfReader doesn't handle
+var errPDFUnsupported = errors.New("Unsupported PDF")
+
+var (
+	pdfRefRegexp    = regexp.MustCompile(`^\s*(\d+)\s+(\d+)\s+R`)
+	pdfObjRegexp    = regexp.MustCompile(`^\s*(\d+)\s+(\d+)\s+obj`)
+	pdfIntRegexp    = regexp.MustCompile(`^\s*(\d+)`)
+	pdfArrayRegexp  = regexp.MustCompile(`^\s*\[([^\]]*)\]`)
+	pdfStreamRegexp = regexp.MustCompile(`^\s*stream\r?\n`)
+)
+
+//pdfXRefEntry is the location of an object in a PDF
+type pdfXRefEntry struct {
+	offset     int //offset of uncompressed object
+	compressed bool
+	stream     int //object number of object stream containing compressed object
+	index      int //index of compressed object in its object stream
+}
+
+//pdfReader reads the cross-reference information and objects of an existing PDF.
+//It only reads as much of the file as is needed to append pages with an incremental update.
+type pdfReader struct {
+	data       []byte
+	xref       map[int]pdfXRefEntry
+	trailer    string //newest trailer dictionary
+	startxref  int
+	xrefStream bool //newest cross-reference section is a stream
+}
+
+//newPDFReader parses the cross-reference sections of the given PDF
+func newPDFReader(data []byte) (*pdfReader, error) {
+	i := bytes.LastIndex(data, []byte("startxref"))
+	if i < 0 {
+		return nil, errors.New("startxref not found")
+	}
+	m := pdfIntRegexp.FindSubmatch(data[i+len("startxref"):])
+	if m == nil {
+		return nil, errors.New("Invalid startxref")
+	}
+	start, _ := strconv.Atoi(string(m[1]))
+
+	r := &pdfReader{data: data, xref: make(map[int]pdfXRefEntry), startxref: start}
+
+	//walk sections newest to oldest; newer entries take precedence
+	seen := make(map[int]bool)
+	for offset := start; offset >= 0; {
+		if offset >= len(data) || seen[offset] {
+			return nil, fmt.Errorf("Invalid cross-reference offset: %d", offset)
+		}
+		seen[offset] = true
+
+		trailer, isStream, err := r.readXRef(offset)
+		if err != nil {
+			return nil, err
+		}
+		if offset == start {
+			r.trailer, r.xrefStream = trailer, isStream
+		}
+
+		offset = -1
+		if prev, ok := pdfDictInt(trailer, "Prev"); ok {
+			offset = prev
+		}
+	}
+
+	if _, ok := pdfDictValue(r.trailer, "Encrypt"); ok {
+		return nil, errPDFUnsupported
+	}
+
+	return r, nil
+}
+
+//setXRef adds an entry if a newer section hasn't already
+func (r *pdfReader) setXRef(num int, e pdfXRefEntry) {
+	if _, ok := r.xref[num]; !ok {
+		r.xref[num] = e
+	}
+}
+
+//readXRef reads the cross-reference section at offset, returning its trailer dictionary
+func (r *pdfReader) readXRef(offset int) (trailer string, isStream bool, err error) {
+	if !bytes.HasPrefix(r.data[offset:], []byte("xref")) {
+		return r.readXRefStream(offset)
+	}
+
+	t := &pdfTokenizer{data: r.data, pos: offset + len("xref")}
+	for {
+		tok := t.next()
+		if tok == "trailer" {
+			break
+		}
+		first, err1 := strconv.Atoi(tok)
+		count, err2 := strconv.Atoi(t.next())
+		if err1 != nil || err2 != nil {
+			return "", false, errors.New("Invalid cross-reference table")
+		}
+		for i := 0; i < count; i++ {
+			off, err1 := strconv.Atoi(t.next())
+			_, err2 := strconv.Atoi(t.next())
+			typ := t.next()
+			if err1 != nil || err2 != nil {
+				return "", false, errors.New("Invalid cross-reference entry")
+			}
+			if typ == "n" {
+				r.setXRef(first+i, pdfXRefEntry{offset: off})
+			} else {
+				//free entries hide older definitions
+				r.setXRef(first+i, pdfXRefEntry{offset: -1})
+			}
+		}
+	}
+
+	dict, _, err := pdfDict(r.data, t.pos)
+	if err != nil {
+		return "", false, err
+	}
+
+	//hybrid files have a cross-reference stream for compressed objects
+	if stm, ok := pdfDictInt(dict, "XRefStm"); ok {
+		if _, _, err = r.readXRefStream(stm); err != nil {
+			return "", false, err
+		}
+	}
+
+	return dict, false, nil
+}
+
+//readXRefStream reads the cross-reference stream object at offset, returning its dictionary
+func (r *pdfReader) readXRefStream(offset int) (dict string, isStream bool, err error) {
+	dict, stream, err := r.readObjectAt(offset)
+	if err != nil {
+		return "", false, err
+	}
+	if stream == nil {
+		return "", false, errors.New("Invalid cross-reference stream")
+	}
+
+	data, err := pdfDecode(dict, stream)
+	if err != nil {
+		return "", false, err
+	}
+
+	w, ok := pdfDictInts(dict, "W")
+	if !ok || len(w) != 3 {
+		return "", false, errors.New("Invalid cross-reference stream widths")
+	}
+	//fields wider than 4 bytes can overflow offsets, and entries must use at least one byte or they never run out
+	if w[0] < 0 || w[1] < 0 || w[2] < 0 || w[0] > 4 || w[1] > 4 || w[2] > 4 || w[0]+w[1]+w[2] == 0 {
+		return "", false, errPDFUnsupported
+	}
+	index, ok := pdfDictInts(dict, "Index")
+	if !ok {
+		size, _ := pdfDictInt(dict, "Size")
+		index = []int{0, size}
+	}
+	if len(index)%2 != 0 {
+		return "", false, errPDFUnsupported
+	}
+	for i := 0; i < len(index); i += 2 {
+		if index[i] < 0 || index[i+1] < 0 || index[i] > len(r.data) || index[i+1] > len(data) {
+			return "", false, errPDFUnsupported
+		}
+	}
+
+	field := func(b []byte) int {
+		v := 0
+		for _, c := range b {
+			v = v<<8 | int(c)
+		}
+		return v
+	}
+
+	width := w[0] + w[1] + w[2]
+	pos := 0
+	for i := 0; i+1 < len(index); i += 2 {
+		for num := index[i]; num < index[i]+index[i+1]; num++ {
+			if pos+width > len(data) {
+				return "", false, errors.New("Truncated cross-reference stream")
+			}
+			typ := 1
+			if w[0] > 0 {
+				typ = field(data[pos : pos+w[0]])
+			}
+			f2 := field(data[pos+w[0] : pos+w[0]+w[1]])
+			f3 := field(data[pos+w[0]+w[1] : pos+width])
+			pos += width
+
+			switch typ {
+			case 0:
+				r.setXRef(num, pdfXRefEntry{offset: -1})
+			case 1:
+				r.setXRef(num, pdfXRefEntry{offset: f2})
+			case 2:
+				r.setXRef(num, pdfXRefEntry{compressed: true, stream: f2, index: f3})
+			}
+		}
+	}
+
+	return dict, true, nil
+}
+
+//readObjectAt reads the uncompressed object at offset, returning its body and stream data if it has one
+func (r *pdfReader) readObjectAt(offset int) (body string, stream []byte, err error) {
+	return r.readObject(offset, true)
+}
+
+//readObject reads the uncompressed object at offset, returning its body and stream data if it has one.
+//If resolve is false, an indirect stream Length isn't read and the stream ends at endstream instead.
+func (r *pdfReader) readObject(offset int, resolve bool) (body string, stream []byte, err error) {
+	if offset < 0 || offset >= len(r.data) {
+		return "", nil, fmt.Errorf("Invalid object offset: %d", offset)
+	}
+	loc := pdfObjRegexp.FindIndex(r.data[offset:])
+	if loc == nil {
+		return "", nil, fmt.Errorf("Object not found at offset: %d", offset)
+	}
+	start := offset + loc[1]
+
+	//objects that aren't dictionaries are read up to endobj
+	if t := bytes.TrimLeft(r.data[start:], " \t\r\n"); !bytes.HasPrefix(t, []byte("<<")) {
+		end := bytes.Index(r.data[start:], []byte("endobj"))
+		if end < 0 {
+			return "", nil, errors.New("endobj not found")
+		}
+		return string(bytes.TrimSpace(r.data[start : start+end])), nil, nil
+	}
+
+	body, end, err := pdfDict(r.data, start)
+	if err != nil {
+		return "", nil, err
+	}
+
+	loc = pdfStreamRegexp.FindIndex(r.data[end:])
+	if loc == nil {
+		return body, nil, nil
+	}
+	streamStart := end + loc[1]
+
+	length := -1
+	if v, ok := pdfDictValue(body, "Length"); ok {
+		if m := pdfRefRegexp.FindStringSubmatch(v); m != nil {
+			//a Length is never resolved while reading another Length,
+			//which would recurse forever if an object's Length referred to itself
+			if resolve {
+				num, _ := strconv.Atoi(m[1])
+				if l, err := r.readBody(num, false); err == nil {
+					length, _ = strconv.Atoi(l)
+				}
+			}
+		} else if m := pdfIntRegexp.FindStringSubmatch(v); m != nil {
+			length, _ = strconv.Atoi(m[1])
+		}
+	}
+	if length < 0 || length > len(r.data)-streamStart {
+		length = bytes.Index(r.data[streamStart:], []byte("endstream"))
+		if length < 0 {
+			return "", nil, errors.New("endstream not found")
+		}
+	}
+
+	return body, r.data[streamStart : streamStart+length], nil
+}
+
+//object returns the body of the given object number, without any stream data
+func (r *pdfReader) object(num int) (string, error) {
+	return r.readBody(num, true)
+}
+
+//readBody returns the body of the given object number, without any stream data.
+//resolve is passed to readObject for the object or its object stream.
+func (r *pdfReader) readBody(num int, resolve bool) (string, error) {
+	e, ok := r.xref[num]
+	if !ok || (!e.compressed && e.offset < 0) {
+		return "", fmt.Errorf("Object %d not found", num)
+	}
+
+	if !e.compressed {
+		body, _, err := r.readObject(e.offset, resolve)
+		return body, err
+	}
+
+	se, ok := r.xref[e.stream]
+	if !ok || se.compressed {
+		return "", fmt.Errorf("Object stream %d not found", e.stream)
+	}
+	dict, stream, err := r.readObject(se.offset, resolve)
+	if err != nil {
+		return "", err
+	}
+	data, err := pdfDecode(dict, stream)
+	if err != nil {
+		return "", err
+	}
+
+	n, ok1 := pdfDictInt(dict, "N")
+	first, ok2 := pdfDictInt(dict, "First")
+	if !ok1 || !ok2 || first > len(data) {
+		return "", errors.New("Invalid object stream")
+	}
+	//each object in the header takes at least two bytes
+	if n > first {
+		return "", errPDFUnsupported
+	}
+
+	t := &pdfTokenizer{data: data[:first]}
+	offsets := make([]int, n)
+	for i := 0; i < n; i++ {
+		t.next()
+		off, err := strconv.Atoi(t.next())
+		if err != nil {
+			return "", errors.New("Invalid object stream header")
+		}
+		if off < 0 || off > len(data)-first || (i > 0 && first+off < offsets[i-1]) {
+			return "", errPDFUnsupported
+		}
+		offsets[i] = first + off
+	}
+	if e.index < 0 || e.index >= n {
+		return "", errors.New("Invalid object stream index")
+	}
+
+	end := len(data)
+	if e.index+1 < n {
+		end = offsets[e.index+1]
+	}
+	return string(bytes.TrimSpace(data[offsets[e.index]:end])), nil
+}
+
+//pdfDecode returns the decoded data of a stream with the given dictionary.
+//Only FlateDecode with optional PNG predictors is supported.
+func pdfDecode(dict string, stream []byte) ([]byte, error) {
+	filter, ok := pdfDictValue(dict, "Filter")
+	if !ok {
+		return stream, nil
+	}
+	if m := regexp.MustCompile(`^\s*\[?\s*/(\w+)\s*\]?`).FindStringSubmatch(filter); m == nil || m[1] != "FlateDecode" {
+		return nil, errPDFUnsupported
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(stream))
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil && len(data) == 0 {
+		return nil, err
+	}
+
+	params, ok := pdfDictValue(dict, "DecodeParms")
+	if !ok {
+		return data, nil
+	}
+	predictor, _ := pdfDictInt(params, "Predictor")
+	if predictor < 10 {
+		return data, nil
+	}
+	columns, ok := pdfDictInt(params, "Columns")
+	if !ok {
+		columns = 1
+	}
+	if columns > len(data) {
+		return nil, errPDFUnsupported
+	}
+
+	return pdfUnpredict(data, columns)
+}
+
+//pdfUnpredict reverses PNG prediction on rows of the given number of 1 byte columns
+func pdfUnpredict(data []byte, columns int) ([]byte, error) {
+	var out []byte
+	prev := make([]byte, columns)
+	for pos := 0; pos < len(data); pos += columns + 1 {
+		if pos+1+columns > len(data) {
+			return nil, errors.New("Truncated predicted stream")
+		}
+		typ, row := data[pos], append([]byte(nil), data[pos+1:pos+1+columns]...)
+		for i := range row {
+			var left, upLeft byte
+			if i > 0 {
+				left, upLeft = row[i-1], prev[i-1]
+			}
+			up := prev[i]
+			switch typ {
+			case 0:
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += up
+			case 3:
+				row[i] += byte((int(left) + int(up)) / 2)
+			case 4:
+				row[i] += pdfPaeth(left, up, upLeft)
+			default:
+				return nil, errPDFUnsupported
+			}
+		}
+		out = append(out, row...)
+		prev = row
+	}
+	return out, nil
+}
+
+//pdfPaeth is the PNG Paeth predictor
+func pdfPaeth(a, b, c byte) byte {
+	abs := func(x int) int {
+		if x < 0 {
+			return -x
+		}
+		return x
+	}
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+//pdfDict returns the dictionary starting at the first "<<" at or after start, and the offset after it
+func pdfDict(data []byte, start int) (dict string, end int, err error) {
+	i := bytes.Index(data[start:], []byte("<<"))
+	if i < 0 {
+		return "", 0, errors.New("Dictionary not found")
+	}
+	i += start
+
+	depth := 0
+	for j := i; j < len(data); j++ {
+		switch data[j] {
+		case '(':
+			//skip literal strings, which may contain unbalanced delimiters
+			nest := 0
+			for ; j < len(data); j++ {
+				if data[j] == '\\' {
+					j++
+				} else if data[j] == '(' {
+					nest++
+				} else if data[j] == ')' {
+					nest--
+					if nest == 0 {
+						break
+					}
+				}
+			}
+		case '<':
+			if j+1 < len(data) && data[j+1] == '<' {
+				depth++
+				j++
+			}
+		case '>':
+			if j+1 < len(data) && data[j+1] == '>' {
+				depth--
+				j++
+				if depth == 0 {
+					return string(data[i : j+1]), j + 1, nil
+				}
+			}
+		}
+	}
+	return "", 0, errors.New("Unterminated dictionary")
+}
+
+//pdfDictValue returns the text following the given top-level key in dict.
+//The text includes the value and anything after it.
+func pdfDictValue(dict, key string) (string, bool) {
+	depth := 0
+	for i := 0; i < len(dict); i++ {
+		switch {
+		case dict[i] == '(':
+			//skip literal strings
+			nest := 0
+			for ; i < len(dict); i++ {
+				if dict[i] == '\\' {
+					i++
+				} else if dict[i] == '(' {
+					nest++
+				} else if dict[i] == ')' {
+					nest--
+					if nest == 0 {
+						break
+					}
+				}
+			}
+		case bytes.HasPrefix([]byte(dict[i:]), []byte("<<")):
+			depth++
+			i++
+		case bytes.HasPrefix([]byte(dict[i:]), []byte(">>")):
+			depth--
+			i++
+		case dict[i] == '/' && depth == 1 && bytes.HasPrefix([]byte(dict[i+1:]), []byte(key)):
+			end := i + 1 + len(key)
+			if end < len(dict) && pdfRegular(dict[end]) {
+				continue
+			}
+			return dict[end:], true
+		}
+	}
+	return "", false
+}
+
+//pdfRegular returns whether c is a regular (not whitespace or delimiter) PDF character
+func pdfRegular(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', '\f', 0, '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return false
+	}
+	return true
+}
+
+//pdfDictInt returns the integer value of the given key in dict
+func pdfDictInt(dict, key string) (int, bool) {
+	v, ok := pdfDictValue(dict, key)
+	if !ok {
+		return 0, false
+	}
+	m := pdfIntRegexp.FindStringSubmatch(v)
+	if m == nil {
+		return 0, false
+	}
+	i, err := strconv.Atoi(m[1])
+	return i, err == nil
+}
+
+//pdfDictInts returns the integer array value of the given key in dict
+func pdfDictInts(dict, key string) ([]int, bool) {
+	v, ok := pdfDictValue(dict, key)
+	if !ok {
+		return nil, false
+	}
+	m := pdfArrayRegexp.FindStringSubmatch(v)
+	if m == nil {
+		return nil, false
+	}
+	t := &pdfTokenizer{data: []byte(m[1])}
+	var ints []int
+	for tok := t.next(); tok != ""; tok = t.next() {
+		i, err := strconv.Atoi(tok)
+		if err != nil {
+			return nil, false
+		}
+		ints = append(ints, i)
+	}
+	return ints, true
+}
+
+//pdfDictRef returns the object number of the indirect reference value of the given key in dict
+func pdfDictRef(dict, key string) (num, gen int, ok bool) {
+	v, ok := pdfDictValue(dict, key)
+	if !ok {
+		return 0, 0, false
+	}
+	m := pdfRefRegexp.FindStringSubmatch(v)
+	if m == nil {
+		return 0, 0, false
+	}
+	num, _ = strconv.Atoi(m[1])
+	gen, _ = strconv.Atoi(m[2])
+	return num, gen, true
+}
+
+//pdfTokenizer splits PDF data into whitespace separated tokens, skipping comments
+type pdfTokenizer struct {
+	data []byte
+	pos  int
+}
+
+//next returns the next token or an empty string at the end of data
+func (t *pdfTokenizer) next() string {
+	for t.pos < len(t.data) {
+		c := t.data[t.pos]
+		if c == '%' {
+			for t.pos < len(t.data) && t.data[t.pos] != '\n' && t.data[t.pos] != '\r' {
+				t.pos++
+			}
+			continue
+		}
+		if pdfRegular(c) {
+			break
+		}
+		t.pos++
+	}
+	start := t.pos
+	for t.pos < len(t.data) && pdfRegular(t.data[t.pos]) {
+		t.pos++
+	}
+	return string(t.data[start:t.pos])
+}
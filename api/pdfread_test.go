@@ -0,0 +1,111 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const (
+	testPDFCatalog = "<< /Type /Catalog /Pages 2 0 R >>"
+	testPDFPages   = "<< /Type /Pages /Kids [] /Count 0 >>"
+)
+
+//testPDF returns a PDF with the given objects, numbered from 1, and a cross-reference table
+func testPDF(objects ...string) []byte {
+	buf := bytes.NewBufferString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, o := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(buf, "%d 0 obj\n%s\nendobj\n", i+1, o)
+	}
+
+	start := buf.Len()
+	fmt.Fprintf(buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, start)
+	return buf.Bytes()
+}
+
+//testObjStmPDF returns a PDF with a cross-reference stream with the given W and Index arrays.
+//Its catalog is object 1, and its pages object 2 is stored in object stream 3 with the given dictionary entries and header.
+func testObjStmPDF(w, index, objStm, header string) []byte {
+	buf := bytes.NewBufferString("%PDF-1.5\n")
+	catalog := buf.Len()
+	fmt.Fprintf(buf, "1 0 obj\n%s\nendobj\n", testPDFCatalog)
+	stream := buf.Len()
+	fmt.Fprintf(buf, "3 0 obj\n<< /Type /ObjStm %s /Length %d >>\nstream\n%s%s\nendstream\nendobj\n",
+		objStm, len(header)+len(testPDFPages), header, testPDFPages)
+	xref := buf.Len()
+
+	//type, offset or object stream number, generation or index
+	var data []byte
+	for _, e := range [][3]int{{0, 0, 65535}, {1, catalog, 0}, {2, 3, 0}, {1, stream, 0}, {1, xref, 0}} {
+		data = append(data, byte(e[0]), byte(e[1]>>24), byte(e[1]>>16), byte(e[1]>>8), byte(e[1]), byte(e[2]>>8), byte(e[2]))
+	}
+	fmt.Fprintf(buf, "4 0 obj\n<< /Type /XRef /Size 5 /Root 1 0 R /W %s /Index %s /Length %d >>\nstream\n%s\nendstream\nendobj\nstartxref\n%d\n%%%%EOF\n",
+		w, index, len(data), data, xref)
+	return buf.Bytes()
+}
+
+func TestAppendPDFPage(t *testing.T) {
+	tests := []struct {
+		name string
+		pdf  []byte
+		err  string
+	}{
+		{"cross-reference table", testPDF(testPDFCatalog, testPDFPages), ""},
+		{"cross-reference stream", testObjStmPDF("[1 4 2]", "[0 5]", "/N 1 /First 4", "2 0 "), ""},
+		{"self-referencing length", testPDF("<< /Type /Catalog /Pages 2 0 R /Length 1 0 R >>\nstream\nabc\nendstream", testPDFPages), ""},
+		{"mutually referencing lengths", testPDF(
+			"<< /Type /Catalog /Pages 2 0 R /Length 2 0 R >>\nstream\nabc\nendstream",
+			"<< /Type /Pages /Kids [] /Count 0 /Length 1 0 R >>\nstream\nabc\nendstream",
+		), ""},
+		{"oversized length", testPDF("<< /Type /Catalog /Pages 2 0 R /Length 9223372036854775807 >>\nstream\nabc\nendstream", testPDFPages), ""},
+		{"no startxref", []byte("%PDF-1.4\n"), "startxref not found"},
+		{"negative width", testObjStmPDF("[1 -1 2]", "[0 5]", "/N 1 /First 4", "2 0 "), errPDFUnsupported.Error()},
+		{"oversized width", testObjStmPDF("[1 9 2]", "[0 5]", "/N 1 /First 4", "2 0 "), errPDFUnsupported.Error()},
+		{"empty entries", testObjStmPDF("[0 0 0]", "[0 5]", "/N 1 /First 4", "2 0 "), errPDFUnsupported.Error()},
+		{"negative index count", testObjStmPDF("[1 4 2]", "[0 -1]", "/N 1 /First 4", "2 0 "), errPDFUnsupported.Error()},
+		{"oversized index count", testObjStmPDF("[1 4 2]", "[0 1000000]", "/N 1 /First 4", "2 0 "), errPDFUnsupported.Error()},
+		{"odd index", testObjStmPDF("[1 4 2]", "[0]", "/N 1 /First 4", "2 0 "), errPDFUnsupported.Error()},
+		{"oversized object count", testObjStmPDF("[1 4 2]", "[0 5]", "/N 1000000 /First 4", "2 0 "), errPDFUnsupported.Error()},
+		{"negative object offset", testObjStmPDF("[1 4 2]", "[0 5]", "/N 1 /First 4", "2 -4"), errPDFUnsupported.Error()},
+		{"oversized object offset", testObjStmPDF("[1 4 2]", "[0 5]", "/N 1 /First 6", "2 999 "), errPDFUnsupported.Error()},
+		{"object offsets out of order", testObjStmPDF("[1 4 2]", "[0 5]", "/N 2 /First 8", "2 9 2 0 "), errPDFUnsupported.Error()},
+	}
+
+	for _, test := range tests {
+		out, err := AppendPDFPage(test.pdf, []pdfLine{{Text: "Signed"}})
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected error containing %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Error appending page: %v", test.name, err)
+			continue
+		}
+
+		if !bytes.HasPrefix(out, test.pdf) {
+			t.Errorf("%s: expected original PDF to be left intact", test.name)
+		}
+		r, err := newPDFReader(out)
+		if err != nil {
+			t.Errorf("%s: Error reading appended PDF: %v", test.name, err)
+			continue
+		}
+		pages, err := r.object(2)
+		if err != nil {
+			t.Errorf("%s: Error reading appended pages: %v", test.name, err)
+			continue
+		}
+		if !strings.Contains(pages, "/Count 1") {
+			t.Errorf("%s: expected appended page to be counted, got %s", test.name, pages)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+//ErrInvalidReceipt is returned when a receipt's signature doesn't match its contents
+var ErrInvalidReceipt = errors.New("Invalid receipt signature")
+
+//Receipt is a server-signed statement that an employee signed a document
+type Receipt struct {
+	EmployeeID   string
+	FirstName    string
+	LastName     string
+	Campus       string
+	Version      string
+	DocumentHash string
+	Time         time.Time
+	KeyID        string
+	Signature    []byte
+}
+
+//payload returns the bytes covered by the receipt's signature
+func (r *Receipt) payload() []byte {
+	return canonicalFields([]string{
+		"handbook-receipt-v1",
+		r.EmployeeID,
+		r.FirstName,
+		r.LastName,
+		r.Campus,
+		r.Version,
+		r.DocumentHash,
+		r.Time.UTC().Format(time.RFC3339Nano),
+		r.KeyID,
+	})
+}
+
+//KeyID returns the identifier of the given public key used in receipts
+func KeyID(pub ed25519.PublicKey) string {
+	h := sha256.Sum256(pub)
+	return hex.EncodeToString(h[:8])
+}
+
+//ParseReceipt decodes an encoded receipt without verifying its signature
+func ParseReceipt(receipt string) (*Receipt, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(receipt)
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding receipt: %v", err)
+	}
+
+	r := &Receipt{}
+	if err = json.Unmarshal(buf, r); err != nil {
+		return nil, fmt.Errorf("Error decoding receipt: %v", err)
+	}
+
+	return r, nil
+}
+
+//VerifyReceipt decodes an encoded receipt and verifies it was signed by the given public key.
+//If the signature is not valid, ErrInvalidReceipt is returned.
+//VerifyReceipt needs no access to the server or database, so receipts can be verified offline.
+func VerifyReceipt(pub ed25519.PublicKey, receipt string) (*Receipt, error) {
+	r, err := ParseReceipt(receipt)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.KeyID != KeyID(pub) || !ed25519.Verify(pub, r.payload(), r.Signature) {
+		return nil, ErrInvalidReceipt
+	}
+
+	return r, nil
+}
+
+//ReceiptSigner issues and verifies signed receipts
+type ReceiptSigner struct {
+	key  ed25519.PrivateKey
+	keys map[string]ed25519.PublicKey
+}
+
+//NewReceiptSigner returns a new ReceiptSigner that signs with the Ed25519 private key from the given seed.
+//Receipts signed by any of oldKeys will still verify, so keys can be rotated.
+func NewReceiptSigner(seed []byte, oldKeys []ed25519.PublicKey) (*ReceiptSigner, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("Invalid seed length: %d", len(seed))
+	}
+
+	key := ed25519.NewKeyFromSeed(seed)
+	pub := key.Public().(ed25519.PublicKey)
+
+	keys := map[string]ed25519.PublicKey{KeyID(pub): pub}
+	for _, k := range oldKeys {
+		keys[KeyID(k)] = k
+	}
+
+	return &ReceiptSigner{key: key, keys: keys}, nil
+}
+
+//PublicKey returns the public key receipts are currently signed with
+func (s *ReceiptSigner) PublicKey() ed25519.PublicKey {
+	return s.key.Public().(ed25519.PublicKey)
+}
+
+//Sign returns an encoded, signed receipt for the given Entry
+func (s *ReceiptSigner) Sign(e *Entry) (string, error) {
+	r := &Receipt{
+		EmployeeID:   e.EmployeeID,
+		FirstName:    e.FirstName,
+		LastName:     e.LastName,
+		Campus:       e.Campus,
+		Version:      e.Version,
+		DocumentHash: e.DocumentHash,
+		Time:         e.Time.UTC(),
+		KeyID:        KeyID(s.PublicKey()),
+	}
+	r.Signature = ed25519.Sign(s.key, r.payload())
+
+	buf, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+//Verify decodes an encoded receipt and verifies it was signed by the current or an old key.
+//If the signature is not valid, ErrInvalidReceipt is returned.
+func (s *ReceiptSigner) Verify(receipt string) (*Receipt, error) {
+	r, err := ParseReceipt(receipt)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, ok := s.keys[r.KeyID]
+	if !ok {
+		return nil, ErrInvalidReceipt
+	}
+
+	return VerifyReceipt(pub, receipt)
+}
@@ -0,0 +1,221 @@
+package api
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//RedisConfig represents the configuration of a connection to a Redis server
+type RedisConfig struct {
+	Addr     string      //host:port
+	Password string      //optional
+	DB       int         //database number
+	TLS      *tls.Config //optional; if set, connections use TLS
+	PoolSize int         //maximum idle connections
+	Timeout  time.Duration
+}
+
+//redisError represents an error reply from a Redis server
+type redisError string
+
+func (e redisError) Error() string {
+	return "Redis error: " + string(e)
+}
+
+//redisConn represents a pooled connection to a Redis server
+type redisConn struct {
+	net.Conn
+	r      *bufio.Reader
+	reused bool //whether or not the connection came from the idle pool
+}
+
+//redisClient represents a pool of connections to a Redis server speaking RESP, the Redis protocol
+type redisClient struct {
+	config *RedisConfig
+
+	mu   *sync.Mutex
+	idle []*redisConn
+}
+
+//newRedisClient returns a new redisClient with the given config. Connections are made when first needed.
+func newRedisClient(config *RedisConfig) *redisClient {
+	return &redisClient{config: config, mu: new(sync.Mutex)}
+}
+
+//dial returns a new connection, authenticated and with the configured database selected
+func (c *redisClient) dial() (*redisConn, error) {
+	nc, err := net.DialTimeout("tcp", c.config.Addr, c.config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.config.TLS != nil {
+		tlsConfig := c.config.TLS.Clone()
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName, _, _ = net.SplitHostPort(c.config.Addr)
+		}
+		nc = tls.Client(nc, tlsConfig)
+	}
+
+	conn := &redisConn{Conn: nc, r: bufio.NewReader(nc)}
+
+	if c.config.Password != "" {
+		if _, err = conn.do(c.config.Timeout, "AUTH", c.config.Password); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Error authenticating: %v", err)
+		}
+	}
+
+	if c.config.DB != 0 {
+		if _, err = conn.do(c.config.Timeout, "SELECT", strconv.Itoa(c.config.DB)); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Error selecting database: %v", err)
+		}
+	}
+
+	return conn, nil
+}
+
+//get returns an idle connection or a new one
+func (c *redisClient) get() (*redisConn, error) {
+	c.mu.Lock()
+	if n := len(c.idle); n > 0 {
+		conn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		conn.reused = true
+		return conn, nil
+	}
+	c.mu.Unlock()
+
+	return c.dial()
+}
+
+//put returns conn to the pool, or closes it if the pool is full
+func (c *redisClient) put(conn *redisConn) {
+	c.mu.Lock()
+	if len(c.idle) < c.config.PoolSize {
+		c.idle = append(c.idle, conn)
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+	conn.Close()
+}
+
+//do sends a command and returns its reply: a string, int64, []byte, []interface{}, or nil.
+//Error replies are returned as a redisError.
+//If a pooled connection turns out to be closed, the command is retried on another connection.
+func (c *redisClient) do(args ...string) (interface{}, error) {
+	for {
+		conn, err := c.get()
+		if err != nil {
+			return nil, fmt.Errorf("Error connecting to Redis server %s: %v", c.config.Addr, err)
+		}
+
+		reply, err := conn.do(c.config.Timeout, args...)
+		if _, ok := err.(redisError); err == nil || ok {
+			c.put(conn)
+			return reply, err
+		}
+
+		conn.Close()
+		if !conn.reused {
+			return nil, fmt.Errorf("Error communicating with Redis server %s: %v", c.config.Addr, err)
+		}
+	}
+}
+
+//do sends a command on conn and reads its reply within timeout
+func (conn *redisConn) do(timeout time.Duration, args ...string) (interface{}, error) {
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, a := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(a))+"\r\n"...)
+		buf = append(buf, a...)
+		buf = append(buf, "\r\n"...)
+	}
+	if _, err := conn.Write(buf); err != nil {
+		return nil, err
+	}
+
+	return readRESP(conn.r)
+}
+
+//readRESPLine reads a line without its CRLF
+func readRESPLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", errors.New("Malformed RESP line")
+	}
+	return line[:len(line)-2], nil
+}
+
+//readRESP reads a RESP value from r: a string, int64, []byte, []interface{}, or nil.
+//Error values are returned as a redisError.
+func readRESP(r *bufio.Reader) (interface{}, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		return nil, errors.New("Malformed RESP value")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("Malformed RESP bulk string length: %v", err)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("Malformed RESP array length: %v", err)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			//error elements are returned in the array instead of failing the whole reply
+			values[i], err = readRESP(r)
+			if e, ok := err.(redisError); ok {
+				values[i] = e
+			} else if err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+
+	return nil, fmt.Errorf("Unknown RESP type %q", line[0])
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+//RedisSessionStore represents a SessionStore that uses a Redis server, so sessions are shared by replicas and survive restarts.
+//Sessions are stored as JSON under prefix plus the hashed session ID, and Redis removes them when they expire.
+type RedisSessionStore struct {
+	client        *redisClient
+	prefix        string
+	duration      time.Duration
+	adminDuration time.Duration
+}
+
+//NewRedisSessionStore returns a new RedisSessionStore with the given expiration duration
+//that stores sessions on the Redis server in config with keys starting with prefix
+func NewRedisSessionStore(config *RedisConfig, prefix string, duration time.Duration, adminDuration time.Duration) *RedisSessionStore {
+	return &RedisSessionStore{
+		client:        newRedisClient(config),
+		prefix:        prefix,
+		duration:      duration,
+		adminDuration: adminDuration,
+	}
+}
+
+//Create returns a new sessionID with the given User. If the Redis server malfunctions,
+//sessionID will be an empty string and err will be non-nil.
+func (s *RedisSessionStore) Create(user *User) (sessionID string, err error) {
+	id := randString(128)
+	var dur time.Duration
+	if user.Admin {
+		dur = s.adminDuration
+	} else {
+		dur = s.duration
+	}
+
+	data, err := json.Marshal(&Session{User: user, Expires: time.Now().Add(dur)})
+	if err != nil {
+		return "", fmt.Errorf("Error encoding session: %v", err)
+	}
+
+	_, err = s.client.do("SET", s.prefix+hashToken(id), string(data), "PX", strconv.FormatInt(int64(dur/time.Millisecond), 10))
+	if err != nil {
+		return "", fmt.Errorf("Error creating session: %v", err)
+	}
+
+	return id, nil
+}
+
+//Check returns whether or not sessionID is a valid session. If sessionID is not valid, session will be nil.
+//If the Redis server malfunctions, session will be nil and err will be non-nil.
+func (s *RedisSessionStore) Check(sessionID string) (session *Session, err error) {
+	reply, err := s.client.do("GET", s.prefix+hashToken(sessionID))
+	if err != nil {
+		return nil, fmt.Errorf("Error checking session: %v", err)
+	}
+	if reply == nil {
+		return nil, nil
+	}
+
+	data, ok := reply.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("Error checking session: unexpected reply %v", reply)
+	}
+
+	session = new(Session)
+	if err = json.Unmarshal(data, session); err != nil {
+		return nil, fmt.Errorf("Error decoding session: %v", err)
+	}
+
+	//Redis expires keys to the millisecond, but a replica's clock may be behind
+	if !session.Expires.After(time.Now()) {
+		return nil, nil
+	}
+
+	return session, nil
+}
+
+//Delete ends the session with the given sessionID. If the Redis server malfunctions, err will be non-nil.
+func (s *RedisSessionStore) Delete(sessionID string) error {
+	if _, err := s.client.do("DEL", s.prefix+hashToken(sessionID)); err != nil {
+		return fmt.Errorf("Error deleting session: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/korylprince/handbook/api/redistest"
+)
+
+//startRedis starts a redistest.Server requiring password and returns a config for it using database 3.
+//The caller must Close the server.
+func startRedis(t *testing.T, password string) (*redistest.Server, *RedisConfig) {
+	srv, err := redistest.Start(password)
+	if err != nil {
+		t.Fatalf("Error starting Redis server: %v", err)
+	}
+	return srv, &RedisConfig{Addr: srv.Addr(), Password: password, DB: 3, PoolSize: 2, Timeout: time.Second}
+}
+
+func TestRedisSessionStore(t *testing.T) {
+	srv, config := startRedis(t, "secret")
+	defer srv.Close()
+	//two stores stand in for two replicas
+	a := NewRedisSessionStore(config, "handbook:session:", 5*time.Minute, time.Hour)
+	b := NewRedisSessionStore(config, "handbook:session:", 5*time.Minute, time.Hour)
+
+	user := &User{EmployeeID: "123", Username: "jdoe", FirstName: "Jane", LastName: "Doe"}
+	id, err := a.Create(user)
+	if err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+
+	session, err := b.Check(id)
+	if err != nil {
+		t.Fatalf("Error checking session: %v", err)
+	}
+	if session == nil {
+		t.Fatal("Expected session created by another store to be valid")
+	}
+	if !reflect.DeepEqual(session.User, user) {
+		t.Errorf("Expected user %+v, got %+v", user, session.User)
+	}
+
+	if session, err = b.Check("unknown"); err != nil || session != nil {
+		t.Errorf("Expected unknown session to be invalid, got %v, %v", session, err)
+	}
+
+	if err = b.Delete(id); err != nil {
+		t.Fatalf("Error deleting session: %v", err)
+	}
+	if session, err = a.Check(id); err != nil || session != nil {
+		t.Errorf("Expected deleted session to be invalid, got %v, %v", session, err)
+	}
+	if err = a.Delete(id); err != nil {
+		t.Errorf("Expected deleting a deleted session to succeed, got %v", err)
+	}
+}
+
+func TestRedisSessionStoreKeys(t *testing.T) {
+	srv, config := startRedis(t, "secret")
+	defer srv.Close()
+	s := NewRedisSessionStore(config, "handbook:session:", 5*time.Minute, time.Hour)
+
+	id, err := s.Create(&User{Username: "jdoe"})
+	if err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+
+	if keys := srv.Keys(0); len(keys) != 0 {
+		t.Errorf("Expected no keys in database 0, got %v", keys)
+	}
+	keys := srv.Keys(3)
+	if len(keys) != 1 {
+		t.Fatalf("Expected 1 key in database 3, got %v", keys)
+	}
+	if keys[0] != "handbook:session:"+hashToken(id) {
+		t.Errorf("Expected key to be the prefix and hashed session ID, got %s", keys[0])
+	}
+	if strings.Contains(keys[0], id) {
+		t.Error("Expected session ID not to appear in key")
+	}
+}
+
+func TestRedisSessionStoreTTL(t *testing.T) {
+	srv, config := startRedis(t, "secret")
+	defer srv.Close()
+	s := NewRedisSessionStore(config, "handbook:session:", 5*time.Minute, time.Hour)
+
+	tests := []struct {
+		name     string
+		user     *User
+		duration time.Duration
+	}{
+		{"staff", &User{Username: "jdoe"}, 5 * time.Minute},
+		{"admin", &User{Username: "admin", Admin: true}, time.Hour},
+	}
+
+	for _, test := range tests {
+		id, err := s.Create(test.user)
+		if err != nil {
+			t.Fatalf("Error creating %s session: %v", test.name, err)
+		}
+
+		reply, err := s.client.do("PTTL", "handbook:session:"+hashToken(id))
+		if err != nil {
+			t.Fatalf("Error getting %s session TTL: %v", test.name, err)
+		}
+		ttl := time.Duration(reply.(int64)) * time.Millisecond
+		if ttl > test.duration || ttl < test.duration-5*time.Second {
+			t.Errorf("Expected %s session TTL of %v, got %v", test.name, test.duration, ttl)
+		}
+
+		session, err := s.Check(id)
+		if err != nil || session == nil {
+			t.Fatalf("Expected %s session to be valid, got %v, %v", test.name, session, err)
+		}
+		if d := time.Until(session.Expires); d > test.duration || d < test.duration-5*time.Second {
+			t.Errorf("Expected %s session to expire in %v, got %v", test.name, test.duration, d)
+		}
+	}
+}
+
+func TestRedisSessionStoreExpired(t *testing.T) {
+	srv, config := startRedis(t, "secret")
+	defer srv.Close()
+	s := NewRedisSessionStore(config, "handbook:session:", 5*time.Minute, time.Hour)
+
+	staff, err := s.Create(&User{Username: "jdoe"})
+	if err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+	admin, err := s.Create(&User{Username: "admin", Admin: true})
+	if err != nil {
+		t.Fatalf("Error creating session: %v", err)
+	}
+
+	srv.FastForward(6 * time.Minute)
+
+	if session, err := s.Check(staff); err != nil || session != nil {
+		t.Errorf("Expected expired session to be invalid, got %v, %v", session, err)
+	}
+	if session, err := s.Check(admin); err != nil || session == nil {
+		t.Errorf("Expected admin session to still be valid, got %v, %v", session, err)
+	}
+	if keys := srv.Keys(3); len(keys) != 1 || keys[0] != "handbook:session:"+hashToken(admin) {
+		t.Errorf("Expected only the admin session key, got %v", keys)
+	}
+}
+
+func TestRedisSessionStoreAuth(t *testing.T) {
+	srv, config := startRedis(t, "secret")
+	defer srv.Close()
+
+	config.Password = "wrong"
+	if _, err := NewRedisSessionStore(config, "handbook:session:", time.Minute, time.Minute).Create(&User{}); err == nil {
+		t.Error("Expected wrong password to fail")
+	}
+
+	config.Password = ""
+	if _, err := NewRedisSessionStore(config, "handbook:session:", time.Minute, time.Minute).Check("id"); err == nil {
+		t.Error("Expected missing password to fail")
+	}
+}
@@ -0,0 +1,456 @@
+//Package redistest provides an in-process, Redis-compatible server for developing and testing the Redis session store.
+//It speaks RESP, the Redis protocol, and supports the string commands handbook uses with key expiration.
+//Data is only kept in memory.
+package redistest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//databases is the number of databases SELECT accepts
+const databases = 16
+
+//item represents a stored value
+type item struct {
+	value   string
+	expires time.Time //zero if the key doesn't expire
+}
+
+//Server represents a Redis-compatible server
+type Server struct {
+	password string //if set, clients must AUTH
+
+	mu     *sync.Mutex
+	dbs    [databases]map[string]*item
+	offset time.Duration //added to the clock by FastForward
+	l      net.Listener
+	conns  map[net.Conn]bool
+}
+
+//NewServer returns a new Server that requires clients to authenticate with password, unless it's empty.
+//Call Serve or Start to accept connections.
+func NewServer(password string) *Server {
+	s := &Server{password: password, mu: new(sync.Mutex), conns: make(map[net.Conn]bool)}
+	for i := range s.dbs {
+		s.dbs[i] = make(map[string]*item)
+	}
+	return s
+}
+
+//Start starts a new Server listening on a random local port, like httptest.NewServer
+func Start(password string) (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := NewServer(password)
+	s.l = l
+	go s.Serve(l)
+	return s, nil
+}
+
+//Addr returns the address the Server is listening on, or an empty string if it isn't
+func (s *Server) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.l == nil {
+		return ""
+	}
+	return s.l.Addr().String()
+}
+
+//Serve accepts connections on l until it's closed
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	s.l = l
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+//Close stops the Server and closes all client connections
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	if s.l == nil {
+		return nil
+	}
+	return s.l.Close()
+}
+
+//CloseClients closes all client connections, like a server restart, but keeps the data and keeps listening
+func (s *Server) CloseClients() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+//FastForward moves the Server's clock forward by d, expiring keys without waiting
+func (s *Server) FastForward(d time.Duration) {
+	s.mu.Lock()
+	s.offset += d
+	s.mu.Unlock()
+}
+
+//Keys returns the unexpired keys in the given database, sorted
+func (s *Server) Keys(db int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keys(db, "*")
+}
+
+//now returns the Server's clock. s.mu must be held.
+func (s *Server) now() time.Time {
+	return time.Now().Add(s.offset)
+}
+
+//get returns the unexpired item for key, removing it if it's expired. s.mu must be held.
+func (s *Server) get(db int, key string) *item {
+	it, ok := s.dbs[db][key]
+	if !ok {
+		return nil
+	}
+	if !it.expires.IsZero() && !it.expires.After(s.now()) {
+		delete(s.dbs[db], key)
+		return nil
+	}
+	return it
+}
+
+//keys returns the unexpired keys in db matching pattern, sorted. s.mu must be held.
+func (s *Server) keys(db int, pattern string) []string {
+	keys := make([]string, 0)
+	for k := range s.dbs[db] {
+		if ok, _ := path.Match(pattern, k); ok && s.get(db, k) != nil {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//readCommand reads a command as a RESP array of bulk strings or an inline command
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 {
+		return nil, errors.New("invalid multibulk length")
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err = r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("expected '$', got '%s'", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, errors.New("invalid bulk length")
+		}
+		buf := make([]byte, size+2)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+//serveConn handles commands from a client until it disconnects
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	c := &client{authed: s.password == ""}
+
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				w.WriteString("-ERR Protocol error: " + err.Error() + "\r\n")
+				w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		quit := s.exec(c, w, args)
+		if err = w.Flush(); err != nil {
+			log.Println("Error writing reply:", err)
+			return
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+//client represents a client connection's state
+type client struct {
+	authed bool
+	db     int
+}
+
+//writeBulk writes a bulk string, or a nil bulk string if v is nil
+func writeBulk(w *bufio.Writer, v *string) {
+	if v == nil {
+		w.WriteString("$-1\r\n")
+		return
+	}
+	w.WriteString("$" + strconv.Itoa(len(*v)) + "\r\n" + *v + "\r\n")
+}
+
+//writeInt writes an integer
+func writeInt(w *bufio.Writer, n int64) {
+	w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+//exec runs a command and writes its reply to w. It returns true if the client should be disconnected.
+func (s *Server) exec(c *client, w *bufio.Writer, args []string) (quit bool) {
+	cmd := strings.ToUpper(args[0])
+	wrongArgs := func() {
+		w.WriteString("-ERR wrong number of arguments for '" + strings.ToLower(cmd) + "' command\r\n")
+	}
+
+	switch cmd {
+	case "QUIT":
+		w.WriteString("+OK\r\n")
+		return true
+	case "AUTH":
+		//AUTH password, or AUTH username password for Redis 6 ACLs
+		if len(args) != 2 && len(args) != 3 {
+			wrongArgs()
+			return false
+		}
+		if s.password == "" {
+			w.WriteString("-ERR AUTH <password> called without any password configured for the default user\r\n")
+			return false
+		}
+		if args[len(args)-1] != s.password || (len(args) == 3 && args[1] != "default") {
+			w.WriteString("-WRONGPASS invalid username-password pair or user is disabled.\r\n")
+			return false
+		}
+		c.authed = true
+		w.WriteString("+OK\r\n")
+		return false
+	}
+
+	if !c.authed {
+		w.WriteString("-NOAUTH Authentication required.\r\n")
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch cmd {
+	case "PING":
+		if len(args) > 1 {
+			writeBulk(w, &args[1])
+		} else {
+			w.WriteString("+PONG\r\n")
+		}
+	case "ECHO":
+		if len(args) != 2 {
+			wrongArgs()
+			break
+		}
+		writeBulk(w, &args[1])
+	case "SELECT":
+		if len(args) != 2 {
+			wrongArgs()
+			break
+		}
+		db, err := strconv.Atoi(args[1])
+		if err != nil || db < 0 || db >= databases {
+			w.WriteString("-ERR DB index is out of range\r\n")
+			break
+		}
+		c.db = db
+		w.WriteString("+OK\r\n")
+	case "GET":
+		if len(args) != 2 {
+			wrongArgs()
+			break
+		}
+		if it := s.get(c.db, args[1]); it != nil {
+			writeBulk(w, &it.value)
+		} else {
+			writeBulk(w, nil)
+		}
+	case "SET":
+		s.set(c, w, args)
+	case "DEL", "EXISTS":
+		if len(args) < 2 {
+			wrongArgs()
+			break
+		}
+		var n int64
+		for _, k := range args[1:] {
+			if s.get(c.db, k) != nil {
+				n++
+				if cmd == "DEL" {
+					delete(s.dbs[c.db], k)
+				}
+			}
+		}
+		writeInt(w, n)
+	case "EXPIRE", "PEXPIRE":
+		if len(args) != 3 {
+			wrongArgs()
+			break
+		}
+		n, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			w.WriteString("-ERR value is not an integer or out of range\r\n")
+			break
+		}
+		unit := time.Second
+		if cmd == "PEXPIRE" {
+			unit = time.Millisecond
+		}
+		it := s.get(c.db, args[1])
+		if it == nil {
+			writeInt(w, 0)
+			break
+		}
+		it.expires = s.now().Add(time.Duration(n) * unit)
+		writeInt(w, 1)
+	case "TTL", "PTTL":
+		if len(args) != 2 {
+			wrongArgs()
+			break
+		}
+		it := s.get(c.db, args[1])
+		switch {
+		case it == nil:
+			writeInt(w, -2)
+		case it.expires.IsZero():
+			writeInt(w, -1)
+		case cmd == "TTL":
+			writeInt(w, int64((it.expires.Sub(s.now())+time.Second-1)/time.Second))
+		default:
+			writeInt(w, int64(it.expires.Sub(s.now())/time.Millisecond))
+		}
+	case "KEYS":
+		if len(args) != 2 {
+			wrongArgs()
+			break
+		}
+		keys := s.keys(c.db, args[1])
+		w.WriteString("*" + strconv.Itoa(len(keys)) + "\r\n")
+		for i := range keys {
+			writeBulk(w, &keys[i])
+		}
+	case "DBSIZE":
+		writeInt(w, int64(len(s.keys(c.db, "*"))))
+	case "FLUSHDB":
+		s.dbs[c.db] = make(map[string]*item)
+		w.WriteString("+OK\r\n")
+	case "FLUSHALL":
+		for i := range s.dbs {
+			s.dbs[i] = make(map[string]*item)
+		}
+		w.WriteString("+OK\r\n")
+	default:
+		w.WriteString("-ERR unknown command '" + args[0] + "'\r\n")
+	}
+
+	return false
+}
+
+//set runs SET key value [EX seconds|PX milliseconds] [NX|XX] and writes its reply to w. s.mu must be held.
+func (s *Server) set(c *client, w *bufio.Writer, args []string) {
+	if len(args) < 3 {
+		w.WriteString("-ERR wrong number of arguments for 'set' command\r\n")
+		return
+	}
+
+	var expires time.Time
+	var nx, xx bool
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); opt {
+		case "EX", "PX":
+			if i+1 >= len(args) || !expires.IsZero() {
+				w.WriteString("-ERR syntax error\r\n")
+				return
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || n <= 0 {
+				w.WriteString("-ERR invalid expire time in 'set' command\r\n")
+				return
+			}
+			unit := time.Second
+			if opt == "PX" {
+				unit = time.Millisecond
+			}
+			expires = s.now().Add(time.Duration(n) * unit)
+			i++
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		default:
+			w.WriteString("-ERR syntax error\r\n")
+			return
+		}
+	}
+	if nx && xx {
+		w.WriteString("-ERR syntax error\r\n")
+		return
+	}
+
+	exists := s.get(c.db, args[1]) != nil
+	if (nx && exists) || (xx && !exists) {
+		writeBulk(w, nil)
+		return
+	}
+
+	s.dbs[c.db][args[1]] = &item{value: args[2], expires: expires}
+	w.WriteString("+OK\r\n")
+}
@@ -0,0 +1,494 @@
+package api
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//SAML namespaces and identifiers
+const (
+	samlAssertionNamespace = "urn:oasis:names:tc:SAML:2.0:assertion"
+	samlProtocolNamespace  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	samlMetadataNamespace  = "urn:oasis:names:tc:SAML:2.0:metadata"
+
+	samlBindingPOST   = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	samlStatusSuccess = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	samlBearer        = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+	samlNameIDFormat  = "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified"
+)
+
+//ErrInvalidSAMLResponse is returned when a SAML Response fails validation
+var ErrInvalidSAMLResponse = errors.New("Invalid SAML Response")
+
+//samlRequestDuration is how long a login has to complete at the identity provider
+const samlRequestDuration = 10 * time.Minute
+
+//samlClockSkew is the allowed difference between the identity provider's and the server's clocks
+const samlClockSkew = 2 * time.Minute
+
+//samlReauthDuration is how long a re-authentication is valid for, both since the user authenticated
+//at the identity provider and until the client uses it to sign
+const samlReauthDuration = 5 * time.Minute
+
+//SAMLConfig represents the configuration of a SAML 2.0 service provider
+type SAMLConfig struct {
+	EntityID string //service provider entity ID
+	ACSURL   string //URL of the assertion consumer service endpoint registered with the identity provider
+
+	IdPEntityID     string
+	IdPSSOURL       string              //identity provider single sign-on URL for the HTTP-Redirect binding
+	IdPCertificates []*x509.Certificate //certificates trusted to sign responses or assertions
+
+	EmployeeIDAttribute string //attribute mapped to User.EmployeeID; default: employeeID
+	UsernameAttribute   string //attribute mapped to User.Username; default: the NameID
+	FirstNameAttribute  string //attribute mapped to User.FirstName; default: givenName
+	LastNameAttribute   string //attribute mapped to User.LastName; default: sn
+	GroupsAttribute     string //attribute listing the user's groups; default: groups
+
+	Group  string       //if non-empty, only members of Group can log in
+	Admins *AdminGroups //groups mapped to admin Roles and campuses
+}
+
+//samlPending represents a login waiting on the identity provider
+type samlPending struct {
+	ID     string
+	Admin  bool
+	Reauth bool //if the login re-confirms the identity of a user who is signing
+}
+
+//SAMLAuth represents an Auth that uses a SAML 2.0 identity provider,
+//sending AuthnRequests with the HTTP-Redirect binding and receiving Responses with the HTTP-POST binding.
+//Users are redirected to the identity provider instead of sending a password,
+//so Login and AdminLogin always fail; use AuthnRequestURL and ParseResponse instead.
+type SAMLAuth struct {
+	config *SAMLConfig
+
+	state    StateStore
+	handoffs *loginHandoffs
+	reauths  *loginHandoffs
+}
+
+//NewSAMLAuth returns a new SAMLAuth with the given config that keeps pending logins in state
+func NewSAMLAuth(config *SAMLConfig, state StateStore) *SAMLAuth {
+	if config.EmployeeIDAttribute == "" {
+		config.EmployeeIDAttribute = "employeeID"
+	}
+	if config.FirstNameAttribute == "" {
+		config.FirstNameAttribute = "givenName"
+	}
+	if config.LastNameAttribute == "" {
+		config.LastNameAttribute = "sn"
+	}
+	if config.GroupsAttribute == "" {
+		config.GroupsAttribute = "groups"
+	}
+	if config.Admins == nil {
+		config.Admins = &AdminGroups{}
+	}
+
+	return &SAMLAuth{
+		config:   config,
+		state:    state,
+		handoffs: newLoginHandoffs(state, "saml_handoff", handoffDuration),
+		reauths:  newLoginHandoffs(state, "saml_reauth", samlReauthDuration),
+	}
+}
+
+//Login always returns a nil user since SAMLAuth doesn't accept passwords
+func (a *SAMLAuth) Login(username, password string) (user *User, err error) {
+	return nil, nil
+}
+
+//AdminLogin always returns a nil user since SAMLAuth doesn't accept passwords
+func (a *SAMLAuth) AdminLogin(username, password string) (user *User, err error) {
+	return nil, nil
+}
+
+//samlMetadata represents service provider metadata
+type samlMetadata struct {
+	XMLName  xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID string   `xml:"entityID,attr"`
+	SP       struct {
+		AuthnRequestsSigned  bool   `xml:",attr"`
+		WantAssertionsSigned bool   `xml:",attr"`
+		Protocols            string `xml:"protocolSupportEnumeration,attr"`
+		NameIDFormat         string
+		ACS                  struct {
+			Binding   string `xml:",attr"`
+			Location  string `xml:",attr"`
+			Index     int    `xml:"index,attr"`
+			IsDefault bool   `xml:"isDefault,attr"`
+		} `xml:"AssertionConsumerService"`
+	} `xml:"SPSSODescriptor"`
+}
+
+//Metadata returns the service provider's metadata to register with the identity provider
+func (a *SAMLAuth) Metadata() ([]byte, error) {
+	var m samlMetadata
+	m.EntityID = a.config.EntityID
+	m.SP.WantAssertionsSigned = true
+	m.SP.Protocols = samlProtocolNamespace
+	m.SP.NameIDFormat = samlNameIDFormat
+	m.SP.ACS.Binding = samlBindingPOST
+	m.SP.ACS.Location = a.config.ACSURL
+	m.SP.ACS.IsDefault = true
+
+	buf, err := xml.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), buf...), nil
+}
+
+//samlAuthnRequest represents a SAML AuthnRequest
+type samlAuthnRequest struct {
+	XMLName      xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol AuthnRequest"`
+	ID           string   `xml:",attr"`
+	Version      string   `xml:",attr"`
+	IssueInstant string   `xml:",attr"`
+	Destination  string   `xml:",attr"`
+	ACSURL       string   `xml:"AssertionConsumerServiceURL,attr"`
+	Binding      string   `xml:"ProtocolBinding,attr"`
+	ForceAuthn   bool     `xml:",attr,omitempty"`
+	Issuer       string   `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	NameIDPolicy struct {
+		AllowCreate bool `xml:",attr"`
+	}
+}
+
+//AuthnRequestURL returns the identity provider URL to redirect the user to for login.
+//If admin is true, the login must be a valid admin login.
+func (a *SAMLAuth) AuthnRequestURL(admin bool) (string, error) {
+	return a.authnRequestURL(admin, false)
+}
+
+//ReauthRequestURL returns the identity provider URL to redirect a user to so they re-confirm their identity before signing.
+//The identity provider is asked to authenticate the user even if they're already signed in there.
+func (a *SAMLAuth) ReauthRequestURL() (string, error) {
+	return a.authnRequestURL(false, true)
+}
+
+//authnRequestURL returns the identity provider URL for a login with the given admin and reauth modes
+func (a *SAMLAuth) authnRequestURL(admin, reauth bool) (string, error) {
+	//IDs must not start with a digit
+	id, relayState := "_"+randString(40), randString(32)
+
+	now := time.Now()
+	req := samlAuthnRequest{
+		ID:           id,
+		Version:      "2.0",
+		IssueInstant: now.UTC().Format(time.RFC3339),
+		Destination:  a.config.IdPSSOURL,
+		ACSURL:       a.config.ACSURL,
+		Binding:      samlBindingPOST,
+		ForceAuthn:   reauth,
+		Issuer:       a.config.EntityID,
+	}
+	req.NameIDPolicy.AllowCreate = true
+
+	buf, err := xml.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("Error encoding AuthnRequest: %v", err)
+	}
+
+	deflated := new(bytes.Buffer)
+	w, err := flate.NewWriter(deflated, flate.DefaultCompression)
+	if err != nil {
+		return "", fmt.Errorf("Error compressing AuthnRequest: %v", err)
+	}
+	if _, err = w.Write(buf); err != nil {
+		return "", fmt.Errorf("Error compressing AuthnRequest: %v", err)
+	}
+	if err = w.Close(); err != nil {
+		return "", fmt.Errorf("Error compressing AuthnRequest: %v", err)
+	}
+
+	if err = putState(a.state, "saml_pending", relayState, &samlPending{ID: id, Admin: admin, Reauth: reauth}, now.Add(samlRequestDuration)); err != nil {
+		return "", fmt.Errorf("Error storing login state: %v", err)
+	}
+
+	u, err := url.Parse(a.config.IdPSSOURL)
+	if err != nil {
+		return "", fmt.Errorf("Error parsing identity provider URL: %v", err)
+	}
+	q := u.Query()
+	q.Set("SAMLRequest", base64.StdEncoding.EncodeToString(deflated.Bytes()))
+	q.Set("RelayState", relayState)
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+//samlTime parses the SAML dateTime attribute of e with the given name.
+//If the attribute is missing, the zero time is returned.
+func samlTime(e *xmlElement, name string) (time.Time, error) {
+	v := e.attr(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%v: invalid %s", ErrInvalidSAMLResponse, name)
+	}
+	return t, nil
+}
+
+//checkAssertion validates the conditions and subject of the given assertion for the request with the given ID
+//and returns its NameID
+func (a *SAMLAuth) checkAssertion(assertion *xmlElement, requestID string) (nameID string, err error) {
+	if issuer := assertion.child(samlAssertionNamespace, "Issuer"); issuer == nil || strings.TrimSpace(issuer.text()) != a.config.IdPEntityID {
+		return "", fmt.Errorf("%v: unexpected assertion issuer", ErrInvalidSAMLResponse)
+	}
+
+	now := time.Now()
+
+	conditions := assertion.child(samlAssertionNamespace, "Conditions")
+	if conditions == nil {
+		return "", fmt.Errorf("%v: missing Conditions", ErrInvalidSAMLResponse)
+	}
+	notBefore, err := samlTime(conditions, "NotBefore")
+	if err != nil {
+		return "", err
+	}
+	notOnOrAfter, err := samlTime(conditions, "NotOnOrAfter")
+	if err != nil {
+		return "", err
+	}
+	if (!notBefore.IsZero() && now.Add(samlClockSkew).Before(notBefore)) || (!notOnOrAfter.IsZero() && !now.Add(-samlClockSkew).Before(notOnOrAfter)) {
+		return "", fmt.Errorf("%v: assertion not valid at this time", ErrInvalidSAMLResponse)
+	}
+
+	//every AudienceRestriction must include this service provider
+	restrictions := conditions.childElements(samlAssertionNamespace, "AudienceRestriction")
+	if len(restrictions) == 0 {
+		return "", fmt.Errorf("%v: missing AudienceRestriction", ErrInvalidSAMLResponse)
+	}
+	for _, r := range restrictions {
+		found := false
+		for _, aud := range r.childElements(samlAssertionNamespace, "Audience") {
+			if strings.TrimSpace(aud.text()) == a.config.EntityID {
+				found = true
+			}
+		}
+		if !found {
+			return "", fmt.Errorf("%v: not issued for this service provider", ErrInvalidSAMLResponse)
+		}
+	}
+
+	subject := assertion.child(samlAssertionNamespace, "Subject")
+	if subject == nil {
+		return "", fmt.Errorf("%v: missing Subject", ErrInvalidSAMLResponse)
+	}
+
+	confirmed := false
+	for _, sc := range subject.childElements(samlAssertionNamespace, "SubjectConfirmation") {
+		data := sc.child(samlAssertionNamespace, "SubjectConfirmationData")
+		if sc.attr("Method") != samlBearer || data == nil {
+			continue
+		}
+		notOnOrAfter, err := samlTime(data, "NotOnOrAfter")
+		if err != nil {
+			return "", err
+		}
+		if notOnOrAfter.IsZero() || !now.Add(-samlClockSkew).Before(notOnOrAfter) {
+			continue
+		}
+		if data.attr("Recipient") != a.config.ACSURL {
+			continue
+		}
+		if irt := data.attr("InResponseTo"); irt != "" && irt != requestID {
+			continue
+		}
+		confirmed = true
+	}
+	if !confirmed {
+		return "", fmt.Errorf("%v: no valid bearer SubjectConfirmation", ErrInvalidSAMLResponse)
+	}
+
+	if n := subject.child(samlAssertionNamespace, "NameID"); n != nil {
+		nameID = strings.TrimSpace(n.text())
+	}
+
+	return nameID, nil
+}
+
+//samlAttributes returns the values of the attributes in the given assertion by Name and FriendlyName
+func samlAttributes(assertion *xmlElement) map[string][]string {
+	attrs := make(map[string][]string)
+	for _, stmt := range assertion.childElements(samlAssertionNamespace, "AttributeStatement") {
+		for _, attr := range stmt.childElements(samlAssertionNamespace, "Attribute") {
+			var values []string
+			for _, v := range attr.childElements(samlAssertionNamespace, "AttributeValue") {
+				values = append(values, strings.TrimSpace(v.text()))
+			}
+			for _, name := range []string{attr.attr("Name"), attr.attr("FriendlyName")} {
+				if name != "" {
+					attrs[name] = append(attrs[name], values...)
+				}
+			}
+		}
+	}
+	return attrs
+}
+
+//ParseResponse validates the base64 encoded SAML Response and RelayState posted by the identity provider
+//and returns the logged in User. admin is whether the login was started as an admin login,
+//and reauth whether it was started with ReauthRequestURL.
+//The Response or its Assertion must be signed by one of the configured identity provider certificates,
+//and must be in response to an AuthnRequest from AuthnRequestURL; unsolicited responses are rejected.
+//If the login is valid, user will be non-nil.
+//If the response is invalid, user will be nil and error will be non-nil.
+func (a *SAMLAuth) ParseResponse(samlResponse, relayState string) (user *User, admin, reauth bool, err error) {
+	pending := new(samlPending)
+	ok, err := takeState(a.state, "saml_pending", relayState, pending)
+	if err != nil {
+		return nil, false, false, fmt.Errorf("Error loading login state: %v", err)
+	}
+	if !ok {
+		return nil, false, false, errors.New("Unknown or expired login state")
+	}
+
+	buf, err := decodeBase64(samlResponse)
+	if err != nil {
+		return nil, pending.Admin, pending.Reauth, fmt.Errorf("%v: malformed base64", ErrInvalidSAMLResponse)
+	}
+
+	root, err := parseXML(bytes.NewReader(buf))
+	if err != nil {
+		return nil, pending.Admin, pending.Reauth, fmt.Errorf("%v: %v", ErrInvalidSAMLResponse, err)
+	}
+	if !root.is(samlProtocolNamespace, "Response") {
+		return nil, pending.Admin, pending.Reauth, fmt.Errorf("%v: not a Response", ErrInvalidSAMLResponse)
+	}
+
+	//duplicate IDs could make a signature cover a different element than the one read
+	ids := make(map[string]bool)
+	dup := false
+	root.walk(func(e *xmlElement) {
+		if id := e.attr("ID"); id != "" {
+			dup = dup || ids[id]
+			ids[id] = true
+		}
+	})
+	if dup {
+		return nil, pending.Admin, pending.Reauth, fmt.Errorf("%v: duplicate IDs", ErrInvalidSAMLResponse)
+	}
+
+	if d := root.attr("Destination"); d != "" && d != a.config.ACSURL {
+		return nil, pending.Admin, pending.Reauth, fmt.Errorf("%v: unexpected Destination %s", ErrInvalidSAMLResponse, d)
+	}
+	if root.attr("InResponseTo") != pending.ID {
+		return nil, pending.Admin, pending.Reauth, fmt.Errorf("%v: not in response to this login", ErrInvalidSAMLResponse)
+	}
+	if issuer := root.child(samlAssertionNamespace, "Issuer"); issuer != nil && strings.TrimSpace(issuer.text()) != a.config.IdPEntityID {
+		return nil, pending.Admin, pending.Reauth, fmt.Errorf("%v: unexpected issuer", ErrInvalidSAMLResponse)
+	}
+
+	var status string
+	if s := root.child(samlProtocolNamespace, "Status"); s != nil {
+		if code := s.child(samlProtocolNamespace, "StatusCode"); code != nil {
+			status = code.attr("Value")
+		}
+	}
+	if status != samlStatusSuccess {
+		return nil, pending.Admin, pending.Reauth, fmt.Errorf("Login failed at identity provider: %s", status)
+	}
+
+	if root.child(samlAssertionNamespace, "EncryptedAssertion") != nil {
+		return nil, pending.Admin, pending.Reauth, fmt.Errorf("%v: encrypted assertions aren't supported", ErrInvalidSAMLResponse)
+	}
+	assertions := root.childElements(samlAssertionNamespace, "Assertion")
+	if len(assertions) != 1 {
+		return nil, pending.Admin, pending.Reauth, fmt.Errorf("%v: expected one Assertion, found %d", ErrInvalidSAMLResponse, len(assertions))
+	}
+	assertion := assertions[0]
+
+	signed := false
+	for _, e := range []*xmlElement{root, assertion} {
+		sigs := e.childElements(dsigNamespace, "Signature")
+		if len(sigs) > 1 {
+			return nil, pending.Admin, pending.Reauth, fmt.Errorf("%v: multiple signatures", ErrInvalidSAMLResponse)
+		}
+		if len(sigs) == 1 {
+			if err = verifyXMLSignature(sigs[0], a.config.IdPCertificates); err != nil {
+				return nil, pending.Admin, pending.Reauth, err
+			}
+			signed = true
+		}
+	}
+	if !signed {
+		return nil, pending.Admin, pending.Reauth, fmt.Errorf("%v: neither Response nor Assertion is signed", ErrInvalidSAMLResponse)
+	}
+
+	nameID, err := a.checkAssertion(assertion, pending.ID)
+	if err != nil {
+		return nil, pending.Admin, pending.Reauth, err
+	}
+
+	if pending.Reauth {
+		//identity providers may ignore ForceAuthn, so the user must have just authenticated
+		var authnInstant time.Time
+		for _, stmt := range assertion.childElements(samlAssertionNamespace, "AuthnStatement") {
+			t, err := samlTime(stmt, "AuthnInstant")
+			if err != nil {
+				return nil, pending.Admin, pending.Reauth, err
+			}
+			if t.After(authnInstant) {
+				authnInstant = t
+			}
+		}
+		if authnInstant.IsZero() || time.Since(authnInstant) > samlReauthDuration+samlClockSkew {
+			return nil, pending.Admin, pending.Reauth, fmt.Errorf("%v: re-authentication too old", ErrInvalidSAMLResponse)
+		}
+	}
+
+	attrs := samlAttributes(assertion)
+	first := func(name string) string {
+		if v := attrs[name]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	username := nameID
+	if a.config.UsernameAttribute != "" {
+		username = first(a.config.UsernameAttribute)
+	}
+	if username == "" {
+		return nil, pending.Admin, pending.Reauth, fmt.Errorf("%v: missing username", ErrInvalidSAMLResponse)
+	}
+
+	//some identity providers send groups as distinguished names, which match configured distinguished names
+	groups := attrs[a.config.GroupsAttribute]
+
+	if pending.Admin {
+		user = a.config.Admins.User(username, groups)
+	} else {
+		user = &User{Username: username}
+		if a.config.Group != "" {
+			user = nil
+			for _, g := range groups {
+				if matchGroup(a.config.Group, g) {
+					user = &User{Username: username}
+				}
+			}
+		}
+	}
+	if user == nil {
+		return nil, pending.Admin, pending.Reauth, nil
+	}
+
+	user.EmployeeID = first(a.config.EmployeeIDAttribute)
+	user.FirstName = first(a.config.FirstNameAttribute)
+	user.LastName = first(a.config.LastNameAttribute)
+
+	return user, pending.Admin, pending.Reauth, nil
+}
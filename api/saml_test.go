@@ -0,0 +1,306 @@
+package api
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"io/ioutil"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/korylprince/handbook/api/samltest"
+)
+
+const (
+	testSAMLEntityID = "https://handbook.example.com/api/1.0/saml/metadata"
+	testSAMLACSURL   = "https://handbook.example.com/api/1.0/saml/acs"
+	testSAMLIssuer   = "https://idp.example.com/saml"
+	testSAMLGroup    = "CN=Staff,OU=Groups,DC=example,DC=com"
+)
+
+//newTestIdP returns a new samltest.IdP issuing as testSAMLIssuer
+func newTestIdP(t *testing.T) *samltest.IdP {
+	idp, err := samltest.NewIdP(testSAMLIssuer)
+	if err != nil {
+		t.Fatalf("Error creating identity provider: %v", err)
+	}
+	return idp
+}
+
+//testSAMLLogin returns a login by jdoe with the given groups for the AuthnRequest with requestID
+func testSAMLLogin(requestID string, groups ...string) *samltest.Login {
+	return &samltest.Login{
+		RequestID: requestID,
+		ACSURL:    testSAMLACSURL,
+		Audience:  testSAMLEntityID,
+		Attributes: map[string][]string{
+			"uid": {"jdoe"}, "employeeID": {"123"}, "givenName": {"Jane"}, "sn": {"Doe"}, "groups": groups,
+		},
+		AssertionID: "_assertion",
+	}
+}
+
+//assertion returns a signed Assertion for l from idp
+func assertion(t *testing.T, idp *samltest.IdP, l *samltest.Login) string {
+	a, err := idp.Assertion(l)
+	if err != nil {
+		t.Fatalf("Error signing assertion: %v", err)
+	}
+	return a
+}
+
+//forged returns an unsigned Assertion for l logging in as admin
+func forged(t *testing.T, idp *samltest.IdP, l *samltest.Login) string {
+	l.Attributes["uid"] = []string{"admin"}
+	return regexp.MustCompile(`<ds:Signature .*</ds:Signature>`).ReplaceAllString(assertion(t, idp, l), "")
+}
+
+//samlResponse returns a successful Response for l from idp containing the given assertions, base64 encoded
+func samlResponse(idp *samltest.IdP, l *samltest.Login, assertions ...string) string {
+	return base64.StdEncoding.EncodeToString([]byte(idp.ResponseWith(l, assertions...)))
+}
+
+//newTestSAMLAuth returns a SAMLAuth trusting idp
+func newTestSAMLAuth(idp *samltest.IdP) *SAMLAuth {
+	return NewSAMLAuth(&SAMLConfig{
+		EntityID:        testSAMLEntityID,
+		ACSURL:          testSAMLACSURL,
+		IdPEntityID:     testSAMLIssuer,
+		IdPSSOURL:       "https://idp.example.com/saml/sso",
+		IdPCertificates: []*x509.Certificate{idp.Certificate()},
+		Group:           testSAMLGroup,
+		Admins: &AdminGroups{
+			Admin:    "Handbook Admins",
+			Campuses: map[string][]string{"HS Principals": {"High School"}},
+		},
+	}, NewMemoryStateStore())
+}
+
+//startSAMLLogin starts a login with a and returns its RelayState and the ID of the AuthnRequest sent to the identity provider
+func startSAMLLogin(t *testing.T, a *SAMLAuth, admin bool) (relayState, requestID string) {
+	u, err := a.AuthnRequestURL(admin)
+	if err != nil {
+		t.Fatalf("Error starting login: %v", err)
+	}
+	relayState, req := authnRequest(t, u)
+	if req.ForceAuthn {
+		t.Fatalf("Unexpected ForceAuthn in login: %+v", req)
+	}
+	return relayState, req.ID
+}
+
+//authnRequest returns the RelayState and AuthnRequest in the given identity provider URL
+func authnRequest(t *testing.T, u string) (relayState string, req *samlAuthnRequest) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		t.Fatalf("Error parsing AuthnRequest URL: %v", err)
+	}
+
+	deflated, err := base64.StdEncoding.DecodeString(parsed.Query().Get("SAMLRequest"))
+	if err != nil {
+		t.Fatalf("Error decoding AuthnRequest: %v", err)
+	}
+	buf, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	if err != nil {
+		t.Fatalf("Error decompressing AuthnRequest: %v", err)
+	}
+	req = new(samlAuthnRequest)
+	if err = xml.Unmarshal(buf, req); err != nil {
+		t.Fatalf("Error parsing AuthnRequest: %v", err)
+	}
+	if req.ACSURL != testSAMLACSURL || req.Issuer != testSAMLEntityID {
+		t.Fatalf("Unexpected AuthnRequest: %+v", req)
+	}
+
+	return parsed.Query().Get("RelayState"), req
+}
+
+func TestSAMLLogin(t *testing.T) {
+	idp := newTestIdP(t)
+	a := newTestSAMLAuth(idp)
+
+	tests := []struct {
+		name     string
+		admin    bool
+		groups   []string
+		expected *User
+	}{
+		{"staff", false, []string{"cn=Staff,ou=Groups,dc=example,dc=com"},
+			&User{EmployeeID: "123", Username: "jdoe", FirstName: "Jane", LastName: "Doe"}},
+		{"admin", true, []string{"Staff", "HS Principals"},
+			&User{EmployeeID: "123", Username: "jdoe", FirstName: "Jane", LastName: "Doe", Admin: true, Role: RoleViewer, Campuses: []string{"High School"}}},
+		{"staff not in group", false, []string{"Students"}, nil},
+		{"staff in a same-named group elsewhere", false, []string{"CN=Staff,OU=Students,DC=example,DC=com"}, nil},
+		{"staff in a group with the same name", false, []string{"Staff"}, nil},
+		{"admin not in admin group", true, []string{"Staff"}, nil},
+	}
+
+	for _, test := range tests {
+		relayState, requestID := startSAMLLogin(t, a, test.admin)
+		l := testSAMLLogin(requestID, test.groups...)
+
+		user, admin, reauth, err := a.ParseResponse(samlResponse(idp, l, assertion(t, idp, l)), relayState)
+		if err != nil {
+			t.Errorf("%s: Error parsing response: %v", test.name, err)
+			continue
+		}
+		if admin != test.admin || reauth {
+			t.Errorf("%s: expected admin %v without reauth, got %v, %v", test.name, test.admin, admin, reauth)
+		}
+		if !reflect.DeepEqual(user, test.expected) {
+			t.Errorf("%s: expected user %+v, got %+v", test.name, test.expected, user)
+		}
+	}
+}
+
+func TestSAMLResponseRejected(t *testing.T) {
+	idp := newTestIdP(t)
+	other := newTestIdP(t)
+	a := newTestSAMLAuth(idp)
+
+	tests := []struct {
+		name     string
+		response func(requestID string) string
+		err      string
+	}{
+		{"tampered attribute", func(requestID string) string {
+			l := testSAMLLogin(requestID, testSAMLGroup)
+			return samlResponse(idp, l, strings.Replace(assertion(t, idp, l), ">Jane<", ">Eve<", 1))
+		}, "digest mismatch"},
+		{"signed assertion beside an unsigned one", func(requestID string) string {
+			l := testSAMLLogin(requestID, testSAMLGroup)
+			signed := assertion(t, idp, l)
+			f := testSAMLLogin(requestID, testSAMLGroup)
+			f.AssertionID = "_forged"
+			return samlResponse(idp, l, forged(t, idp, f), signed)
+		}, "expected one Assertion, found 2"},
+		{"signed assertion inside an unsigned one", func(requestID string) string {
+			l := testSAMLLogin(requestID, testSAMLGroup)
+			signed := assertion(t, idp, l)
+			f := testSAMLLogin(requestID, testSAMLGroup)
+			f.AssertionID = "_forged"
+			wrapped := strings.Replace(forged(t, idp, f), "</saml:Issuer>", "</saml:Issuer><saml:Advice>"+signed+"</saml:Advice>", 1)
+			return samlResponse(idp, l, wrapped)
+		}, "neither Response nor Assertion is signed"},
+		{"duplicate ID", func(requestID string) string {
+			l := testSAMLLogin(requestID, testSAMLGroup)
+			signed := assertion(t, idp, l)
+			wrapped := strings.Replace(forged(t, idp, testSAMLLogin(requestID, testSAMLGroup)), "</saml:Issuer>", "</saml:Issuer><saml:Advice>"+signed+"</saml:Advice>", 1)
+			return samlResponse(idp, l, wrapped)
+		}, "duplicate IDs"},
+		{"unsigned", func(requestID string) string {
+			l := testSAMLLogin(requestID, testSAMLGroup)
+			return samlResponse(idp, l, forged(t, idp, l))
+		}, "neither Response nor Assertion is signed"},
+		{"untrusted certificate", func(requestID string) string {
+			l := testSAMLLogin(requestID, testSAMLGroup)
+			return samlResponse(idp, l, assertion(t, other, l))
+		}, "signature not made by a trusted certificate"},
+		{"wrong audience", func(requestID string) string {
+			l := testSAMLLogin(requestID, testSAMLGroup)
+			l.Audience = "https://other.example.com"
+			return samlResponse(idp, l, assertion(t, idp, l))
+		}, "not issued for this service provider"},
+		{"wrong destination", func(requestID string) string {
+			l := testSAMLLogin(requestID, testSAMLGroup)
+			signed := assertion(t, idp, l)
+			l.ACSURL = "https://other.example.com/acs"
+			return samlResponse(idp, l, signed)
+		}, "unexpected Destination"},
+		{"expired", func(requestID string) string {
+			l := testSAMLLogin(requestID, testSAMLGroup)
+			l.NotOnOrAfter = time.Now().Add(-time.Hour)
+			return samlResponse(idp, l, assertion(t, idp, l))
+		}, "assertion not valid at this time"},
+		{"another login's response", func(requestID string) string {
+			l := testSAMLLogin("_other", testSAMLGroup)
+			return samlResponse(idp, l, assertion(t, idp, l))
+		}, "not in response to this login"},
+		{"failed login", func(requestID string) string {
+			l := testSAMLLogin(requestID)
+			l.Attributes = nil
+			resp, err := idp.Response(l)
+			if err != nil {
+				t.Fatalf("Error creating response: %v", err)
+			}
+			return base64.StdEncoding.EncodeToString([]byte(resp))
+		}, "Login failed at identity provider"},
+	}
+
+	for _, test := range tests {
+		relayState, requestID := startSAMLLogin(t, a, false)
+		user, _, _, err := a.ParseResponse(test.response(requestID), relayState)
+		if user != nil {
+			t.Errorf("%s: expected no user, got %+v", test.name, user)
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing %q, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestSAMLRelayStateSingleUse(t *testing.T) {
+	idp := newTestIdP(t)
+	a := newTestSAMLAuth(idp)
+
+	relayState, requestID := startSAMLLogin(t, a, false)
+	l := testSAMLLogin(requestID, testSAMLGroup)
+	resp := samlResponse(idp, l, assertion(t, idp, l))
+
+	if user, _, _, err := a.ParseResponse(resp, relayState); err != nil || user == nil {
+		t.Fatalf("Expected first response to log in, got %v, %v", user, err)
+	}
+	if user, _, _, err := a.ParseResponse(resp, relayState); user != nil || err == nil || !strings.Contains(err.Error(), "Unknown or expired login state") {
+		t.Errorf("Expected replayed response to be rejected, got %v, %v", user, err)
+	}
+	if user, _, _, err := a.ParseResponse(resp, "forged"); user != nil || err == nil || !strings.Contains(err.Error(), "Unknown or expired login state") {
+		t.Errorf("Expected unknown RelayState to be rejected, got %v, %v", user, err)
+	}
+}
+
+func TestSAMLReauth(t *testing.T) {
+	idp := newTestIdP(t)
+	a := newTestSAMLAuth(idp)
+
+	tests := []struct {
+		name         string
+		authnInstant time.Time
+		err          string
+	}{
+		{"fresh", time.Now(), ""},
+		{"already signed in at identity provider", time.Now().Add(-time.Hour), "re-authentication too old"},
+	}
+
+	for _, test := range tests {
+		u, err := a.ReauthRequestURL()
+		if err != nil {
+			t.Fatalf("Error starting re-authentication: %v", err)
+		}
+		relayState, req := authnRequest(t, u)
+		if !req.ForceAuthn {
+			t.Errorf("%s: expected ForceAuthn in AuthnRequest", test.name)
+		}
+
+		l := testSAMLLogin(req.ID, testSAMLGroup)
+		l.AuthnInstant = test.authnInstant
+		user, admin, reauth, err := a.ParseResponse(samlResponse(idp, l, assertion(t, idp, l)), relayState)
+		if admin || !reauth {
+			t.Errorf("%s: expected reauth without admin, got %v, %v", test.name, admin, reauth)
+		}
+		if test.err != "" {
+			if user != nil || err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected error containing %q, got %v, %v", test.name, test.err, user, err)
+			}
+			continue
+		}
+		if err != nil || user == nil || user.EmployeeID != "123" {
+			t.Errorf("%s: expected user 123, got %+v, %v", test.name, user, err)
+		}
+	}
+}
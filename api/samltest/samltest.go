@@ -0,0 +1,236 @@
+//Package samltest provides a SAML 2.0 identity provider for developing and testing SAML logins.
+//It writes Responses with a signed Assertion in exclusive canonical form, so they can be signed without a canonicalizer.
+//It generates a new signing key each time it's created and should not be used in production.
+package samltest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"time"
+)
+
+//SAML namespaces and identifiers
+const (
+	NSAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+	NSProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	NSDSig      = "http://www.w3.org/2000/09/xmldsig#"
+
+	statusSuccess   = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	statusRequester = "urn:oasis:names:tc:SAML:2.0:status:Requester"
+	statusFailed    = "urn:oasis:names:tc:SAML:2.0:status:AuthnFailed"
+)
+
+//AssertionDuration is how long an issued assertion is valid by default
+const AssertionDuration = 5 * time.Minute
+
+//attributes are the attributes an Assertion can have, in order
+var attributes = []string{"uid", "employeeID", "givenName", "sn", "groups"}
+
+//IdP represents a SAML identity provider
+type IdP struct {
+	EntityID string
+
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+}
+
+//NewIdP returns a new IdP with a new signing key and self-signed certificate
+func NewIdP(entityID string) (*IdP, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "handbook-saml"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &IdP{EntityID: entityID, key: key, cert: cert}, nil
+}
+
+//Certificate returns the IdP's signing certificate
+func (idp *IdP) Certificate() *x509.Certificate {
+	return idp.cert
+}
+
+//CertificatePEM returns the IdP's signing certificate PEM encoded
+func (idp *IdP) CertificatePEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: idp.cert.Raw})
+}
+
+//randID returns a random XML ID
+func randID() string {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return "_" + hex.EncodeToString(buf)
+}
+
+//escapeText escapes s as canonical XML character data
+func escapeText(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;").Replace(s)
+}
+
+//EscapeAttr escapes s as a canonical XML attribute value
+func EscapeAttr(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", "\"", "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;").Replace(s)
+}
+
+//signature returns an enveloped XML Signature over the canonical element with the given ID.
+//canonical must be the exclusive canonical form of the element without the signature.
+func (idp *IdP) signature(id string, canonical string) (string, error) {
+	digest := sha256.Sum256([]byte(canonical))
+
+	//written in canonical form so it can be signed as is
+	signedInfo := `<ds:SignedInfo xmlns:ds="` + NSDSig + `">` +
+		`<ds:CanonicalizationMethod Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#"></ds:CanonicalizationMethod>` +
+		`<ds:SignatureMethod Algorithm="http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"></ds:SignatureMethod>` +
+		`<ds:Reference URI="#` + id + `"><ds:Transforms>` +
+		`<ds:Transform Algorithm="http://www.w3.org/2000/09/xmldsig#enveloped-signature"></ds:Transform>` +
+		`<ds:Transform Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#"></ds:Transform>` +
+		`</ds:Transforms><ds:DigestMethod Algorithm="http://www.w3.org/2001/04/xmlenc#sha256"></ds:DigestMethod>` +
+		`<ds:DigestValue>` + base64.StdEncoding.EncodeToString(digest[:]) + `</ds:DigestValue></ds:Reference>` +
+		`</ds:SignedInfo>`
+
+	hashed := sha256.Sum256([]byte(signedInfo))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", err
+	}
+
+	//SignedInfo inherits the ds namespace from Signature in the document
+	return `<ds:Signature xmlns:ds="` + NSDSig + `">` +
+		strings.Replace(signedInfo, ` xmlns:ds="`+NSDSig+`"`, "", 1) +
+		`<ds:SignatureValue>` + base64.StdEncoding.EncodeToString(sig) + `</ds:SignatureValue>` +
+		`<ds:KeyInfo><ds:X509Data><ds:X509Certificate>` + base64.StdEncoding.EncodeToString(idp.cert.Raw) +
+		`</ds:X509Certificate></ds:X509Data></ds:KeyInfo></ds:Signature>`, nil
+}
+
+//Login represents a user logging in for an AuthnRequest
+type Login struct {
+	RequestID string
+	ACSURL    string //the Response's Destination and the Assertion's Recipient
+	Audience  string
+
+	//Attributes are the user's uid, employeeID, givenName, sn, and groups attributes; others aren't sent.
+	//The uid is also the NameID. If nil, the login failed.
+	Attributes map[string][]string
+
+	AssertionID  string    //default: a random ID
+	NotOnOrAfter time.Time //default: AssertionDuration from now
+	AuthnInstant time.Time //when the user authenticated; default: now
+}
+
+//Response returns a Response for l with a signed Assertion.
+//If l.Attributes is nil, the Response is a failure without an Assertion.
+func (idp *IdP) Response(l *Login) (string, error) {
+	if l.Attributes == nil {
+		status := `<samlp:Status><samlp:StatusCode Value="` + statusRequester + `"><samlp:StatusCode Value="` +
+			statusFailed + `"></samlp:StatusCode></samlp:StatusCode></samlp:Status>`
+		return idp.envelope(l, status), nil
+	}
+
+	assertion, err := idp.Assertion(l)
+	if err != nil {
+		return "", err
+	}
+	return idp.ResponseWith(l, assertion), nil
+}
+
+//ResponseWith returns a successful Response for l containing the given assertions as is.
+//Assertions can come from Assertion, unsigned, modified, or from another IdP, to test how they're handled.
+func (idp *IdP) ResponseWith(l *Login, assertions ...string) string {
+	status := `<samlp:Status><samlp:StatusCode Value="` + statusSuccess + `"></samlp:StatusCode></samlp:Status>`
+	return idp.envelope(l, status+strings.Join(assertions, ""))
+}
+
+//envelope returns a Response for l with the given content after its Issuer
+func (idp *IdP) envelope(l *Login, content string) string {
+	//the Response declares the saml namespace, so the Assertion inherits it in the document
+	return `<samlp:Response xmlns:samlp="` + NSProtocol + `" xmlns:saml="` + NSAssertion + `"` +
+		` Destination="` + EscapeAttr(l.ACSURL) + `" ID="` + randID() + `" InResponseTo="` + EscapeAttr(l.RequestID) + `"` +
+		` IssueInstant="` + time.Now().UTC().Format(time.RFC3339) + `" Version="2.0">` +
+		`<saml:Issuer>` + escapeText(idp.EntityID) + `</saml:Issuer>` + content + `</samlp:Response>`
+}
+
+//Assertion returns a signed Assertion for l, written to be placed in a Response that declares the saml namespace
+func (idp *IdP) Assertion(l *Login) (string, error) {
+	now := time.Now().UTC()
+	instant := now.Format(time.RFC3339)
+	expires := now.Add(AssertionDuration)
+	if !l.NotOnOrAfter.IsZero() {
+		expires = l.NotOnOrAfter
+	}
+	notOnOrAfter := expires.UTC().Format(time.RFC3339)
+	authnInstant := instant
+	if !l.AuthnInstant.IsZero() {
+		authnInstant = l.AuthnInstant.UTC().Format(time.RFC3339)
+	}
+	id := l.AssertionID
+	if id == "" {
+		id = randID()
+	}
+
+	username := ""
+	if u := l.Attributes["uid"]; len(u) > 0 {
+		username = u[0]
+	}
+
+	var attrs strings.Builder
+	for _, name := range attributes {
+		attrs.WriteString(`<saml:Attribute Name="` + name + `">`)
+		for _, v := range l.Attributes[name] {
+			attrs.WriteString(`<saml:AttributeValue>` + escapeText(v) + `</saml:AttributeValue>`)
+		}
+		attrs.WriteString(`</saml:Attribute>`)
+	}
+
+	//written in canonical form so it can be signed as is
+	open := `<saml:Assertion xmlns:saml="` + NSAssertion + `" ID="` + EscapeAttr(id) + `" IssueInstant="` + instant + `" Version="2.0">`
+	issuer := `<saml:Issuer>` + escapeText(idp.EntityID) + `</saml:Issuer>`
+	body := `<saml:Subject><saml:NameID>` + escapeText(username) + `</saml:NameID>` +
+		`<saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">` +
+		`<saml:SubjectConfirmationData InResponseTo="` + EscapeAttr(l.RequestID) + `" NotOnOrAfter="` + notOnOrAfter +
+		`" Recipient="` + EscapeAttr(l.ACSURL) + `"></saml:SubjectConfirmationData></saml:SubjectConfirmation></saml:Subject>` +
+		`<saml:Conditions NotBefore="` + instant + `" NotOnOrAfter="` + notOnOrAfter + `">` +
+		`<saml:AudienceRestriction><saml:Audience>` + escapeText(l.Audience) + `</saml:Audience></saml:AudienceRestriction>` +
+		`</saml:Conditions>` +
+		`<saml:AuthnStatement AuthnInstant="` + authnInstant + `" SessionIndex="` + EscapeAttr(id) + `"><saml:AuthnContext>` +
+		`<saml:AuthnContextClassRef>urn:oasis:names:tc:SAML:2.0:ac:classes:unspecified</saml:AuthnContextClassRef>` +
+		`</saml:AuthnContext></saml:AuthnStatement>` +
+		`<saml:AttributeStatement>` + attrs.String() + `</saml:AttributeStatement></saml:Assertion>`
+
+	sig, err := idp.signature(id, open+issuer+body)
+	if err != nil {
+		return "", err
+	}
+
+	open = strings.Replace(open, ` xmlns:saml="`+NSAssertion+`"`, "", 1)
+	return open + issuer + sig + body, nil
+}
@@ -15,6 +15,10 @@ type SessionStore interface {
 	//If sessionID is not valid, session will be nil.
 	//If the backend malfunctions, session will be nil and err will be non-nil.
 	Check(sessionID string) (session *Session, err error)
+
+	//Delete ends the session with the given sessionID, if it's valid.
+	//If the backend malfunctions, err will be non-nil.
+	Delete(sessionID string) error
 }
 
 //Session represents a login session
@@ -89,3 +93,11 @@ func (m *MemorySessionStore) Check(sessionID string) (session *Session, err erro
 	}
 	return nil, nil
 }
+
+//Delete ends the session with the given sessionID. err will always be nil.
+func (m *MemorySessionStore) Delete(sessionID string) error {
+	m.mu.Lock()
+	delete(m.store, sessionID)
+	m.mu.Unlock()
+	return nil
+}
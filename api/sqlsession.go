@@ -0,0 +1,143 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+//sqlScavengeInterval is how often expired sessions are removed from the database
+const sqlScavengeInterval = 10 * time.Minute
+
+//sqlScavengeLease is the name of the lease the replica removing expired sessions holds
+const sqlScavengeLease = "session_scavenger"
+
+//SQLSessionStore represents a SessionStore that uses a SQL database, so sessions are shared by replicas and survive restarts.
+//Session IDs are stored hashed, so they can't be recovered from the database.
+//Expired sessions are removed by a single replica, chosen with a lease in the database.
+type SQLSessionStore struct {
+	db            *SQLDB
+	duration      time.Duration
+	adminDuration time.Duration
+	owner         string //identifies this replica when holding the scavenger lease
+}
+
+//NewSQLSessionStore returns a new SQLSessionStore with the given expiration duration that stores sessions in db
+func NewSQLSessionStore(db *SQLDB, duration time.Duration, adminDuration time.Duration) *SQLSessionStore {
+	s := &SQLSessionStore{
+		db:            db,
+		duration:      duration,
+		adminDuration: adminDuration,
+		owner:         randString(32),
+	}
+	go s.scavenge()
+	return s
+}
+
+//Create returns a new sessionID with the given User. If the database malfunctions,
+//sessionID will be an empty string and err will be non-nil.
+func (s *SQLSessionStore) Create(user *User) (sessionID string, err error) {
+	id := randString(128)
+	var dur time.Duration
+	if user.Admin {
+		dur = s.adminDuration
+	} else {
+		dur = s.duration
+	}
+
+	data, err := json.Marshal(user)
+	if err != nil {
+		return "", fmt.Errorf("Error encoding user: %v", err)
+	}
+
+	//times are stored in UTC so they compare correctly in every driver
+	_, err = s.db.db.Exec("INSERT INTO sessions(id, data, expires) VALUES(?, ?, ?);", hashToken(id), data, time.Now().Add(dur).UTC())
+	if err != nil {
+		return "", fmt.Errorf("Error creating session: %v", err)
+	}
+
+	return id, nil
+}
+
+//Check returns whether or not sessionID is a valid session. If sessionID is not valid, session will be nil.
+//If the database malfunctions, session will be nil and err will be non-nil.
+func (s *SQLSessionStore) Check(sessionID string) (session *Session, err error) {
+	var data []byte
+	var expires time.Time
+	row := s.db.db.QueryRow("SELECT data, expires FROM sessions WHERE id=? AND expires>?;", hashToken(sessionID), time.Now().UTC())
+	if err = row.Scan(&data, &expires); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Error checking session: %v", err)
+	}
+
+	user := new(User)
+	if err = json.Unmarshal(data, user); err != nil {
+		return nil, fmt.Errorf("Error decoding user: %v", err)
+	}
+
+	return &Session{User: user, Expires: expires}, nil
+}
+
+//Delete ends the session with the given sessionID. If the database malfunctions, err will be non-nil.
+func (s *SQLSessionStore) Delete(sessionID string) error {
+	if _, err := s.db.db.Exec("DELETE FROM sessions WHERE id=?;", hashToken(sessionID)); err != nil {
+		return fmt.Errorf("Error deleting session: %v", err)
+	}
+	return nil
+}
+
+//lease returns whether or not owner holds the named lease, taking or renewing it for duration.
+//A lease can be taken when it's held by no one or it's expired.
+//If the database malfunctions, err will be non-nil.
+func (db *SQLDB) lease(name, owner string, duration time.Duration) (bool, error) {
+	now := time.Now().UTC()
+	res, err := db.db.Exec("UPDATE leases SET owner=?, expires=? WHERE name=? AND (owner=? OR expires<?);",
+		owner, now.Add(duration), name, owner, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n > 0 {
+		return true, nil
+	}
+
+	//the lease has never been taken, or another replica holds it; in the latter case the insert fails
+	_, err = db.db.Exec("INSERT INTO leases(name, owner, expires) VALUES(?, ?, ?);", name, owner, now.Add(duration))
+	if err == nil {
+		return true, nil
+	}
+
+	//any other failure is returned, so a broken database isn't mistaken for another replica holding the lease
+	if e := db.db.QueryRow("SELECT COUNT(*) FROM leases WHERE name=?;", name).Scan(&n); e != nil || n == 0 {
+		return false, fmt.Errorf("Error taking lease: %v", err)
+	}
+	return false, nil
+}
+
+//scavenge removes expired sessions every sqlScavengeInterval while this replica holds the scavenger lease.
+//The lease outlasts two intervals, so another replica takes over if the holder stops.
+func (s *SQLSessionStore) scavenge() {
+	for {
+		time.Sleep(sqlScavengeInterval)
+
+		held, err := s.db.lease(sqlScavengeLease, s.owner, 2*sqlScavengeInterval+time.Minute)
+		if err != nil {
+			log.Println("Error taking session scavenger lease:", err)
+			continue
+		}
+		if !held {
+			continue
+		}
+
+		if _, err = s.db.db.Exec("DELETE FROM sessions WHERE expires<?;", time.Now().UTC()); err != nil {
+			log.Println("Error removing expired sessions:", err)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"database/sql"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+//sqlStateScavengeLease is the name of the lease the replica removing expired login state holds
+const sqlStateScavengeLease = "state_scavenger"
+
+//sqlStateUpdateAttempts is how many times an update that conflicts with another replica's is tried
+const sqlStateUpdateAttempts = 5
+
+//stateFuncError wraps an error returned by an Update function, so it isn't retried
+type stateFuncError struct {
+	err error
+}
+
+func (e stateFuncError) Error() string {
+	return e.err.Error()
+}
+
+//SQLStateStore represents a StateStore that uses a SQL database, so login state is shared by replicas.
+//Keys are stored hashed, so tokens can't be recovered from the database.
+//Expired values are removed by a single replica, chosen with a lease in the database.
+type SQLStateStore struct {
+	db    *SQLDB
+	owner string      //identifies this replica when holding the scavenger lease
+	mu    *sync.Mutex //serializes updates from this replica, so only other replicas' updates conflict
+}
+
+//NewSQLStateStore returns a new SQLStateStore that stores login state in db
+func NewSQLStateStore(db *SQLDB) *SQLStateStore {
+	s := &SQLStateStore{db: db, owner: randString(32), mu: new(sync.Mutex)}
+	go s.scavenge()
+	return s
+}
+
+//Get returns the value of the given kind for key, or nil if there isn't one.
+//If the database malfunctions, err will be non-nil.
+func (s *SQLStateStore) Get(kind, key string) (entry *StateEntry, err error) {
+	var data string
+	var expires time.Time
+	row := s.db.db.QueryRow("SELECT data, expires FROM login_state WHERE kind=? AND id=? AND expires>?;", kind, hashToken(key), time.Now().UTC())
+	if err = row.Scan(&data, &expires); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &StateEntry{Value: []byte(data), Expires: expires}, nil
+}
+
+//List returns all the values of the given kind.
+//If the database malfunctions, err will be non-nil.
+func (s *SQLStateStore) List(kind string) (entries []*StateEntry, err error) {
+	rows, err := s.db.db.Query("SELECT data, expires FROM login_state WHERE kind=? AND expires>?;", kind, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var data string
+		var expires time.Time
+		if err = rows.Scan(&data, &expires); err != nil {
+			return nil, err
+		}
+		entries = append(entries, &StateEntry{Value: []byte(data), Expires: expires})
+	}
+
+	return entries, rows.Err()
+}
+
+//Update atomically replaces the values of the given kind for keys with the ones f sets.
+//Rows are locked in a transaction, and if another replica's update conflicts, the update is tried again.
+//If f returns an error, nothing is changed and the error is returned.
+//If the database malfunctions, err will be non-nil.
+func (s *SQLStateStore) Update(kind string, keys []string, f func(entries []*StateEntry) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	for i := 0; i < sqlStateUpdateAttempts; i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(i) * 20 * time.Millisecond)
+		}
+		err = s.update(kind, keys, f)
+		if e, ok := err.(stateFuncError); ok {
+			return e.err
+		}
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+//update runs a single attempt of Update in a transaction
+func (s *SQLStateStore) update(kind string, keys []string, f func(entries []*StateEntry) error) (err error) {
+	ids := make([]string, len(keys))
+	order := make([]int, len(keys))
+	for i, k := range keys {
+		ids[i] = hashToken(k)
+		order[i] = i
+	}
+	//rows are always locked in the same order so concurrent updates can't deadlock
+	sort.Slice(order, func(i, j int) bool { return ids[order[i]] < ids[order[j]] })
+
+	tx, err := s.db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	query := "SELECT data, expires FROM login_state WHERE kind=? AND id=?"
+	if s.db.driver == "mysql" {
+		query += " FOR UPDATE"
+	}
+
+	now := time.Now().UTC()
+	exists := make([]bool, len(keys))
+	loaded := make([]*StateEntry, len(keys))
+	entries := make([]*StateEntry, len(keys))
+	for _, i := range order {
+		var data string
+		var expires time.Time
+		err = tx.QueryRow(query+";", kind, ids[i]).Scan(&data, &expires)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		exists[i] = true
+		if expires.After(now) {
+			loaded[i] = &StateEntry{Value: []byte(data), Expires: expires}
+			entries[i] = &StateEntry{Value: loaded[i].Value, Expires: expires}
+		}
+	}
+
+	if err = f(entries); err != nil {
+		return stateFuncError{err}
+	}
+
+	for _, i := range order {
+		e := entries[i]
+		switch {
+		case e == nil && exists[i]:
+			_, err = tx.Exec("DELETE FROM login_state WHERE kind=? AND id=?;", kind, ids[i])
+		case e == nil:
+		case loaded[i] != nil && string(e.Value) == string(loaded[i].Value) && e.Expires.Equal(loaded[i].Expires):
+			//unchanged
+		case exists[i]:
+			_, err = tx.Exec("UPDATE login_state SET data=?, expires=? WHERE kind=? AND id=?;", e.Value, e.Expires.UTC(), kind, ids[i])
+		default:
+			//fails if another replica inserted the key since it was read, and the update is tried again
+			_, err = tx.Exec("INSERT INTO login_state(kind, id, data, expires) VALUES(?, ?, ?, ?);", kind, ids[i], e.Value, e.Expires.UTC())
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//scavenge removes expired login state every sqlScavengeInterval while this replica holds the scavenger lease
+func (s *SQLStateStore) scavenge() {
+	for {
+		time.Sleep(sqlScavengeInterval)
+
+		held, err := s.db.lease(sqlStateScavengeLease, s.owner, 2*sqlScavengeInterval+time.Minute)
+		if err != nil {
+			log.Println("Error taking login state scavenger lease:", err)
+			continue
+		}
+		if !held {
+			continue
+		}
+
+		if _, err = s.db.db.Exec("DELETE FROM login_state WHERE expires<?;", time.Now().UTC()); err != nil {
+			log.Println("Error removing expired login state:", err)
+		}
+	}
+}
@@ -3,17 +3,19 @@ package api
 import (
 	"database/sql"
 	"fmt"
+	"strconv"
 	"strings"
 )
 
-const staffQuery = `
+const staffSelect = `
 SELECT
     name."ALTERNATE-ID" AS EmployeeID,
     name."FIRST-NAME" AS FirstName,
     name."LAST-NAME" AS LastName,
     empcode."HAAETY-DESC" AS EmployeeTypeDesc,
     empcode."HAAETY-EMP-TYPE-CODE" AS EmployeeTypeCode,
-    bldcode."HAABLD-DESC" AS BuildingDesc
+    bldcode."HAABLD-DESC" AS BuildingDesc,
+    name."INTERNET-ADDRESS" AS Email
 FROM PUB.NAME AS name
     INNER JOIN PUB."HAAPRO-PROFILE" AS profile
 ON 
@@ -22,6 +24,12 @@ INNER JOIN PUB."HAAETY-EMP-TYPES" AS empcode ON
     empcode."HAAETY-EMP-TYPE-CODE" = profile."HAAETY-EMP-TYPE-CODE"
 INNER JOIN PUB."HAABLD-BLD-CODES" as bldcode ON
     bldcode."HAABLD-BLD-CODE" = profile."HAABLD-BLD-CODE"
+`
+
+const staffQuery = staffSelect + `WITH (READPAST NOWAIT)
+`
+
+const staffGetQuery = staffSelect + `WHERE name."ALTERNATE-ID" = ?
 WITH (READPAST NOWAIT)
 `
 
@@ -32,12 +40,18 @@ type StaffMember struct {
 	LastName   string
 	Type       string
 	Location   string
+	Email      string
 }
 
 //StaffDB represents a Staff database
 type StaffDB interface {
 	//List returns all entries in the database
 	List() ([]*StaffMember, error)
+
+	//Get returns the StaffMember with the given employeeID.
+	//If the StaffMember doesn't exist, staff will be nil.
+	//Get returns an error if one occurred.
+	Get(employeeID string) (staff *StaffMember, err error)
 }
 
 //SkywardDB is a DB backed by a Skyward (Progress) Database
@@ -76,6 +90,37 @@ func NewSkywardDB(driver, dsn string, excludedIDs, excludedTypes []string) (*Sky
 	}, nil
 }
 
+//scanStaff returns the StaffMember in the current row of rows.
+//If the StaffMember is excluded, staff will be nil.
+func (db *SkywardDB) scanStaff(rows *sql.Rows) (staff *StaffMember, err error) {
+	s := &StaffMember{}
+	var id int64
+	var code, email sql.NullString
+
+	err = rows.Scan(&id, &(s.FirstName), &(s.LastName), &(s.Type), &code, &(s.Location), &email)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := db.typeSkips[code.String]; ok {
+		return nil, nil
+	}
+
+	s.EmployeeID = fmt.Sprintf("f%d", id)
+
+	if _, ok := db.skips[s.EmployeeID]; ok {
+		return nil, nil
+	}
+
+	s.FirstName = strings.Title(strings.ToLower(strings.TrimSpace(s.FirstName)))
+	s.LastName = strings.Title(strings.ToLower(strings.TrimSpace(s.LastName)))
+	s.Type = strings.Title(strings.ToLower(strings.TrimSpace(s.Type)))
+	s.Location = strings.Title(strings.ToLower(strings.TrimSpace(s.Location)))
+	s.Email = strings.ToLower(strings.TrimSpace(email.String))
+
+	return s, nil
+}
+
 //List returns all entries in the database
 func (db *SkywardDB) List() (list []*StaffMember, err error) {
 	rows, err := db.db.Query(staffQuery)
@@ -92,35 +137,57 @@ func (db *SkywardDB) List() (list []*StaffMember, err error) {
 
 	var staff []*StaffMember
 	for rows.Next() {
-		s := &StaffMember{}
-		var id int64
-		var code sql.NullString
-
-		err = rows.Scan(&id, &(s.FirstName), &(s.LastName), &(s.Type), &code, &(s.Location))
+		s, err := db.scanStaff(rows)
 		if err != nil {
 			return nil, err
 		}
-
-		if _, ok := db.typeSkips[code.String]; ok {
-			continue
+		if s != nil {
+			staff = append(staff, s)
 		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return staff, nil
+}
 
-		s.EmployeeID = fmt.Sprintf("f%d", id)
-
-		if _, ok := db.skips[s.EmployeeID]; ok {
-			continue
-		}
+//Get returns the StaffMember with the given employeeID.
+//If the StaffMember doesn't exist, staff will be nil.
+//Get returns an error if one occurred.
+func (db *SkywardDB) Get(employeeID string) (staff *StaffMember, err error) {
+	//EmployeeIDs are formatted from the database's ALTERNATE-ID
+	if !strings.HasPrefix(employeeID, "f") {
+		return nil, nil
+	}
+	id, err := strconv.ParseInt(employeeID[1:], 10, 64)
+	if err != nil || fmt.Sprintf("f%d", id) != employeeID {
+		return nil, nil
+	}
 
-		s.FirstName = strings.Title(strings.ToLower(strings.TrimSpace(s.FirstName)))
-		s.LastName = strings.Title(strings.ToLower(strings.TrimSpace(s.LastName)))
-		s.Type = strings.Title(strings.ToLower(strings.TrimSpace(s.Type)))
-		s.Location = strings.Title(strings.ToLower(strings.TrimSpace(s.Location)))
+	rows, err := db.db.Query(staffGetQuery, id)
+	if err != nil {
+		return nil, err
+	}
 
-		staff = append(staff, s)
+	defer func() {
+		e := rows.Close()
+		if err == nil {
+			err = e
+		}
+	}()
 
+	//a staff member can have more than one profile, so use the first one that isn't excluded, like List
+	for rows.Next() {
+		s, err := db.scanStaff(rows)
+		if err != nil {
+			return nil, err
+		}
+		if s != nil {
+			return s, nil
+		}
 	}
 	if err = rows.Err(); err != nil {
 		return nil, err
 	}
-	return staff, nil
+	return nil, nil
 }
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+//StateEntry represents a value in a StateStore
+type StateEntry struct {
+	Value   []byte
+	Expires time.Time
+}
+
+//StateStore is an interface to an arbitrary backend for short-lived login state:
+//pending logins, single-use tokens, and login throttling.
+//A shared StateStore lets any replica continue a login another replica started.
+//Values are grouped by kind, and expired values are never returned.
+type StateStore interface {
+	//Get returns the value of the given kind for key, or nil if there isn't one.
+	//If the backend malfunctions, err will be non-nil.
+	Get(kind, key string) (entry *StateEntry, err error)
+
+	//List returns all the values of the given kind.
+	//If the backend malfunctions, err will be non-nil.
+	List(kind string) (entries []*StateEntry, err error)
+
+	//Update atomically replaces the values of the given kind for keys with the ones f sets.
+	//f is called with the current value for each key, or nil if there isn't one, and must not modify the values it's given.
+	//Setting an entry to nil removes it. If f returns an error, nothing is changed and the error is returned.
+	//f may be called more than once if the update conflicts with another one.
+	//If the backend malfunctions, err will be non-nil.
+	Update(kind string, keys []string, f func(entries []*StateEntry) error) error
+}
+
+//newStateEntry returns a StateEntry with v JSON encoded
+func newStateEntry(v interface{}, expires time.Time) (*StateEntry, error) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return &StateEntry{Value: buf, Expires: expires}, nil
+}
+
+//putState stores v JSON encoded under the given kind and key until expires, replacing any existing value
+func putState(s StateStore, kind, key string, v interface{}, expires time.Time) error {
+	e, err := newStateEntry(v, expires)
+	if err != nil {
+		return err
+	}
+	return s.Update(kind, []string{key}, func(entries []*StateEntry) error {
+		entries[0] = e
+		return nil
+	})
+}
+
+//getState decodes the value of the given kind and key into v. If there isn't one, ok will be false.
+func getState(s StateStore, kind, key string, v interface{}) (ok bool, err error) {
+	e, err := s.Get(kind, key)
+	if err != nil || e == nil {
+		return false, err
+	}
+	return true, json.Unmarshal(e.Value, v)
+}
+
+//takeState decodes the value of the given kind and key into v and removes it, so it can only be taken once.
+//If there isn't one, ok will be false.
+func takeState(s StateStore, kind, key string, v interface{}) (ok bool, err error) {
+	var value []byte
+	err = s.Update(kind, []string{key}, func(entries []*StateEntry) error {
+		value = nil
+		if entries[0] != nil {
+			value = entries[0].Value
+			entries[0] = nil
+		}
+		return nil
+	})
+	if err != nil || value == nil {
+		return false, err
+	}
+	return true, json.Unmarshal(value, v)
+}
+
+//deleteState removes the value of the given kind and key
+func deleteState(s StateStore, kind, key string) error {
+	return s.Update(kind, []string{key}, func(entries []*StateEntry) error {
+		entries[0] = nil
+		return nil
+	})
+}
+
+//MemoryStateStore represents a StateStore that uses an in-memory map, so state is only seen by this replica
+type MemoryStateStore struct {
+	mu    *sync.Mutex
+	kinds map[string]map[string]*StateEntry
+}
+
+//NewMemoryStateStore returns a new, empty MemoryStateStore
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{mu: new(sync.Mutex), kinds: make(map[string]map[string]*StateEntry)}
+}
+
+//prune removes the expired values of the given kind and returns the rest. s.mu must be held.
+func (s *MemoryStateStore) prune(kind string, now time.Time) map[string]*StateEntry {
+	m, ok := s.kinds[kind]
+	if !ok {
+		m = make(map[string]*StateEntry)
+		s.kinds[kind] = m
+	}
+	for k, e := range m {
+		if !e.Expires.After(now) {
+			delete(m, k)
+		}
+	}
+	return m
+}
+
+//Get returns the value of the given kind for key, or nil if there isn't one. err will always be nil.
+func (s *MemoryStateStore) Get(kind, key string) (entry *StateEntry, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.kinds[kind][key]; ok && e.Expires.After(time.Now()) {
+		return &StateEntry{Value: e.Value, Expires: e.Expires}, nil
+	}
+	return nil, nil
+}
+
+//List returns all the values of the given kind. err will always be nil.
+func (s *MemoryStateStore) List(kind string) (entries []*StateEntry, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.prune(kind, time.Now()) {
+		entries = append(entries, &StateEntry{Value: e.Value, Expires: e.Expires})
+	}
+	return entries, nil
+}
+
+//Update atomically replaces the values of the given kind for keys with the ones f sets.
+//If f returns an error, nothing is changed and the error is returned.
+func (s *MemoryStateStore) Update(kind string, keys []string, f func(entries []*StateEntry) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.prune(kind, time.Now())
+	entries := make([]*StateEntry, len(keys))
+	for i, k := range keys {
+		if e, ok := m[k]; ok {
+			entries[i] = &StateEntry{Value: e.Value, Expires: e.Expires}
+		}
+	}
+
+	if err := f(entries); err != nil {
+		return err
+	}
+
+	for i, k := range keys {
+		if entries[i] == nil {
+			delete(m, k)
+		} else {
+			m[k] = entries[i]
+		}
+	}
+	return nil
+}
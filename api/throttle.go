@@ -0,0 +1,260 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+//Throttle identity types
+const (
+	ThrottleUsername = "username"
+	ThrottleIP       = "ip"
+)
+
+//throttleBaseDelay is the first delay after the free attempts are used
+const throttleBaseDelay = time.Second
+
+//ThrottledIdentity represents a username or IP address with failed login attempts
+type ThrottledIdentity struct {
+	Type         string //ThrottleUsername or ThrottleIP
+	Identity     string
+	Failures     int
+	LastFailure  time.Time
+	BlockedUntil time.Time
+}
+
+//throttleKind is the StateStore kind failed login attempts are kept under
+const throttleKind = "throttle"
+
+//LoginThrottle tracks password login attempts by username and by client IP address.
+//After the free attempts for an identity are used, each further attempt blocks it for twice as long
+//as the last, up to maxBlock. Failures are forgotten maxBlock after the last one.
+//Blocked attempts are rejected before they reach the Auth backend, so they can't lock directory accounts.
+//Attempts are kept in a StateStore, so a shared store throttles attempts spread across replicas.
+type LoginThrottle struct {
+	state            StateStore
+	usernameAttempts int
+	ipAttempts       int
+	maxBlock         time.Duration
+}
+
+//NewLoginThrottle returns a new LoginThrottle that keeps attempts in state, and allows usernameAttempts failed attempts
+//per username and ipAttempts per IP address before blocking them, for at most maxBlock
+func NewLoginThrottle(state StateStore, usernameAttempts, ipAttempts int, maxBlock time.Duration) *LoginThrottle {
+	return &LoginThrottle{
+		state:            state,
+		usernameAttempts: usernameAttempts,
+		ipAttempts:       ipAttempts,
+		maxBlock:         maxBlock,
+	}
+}
+
+//throttleUsername returns the identity for username. Domains are removed so jdoe, jdoe@domain, and DOMAIN\jdoe are the same.
+func throttleUsername(username string) string {
+	u := strings.ToLower(strings.TrimSpace(username))
+	if i := strings.LastIndex(u, `\`); i != -1 {
+		u = u[i+1:]
+	}
+	if i := strings.Index(u, "@"); i != -1 {
+		u = u[:i]
+	}
+	return u
+}
+
+//clientIP returns the client IP address of r, from its RemoteAddr.
+//The X-Forwarded-For header isn't read here; the server only uses it to set RemoteAddr for trusted proxies.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+//throttleIP returns the identity for the client IP address of r.
+//IPv6 addresses are grouped by /64, since a single client usually has the whole network.
+func throttleIP(r *http.Request) string {
+	host := clientIP(r)
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	if ip.To4() == nil {
+		return (&net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String()
+	}
+	return ip.String()
+}
+
+//delay returns how long an identity is blocked after the given number of failures
+func (t *LoginThrottle) delay(failures, free int) time.Duration {
+	if failures <= free {
+		return 0
+	}
+	d := throttleBaseDelay
+	for n := failures - free; n > 1 && d < t.maxBlock; n-- {
+		d *= 2
+	}
+	if d > t.maxBlock {
+		d = t.maxBlock
+	}
+	return d
+}
+
+//free returns the free attempts for the given identity type
+func (t *LoginThrottle) free(typ string) int {
+	if typ == ThrottleIP {
+		return t.ipAttempts
+	}
+	return t.usernameAttempts
+}
+
+//load decodes the failures for an identity from entry, or returns nil if there aren't any
+func (t *LoginThrottle) load(entry *StateEntry) (*ThrottledIdentity, error) {
+	if entry == nil {
+		return nil, nil
+	}
+	id := new(ThrottledIdentity)
+	if err := json.Unmarshal(entry.Value, id); err != nil {
+		return nil, fmt.Errorf("Error decoding throttle entry: %v", err)
+	}
+	return id, nil
+}
+
+//store encodes the failures for an identity, kept until they're forgotten and the identity isn't blocked
+func (t *LoginThrottle) store(id *ThrottledIdentity) (*StateEntry, error) {
+	expires := id.LastFailure.Add(t.maxBlock)
+	if id.BlockedUntil.After(expires) {
+		expires = id.BlockedUntil
+	}
+	return newStateEntry(id, expires)
+}
+
+//Attempt records a login attempt for username from the client of r.
+//If the username or IP address is blocked, the attempt isn't recorded and the time to wait is returned.
+//Otherwise the attempt counts as a failure until Succeed is called.
+//Counting attempts before they're checked keeps concurrent guesses from getting around the limit.
+//If the StateStore malfunctions, err will be non-nil.
+func (t *LoginThrottle) Attempt(username string, r *http.Request) (wait time.Duration, err error) {
+	keys := [][2]string{{ThrottleUsername, throttleUsername(username)}, {ThrottleIP, throttleIP(r)}}
+
+	err = t.state.Update(throttleKind, []string{keys[0][0] + ":" + keys[0][1], keys[1][0] + ":" + keys[1][1]}, func(entries []*StateEntry) error {
+		now := time.Now()
+		wait = 0
+
+		ids := make([]*ThrottledIdentity, len(entries))
+		for i, e := range entries {
+			id, err := t.load(e)
+			if err != nil {
+				return err
+			}
+			if id != nil && now.Before(id.BlockedUntil) && id.BlockedUntil.Sub(now) > wait {
+				wait = id.BlockedUntil.Sub(now)
+			}
+			ids[i] = id
+		}
+		if wait > 0 {
+			return nil
+		}
+
+		for i, k := range keys {
+			id := ids[i]
+			if id == nil {
+				id = &ThrottledIdentity{Type: k[0], Identity: k[1]}
+			}
+			id.Failures++
+			id.LastFailure = now
+			id.BlockedUntil = now.Add(t.delay(id.Failures, t.free(k[0])))
+			e, err := t.store(id)
+			if err != nil {
+				return err
+			}
+			entries[i] = e
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Error recording login attempt: %v", err)
+	}
+
+	return wait, nil
+}
+
+//Succeed records that the last attempt for username from the client of r succeeded.
+//The username's failures are cleared, and the attempt no longer counts against the IP address,
+//so many users behind one address can log in.
+//If the StateStore malfunctions, err will be non-nil.
+func (t *LoginThrottle) Succeed(username string, r *http.Request) error {
+	keys := []string{ThrottleUsername + ":" + throttleUsername(username), ThrottleIP + ":" + throttleIP(r)}
+
+	err := t.state.Update(throttleKind, keys, func(entries []*StateEntry) error {
+		entries[0] = nil
+
+		id, err := t.load(entries[1])
+		if err != nil || id == nil {
+			return err
+		}
+		id.Failures--
+		if id.Failures <= 0 {
+			entries[1] = nil
+			return nil
+		}
+		id.BlockedUntil = id.LastFailure.Add(t.delay(id.Failures, t.ipAttempts))
+		entries[1], err = t.store(id)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Error recording login success: %v", err)
+	}
+
+	return nil
+}
+
+//Blocked returns the identities that have used their free attempts, most recent first.
+//If the StateStore malfunctions, err will be non-nil.
+func (t *LoginThrottle) Blocked() ([]*ThrottledIdentity, error) {
+	entries, err := t.state.List(throttleKind)
+	if err != nil {
+		return nil, fmt.Errorf("Error listing throttled identities: %v", err)
+	}
+
+	blocked := make([]*ThrottledIdentity, 0)
+	for _, e := range entries {
+		id, err := t.load(e)
+		if err != nil {
+			return nil, err
+		}
+		if id.Failures <= t.free(id.Type) {
+			continue
+		}
+		blocked = append(blocked, id)
+	}
+
+	sort.Slice(blocked, func(i, j int) bool { return blocked[i].LastFailure.After(blocked[j].LastFailure) })
+
+	return blocked, nil
+}
+
+//Clear removes the failures for the given identity. It returns false if the identity has no failures.
+//If the StateStore malfunctions, err will be non-nil.
+func (t *LoginThrottle) Clear(typ, identity string) (bool, error) {
+	if typ == ThrottleUsername {
+		identity = throttleUsername(identity)
+	}
+
+	var found bool
+	err := t.state.Update(throttleKind, []string{typ + ":" + identity}, func(entries []*StateEntry) error {
+		found = entries[0] != nil
+		entries[0] = nil
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("Error clearing throttled identity: %v", err)
+	}
+
+	return found, nil
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+//testThrottleRequest returns a request from the given client address
+func testThrottleRequest(remoteAddr string) *http.Request {
+	return &http.Request{RemoteAddr: remoteAddr}
+}
+
+//attempt records a login attempt with throttle and returns how long it had to wait
+func attempt(t *testing.T, throttle *LoginThrottle, username string, r *http.Request) time.Duration {
+	wait, err := throttle.Attempt(username, r)
+	if err != nil {
+		t.Fatalf("Error recording attempt: %v", err)
+	}
+	return wait
+}
+
+func TestThrottleDelay(t *testing.T) {
+	throttle := NewLoginThrottle(NewMemoryStateStore(), 3, 3, time.Minute)
+
+	tests := []struct {
+		failures int
+		expected time.Duration
+	}{
+		{0, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{9, 32 * time.Second},
+		{10, time.Minute},
+		{1000, time.Minute},
+	}
+
+	for _, test := range tests {
+		if d := throttle.delay(test.failures, 3); d != test.expected {
+			t.Errorf("%d failures: expected delay %v, got %v", test.failures, test.expected, d)
+		}
+	}
+}
+
+func TestThrottleIP(t *testing.T) {
+	tests := []struct {
+		remoteAddr string
+		expected   string
+	}{
+		{"192.0.2.1:1234", "192.0.2.1"},
+		{"[2001:db8::1]:1234", "2001:db8::/64"},
+		{"[2001:db8::ffff:1]:1234", "2001:db8::/64"},
+		{"[2001:db8:0:1::1]:1234", "2001:db8:0:1::/64"},
+		{"unix", "unix"},
+	}
+
+	for _, test := range tests {
+		if ip := throttleIP(testThrottleRequest(test.remoteAddr)); ip != test.expected {
+			t.Errorf("%s: expected %s, got %s", test.remoteAddr, test.expected, ip)
+		}
+	}
+}
+
+func TestThrottleUsername(t *testing.T) {
+	throttle := NewLoginThrottle(NewMemoryStateStore(), 2, 100, time.Minute)
+	r := testThrottleRequest("192.0.2.1:1234")
+
+	for i := 0; i < 3; i++ {
+		if wait := attempt(t, throttle, "jdoe", r); wait != 0 {
+			t.Fatalf("Attempt %d: expected no wait, got %v", i+1, wait)
+		}
+	}
+
+	//domains are removed, so these are the same user
+	for _, username := range []string{"jdoe", "JDoe@example.com", `EXAMPLE\jdoe`} {
+		if wait := attempt(t, throttle, username, r); wait <= 0 || wait > time.Second {
+			t.Errorf("%s: expected to wait up to a second, got %v", username, wait)
+		}
+	}
+
+	if wait := attempt(t, throttle, "other", r); wait != 0 {
+		t.Errorf("Expected other user not to wait, got %v", wait)
+	}
+
+	blocked, err := throttle.Blocked()
+	if err != nil {
+		t.Fatalf("Error listing blocked identities: %v", err)
+	}
+	if len(blocked) != 1 || blocked[0].Type != ThrottleUsername || blocked[0].Identity != "jdoe" || blocked[0].Failures != 3 {
+		t.Errorf("Expected jdoe to be blocked after 3 failures, got %+v", blocked)
+	}
+
+	if err = throttle.Succeed("jdoe", r); err != nil {
+		t.Fatalf("Error recording success: %v", err)
+	}
+	if wait := attempt(t, throttle, "jdoe", r); wait != 0 {
+		t.Errorf("Expected no wait after success, got %v", wait)
+	}
+}
+
+func TestThrottleAddress(t *testing.T) {
+	throttle := NewLoginThrottle(NewMemoryStateStore(), 100, 3, time.Minute)
+	r := testThrottleRequest("192.0.2.1:1234")
+
+	//successful logins don't count against an address shared by many users
+	for i := 0; i < 10; i++ {
+		username := fmt.Sprintf("user%d", i)
+		if wait := attempt(t, throttle, username, r); wait != 0 {
+			t.Fatalf("%s: expected no wait, got %v", username, wait)
+		}
+		if err := throttle.Succeed(username, r); err != nil {
+			t.Fatalf("Error recording success: %v", err)
+		}
+	}
+
+	for i := 0; i < 4; i++ {
+		if wait := attempt(t, throttle, fmt.Sprintf("guess%d", i), r); wait != 0 {
+			t.Fatalf("Guess %d: expected no wait, got %v", i+1, wait)
+		}
+	}
+	if wait := attempt(t, throttle, "another", r); wait <= 0 {
+		t.Errorf("Expected address to be blocked, got %v", wait)
+	}
+	if wait := attempt(t, throttle, "another", testThrottleRequest("192.0.2.2:1234")); wait != 0 {
+		t.Errorf("Expected other address not to wait, got %v", wait)
+	}
+
+	found, err := throttle.Clear(ThrottleIP, "192.0.2.1")
+	if err != nil || !found {
+		t.Fatalf("Expected address to be cleared, got %v, %v", found, err)
+	}
+	if wait := attempt(t, throttle, "another", r); wait != 0 {
+		t.Errorf("Expected no wait after clearing, got %v", wait)
+	}
+}
@@ -0,0 +1,417 @@
+package api
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"time"
+
+	//register hashes used by timestamp authorities
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+//ErrInvalidTimestamp is returned when a timestamp token doesn't match the signature or isn't signed by a trusted authority
+var ErrInvalidTimestamp = errors.New("Invalid timestamp")
+
+//maxTimestampSize is the largest timestamp response that will be read
+const maxTimestampSize = 1 << 20
+
+var (
+	oidSHA1        = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	oidSignedData  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidTSTInfo     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidContentType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidDigest      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+)
+
+//tsHashes maps digest algorithm OIDs to hashes
+var tsHashes = map[string]crypto.Hash{
+	oidSHA1.String():   crypto.SHA1,
+	oidSHA256.String(): crypto.SHA256,
+	oidSHA384.String(): crypto.SHA384,
+	oidSHA512.String(): crypto.SHA512,
+}
+
+//tsSignatureAlgorithms maps public key and hash types to signature algorithms
+var tsSignatureAlgorithms = map[x509.PublicKeyAlgorithm]map[crypto.Hash]x509.SignatureAlgorithm{
+	x509.RSA: {
+		crypto.SHA1:   x509.SHA1WithRSA,
+		crypto.SHA256: x509.SHA256WithRSA,
+		crypto.SHA384: x509.SHA384WithRSA,
+		crypto.SHA512: x509.SHA512WithRSA,
+	},
+	x509.ECDSA: {
+		crypto.SHA1:   x509.ECDSAWithSHA1,
+		crypto.SHA256: x509.ECDSAWithSHA256,
+		crypto.SHA384: x509.ECDSAWithSHA384,
+		crypto.SHA512: x509.ECDSAWithSHA512,
+	},
+}
+
+//RFC 3161 and RFC 5652 structures
+
+type tsMessageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type tsRequest struct {
+	Version        int
+	MessageImprint tsMessageImprint
+	Nonce          *big.Int `asn1:"optional"`
+	CertReq        bool     `asn1:"optional"`
+}
+
+type tsStatus struct {
+	Status       int
+	StatusString []string       `asn1:"optional,utf8"`
+	FailInfo     asn1.BitString `asn1:"optional"`
+}
+
+type tsResponse struct {
+	Status tsStatus
+	Token  asn1.RawValue `asn1:"optional"`
+}
+
+type tsContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type tsEncapContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     []byte `asn1:"explicit,optional,tag:0"`
+}
+
+type tsSignerInfo struct {
+	Version            int
+	SID                asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type tsSignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo tsEncapContentInfo
+	Certificates     asn1.RawValue  `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue  `asn1:"optional,tag:1"`
+	SignerInfos      []tsSignerInfo `asn1:"set"`
+}
+
+type tsAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+type tsIssuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type tsAccuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+type tsInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint tsMessageImprint
+	SerialNumber   *big.Int
+	GenTime        asn1.RawValue
+	Accuracy       tsAccuracy    `asn1:"optional"`
+	Ordering       bool          `asn1:"optional"`
+	Nonce          *big.Int      `asn1:"optional"`
+	TSA            asn1.RawValue `asn1:"optional,explicit,tag:0"`
+	Extensions     asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+//Timestamp is the verified contents of an RFC 3161 timestamp token
+type Timestamp struct {
+	Time         time.Time
+	SerialNumber string
+	Authority    string //subject of the timestamp authority's certificate
+}
+
+//parseTimestamp decodes an RFC 3161 timestamp token without verifying it
+func parseTimestamp(token []byte) (*tsSignedData, *tsInfo, error) {
+	ci := &tsContentInfo{}
+	if _, err := asn1.Unmarshal(token, ci); err != nil {
+		return nil, nil, fmt.Errorf("Error decoding token: %v", err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, nil, errors.New("Error decoding token: not SignedData")
+	}
+
+	sd := &tsSignedData{}
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, sd); err != nil {
+		return nil, nil, fmt.Errorf("Error decoding token: %v", err)
+	}
+	if !sd.EncapContentInfo.ContentType.Equal(oidTSTInfo) {
+		return nil, nil, errors.New("Error decoding token: not TSTInfo")
+	}
+
+	info := &tsInfo{}
+	if _, err := asn1.Unmarshal(sd.EncapContentInfo.Content, info); err != nil {
+		return nil, nil, fmt.Errorf("Error decoding TSTInfo: %v", err)
+	}
+
+	return sd, info, nil
+}
+
+//time returns the token's GeneralizedTime genTime.
+//encoding/asn1 doesn't allow fractional seconds, which are common in timestamps, so it's parsed here.
+func (i *tsInfo) time() (time.Time, error) {
+	if i.GenTime.Class != asn1.ClassUniversal || i.GenTime.Tag != asn1.TagGeneralizedTime {
+		return time.Time{}, errors.New("Error decoding TSTInfo: invalid genTime")
+	}
+	t, err := time.Parse("20060102150405Z0700", string(i.GenTime.Bytes))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Error decoding TSTInfo: %v", err)
+	}
+	return t, nil
+}
+
+//ParseTimestamp decodes an RFC 3161 timestamp token without verifying it
+func ParseTimestamp(token []byte) (*Timestamp, error) {
+	_, info, err := parseTimestamp(token)
+	if err != nil {
+		return nil, err
+	}
+	genTime, err := info.time()
+	if err != nil {
+		return nil, err
+	}
+	return &Timestamp{Time: genTime, SerialNumber: info.SerialNumber.String()}, nil
+}
+
+//VerifyTimestamp verifies the RFC 3161 timestamp token covers the given SHA-256 digest
+//and is signed by a timestamp authority trusted by roots.
+//If roots is nil, the system roots are used.
+//If the token is not valid, an error describing why is returned.
+func VerifyTimestamp(token, digest []byte, roots *x509.CertPool) (*Timestamp, error) {
+	sd, info, err := parseTimestamp(token)
+	if err != nil {
+		return nil, err
+	}
+	genTime, err := info.time()
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.MessageImprint.HashAlgorithm.Algorithm.Equal(oidSHA256) || !bytes.Equal(info.MessageImprint.HashedMessage, digest) {
+		return nil, fmt.Errorf("%v: token does not match signature", ErrInvalidTimestamp)
+	}
+
+	if len(sd.SignerInfos) != 1 {
+		return nil, fmt.Errorf("%v: expected 1 signer, found %d", ErrInvalidTimestamp, len(sd.SignerInfos))
+	}
+	si := sd.SignerInfos[0]
+
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing certificates: %v", err)
+	}
+	cert := tsSigner(si, certs)
+	if cert == nil {
+		return nil, fmt.Errorf("%v: signer certificate not found", ErrInvalidTimestamp)
+	}
+
+	if err = tsVerifySignature(si, cert, sd.EncapContentInfo.Content); err != nil {
+		return nil, fmt.Errorf("%v: %v", ErrInvalidTimestamp, err)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, c := range certs {
+		intermediates.AddCert(c)
+	}
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   genTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", ErrInvalidTimestamp, err)
+	}
+
+	return &Timestamp{Time: genTime, SerialNumber: info.SerialNumber.String(), Authority: cert.Subject.String()}, nil
+}
+
+//tsSigner returns the certificate in certs identified by si, or nil if it isn't found
+func tsSigner(si tsSignerInfo, certs []*x509.Certificate) *x509.Certificate {
+	//subjectKeyIdentifier
+	if si.SID.Class == asn1.ClassContextSpecific && si.SID.Tag == 0 {
+		for _, c := range certs {
+			if bytes.Equal(c.SubjectKeyId, si.SID.Bytes) {
+				return c
+			}
+		}
+		return nil
+	}
+
+	ias := &tsIssuerAndSerial{}
+	if _, err := asn1.Unmarshal(si.SID.FullBytes, ias); err != nil {
+		return nil
+	}
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, ias.Issuer.FullBytes) && c.SerialNumber.Cmp(ias.Serial) == 0 {
+			return c
+		}
+	}
+	return nil
+}
+
+//tsVerifySignature verifies si's signature over content was made by cert
+func tsVerifySignature(si tsSignerInfo, cert *x509.Certificate, content []byte) error {
+	hash, ok := tsHashes[si.DigestAlgorithm.Algorithm.String()]
+	if !ok {
+		return fmt.Errorf("unsupported digest algorithm %v", si.DigestAlgorithm.Algorithm)
+	}
+	alg, ok := tsSignatureAlgorithms[cert.PublicKeyAlgorithm][hash]
+	if !ok {
+		return fmt.Errorf("unsupported public key algorithm %v", cert.PublicKeyAlgorithm)
+	}
+
+	//without signed attributes, the signature is over the content itself
+	if len(si.SignedAttrs.Bytes) == 0 {
+		return cert.CheckSignature(alg, content, si.Signature)
+	}
+
+	//signed attributes are signed as a SET instead of their implicitly tagged encoding
+	signed := append([]byte{0x31}, si.SignedAttrs.FullBytes[1:]...)
+
+	var attrs []tsAttribute
+	if _, err := asn1.UnmarshalWithParams(signed, &attrs, "set"); err != nil {
+		return fmt.Errorf("error decoding signed attributes: %v", err)
+	}
+
+	h := hash.New()
+	h.Write(content)
+
+	var digestOK, typeOK bool
+	for _, a := range attrs {
+		if len(a.Values) != 1 {
+			continue
+		}
+		switch {
+		case a.Type.Equal(oidDigest):
+			var d []byte
+			if _, err := asn1.Unmarshal(a.Values[0].FullBytes, &d); err == nil {
+				digestOK = bytes.Equal(d, h.Sum(nil))
+			}
+		case a.Type.Equal(oidContentType):
+			var t asn1.ObjectIdentifier
+			if _, err := asn1.Unmarshal(a.Values[0].FullBytes, &t); err == nil {
+				typeOK = t.Equal(oidTSTInfo)
+			}
+		}
+	}
+	if !digestOK || !typeOK {
+		return errors.New("signed attributes do not match content")
+	}
+
+	return cert.CheckSignature(alg, signed, si.Signature)
+}
+
+//TimestampClient requests and verifies RFC 3161 timestamps from a timestamp authority
+type TimestampClient struct {
+	url    string
+	client *http.Client
+	roots  *x509.CertPool
+}
+
+//NewTimestampClient returns a new TimestampClient for the timestamp authority at the given URL.
+//Tokens are verified against roots, or the system roots if roots is nil.
+func NewTimestampClient(url string, timeout time.Duration, roots *x509.CertPool) *TimestampClient {
+	return &TimestampClient{url: url, client: &http.Client{Timeout: timeout}, roots: roots}
+}
+
+//Timestamp returns a verified RFC 3161 timestamp token for the given SHA-256 digest
+func (t *TimestampClient) Timestamp(digest []byte) ([]byte, error) {
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, fmt.Errorf("Error generating nonce: %v", err)
+	}
+
+	req, err := asn1.Marshal(tsRequest{
+		Version: 1,
+		MessageImprint: tsMessageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue},
+			HashedMessage: digest,
+		},
+		Nonce:   nonce,
+		CertReq: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error encoding request: %v", err)
+	}
+
+	resp, err := t.client.Post(t.url, "application/timestamp-query", bytes.NewReader(req))
+	if err != nil {
+		return nil, fmt.Errorf("Error requesting timestamp: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Error requesting timestamp: %s", resp.Status)
+	}
+
+	//read one byte past the limit so an oversized response is rejected instead of truncated
+	buf, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxTimestampSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("Error reading response: %v", err)
+	}
+	if len(buf) > maxTimestampSize {
+		return nil, fmt.Errorf("Error reading response: larger than %d bytes", maxTimestampSize)
+	}
+
+	tsResp := &tsResponse{}
+	if _, err = asn1.Unmarshal(buf, tsResp); err != nil {
+		return nil, fmt.Errorf("Error decoding response: %v", err)
+	}
+	//0: granted, 1: granted with modifications
+	if tsResp.Status.Status > 1 {
+		return nil, fmt.Errorf("Timestamp rejected: status %d %v", tsResp.Status.Status, tsResp.Status.StatusString)
+	}
+	token := tsResp.Token.FullBytes
+
+	_, info, err := parseTimestamp(token)
+	if err != nil {
+		return nil, err
+	}
+	if info.Nonce == nil || info.Nonce.Cmp(nonce) != 0 {
+		return nil, fmt.Errorf("%v: nonce does not match request", ErrInvalidTimestamp)
+	}
+
+	if _, err = t.Verify(token, digest); err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+//Verify verifies the RFC 3161 timestamp token covers the given SHA-256 digest
+//and is signed by a trusted timestamp authority
+func (t *TimestampClient) Verify(token, digest []byte) (*Timestamp, error) {
+	return VerifyTimestamp(token, digest, t.roots)
+}
@@ -0,0 +1,255 @@
+package api
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+//testTSAPolicy is the policy of timestamps from testTSA
+var testTSAPolicy = asn1.ObjectIdentifier{1, 2, 3, 4}
+
+//testTSA is an RFC 3161 timestamp authority that signs with a self-signed ECDSA certificate
+type testTSA struct {
+	key  *ecdsa.PrivateKey
+	cert *x509.Certificate
+
+	GenTime    string   //default: now with fractional seconds
+	Status     int      //PKIStatus of responses
+	Nonce      *big.Int //default: the request's nonce
+	Tamper     bool     //if true, the signed content is changed after signing
+	ExtraBytes int      //bytes of padding after the response
+}
+
+//newTestTSA returns a new testTSA with a new key and certificate
+func newTestTSA(t *testing.T) *testTSA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test TSA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Error parsing certificate: %v", err)
+	}
+	return &testTSA{key: key, cert: cert}
+}
+
+//roots returns a CertPool trusting the TSA
+func (tsa *testTSA) roots() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(tsa.cert)
+	return pool
+}
+
+//marshal returns the DER encoding of v, panicking on errors since the test structures are always valid
+func marshal(v interface{}) []byte {
+	buf, err := asn1.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return buf
+}
+
+//token returns a timestamp token over digest with the given nonce, signed with signed attributes
+func (tsa *testTSA) token(digest []byte, nonce *big.Int) []byte {
+	genTime := tsa.GenTime
+	if genTime == "" {
+		genTime = time.Now().UTC().Format("20060102150405.000Z")
+	}
+	sha256ID := pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+
+	content := marshal(tsInfo{
+		Version:        1,
+		Policy:         testTSAPolicy,
+		MessageImprint: tsMessageImprint{HashAlgorithm: sha256ID, HashedMessage: digest},
+		SerialNumber:   big.NewInt(42),
+		GenTime:        asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagGeneralizedTime, Bytes: []byte(genTime)},
+		Nonce:          nonce,
+	})
+
+	h := sha256.Sum256(content)
+	var attrs []byte
+	for _, a := range []tsAttribute{
+		{Type: oidContentType, Values: []asn1.RawValue{{FullBytes: marshal(oidTSTInfo)}}},
+		{Type: oidDigest, Values: []asn1.RawValue{{FullBytes: marshal(h[:])}}},
+	} {
+		attrs = append(attrs, marshal(a)...)
+	}
+
+	//signed attributes are signed as a SET
+	signed := sha256.Sum256(marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: attrs}))
+	r, s, err := ecdsa.Sign(rand.Reader, tsa.key, signed[:])
+	if err != nil {
+		panic(err)
+	}
+	sig := marshal(struct{ R, S *big.Int }{r, s})
+
+	if tsa.Tamper {
+		content = bytes.Replace(content, marshal(testTSAPolicy), marshal(asn1.ObjectIdentifier{1, 2, 3, 5}), 1)
+	}
+
+	sd := marshal(tsSignedData{
+		Version:          3,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256ID},
+		EncapContentInfo: tsEncapContentInfo{ContentType: oidTSTInfo, Content: content},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: tsa.cert.Raw},
+		SignerInfos: []tsSignerInfo{{
+			Version:            1,
+			SID:                asn1.RawValue{FullBytes: marshal(tsIssuerAndSerial{Issuer: asn1.RawValue{FullBytes: tsa.cert.RawIssuer}, Serial: tsa.cert.SerialNumber})},
+			DigestAlgorithm:    sha256ID,
+			SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrs},
+			SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+			Signature:          sig,
+		}},
+	})
+
+	return marshal(tsContentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd},
+	})
+}
+
+//ServeHTTP responds to timestamp requests
+func (tsa *testTSA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	buf, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := new(tsRequest)
+	if _, err = asn1.Unmarshal(buf, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := tsResponse{Status: tsStatus{Status: tsa.Status}}
+	if tsa.Status <= 1 {
+		nonce := req.Nonce
+		if tsa.Nonce != nil {
+			nonce = tsa.Nonce
+		}
+		resp.Token = asn1.RawValue{FullBytes: tsa.token(req.MessageImprint.HashedMessage, nonce)}
+	}
+
+	w.Header().Set("Content-Type", "application/timestamp-reply")
+	w.Write(marshal(resp))
+	w.Write(make([]byte, tsa.ExtraBytes))
+}
+
+func TestTimestampClient(t *testing.T) {
+	tsa := newTestTSA(t)
+	s := httptest.NewServer(tsa)
+	defer s.Close()
+
+	digest := sha256.Sum256([]byte("signature"))
+
+	tests := []struct {
+		name   string
+		change func(*testTSA)
+		roots  *x509.CertPool
+		err    string
+	}{
+		{"valid", func(*testTSA) {}, tsa.roots(), ""},
+		{"untrusted authority", func(*testTSA) {}, x509.NewCertPool(), "Invalid timestamp"},
+		{"rejected", func(tsa *testTSA) { tsa.Status = 2 }, tsa.roots(), "Timestamp rejected: status 2"},
+		{"wrong nonce", func(tsa *testTSA) { tsa.Nonce = big.NewInt(1) }, tsa.roots(), "nonce does not match request"},
+		{"tampered content", func(tsa *testTSA) { tsa.Tamper = true }, tsa.roots(), "signed attributes do not match content"},
+		{"invalid genTime", func(tsa *testTSA) { tsa.GenTime = "yesterday" }, tsa.roots(), "Error decoding TSTInfo"},
+		{"oversized response", func(tsa *testTSA) { tsa.ExtraBytes = maxTimestampSize }, tsa.roots(), "larger than"},
+	}
+
+	for _, test := range tests {
+		*tsa = testTSA{key: tsa.key, cert: tsa.cert}
+		test.change(tsa)
+
+		token, err := NewTimestampClient(s.URL, time.Minute, test.roots).Timestamp(digest[:])
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected error containing %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Error getting timestamp: %v", test.name, err)
+			continue
+		}
+
+		ts, err := VerifyTimestamp(token, digest[:], test.roots)
+		if err != nil {
+			t.Errorf("%s: Error verifying timestamp: %v", test.name, err)
+			continue
+		}
+		if ts.SerialNumber != "42" || ts.Authority != "CN=Test TSA" || time.Since(ts.Time) > time.Minute {
+			t.Errorf("%s: unexpected timestamp: %+v", test.name, ts)
+		}
+	}
+}
+
+func TestVerifyTimestamp(t *testing.T) {
+	tsa := newTestTSA(t)
+	genTime := time.Now().UTC().Add(-30 * time.Minute).Truncate(time.Second).Add(123 * time.Millisecond)
+	tsa.GenTime = genTime.Format("20060102150405.000Z")
+	digest := sha256.Sum256([]byte("signature"))
+	token := tsa.token(digest[:], big.NewInt(1))
+	other := sha256.Sum256([]byte("other signature"))
+
+	ts, err := VerifyTimestamp(token, digest[:], tsa.roots())
+	if err != nil {
+		t.Fatalf("Error verifying timestamp: %v", err)
+	}
+	if !ts.Time.Equal(genTime) {
+		t.Errorf("Expected fractional genTime %v, got %v", genTime, ts.Time)
+	}
+
+	parsed, err := ParseTimestamp(token)
+	if err != nil || !parsed.Time.Equal(ts.Time) || parsed.SerialNumber != "42" {
+		t.Errorf("Expected parsed timestamp to match, got %+v, %v", parsed, err)
+	}
+
+	tests := []struct {
+		name   string
+		token  []byte
+		digest []byte
+		err    string
+	}{
+		{"other digest", token, other[:], "token does not match signature"},
+		{"truncated", token[:len(token)/2], digest[:], "Error decoding token"},
+		{"garbage", []byte("not a token"), digest[:], "Error decoding token"},
+		{"not SignedData", marshal(tsContentInfo{
+			ContentType: oidTSTInfo,
+			Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: marshal(1)},
+		}), digest[:], "not SignedData"},
+	}
+
+	for _, test := range tests {
+		if _, err := VerifyTimestamp(test.token, test.digest, tsa.roots()); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: expected error containing %q, got %v", test.name, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+//tokenRevocationRefresh is how often revocations made by other replicas are loaded from the database
+const tokenRevocationRefresh = 30 * time.Second
+
+//tokenHeader represents the JOSE header of a session token
+type tokenHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid"`
+}
+
+//tokenClaims represents the claims of a session token
+type tokenClaims struct {
+	ID       string `json:"jti"`
+	IssuedAt int64  `json:"iat"`
+	Expires  int64  `json:"exp"`
+	User     *User  `json:"usr"`
+}
+
+//TokenSessionStore represents a SessionStore that issues signed session tokens, so replicas can check sessions without sharing them.
+//Tokens are JWTs signed with Ed25519 (alg EdDSA) carrying the User and expiration, with the signing key's KeyID as kid.
+//Tokens can be read, but not changed, by the client.
+//Deleted sessions are added to a revocation list, kept until the tokens expire, that replicas share through the database.
+type TokenSessionStore struct {
+	db            *SQLDB
+	key           ed25519.PrivateKey
+	keys          map[string]ed25519.PublicKey
+	duration      time.Duration
+	adminDuration time.Duration
+
+	mu      *sync.Mutex
+	revoked map[string]time.Time //token ID to expiration
+}
+
+//NewTokenSessionStore returns a new TokenSessionStore with the given expiration duration that signs tokens
+//with the Ed25519 private key from the given seed and stores revocations in db.
+//Tokens signed by any of oldKeys will still be accepted until they expire, so keys can be rotated.
+func NewTokenSessionStore(db *SQLDB, seed []byte, oldKeys []ed25519.PublicKey, duration time.Duration, adminDuration time.Duration) (*TokenSessionStore, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("Invalid seed length: %d", len(seed))
+	}
+
+	key := ed25519.NewKeyFromSeed(seed)
+	pub := key.Public().(ed25519.PublicKey)
+
+	keys := map[string]ed25519.PublicKey{KeyID(pub): pub}
+	for _, k := range oldKeys {
+		keys[KeyID(k)] = k
+	}
+
+	s := &TokenSessionStore{
+		db:            db,
+		key:           key,
+		keys:          keys,
+		duration:      duration,
+		adminDuration: adminDuration,
+		mu:            new(sync.Mutex),
+		revoked:       make(map[string]time.Time),
+	}
+
+	if err := s.refresh(); err != nil {
+		return nil, fmt.Errorf("Error loading revoked sessions: %v", err)
+	}
+	go func() {
+		for {
+			time.Sleep(tokenRevocationRefresh)
+			if err := s.refresh(); err != nil {
+				log.Println("Error loading revoked sessions:", err)
+			}
+		}
+	}()
+
+	return s, nil
+}
+
+//PublicKey returns the public key tokens are currently signed with
+func (s *TokenSessionStore) PublicKey() ed25519.PublicKey {
+	return s.key.Public().(ed25519.PublicKey)
+}
+
+//Create returns a new session token with the given User. err will always be nil.
+func (s *TokenSessionStore) Create(user *User) (sessionID string, err error) {
+	var dur time.Duration
+	if user.Admin {
+		dur = s.adminDuration
+	} else {
+		dur = s.duration
+	}
+
+	now := time.Now()
+	header, err := json.Marshal(&tokenHeader{Alg: "EdDSA", Typ: "JWT", Kid: KeyID(s.PublicKey())})
+	if err != nil {
+		return "", fmt.Errorf("Error encoding token header: %v", err)
+	}
+	claims, err := json.Marshal(&tokenClaims{
+		ID:       randString(32),
+		IssuedAt: now.Unix(),
+		Expires:  now.Add(dur).Unix(),
+		User:     user,
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error encoding token claims: %v", err)
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(ed25519.Sign(s.key, []byte(signed))), nil
+}
+
+//verify returns the claims of the given token if its signature is valid and it hasn't expired, or nil if not
+func (s *TokenSessionStore) verify(token string) *tokenClaims {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+
+	header := new(tokenHeader)
+	buf, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(buf, header) != nil || header.Alg != "EdDSA" {
+		return nil
+	}
+
+	pub, ok := s.keys[header.Kid]
+	if !ok {
+		return nil
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !ed25519.Verify(pub, []byte(parts[0]+"."+parts[1]), sig) {
+		return nil
+	}
+
+	claims := new(tokenClaims)
+	buf, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(buf, claims) != nil {
+		return nil
+	}
+
+	if claims.ID == "" || claims.User == nil || !time.Unix(claims.Expires, 0).After(time.Now()) {
+		return nil
+	}
+
+	return claims
+}
+
+//Check returns whether or not sessionID is a valid session token. If sessionID is not valid, session will be nil.
+//err will always be nil.
+func (s *TokenSessionStore) Check(sessionID string) (session *Session, err error) {
+	claims := s.verify(sessionID)
+	if claims == nil {
+		return nil, nil
+	}
+
+	s.mu.Lock()
+	_, revoked := s.revoked[claims.ID]
+	s.mu.Unlock()
+	if revoked {
+		return nil, nil
+	}
+
+	return &Session{User: claims.User, Expires: time.Unix(claims.Expires, 0)}, nil
+}
+
+//Delete revokes the given session token until it expires. Other replicas stop accepting it within tokenRevocationRefresh.
+//If the database malfunctions, err will be non-nil.
+func (s *TokenSessionStore) Delete(sessionID string) error {
+	claims := s.verify(sessionID)
+	if claims == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	_, revoked := s.revoked[claims.ID]
+	s.mu.Unlock()
+	if revoked {
+		return nil
+	}
+
+	expires := time.Unix(claims.Expires, 0).UTC()
+	if _, err := s.db.db.Exec("INSERT INTO revoked_sessions(id, expires) VALUES(?, ?);", claims.ID, expires); err != nil {
+		//another replica may have revoked it since the last refresh
+		var n int
+		if e := s.db.db.QueryRow("SELECT COUNT(*) FROM revoked_sessions WHERE id=?;", claims.ID).Scan(&n); e != nil || n == 0 {
+			return fmt.Errorf("Error revoking session: %v", err)
+		}
+	}
+
+	s.mu.Lock()
+	s.revoked[claims.ID] = expires
+	s.mu.Unlock()
+
+	return nil
+}
+
+//refresh removes expired revocations and adds revocations made by other replicas to the revocation list
+func (s *TokenSessionStore) refresh() error {
+	now := time.Now().UTC()
+
+	//revoked tokens can't be used after they expire anyway, so every replica may remove them
+	if _, err := s.db.db.Exec("DELETE FROM revoked_sessions WHERE expires<?;", now); err != nil {
+		return err
+	}
+
+	rows, err := s.db.db.Query("SELECT id, expires FROM revoked_sessions;")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	revoked := make(map[string]time.Time)
+	for rows.Next() {
+		var id string
+		var expires time.Time
+		if err = rows.Scan(&id, &expires); err != nil {
+			return err
+		}
+		revoked[id] = expires
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	//revocations made on this replica since the query are kept
+	for id, expires := range s.revoked {
+		if expires.After(now) {
+			revoked[id] = expires
+		}
+	}
+	s.revoked = revoked
+
+	return nil
+}
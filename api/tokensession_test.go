@@ -0,0 +1,113 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//newTestTokenSessionStore returns a TokenSessionStore without a database, signing with the key from seed
+//and accepting oldKeys, whose sessions last duration
+func newTestTokenSessionStore(seed byte, duration time.Duration, oldKeys ...ed25519.PublicKey) *TokenSessionStore {
+	key := ed25519.NewKeyFromSeed([]byte(strings.Repeat(string(seed), ed25519.SeedSize)))
+	pub := key.Public().(ed25519.PublicKey)
+
+	keys := map[string]ed25519.PublicKey{KeyID(pub): pub}
+	for _, k := range oldKeys {
+		keys[KeyID(k)] = k
+	}
+
+	return &TokenSessionStore{
+		key:           key,
+		keys:          keys,
+		duration:      duration,
+		adminDuration: duration,
+		mu:            new(sync.Mutex),
+		revoked:       make(map[string]time.Time),
+	}
+}
+
+//createToken returns a new session token from s for a test user
+func createToken(t *testing.T, s *TokenSessionStore) string {
+	token, err := s.Create(&User{EmployeeID: "123", Username: "jdoe"})
+	if err != nil {
+		t.Fatalf("Error creating token: %v", err)
+	}
+	return token
+}
+
+//forgeToken returns a token with the given header, claims, and signature
+func forgeToken(header, claims string, sig []byte) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + "." +
+		base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestTokenSessionVerify(t *testing.T) {
+	s := newTestTokenSessionStore('a', time.Hour)
+	other := newTestTokenSessionStore('b', time.Hour)
+	rotated := newTestTokenSessionStore('c', time.Hour, s.PublicKey())
+	expired := newTestTokenSessionStore('a', -time.Minute)
+
+	token := createToken(t, s)
+	parts := strings.Split(token, ".")
+	header, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	admin := strings.Replace(string(claims), `"Admin":false`, `"Admin":true`, 1)
+	if admin == string(claims) {
+		t.Fatalf("Expected claims to include Admin, got %s", claims)
+	}
+
+	//HS256 signed with the public key, for verifiers that take the algorithm from the token
+	hs256 := `{"alg":"HS256","typ":"JWT","kid":"` + KeyID(s.PublicKey()) + `"}`
+	mac := hmac.New(sha256.New, s.PublicKey())
+	mac.Write([]byte(base64.RawURLEncoding.EncodeToString([]byte(hs256)) + "." + base64.RawURLEncoding.EncodeToString([]byte(admin))))
+
+	tests := []struct {
+		name  string
+		store *TokenSessionStore
+		token string
+		valid bool
+	}{
+		{"valid", s, token, true},
+		{"signed by a rotated key", rotated, token, true},
+		{"unknown kid", other, token, false},
+		{"expired", s, createToken(t, expired), false},
+		{"tampered claims", s, forgeToken(string(header), admin, sig), false},
+		{"alg none", s, forgeToken(`{"alg":"none","typ":"JWT","kid":"`+KeyID(s.PublicKey())+`"}`, admin, nil), false},
+		{"alg HS256", s, forgeToken(hs256, admin, mac.Sum(nil)), false},
+		{"missing signature", s, parts[0] + "." + parts[1], false},
+		{"garbage", s, "not.a.token", false},
+	}
+
+	for _, test := range tests {
+		c := test.store.verify(test.token)
+		if test.valid && (c == nil || c.User.EmployeeID != "123") {
+			t.Errorf("%s: expected valid token, got %+v", test.name, c)
+		}
+		if !test.valid && c != nil {
+			t.Errorf("%s: expected invalid token, got %+v", test.name, c)
+		}
+	}
+}
+
+func TestTokenSessionRevoked(t *testing.T) {
+	s := newTestTokenSessionStore('a', time.Hour)
+	token := createToken(t, s)
+	kept := createToken(t, s)
+
+	//as loaded by refresh after another replica revoked it
+	s.revoked[s.verify(token).ID] = time.Now().Add(time.Hour)
+
+	if sess, err := s.Check(token); err != nil || sess != nil {
+		t.Errorf("Expected revoked token to be rejected, got %+v, %v", sess, err)
+	}
+	if sess, err := s.Check(kept); err != nil || sess == nil || sess.User.Username != "jdoe" {
+		t.Errorf("Expected other token to be accepted, got %+v, %v", sess, err)
+	}
+}
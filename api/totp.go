@@ -0,0 +1,562 @@
+package api
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//TOTP parameters (RFC 6238). These are the defaults authenticator apps assume.
+const (
+	totpPeriod     = 30 //seconds
+	totpDigits     = 6
+	totpSkew       = 1 //time steps accepted before and after the current one
+	totpSecretSize = 20
+)
+
+//recovery code parameters
+const (
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+)
+
+//recoveryCodeChars are the characters in recovery codes, without ones that are easily confused
+const recoveryCodeChars = "abcdefghjkmnpqrstuvwxyz23456789"
+
+//pendingLoginDuration is how long an admin has to enter a TOTP code after their password
+const pendingLoginDuration = 5 * time.Minute
+
+//pendingLoginAttempts is how many wrong codes are allowed before the admin has to enter their password again
+const pendingLoginAttempts = 5
+
+//ErrTOTPNotFound is returned when an admin isn't enrolled in TOTP
+var ErrTOTPNotFound = errors.New("TOTP enrollment not found")
+
+//ErrInvalidTOTPSecret is returned when an encrypted TOTP secret can't be decrypted
+var ErrInvalidTOTPSecret = errors.New("Invalid encrypted TOTP secret")
+
+//TOTPEnrollment represents an admin's TOTP second factor as stored in the database
+type TOTPEnrollment struct {
+	Username      string
+	Secret        string   //encrypted with TOTPAuth's key
+	RecoveryCodes []string //hex-encoded SHA-256 hashes of unused recovery codes
+	LastCounter   int64    //time step of the last code used, so codes can't be replayed
+	Time          time.Time
+}
+
+//totpCode returns the TOTP code for secret and the given time step (RFC 4226)
+func totpCode(secret []byte, counter int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, code%1000000)
+}
+
+//totpCounter returns the time step for t
+func totpCounter(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+//normalizeRecoveryCode returns code without spaces or dashes and lowercased
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
+
+//hashRecoveryCode returns the hash a recovery code is stored with
+func hashRecoveryCode(code string) string {
+	h := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hex.EncodeToString(h[:])
+}
+
+//newRecoveryCodes returns new recovery codes formatted for display and their hashes
+func newRecoveryCodes() (codes, hashes []string, err error) {
+	limit := byte(256 - 256%len(recoveryCodeChars))
+	for len(codes) < recoveryCodeCount {
+		var code []byte
+		buf := make([]byte, 1)
+		for len(code) < recoveryCodeLength {
+			if _, err = rand.Read(buf); err != nil {
+				return nil, nil, fmt.Errorf("Error generating recovery code: %v", err)
+			}
+			//avoid modulo bias
+			if buf[0] >= limit {
+				continue
+			}
+			code = append(code, recoveryCodeChars[int(buf[0])%len(recoveryCodeChars)])
+		}
+		formatted := string(code[:recoveryCodeLength/2]) + "-" + string(code[recoveryCodeLength/2:])
+		codes = append(codes, formatted)
+		hashes = append(hashes, hashRecoveryCode(formatted))
+	}
+	return codes, hashes, nil
+}
+
+//totpUsername returns the key admins are enrolled with
+func totpUsername(username string) string {
+	return strings.ToLower(username)
+}
+
+//pendingLogin represents an admin login waiting for a TOTP code
+type pendingLogin struct {
+	User     *User
+	Enroll   bool //if the admin must enroll before entering a code
+	Attempts int
+}
+
+//pendingEnrollment represents a TOTP secret waiting to be confirmed with a code
+type pendingEnrollment struct {
+	Secret string //encrypted like stored secrets, since it may be kept in a shared StateStore
+}
+
+//TOTPAuth represents TOTP second factors for admin logins.
+//Secrets are encrypted with AES-256-GCM before they're stored in the DB.
+//Pending logins and enrollments are kept in a StateStore.
+type TOTPAuth struct {
+	db       DB
+	state    StateStore
+	aead     cipher.AEAD
+	issuer   string
+	required bool
+}
+
+//NewTOTPAuth returns a new TOTPAuth that stores enrollments in db, encrypted with the given 32 byte key,
+//and keeps pending logins in state. issuer is the name authenticator apps show for the account.
+//If required is true, admins must enroll before they can log in.
+func NewTOTPAuth(db DB, state StateStore, key []byte, issuer string, required bool) (*TOTPAuth, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating cipher: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating cipher: %v", err)
+	}
+
+	return &TOTPAuth{
+		db:       db,
+		state:    state,
+		aead:     aead,
+		issuer:   issuer,
+		required: required,
+	}, nil
+}
+
+//encrypt returns secret encrypted for username.
+//The username is authenticated with the secret so encrypted secrets can't be moved between admins.
+func (a *TOTPAuth) encrypt(username string, secret []byte) (string, error) {
+	nonce := make([]byte, a.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("Error generating nonce: %v", err)
+	}
+	sealed := a.aead.Seal(nonce, nonce, secret, []byte(totpUsername(username)))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+//decrypt returns the secret encrypted for username
+func (a *TOTPAuth) decrypt(username, encrypted string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil || len(sealed) < a.aead.NonceSize() {
+		return nil, ErrInvalidTOTPSecret
+	}
+	secret, err := a.aead.Open(nil, sealed[:a.aead.NonceSize()], sealed[a.aead.NonceSize():], []byte(totpUsername(username)))
+	if err != nil {
+		return nil, ErrInvalidTOTPSecret
+	}
+	return secret, nil
+}
+
+//Required returns whether or not admins must enroll before they can log in
+func (a *TOTPAuth) Required() bool {
+	return a.required
+}
+
+//Enrolled returns whether or not the admin with the given username is enrolled
+func (a *TOTPAuth) Enrolled(username string) (bool, error) {
+	t, err := a.db.TOTP(totpUsername(username))
+	if err != nil {
+		return false, fmt.Errorf("Error getting TOTP enrollment: %v", err)
+	}
+	return t != nil, nil
+}
+
+//RecoveryCodesLeft returns the number of unused recovery codes the admin with the given username has
+func (a *TOTPAuth) RecoveryCodesLeft(username string) (int, error) {
+	t, err := a.db.TOTP(totpUsername(username))
+	if err != nil {
+		return 0, fmt.Errorf("Error getting TOTP enrollment: %v", err)
+	}
+	if t == nil {
+		return 0, nil
+	}
+	return len(t.RecoveryCodes), nil
+}
+
+//AddPending returns an ID the client can complete an admin login for user with after entering a TOTP code.
+//If enroll is true, the admin must enroll with Begin and Confirm first.
+func (a *TOTPAuth) AddPending(user *User, enroll bool) (string, error) {
+	id := randString(64)
+	if err := putState(a.state, "totp_pending", id, &pendingLogin{User: user, Enroll: enroll}, time.Now().Add(pendingLoginDuration)); err != nil {
+		return "", fmt.Errorf("Error storing pending login: %v", err)
+	}
+	return id, nil
+}
+
+//Pending returns the User for the given pending login ID, and whether or not they must enroll.
+//If the ID is unknown, completed, or expired, user will be nil.
+func (a *TOTPAuth) Pending(id string) (user *User, enroll bool, err error) {
+	p := new(pendingLogin)
+	ok, err := getState(a.state, "totp_pending", id, p)
+	if err != nil {
+		return nil, false, fmt.Errorf("Error getting pending login: %v", err)
+	}
+	if !ok {
+		return nil, false, nil
+	}
+	return p.User, p.Enroll, nil
+}
+
+//Complete removes the given pending login, so it can't be used again
+func (a *TOTPAuth) Complete(id string) error {
+	if err := deleteState(a.state, "totp_pending", id); err != nil {
+		return fmt.Errorf("Error removing pending login: %v", err)
+	}
+	return nil
+}
+
+//Fail records a wrong code for the given pending login.
+//After pendingLoginAttempts wrong codes, the pending login is removed.
+func (a *TOTPAuth) Fail(id string) error {
+	err := a.state.Update("totp_pending", []string{id}, func(entries []*StateEntry) error {
+		if entries[0] == nil {
+			return nil
+		}
+		p := new(pendingLogin)
+		if err := json.Unmarshal(entries[0].Value, p); err != nil {
+			return err
+		}
+		p.Attempts++
+		if p.Attempts >= pendingLoginAttempts {
+			entries[0] = nil
+			return nil
+		}
+		e, err := newStateEntry(p, entries[0].Expires)
+		entries[0] = e
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Error recording failed TOTP code: %v", err)
+	}
+	return nil
+}
+
+//Begin starts TOTP enrollment for the admin with the given username, replacing any enrollment in progress.
+//It returns the base32 encoded secret and an otpauth URI for authenticator apps.
+//The enrollment isn't stored until it's confirmed with Confirm.
+func (a *TOTPAuth) Begin(username string) (secret, uri string, err error) {
+	buf := make([]byte, totpSecretSize)
+	if _, err = rand.Read(buf); err != nil {
+		return "", "", fmt.Errorf("Error generating secret: %v", err)
+	}
+
+	encrypted, err := a.encrypt(username, buf)
+	if err != nil {
+		return "", "", err
+	}
+	if err = putState(a.state, "totp_enrolling", totpUsername(username), &pendingEnrollment{Secret: encrypted}, time.Now().Add(pendingLoginDuration)); err != nil {
+		return "", "", fmt.Errorf("Error storing enrollment: %v", err)
+	}
+
+	secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf)
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", a.issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(a.issuer + ":" + username)
+	uri = fmt.Sprintf("otpauth://totp/%s?%s", label, v.Encode())
+
+	return secret, uri, nil
+}
+
+//matchCounter returns the time step within totpSkew of now that code is valid for, or -1 if none
+func matchCounter(secret []byte, code string) int64 {
+	code = strings.TrimSpace(code)
+	now := totpCounter(time.Now())
+	for c := now - totpSkew; c <= now+totpSkew; c++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, c)), []byte(code)) == 1 {
+			return c
+		}
+	}
+	return -1
+}
+
+//Confirm completes TOTP enrollment for the admin with the given username if code is valid for the secret from Begin,
+//replacing any existing enrollment. It returns new recovery codes, which are only stored as hashes.
+//If there's no enrollment in progress or code is invalid, codes will be nil.
+func (a *TOTPAuth) Confirm(username, code string) (codes []string, err error) {
+	e := new(pendingEnrollment)
+	ok, err := getState(a.state, "totp_enrolling", totpUsername(username), e)
+	if err != nil {
+		return nil, fmt.Errorf("Error getting enrollment: %v", err)
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	secret, err := a.decrypt(username, e.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	counter := matchCounter(secret, code)
+	if counter == -1 {
+		return nil, nil
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.db.SetTOTP(&TOTPEnrollment{
+		Username:      totpUsername(username),
+		Secret:        e.Secret,
+		RecoveryCodes: hashes,
+		LastCounter:   counter,
+		Time:          time.Now(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error storing TOTP enrollment: %v", err)
+	}
+
+	if err = deleteState(a.state, "totp_enrolling", totpUsername(username)); err != nil {
+		log.Println("Error removing confirmed enrollment:", err)
+	}
+
+	return codes, nil
+}
+
+//Verify returns whether or not code is a valid TOTP code or unused recovery code for the admin with the given username.
+//TOTP codes can only be used once, and recovery codes are removed when they're used.
+func (a *TOTPAuth) Verify(username, code string) (bool, error) {
+	t, err := a.db.TOTP(totpUsername(username))
+	if err != nil {
+		return false, fmt.Errorf("Error getting TOTP enrollment: %v", err)
+	}
+	if t == nil {
+		return false, nil
+	}
+
+	if len(strings.TrimSpace(code)) == totpDigits {
+		secret, err := a.decrypt(username, t.Secret)
+		if err != nil {
+			return false, fmt.Errorf("Error decrypting TOTP secret for %s: %v", username, err)
+		}
+		counter := matchCounter(secret, code)
+		if counter == -1 {
+			return false, nil
+		}
+		used, err := a.db.UseTOTPCounter(totpUsername(username), counter)
+		if err != nil {
+			return false, fmt.Errorf("Error recording TOTP code use: %v", err)
+		}
+		return used, nil
+	}
+
+	used, err := a.db.UseRecoveryCode(totpUsername(username), hashRecoveryCode(code))
+	if err != nil {
+		return false, fmt.Errorf("Error recording recovery code use: %v", err)
+	}
+	return used, nil
+}
+
+//RegenerateRecoveryCodes replaces the recovery codes of the admin with the given username
+//and returns the new codes. If the admin isn't enrolled, ErrTOTPNotFound is returned.
+func (a *TOTPAuth) RegenerateRecoveryCodes(username string) ([]string, error) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err = a.db.SetRecoveryCodes(totpUsername(username), hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+//Remove removes the TOTP enrollment of the admin with the given username.
+//If the admin isn't enrolled, ErrTOTPNotFound is returned.
+func (a *TOTPAuth) Remove(username string) error {
+	return a.db.DeleteTOTP(totpUsername(username))
+}
+
+//totpAudit is the record audited for TOTP enrollment changes; secrets and recovery codes are never audited
+type totpAudit struct {
+	Username string
+	Counter  int64 `json:",omitempty"` //time step of a used code
+}
+
+//TOTP returns the TOTP enrollment for the given admin username.
+//If the admin isn't enrolled, enrollment will be nil.
+//TOTP returns an error if one occurred.
+func (db *SQLDB) TOTP(username string) (enrollment *TOTPEnrollment, err error) {
+	t := &TOTPEnrollment{}
+	var codes string
+	err = db.db.QueryRow("SELECT username, secret, recovery_codes, last_counter, time FROM admin_totp WHERE username=?;", username).Scan(
+		&(t.Username), &(t.Secret), &codes, &(t.LastCounter), &(t.Time))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if codes != "" {
+		t.RecoveryCodes = strings.Split(codes, ",")
+	}
+
+	return t, nil
+}
+
+//SetTOTP commits a TOTP enrollment, replacing any existing enrollment for its Username
+func (db *SQLDB) SetTOTP(t *TOTPEnrollment) error {
+	return db.transact(func(tx *sql.Tx) error {
+		if err := db.audit(tx, AuditTOTPEnroll, totpAudit{Username: t.Username}); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec("DELETE FROM admin_totp WHERE username=?;", t.Username); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec("INSERT INTO admin_totp(username, secret, recovery_codes, last_counter, time) VALUES(?, ?, ?, ?, ?);",
+			t.Username, t.Secret, strings.Join(t.RecoveryCodes, ","), t.LastCounter, t.Time)
+		return err
+	})
+}
+
+//DeleteTOTP removes the TOTP enrollment for the given admin username.
+//If the admin isn't enrolled, ErrTOTPNotFound is returned.
+func (db *SQLDB) DeleteTOTP(username string) error {
+	return db.transact(func(tx *sql.Tx) error {
+		result, err := tx.Exec("DELETE FROM admin_totp WHERE username=?;", username)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrTOTPNotFound
+		}
+
+		return db.audit(tx, AuditTOTPRemove, totpAudit{Username: username})
+	})
+}
+
+//UseTOTPCounter records that the TOTP code for the given time step was used.
+//If the admin isn't enrolled or a code for the same or a later time step was already used, used will be false.
+func (db *SQLDB) UseTOTPCounter(username string, counter int64) (used bool, err error) {
+	err = db.transact(func(tx *sql.Tx) error {
+		result, err := tx.Exec("UPDATE admin_totp SET last_counter=? WHERE username=? AND last_counter<?;", counter, username, counter)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n != 1 {
+			return nil
+		}
+
+		used = true
+		return db.audit(tx, AuditTOTPUse, totpAudit{Username: username, Counter: counter})
+	})
+	if err != nil {
+		return false, err
+	}
+	return used, nil
+}
+
+//UseRecoveryCode removes the given recovery code hash from the admin's enrollment.
+//If the admin isn't enrolled or the hash isn't one of their recovery codes, used will be false.
+func (db *SQLDB) UseRecoveryCode(username, hash string) (used bool, err error) {
+	err = db.transact(func(tx *sql.Tx) error {
+		var codes string
+		err := tx.QueryRow("SELECT recovery_codes FROM admin_totp WHERE username=?;", username).Scan(&codes)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var kept []string
+		for _, c := range strings.Split(codes, ",") {
+			if c == "" {
+				continue
+			}
+			if subtle.ConstantTimeCompare([]byte(c), []byte(hash)) == 1 {
+				used = true
+				continue
+			}
+			kept = append(kept, c)
+		}
+		if !used {
+			return nil
+		}
+
+		if _, err = tx.Exec("UPDATE admin_totp SET recovery_codes=? WHERE username=?;", strings.Join(kept, ","), username); err != nil {
+			return err
+		}
+
+		return db.audit(tx, AuditTOTPRecoveryUse, totpAudit{Username: username})
+	})
+	if err != nil {
+		return false, err
+	}
+	return used, nil
+}
+
+//SetRecoveryCodes replaces the recovery code hashes of the given admin username.
+//If the admin isn't enrolled, ErrTOTPNotFound is returned.
+func (db *SQLDB) SetRecoveryCodes(username string, hashes []string) error {
+	return db.transact(func(tx *sql.Tx) error {
+		result, err := tx.Exec("UPDATE admin_totp SET recovery_codes=? WHERE username=?;", strings.Join(hashes, ","), username)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrTOTPNotFound
+		}
+
+		return db.audit(tx, AuditTOTPRecoveryNew, totpAudit{Username: username})
+	})
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+//testTOTPDB is a DB that keeps TOTP enrollments in memory, with the same semantics as SQLDB
+type testTOTPDB struct {
+	DB
+	enrollments map[string]*TOTPEnrollment
+}
+
+func (db *testTOTPDB) TOTP(username string) (*TOTPEnrollment, error) {
+	return db.enrollments[username], nil
+}
+
+func (db *testTOTPDB) SetTOTP(t *TOTPEnrollment) error {
+	db.enrollments[t.Username] = t
+	return nil
+}
+
+func (db *testTOTPDB) UseTOTPCounter(username string, counter int64) (bool, error) {
+	t := db.enrollments[username]
+	if t == nil || t.LastCounter >= counter {
+		return false, nil
+	}
+	t.LastCounter = counter
+	return true, nil
+}
+
+func (db *testTOTPDB) UseRecoveryCode(username, hash string) (bool, error) {
+	t := db.enrollments[username]
+	if t == nil {
+		return false, nil
+	}
+	for i, c := range t.RecoveryCodes {
+		if c == hash {
+			t.RecoveryCodes = append(t.RecoveryCodes[:i], t.RecoveryCodes[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func TestTOTPCode(t *testing.T) {
+	//RFC 6238 Appendix B SHA-1 test vectors, truncated to totpDigits
+	secret := []byte("12345678901234567890")
+	tests := []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, test := range tests {
+		if code := totpCode(secret, totpCounter(time.Unix(test.time, 0))); code != test.code {
+			t.Errorf("%d: expected code %s, got %s", test.time, test.code, code)
+		}
+	}
+}
+
+func TestMatchCounter(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	now := totpCounter(time.Now())
+
+	tests := []struct {
+		name     string
+		code     string
+		expected int64
+	}{
+		{"current", totpCode(secret, now), now},
+		{"previous", totpCode(secret, now-1), now - 1},
+		{"next", totpCode(secret, now+1), now + 1},
+		{"surrounding spaces", " " + totpCode(secret, now) + " ", now},
+		{"too old", totpCode(secret, now-3), -1},
+		{"too new", totpCode(secret, now+3), -1},
+		{"other secret", totpCode([]byte("09876543210987654321"), now), -1},
+		{"empty", "", -1},
+	}
+
+	for _, test := range tests {
+		//codes can repeat across time steps, so only check those that differ from every accepted one
+		if test.expected == -1 && (test.code == totpCode(secret, now-1) || test.code == totpCode(secret, now) || test.code == totpCode(secret, now+1)) {
+			continue
+		}
+		if c := matchCounter(secret, test.code); c != test.expected {
+			t.Errorf("%s: expected counter %d, got %d", test.name, test.expected, c)
+		}
+	}
+}
+
+func TestTOTPVerify(t *testing.T) {
+	a, err := NewTOTPAuth(&testTOTPDB{enrollments: make(map[string]*TOTPEnrollment)}, NewMemoryStateStore(), make([]byte, 32), "Handbook", false)
+	if err != nil {
+		t.Fatalf("Error creating TOTPAuth: %v", err)
+	}
+
+	encoded, _, err := a.Begin("Admin")
+	if err != nil {
+		t.Fatalf("Error beginning enrollment: %v", err)
+	}
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("Error decoding secret: %v", err)
+	}
+
+	now := totpCounter(time.Now())
+	codes, err := a.Confirm("admin", totpCode(secret, now))
+	if err != nil || len(codes) != recoveryCodeCount {
+		t.Fatalf("Expected enrollment to be confirmed, got %v, %v", codes, err)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		code     string
+		expected bool
+	}{
+		{"code used to enroll", "admin", totpCode(secret, now), false},
+		{"next code", "ADMIN", totpCode(secret, now+1), true},
+		{"replayed code", "admin", totpCode(secret, now+1), false},
+		{"earlier code", "admin", totpCode(secret, now-1), false},
+		{"recovery code", "admin", codes[0], true},
+		{"reused recovery code", "admin", codes[0], false},
+		{"recovery code without dash", "admin", strings.ToUpper(strings.Replace(codes[1], "-", "", 1)), true},
+		{"unknown recovery code", "admin", "aaaaa-aaaaa", false},
+		{"not enrolled", "other", totpCode(secret, now+1), false},
+	}
+
+	for _, test := range tests {
+		ok, err := a.Verify(test.username, test.code)
+		if err != nil {
+			t.Errorf("%s: Error verifying code: %v", test.name, err)
+			continue
+		}
+		if ok != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, ok)
+		}
+	}
+
+	left, err := a.RecoveryCodesLeft("admin")
+	if err != nil {
+		t.Fatalf("Error counting recovery codes: %v", err)
+	}
+	if left != recoveryCodeCount-2 {
+		t.Errorf("Expected %d recovery codes left, got %d", recoveryCodeCount-2, left)
+	}
+}
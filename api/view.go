@@ -0,0 +1,46 @@
+package api
+
+import (
+	"database/sql"
+	"time"
+)
+
+//View represents a user opening a Document
+type View struct {
+	EmployeeID string
+	Version    string
+	Time       time.Time
+}
+
+//RecordView commits a View to the database, and returns an error if one occurred
+func (db *SQLDB) RecordView(v *View) error {
+	return db.transact(func(tx *sql.Tx) error {
+		if err := db.audit(tx, AuditView, v); err != nil {
+			return err
+		}
+		_, err := tx.Exec("INSERT INTO views(employee_id, version, time) VALUES(?, ?, ?);",
+			v.EmployeeID,
+			v.Version,
+			v.Time,
+		)
+		return err
+	})
+}
+
+//FirstView returns the first time the given employeeID viewed the given document version.
+//If the document hasn't been viewed, viewTime will be nil.
+//FirstView returns an error if one occurred.
+func (db *SQLDB) FirstView(employeeID, version string) (viewTime *time.Time, err error) {
+	row := db.db.QueryRow("SELECT time FROM views WHERE employee_id=? AND version=? ORDER BY time LIMIT 1;", employeeID, version)
+
+	t := new(time.Time)
+	err = row.Scan(t)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return t, nil
+}
@@ -0,0 +1,476 @@
+package api
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha256" //SHA-256 digests and signatures
+	_ "crypto/sha512" //SHA-512 digests and signatures
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+//XML namespaces and algorithms used by XML Signatures
+const (
+	xmlNamespace  = "http://www.w3.org/XML/1998/namespace"
+	dsigNamespace = "http://www.w3.org/2000/09/xmldsig#"
+
+	dsigExcC14N     = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	dsigEnveloped   = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	dsigSHA256      = "http://www.w3.org/2001/04/xmlenc#sha256"
+	dsigSHA512      = "http://www.w3.org/2001/04/xmlenc#sha512"
+	dsigRSASHA256   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	dsigRSASHA512   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha512"
+	dsigECDSASHA256 = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256"
+	dsigECDSASHA512 = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha512"
+)
+
+//ErrInvalidXMLSignature is returned when an XML Signature fails verification
+var ErrInvalidXMLSignature = errors.New("Invalid XML Signature")
+
+//xmlAttr represents an XML attribute with its literal prefix
+type xmlAttr struct {
+	prefix string
+	local  string
+	value  string
+}
+
+//xmlText represents XML character data
+type xmlText string
+
+//xmlProcInst represents an XML processing instruction
+type xmlProcInst struct {
+	target string
+	inst   string
+}
+
+//xmlElement represents an XML element with its literal prefix.
+//Children are *xmlElement, xmlText, or xmlProcInst.
+type xmlElement struct {
+	prefix   string
+	local    string
+	attrs    []xmlAttr
+	children []interface{}
+	parent   *xmlElement
+}
+
+//parseXML parses the given document into a tree that keeps namespace prefixes so it can be canonicalized.
+//Comments are dropped and documents with DTDs are rejected.
+func parseXML(r io.Reader) (*xmlElement, error) {
+	d := xml.NewDecoder(r)
+	d.Strict = true
+
+	var root, cur *xmlElement
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if cur == nil && root != nil {
+				return nil, errors.New("Multiple root elements")
+			}
+			e := &xmlElement{prefix: t.Name.Space, local: t.Name.Local, parent: cur}
+			for _, a := range t.Attr {
+				e.attrs = append(e.attrs, xmlAttr{prefix: a.Name.Space, local: a.Name.Local, value: a.Value})
+			}
+			if cur == nil {
+				root = e
+			} else {
+				cur.children = append(cur.children, e)
+			}
+			cur = e
+		case xml.EndElement:
+			if cur == nil || t.Name.Space != cur.prefix || t.Name.Local != cur.local {
+				return nil, fmt.Errorf("Unexpected end element: %s", t.Name.Local)
+			}
+			cur = cur.parent
+		case xml.CharData:
+			if cur != nil {
+				cur.children = append(cur.children, xmlText(t))
+			}
+		case xml.ProcInst:
+			if cur != nil {
+				cur.children = append(cur.children, xmlProcInst{target: t.Target, inst: string(t.Inst)})
+			}
+		case xml.Directive:
+			return nil, errors.New("DTDs are not allowed")
+		}
+	}
+
+	if root == nil {
+		return nil, errors.New("No root element")
+	}
+	if cur != nil {
+		return nil, errors.New("Unclosed element")
+	}
+
+	return root, nil
+}
+
+//lookupNS returns the namespace URI for the given prefix in scope at e.
+//The empty prefix is the default namespace.
+func (e *xmlElement) lookupNS(prefix string) (uri string, ok bool) {
+	if prefix == "xml" {
+		return xmlNamespace, true
+	}
+	for el := e; el != nil; el = el.parent {
+		for _, a := range el.attrs {
+			if (prefix == "" && a.prefix == "" && a.local == "xmlns") || (prefix != "" && a.prefix == "xmlns" && a.local == prefix) {
+				return a.value, true
+			}
+		}
+	}
+	return "", false
+}
+
+//namespace returns e's namespace URI
+func (e *xmlElement) namespace() string {
+	uri, _ := e.lookupNS(e.prefix)
+	return uri
+}
+
+//is returns whether or not e has the given namespace and local name
+func (e *xmlElement) is(namespace, local string) bool {
+	return e.local == local && e.namespace() == namespace
+}
+
+//childElements returns e's child elements with the given namespace and local name
+func (e *xmlElement) childElements(namespace, local string) []*xmlElement {
+	var list []*xmlElement
+	for _, c := range e.children {
+		if el, ok := c.(*xmlElement); ok && el.is(namespace, local) {
+			list = append(list, el)
+		}
+	}
+	return list
+}
+
+//child returns e's first child element with the given namespace and local name, or nil if there isn't one
+func (e *xmlElement) child(namespace, local string) *xmlElement {
+	if list := e.childElements(namespace, local); len(list) > 0 {
+		return list[0]
+	}
+	return nil
+}
+
+//attr returns the value of e's unprefixed attribute with the given name
+func (e *xmlElement) attr(local string) string {
+	for _, a := range e.attrs {
+		if a.prefix == "" && a.local == local {
+			return a.value
+		}
+	}
+	return ""
+}
+
+//text returns the character data of e and its descendants
+func (e *xmlElement) text() string {
+	var buf strings.Builder
+	for _, c := range e.children {
+		switch n := c.(type) {
+		case xmlText:
+			buf.WriteString(string(n))
+		case *xmlElement:
+			buf.WriteString(n.text())
+		}
+	}
+	return buf.String()
+}
+
+//walk calls f for e and each of its descendant elements in document order
+func (e *xmlElement) walk(f func(*xmlElement)) {
+	f(e)
+	for _, c := range e.children {
+		if el, ok := c.(*xmlElement); ok {
+			el.walk(f)
+		}
+	}
+}
+
+//c14nText escapes character data for canonical XML
+var c14nText = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+
+//c14nAttr escapes attribute values for canonical XML
+var c14nAttr = strings.NewReplacer("&", "&amp;", "<", "&lt;", "\"", "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+
+//excC14N returns the Exclusive XML Canonicalization (without comments) of the subtree at e,
+//omitting the element exclude if non-nil. Prefixes in inclusive are treated as in inclusive canonicalization,
+//with "#default" for the default namespace.
+func excC14N(e *xmlElement, exclude *xmlElement, inclusive []string) []byte {
+	incl := make(map[string]bool)
+	for _, p := range inclusive {
+		if p == "#default" {
+			p = ""
+		}
+		incl[p] = true
+	}
+
+	var buf bytes.Buffer
+	c14nElement(&buf, e, exclude, incl, make(map[string]string))
+	return buf.Bytes()
+}
+
+//c14nElement writes the canonicalization of e to buf.
+//rendered maps prefixes to the namespace URIs declared by output ancestors.
+func c14nElement(buf *bytes.Buffer, e *xmlElement, exclude *xmlElement, inclusive map[string]bool, rendered map[string]string) {
+	//namespaces visibly utilized by e, and inclusive ones in scope
+	used := map[string]bool{e.prefix: true}
+	for _, a := range e.attrs {
+		if a.prefix != "" && a.prefix != "xmlns" {
+			used[a.prefix] = true
+		}
+	}
+	for p := range inclusive {
+		if _, ok := e.lookupNS(p); ok {
+			used[p] = true
+		}
+	}
+
+	var prefixes []string
+	scope := make(map[string]string, len(rendered))
+	for p, uri := range rendered {
+		scope[p] = uri
+	}
+	for p := range used {
+		if p == "xml" {
+			continue
+		}
+		uri, _ := e.lookupNS(p)
+		if uri == rendered[p] {
+			continue
+		}
+		//unprefixed names without a default namespace only need xmlns="" to undo a rendered default
+		if p == "" && uri == "" && rendered[""] == "" {
+			continue
+		}
+		scope[p] = uri
+		prefixes = append(prefixes, p)
+	}
+	sort.Strings(prefixes)
+
+	type attr struct {
+		namespace string
+		xmlAttr
+	}
+	var attrs []attr
+	for _, a := range e.attrs {
+		if a.prefix == "xmlns" || (a.prefix == "" && a.local == "xmlns") {
+			continue
+		}
+		var uri string
+		if a.prefix != "" {
+			uri, _ = e.lookupNS(a.prefix)
+		}
+		attrs = append(attrs, attr{namespace: uri, xmlAttr: a})
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		if attrs[i].namespace != attrs[j].namespace {
+			return attrs[i].namespace < attrs[j].namespace
+		}
+		return attrs[i].local < attrs[j].local
+	})
+
+	name := e.local
+	if e.prefix != "" {
+		name = e.prefix + ":" + e.local
+	}
+
+	buf.WriteString("<" + name)
+	for _, p := range prefixes {
+		if p == "" {
+			buf.WriteString(` xmlns="`)
+		} else {
+			buf.WriteString(` xmlns:` + p + `="`)
+		}
+		buf.WriteString(c14nAttr.Replace(scope[p]) + `"`)
+	}
+	for _, a := range attrs {
+		buf.WriteString(" ")
+		if a.prefix != "" {
+			buf.WriteString(a.prefix + ":")
+		}
+		buf.WriteString(a.local + `="` + c14nAttr.Replace(a.value) + `"`)
+	}
+	buf.WriteString(">")
+
+	for _, c := range e.children {
+		switch n := c.(type) {
+		case *xmlElement:
+			if n != exclude {
+				c14nElement(buf, n, exclude, inclusive, scope)
+			}
+		case xmlText:
+			buf.WriteString(c14nText.Replace(string(n)))
+		case xmlProcInst:
+			buf.WriteString("<?" + n.target)
+			if n.inst != "" {
+				buf.WriteString(" " + n.inst)
+			}
+			buf.WriteString("?>")
+		}
+	}
+
+	buf.WriteString("</" + name + ">")
+}
+
+//decodeBase64 decodes base64 that may contain whitespace
+func decodeBase64(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+}
+
+//c14nMethod returns the inclusive prefixes of the given exclusive canonicalization method element.
+//If the element isn't an exclusive canonicalization method, an error is returned.
+func c14nMethod(e *xmlElement) ([]string, error) {
+	if alg := e.attr("Algorithm"); alg != dsigExcC14N {
+		return nil, fmt.Errorf("Unsupported canonicalization algorithm: %s", alg)
+	}
+	if incl := e.child(dsigExcC14N, "InclusiveNamespaces"); incl != nil {
+		return strings.Fields(incl.attr("PrefixList")), nil
+	}
+	return nil, nil
+}
+
+//verifyXMLSignature verifies that sig is a valid enveloped XML Signature over its parent element
+//by the key of one of certs. Only exclusive canonicalization and SHA-256 or SHA-512 are supported.
+func verifyXMLSignature(sig *xmlElement, certs []*x509.Certificate) error {
+	signed := sig.parent
+	if signed == nil || signed.attr("ID") == "" {
+		return fmt.Errorf("%v: signed element has no ID", ErrInvalidXMLSignature)
+	}
+
+	si := sig.child(dsigNamespace, "SignedInfo")
+	if si == nil {
+		return fmt.Errorf("%v: missing SignedInfo", ErrInvalidXMLSignature)
+	}
+
+	cm := si.child(dsigNamespace, "CanonicalizationMethod")
+	if cm == nil {
+		return fmt.Errorf("%v: missing CanonicalizationMethod", ErrInvalidXMLSignature)
+	}
+	siInclusive, err := c14nMethod(cm)
+	if err != nil {
+		return fmt.Errorf("%v: %v", ErrInvalidXMLSignature, err)
+	}
+
+	refs := si.childElements(dsigNamespace, "Reference")
+	if len(refs) != 1 {
+		return fmt.Errorf("%v: expected one Reference, found %d", ErrInvalidXMLSignature, len(refs))
+	}
+	ref := refs[0]
+	if ref.attr("URI") != "#"+signed.attr("ID") {
+		return fmt.Errorf("%v: Reference doesn't match signed element", ErrInvalidXMLSignature)
+	}
+
+	var inclusive []string
+	excC14NFound := false
+	if transforms := ref.child(dsigNamespace, "Transforms"); transforms != nil {
+		for _, t := range transforms.childElements(dsigNamespace, "Transform") {
+			switch t.attr("Algorithm") {
+			case dsigEnveloped:
+			case dsigExcC14N:
+				inclusive, _ = c14nMethod(t)
+				excC14NFound = true
+			default:
+				return fmt.Errorf("%v: unsupported transform: %s", ErrInvalidXMLSignature, t.attr("Algorithm"))
+			}
+		}
+	}
+	if !excC14NFound {
+		return fmt.Errorf("%v: exclusive canonicalization transform required", ErrInvalidXMLSignature)
+	}
+
+	var digestHash crypto.Hash
+	dm := ref.child(dsigNamespace, "DigestMethod")
+	if dm == nil {
+		return fmt.Errorf("%v: missing DigestMethod", ErrInvalidXMLSignature)
+	}
+	switch dm.attr("Algorithm") {
+	case dsigSHA256:
+		digestHash = crypto.SHA256
+	case dsigSHA512:
+		digestHash = crypto.SHA512
+	default:
+		return fmt.Errorf("%v: unsupported digest algorithm: %s", ErrInvalidXMLSignature, dm.attr("Algorithm"))
+	}
+
+	dv := ref.child(dsigNamespace, "DigestValue")
+	if dv == nil {
+		return fmt.Errorf("%v: missing DigestValue", ErrInvalidXMLSignature)
+	}
+	digest, err := decodeBase64(dv.text())
+	if err != nil {
+		return fmt.Errorf("%v: malformed DigestValue", ErrInvalidXMLSignature)
+	}
+
+	h := digestHash.New()
+	h.Write(excC14N(signed, sig, inclusive))
+	if !bytes.Equal(h.Sum(nil), digest) {
+		return fmt.Errorf("%v: digest mismatch", ErrInvalidXMLSignature)
+	}
+
+	var sigHash crypto.Hash
+	var ec bool
+	sm := si.child(dsigNamespace, "SignatureMethod")
+	if sm == nil {
+		return fmt.Errorf("%v: missing SignatureMethod", ErrInvalidXMLSignature)
+	}
+	switch sm.attr("Algorithm") {
+	case dsigRSASHA256:
+		sigHash = crypto.SHA256
+	case dsigRSASHA512:
+		sigHash = crypto.SHA512
+	case dsigECDSASHA256:
+		sigHash, ec = crypto.SHA256, true
+	case dsigECDSASHA512:
+		sigHash, ec = crypto.SHA512, true
+	default:
+		return fmt.Errorf("%v: unsupported signature algorithm: %s", ErrInvalidXMLSignature, sm.attr("Algorithm"))
+	}
+
+	sv := sig.child(dsigNamespace, "SignatureValue")
+	if sv == nil {
+		return fmt.Errorf("%v: missing SignatureValue", ErrInvalidXMLSignature)
+	}
+	sigValue, err := decodeBase64(sv.text())
+	if err != nil {
+		return fmt.Errorf("%v: malformed SignatureValue", ErrInvalidXMLSignature)
+	}
+
+	h = sigHash.New()
+	h.Write(excC14N(si, nil, siInclusive))
+	hashed := h.Sum(nil)
+
+	for _, cert := range certs {
+		switch pub := cert.PublicKey.(type) {
+		case *rsa.PublicKey:
+			if !ec && rsa.VerifyPKCS1v15(pub, sigHash, hashed, sigValue) == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			//XML Signature ECDSA values are the concatenated r and s
+			if ec && len(sigValue)%2 == 0 {
+				r := new(big.Int).SetBytes(sigValue[:len(sigValue)/2])
+				s := new(big.Int).SetBytes(sigValue[len(sigValue)/2:])
+				if ecdsa.Verify(pub, hashed, r, s) {
+					return nil
+				}
+			}
+		}
+	}
+
+	return fmt.Errorf("%v: signature not made by a trusted certificate", ErrInvalidXMLSignature)
+}
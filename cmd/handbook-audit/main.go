@@ -0,0 +1,52 @@
+//Command handbook-audit verifies the handbook audit log hasn't been altered.
+//It uses the same HANDBOOK_SQLDRIVER and HANDBOOK_SQLDSN environment variables as the handbook server,
+//and exits with a non-zero status if the audit log is not valid.
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/kelseyhightower/envconfig"
+	"github.com/korylprince/handbook/api"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+//Config represents options given in the environment
+type Config struct {
+	SQLDriver string //required
+	SQLDSN    string //required
+}
+
+func main() {
+	config := &Config{}
+	err := envconfig.Process("HANDBOOK", config)
+	if err != nil {
+		log.Fatalln("Error reading configuration from environment:", err)
+	}
+	if config.SQLDriver == "" || config.SQLDSN == "" {
+		log.Fatalln("HANDBOOK_SQLDRIVER and HANDBOOK_SQLDSN must be configured")
+	}
+
+	db, err := api.NewSQLDB(config.SQLDriver, config.SQLDSN)
+	if err != nil {
+		log.Fatalln("Error creating SQLDB:", err)
+	}
+
+	report, err := db.VerifyAudit()
+	if err != nil {
+		log.Fatalln("Error verifying audit log:", err)
+	}
+
+	e := json.NewEncoder(os.Stdout)
+	e.SetIndent("", "    ")
+	if err = e.Encode(report); err != nil {
+		log.Fatalln("Error encoding report:", err)
+	}
+
+	if !report.Valid {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,79 @@
+//Command handbook-ldap is a minimal LDAP directory server for development and testing.
+//It supports just enough of the protocol for handbook: simple binds by DN or userPrincipalName,
+//searches with and, or, not, equality, and present filters, StartTLS, and LDAPS.
+//Searches require an authenticated bind, and an empty password is an unauthenticated bind, as in Active Directory.
+//It generates a new self-signed certificate each time it starts, which can be written with -cert and used as a CA bundle.
+//It keeps everything in memory and should not be used in production.
+//
+//Without -data, it serves this directory under dc=example,dc=com, where every password is "password":
+//
+//	cn=Handbook Service,ou=Service Accounts  the service account
+//	jdoe (1001)                               member of Staff
+//	jsmith (1002)                             member of Staff and Handbook Admins
+//	plee (1003)                               member of Handbook Viewers
+//	kwu (1004)                                member of Staff and a Handbook Admins group in ou=Student Clubs
+//
+//The -data file is a JSON list of entries with a DN, Password, and Attributes map.
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/korylprince/handbook/api/ldaptest"
+)
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:3389", "address to listen on for plain text and StartTLS connections")
+	tlsListen := flag.String("tlslisten", "", "address to listen on for LDAPS connections; optional")
+	dataPath := flag.String("data", "", "path to a JSON list of entries; default: the built-in directory")
+	certPath := flag.String("cert", "", "path to write the TLS certificate to; optional")
+	hosts := flag.String("hosts", "localhost,127.0.0.1", "comma separated list of host names and IPs for the TLS certificate")
+	flag.Parse()
+
+	entries := ldaptest.SampleEntries()
+	if *dataPath != "" {
+		buf, err := ioutil.ReadFile(*dataPath)
+		if err != nil {
+			log.Fatalln("Error reading data:", err)
+		}
+		entries = nil
+		if err = json.Unmarshal(buf, &entries); err != nil {
+			log.Fatalln("Error decoding data:", err)
+		}
+	}
+
+	tlsConfig, certPEM, err := ldaptest.NewTLSConfig(strings.Split(*hosts, ","))
+	if err != nil {
+		log.Fatalln("Error creating TLS certificate:", err)
+	}
+	if *certPath != "" {
+		if err = ioutil.WriteFile(*certPath, certPEM, 0644); err != nil {
+			log.Fatalln("Error writing certificate:", err)
+		}
+	}
+
+	s := ldaptest.NewServer(entries, tlsConfig)
+	s.Debug = true
+
+	if *tlsListen != "" {
+		l, err := tls.Listen("tcp", *tlsListen, tlsConfig)
+		if err != nil {
+			log.Fatalln("Error listening:", err)
+		}
+		log.Println("Listening for LDAPS on:", *tlsListen)
+		go func() { log.Fatalln(s.Serve(l)) }()
+	}
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatalln("Error listening:", err)
+	}
+	log.Println("Listening on:", *listen)
+	log.Fatalln(s.Serve(l))
+}
@@ -0,0 +1,371 @@
+//Command handbook-oidc is a minimal OpenID Connect identity provider for development and testing.
+//It supports the authorization code flow with PKCE. Its authorization page is a form
+//to choose the claims of the logged in user, so any user or admin can be tested without a directory.
+//It generates a new signing key each time it starts and should not be used in production.
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+//codeDuration is how long an authorization code is valid
+const codeDuration = time.Minute
+
+//grant represents an issued authorization code
+type grant struct {
+	clientID    string
+	redirectURI string
+	challenge   string
+	nonce       string
+	claims      map[string]interface{}
+	authTime    time.Time //when the user submitted the login form
+	expires     time.Time
+}
+
+//OP represents an OpenID Connect identity provider
+type OP struct {
+	issuer   string
+	clientID string
+	secret   string
+	key      *ecdsa.PrivateKey
+	kid      string
+
+	mu    *sync.Mutex
+	codes map[string]*grant
+}
+
+//NewOP returns a new OP with a new signing key
+func NewOP(issuer, clientID, secret string) (*OP, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &OP{
+		issuer:   issuer,
+		clientID: clientID,
+		secret:   secret,
+		key:      key,
+		kid:      randHex(8),
+		mu:       new(sync.Mutex),
+		codes:    make(map[string]*grant),
+	}, nil
+}
+
+//randHex returns n random bytes hex encoded
+func randHex(n int) string {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+//b64 returns buf base64url encoded without padding
+func b64(buf []byte) string {
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+//writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("Error encoding json:", err)
+	}
+}
+
+//tokenError writes an OAuth 2.0 token error response
+func tokenError(w http.ResponseWriter, code int, e, description string) {
+	writeJSON(w, code, map[string]string{"error": e, "error_description": description})
+}
+
+//sign returns a compact JWS of claims signed with ES256
+func (op *OP) sign(claims map[string]interface{}) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "ES256", "typ": "JWT", "kid": op.kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, op.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	sig := make([]byte, 64)
+	rb, sb := r.Bytes(), s.Bytes()
+	copy(sig[32-len(rb):32], rb)
+	copy(sig[64-len(sb):], sb)
+
+	return signed + "." + b64(sig), nil
+}
+
+func (op *OP) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                op.issuer,
+		"authorization_endpoint":                op.issuer + "/authorize",
+		"token_endpoint":                        op.issuer + "/token",
+		"jwks_uri":                              op.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"ES256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (op *OP) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := op.key.PublicKey
+	x, y := make([]byte, 32), make([]byte, 32)
+	xb, yb := pub.X.Bytes(), pub.Y.Bytes()
+	copy(x[32-len(xb):], xb)
+	copy(y[32-len(yb):], yb)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "EC", "crv": "P-256", "use": "sig", "alg": "ES256", "kid": op.kid, "x": b64(x), "y": b64(y),
+		}},
+	})
+}
+
+var authorizeTemplate = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html>
+<head><title>handbook-oidc</title></head>
+<body>
+<h1>Development Login</h1>
+<p>Choose the claims to log in to {{.ClientID}} with.</p>
+<form method="POST">
+{{range $k, $v := .Params}}<input type="hidden" name="{{$k}}" value="{{$v}}">
+{{end}}<p><label>preferred_username <input name="preferred_username" value="jdoe"></label></p>
+<p><label>employee_id <input name="employee_id" value="12345"></label></p>
+<p><label>given_name <input name="given_name" value="Jane"></label></p>
+<p><label>family_name <input name="family_name" value="Doe"></label></p>
+<p><label>groups (comma separated) <input name="groups" value=""></label></p>
+<p><input type="submit" value="Log In"> <input type="submit" name="deny" value="Deny"></p>
+</form>
+</body>
+</html>
+`))
+
+func (op *OP) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	//errors before the redirect URI is validated can't be redirected
+	clientID, redirectURI := r.Form.Get("client_id"), r.Form.Get("redirect_uri")
+	if clientID != op.clientID {
+		http.Error(w, "Unknown client_id", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(redirectURI)
+	if err != nil || !redirect.IsAbs() {
+		http.Error(w, "Invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	fail := func(e string) {
+		q := redirect.Query()
+		q.Set("error", e)
+		q.Set("state", r.Form.Get("state"))
+		redirect.RawQuery = q.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	}
+
+	if r.Form.Get("response_type") != "code" {
+		fail("unsupported_response_type")
+		return
+	}
+	if r.Form.Get("code_challenge") == "" || r.Form.Get("code_challenge_method") != "S256" {
+		fail("invalid_request")
+		return
+	}
+	if !strings.Contains(" "+r.Form.Get("scope")+" ", " openid ") {
+		fail("invalid_scope")
+		return
+	}
+
+	if r.Method == "GET" {
+		params := make(map[string]string)
+		for _, k := range []string{"client_id", "redirect_uri", "response_type", "scope", "state", "nonce", "code_challenge", "code_challenge_method"} {
+			params[k] = r.Form.Get(k)
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := authorizeTemplate.Execute(w, map[string]interface{}{"ClientID": clientID, "Params": params}); err != nil {
+			log.Println("Error rendering template:", err)
+		}
+		return
+	}
+
+	if r.Form.Get("deny") != "" {
+		fail("access_denied")
+		return
+	}
+
+	username := r.PostForm.Get("preferred_username")
+	claims := map[string]interface{}{
+		"sub":                username,
+		"preferred_username": username,
+		"employee_id":        r.PostForm.Get("employee_id"),
+		"given_name":         r.PostForm.Get("given_name"),
+		"family_name":        r.PostForm.Get("family_name"),
+	}
+	var groups []string
+	for _, g := range strings.Split(r.PostForm.Get("groups"), ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			groups = append(groups, g)
+		}
+	}
+	claims["groups"] = groups
+
+	code := randHex(16)
+	op.mu.Lock()
+	now := time.Now()
+	for c, g := range op.codes {
+		if g.expires.Before(now) {
+			delete(op.codes, c)
+		}
+	}
+	op.codes[code] = &grant{
+		clientID:    clientID,
+		redirectURI: redirectURI,
+		challenge:   r.Form.Get("code_challenge"),
+		nonce:       r.Form.Get("nonce"),
+		claims:      claims,
+		authTime:    now,
+		expires:     now.Add(codeDuration),
+	}
+	op.mu.Unlock()
+
+	log.Printf("Issued code for %s (groups: %v)\n", username, groups)
+
+	q := redirect.Query()
+	q.Set("code", code)
+	q.Set("state", r.Form.Get("state"))
+	redirect.RawQuery = q.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (op *OP) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		tokenError(w, http.StatusMethodNotAllowed, "invalid_request", "POST required")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, http.StatusBadRequest, "invalid_request", "invalid form")
+		return
+	}
+
+	clientID := r.PostForm.Get("client_id")
+	if id, secret, ok := r.BasicAuth(); ok {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+		if op.secret == "" || secret != op.secret {
+			tokenError(w, http.StatusUnauthorized, "invalid_client", "bad client credentials")
+			return
+		}
+		clientID = id
+	} else if op.secret != "" {
+		tokenError(w, http.StatusUnauthorized, "invalid_client", "client authentication required")
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	op.mu.Lock()
+	g, ok := op.codes[code]
+	delete(op.codes, code)
+	op.mu.Unlock()
+
+	if !ok || g.expires.Before(time.Now()) || g.clientID != clientID || g.redirectURI != r.PostForm.Get("redirect_uri") {
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "unknown, expired, or mismatched code")
+		return
+	}
+
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if b64(verifier[:]) != g.challenge {
+		tokenError(w, http.StatusBadRequest, "invalid_grant", "code_verifier doesn't match code_challenge")
+		return
+	}
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":       op.issuer,
+		"aud":       clientID,
+		"iat":       now.Unix(),
+		"exp":       now.Add(5 * time.Minute).Unix(),
+		"auth_time": g.authTime.Unix(),
+	}
+	if g.nonce != "" {
+		claims["nonce"] = g.nonce
+	}
+	for k, v := range g.claims {
+		claims[k] = v
+	}
+
+	idToken, err := op.sign(claims)
+	if err != nil {
+		log.Println("Error signing ID Token:", err)
+		tokenError(w, http.StatusInternalServerError, "server_error", "signing failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randHex(16),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:9000", "address to listen on")
+	issuer := flag.String("issuer", "", "issuer URL; default: http://<listen>")
+	clientID := flag.String("client", "handbook", "client ID to accept")
+	secret := flag.String("secret", "", "client secret to require; optional")
+	flag.Parse()
+
+	if *issuer == "" {
+		*issuer = "http://" + *listen
+	}
+	*issuer = strings.TrimSuffix(*issuer, "/")
+
+	op, err := NewOP(*issuer, *clientID, *secret)
+	if err != nil {
+		log.Fatalln("Error creating OP:", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", op.discovery)
+	mux.HandleFunc("/jwks", op.jwks)
+	mux.HandleFunc("/authorize", op.authorize)
+	mux.HandleFunc("/token", op.token)
+
+	log.Println("Issuer:", *issuer)
+	log.Println("Listening on:", *listen)
+	log.Fatalln(http.ListenAndServe(*listen, mux))
+}
@@ -0,0 +1,25 @@
+//Command handbook-redis is an in-memory, Redis-compatible server for development and testing of the Redis session store.
+//It supports the commands handbook uses with key expiration, loses all data when it stops, and should not be used in production.
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+
+	"github.com/korylprince/handbook/api/redistest"
+)
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:6379", "address to listen on")
+	password := flag.String("password", "", "password clients must AUTH with; optional")
+	flag.Parse()
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatalln("Error listening:", err)
+	}
+
+	log.Printf("Listening on %s\n", *listen)
+	log.Println(redistest.NewServer(*password).Serve(l))
+}
@@ -0,0 +1,187 @@
+//Command handbook-saml is a minimal SAML 2.0 identity provider for development and testing.
+//It accepts AuthnRequests with the HTTP-Redirect binding and posts signed assertions with the HTTP-POST binding.
+//Its login page is a form to choose the attributes of the logged in user, so any user or admin can be tested without a directory.
+//It generates a new signing key each time it starts and should not be used in production.
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/korylprince/handbook/api/samltest"
+)
+
+//server serves an IdP's endpoints
+type server struct {
+	*samltest.IdP
+}
+
+//authnRequest represents the parts of an AuthnRequest the IdP uses
+type authnRequest struct {
+	XMLName xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol AuthnRequest"`
+	ID      string   `xml:",attr"`
+	ACSURL  string   `xml:"AssertionConsumerServiceURL,attr"`
+	Issuer  string   `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+}
+
+var loginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><title>handbook-saml</title></head>
+<body>
+<h1>Development Login</h1>
+<p>Choose the attributes to log in to {{.Issuer}} with.</p>
+<form method="POST">
+<input type="hidden" name="ID" value="{{.ID}}">
+<input type="hidden" name="ACSURL" value="{{.ACSURL}}">
+<input type="hidden" name="Issuer" value="{{.Issuer}}">
+<input type="hidden" name="RelayState" value="{{.RelayState}}">
+<p><label>uid <input name="uid" value="jdoe"></label></p>
+<p><label>employeeID <input name="employeeID" value="12345"></label></p>
+<p><label>givenName <input name="givenName" value="Jane"></label></p>
+<p><label>sn <input name="sn" value="Doe"></label></p>
+<p><label>groups (comma separated) <input name="groups" value=""></label></p>
+<p><input type="submit" value="Log In"> <input type="submit" name="deny" value="Deny"></p>
+</form>
+</body>
+</html>
+`))
+
+var postTemplate = template.Must(template.New("post").Parse(`<!DOCTYPE html>
+<html>
+<head><title>handbook-saml</title></head>
+<body onload="document.forms[0].submit()">
+<form method="POST" action="{{.ACSURL}}">
+<input type="hidden" name="SAMLResponse" value="{{.SAMLResponse}}">
+<input type="hidden" name="RelayState" value="{{.RelayState}}">
+<noscript><input type="submit" value="Continue"></noscript>
+</form>
+</body>
+</html>
+`))
+
+func (idp *server) sso(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == "GET" {
+		buf, err := base64.StdEncoding.DecodeString(r.Form.Get("SAMLRequest"))
+		if err != nil {
+			http.Error(w, "Invalid SAMLRequest encoding", http.StatusBadRequest)
+			return
+		}
+		inflated, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(buf)))
+		if err != nil {
+			http.Error(w, "Invalid SAMLRequest compression", http.StatusBadRequest)
+			return
+		}
+		var req authnRequest
+		if err = xml.Unmarshal(inflated, &req); err != nil {
+			http.Error(w, "Invalid AuthnRequest", http.StatusBadRequest)
+			return
+		}
+		if req.ID == "" || req.ACSURL == "" || req.Issuer == "" {
+			http.Error(w, "AuthnRequest must have ID, AssertionConsumerServiceURL, and Issuer", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err = loginTemplate.Execute(w, map[string]string{
+			"ID": req.ID, "ACSURL": req.ACSURL, "Issuer": req.Issuer, "RelayState": r.Form.Get("RelayState"),
+		})
+		if err != nil {
+			log.Println("Error rendering template:", err)
+		}
+		return
+	}
+
+	l := &samltest.Login{RequestID: r.PostForm.Get("ID"), ACSURL: r.PostForm.Get("ACSURL"), Audience: r.PostForm.Get("Issuer")}
+	if r.PostForm.Get("deny") == "" {
+		l.Attributes = make(map[string][]string)
+		for _, name := range []string{"uid", "employeeID", "givenName", "sn"} {
+			l.Attributes[name] = []string{r.PostForm.Get(name)}
+		}
+		for _, g := range strings.Split(r.PostForm.Get("groups"), ",") {
+			if g = strings.TrimSpace(g); g != "" {
+				l.Attributes["groups"] = append(l.Attributes["groups"], g)
+			}
+		}
+		log.Printf("Issued assertion for %s (groups: %v)\n", r.PostForm.Get("uid"), l.Attributes["groups"])
+	}
+
+	resp, err := idp.Response(l)
+	if err != nil {
+		log.Println("Error creating Response:", err)
+		http.Error(w, "Error creating Response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = postTemplate.Execute(w, map[string]string{
+		"ACSURL":       l.ACSURL,
+		"SAMLResponse": base64.StdEncoding.EncodeToString([]byte(resp)),
+		"RelayState":   r.PostForm.Get("RelayState"),
+	})
+	if err != nil {
+		log.Println("Error rendering template:", err)
+	}
+}
+
+func (idp *server) metadata(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<md:EntityDescriptor xmlns:md="urn:oasis:names:tc:SAML:2.0:metadata" entityID="%s">
+  <md:IDPSSODescriptor protocolSupportEnumeration="%s">
+    <md:KeyDescriptor use="signing"><ds:KeyInfo xmlns:ds="%s"><ds:X509Data><ds:X509Certificate>%s</ds:X509Certificate></ds:X509Data></ds:KeyInfo></md:KeyDescriptor>
+    <md:SingleSignOnService Binding="urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect" Location="%s/sso"/>
+  </md:IDPSSODescriptor>
+</md:EntityDescriptor>
+`, samltest.EscapeAttr(idp.EntityID), samltest.NSProtocol, samltest.NSDSig, base64.StdEncoding.EncodeToString(idp.Certificate().Raw), samltest.EscapeAttr(idp.EntityID))
+}
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:9001", "address to listen on")
+	entityID := flag.String("entity", "", "entity ID and base URL; default: http://<listen>")
+	certPath := flag.String("cert", "", "path to write the signing certificate to; optional, it's also served at /cert.pem")
+	flag.Parse()
+
+	if *entityID == "" {
+		*entityID = "http://" + *listen
+	}
+	*entityID = strings.TrimSuffix(*entityID, "/")
+
+	p, err := samltest.NewIdP(*entityID)
+	if err != nil {
+		log.Fatalln("Error creating IdP:", err)
+	}
+	idp := &server{IdP: p}
+
+	if *certPath != "" {
+		if err = ioutil.WriteFile(*certPath, idp.CertificatePEM(), 0644); err != nil {
+			log.Fatalln("Error writing certificate:", err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sso", idp.sso)
+	mux.HandleFunc("/metadata", idp.metadata)
+	mux.HandleFunc("/cert.pem", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.Write(idp.CertificatePEM())
+	})
+
+	log.Println("Entity ID:", *entityID)
+	log.Println("SSO URL:", *entityID+"/sso")
+	log.Println("Listening on:", *listen)
+	log.Fatalln(http.ListenAndServe(*listen, mux))
+}
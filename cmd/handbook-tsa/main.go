@@ -0,0 +1,344 @@
+//Command handbook-tsa is a minimal RFC 3161 timestamp authority for development and testing.
+//It generates a new self-signed certificate each time it starts and writes it to the -cert file,
+//which can be used as HANDBOOK_TSACERTS with HANDBOOK_TSAURL set to the listen address.
+//It should not be used in production: it keeps no records and its key is never stored.
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"flag"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"sort"
+	"time"
+)
+
+var (
+	oidSHA256          = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidSignedData      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidTSTInfo         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidContentType     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidDigest          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningCertV2   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 47}
+	oidExtKeyUsage     = asn1.ObjectIdentifier{2, 5, 29, 37}
+	oidTimeStamping    = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 8}
+
+	//oidPolicy is an arbitrary policy for development timestamps
+	oidPolicy = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 32473, 1}
+)
+
+//statuses and failure info from RFC 3161
+const (
+	statusGranted   = 0
+	statusRejection = 2
+
+	failBadAlg     = 0
+	failBadRequest = 2
+)
+
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type request struct {
+	Version        int
+	MessageImprint messageImprint
+	ReqPolicy      asn1.ObjectIdentifier `asn1:"optional"`
+	Nonce          *big.Int              `asn1:"optional"`
+	CertReq        bool                  `asn1:"optional"`
+	Extensions     asn1.RawValue         `asn1:"optional,tag:0"`
+}
+
+type status struct {
+	Status   int
+	FailInfo asn1.BitString `asn1:"optional"`
+}
+
+type response struct {
+	Status status
+	Token  asn1.RawValue `asn1:"optional"`
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time `asn1:"generalized"`
+	Nonce          *big.Int  `asn1:"optional"`
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+type essCertIDv2 struct {
+	CertHash []byte
+}
+
+type signingCertificateV2 struct {
+	Certs []essCertIDv2
+}
+
+type issuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type signerInfo struct {
+	Version            int
+	SID                issuerAndSerial
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+}
+
+type encapContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     []byte `asn1:"explicit,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapContentInfo
+	Certificates     asn1.RawValue
+	SignerInfos      []signerInfo `asn1:"set"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+//TSA signs timestamps with a key and certificate
+type TSA struct {
+	key  *ecdsa.PrivateKey
+	cert *x509.Certificate
+}
+
+//NewTSA returns a new TSA with a new key and self-signed certificate
+func NewTSA() (*TSA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "handbook development TSA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	//RFC 3161 requires the timeStamping extended key usage to be critical
+	eku, err := asn1.Marshal([]asn1.ObjectIdentifier{oidTimeStamping})
+	if err != nil {
+		return nil, err
+	}
+	template.ExtraExtensions = []pkix.Extension{{Id: oidExtKeyUsage, Critical: true, Value: eku}}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TSA{key: key, cert: cert}, nil
+}
+
+//attributes returns the DER encoded contents of a SET OF the given attributes
+func attributes(attrs ...attribute) ([]byte, error) {
+	encoded := make([][]byte, len(attrs))
+	for i, a := range attrs {
+		buf, err := asn1.Marshal(a)
+		if err != nil {
+			return nil, err
+		}
+		encoded[i] = buf
+	}
+
+	//DER sorts SET OF members by their encoding
+	sort.Slice(encoded, func(i, j int) bool { return bytes.Compare(encoded[i], encoded[j]) < 0 })
+
+	return bytes.Join(encoded, nil), nil
+}
+
+//Sign returns a DER encoded timestamp token for the given request
+func (t *TSA) Sign(req *request) ([]byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := asn1.Marshal(tstInfo{
+		Version:        1,
+		Policy:         oidPolicy,
+		MessageImprint: req.MessageImprint,
+		SerialNumber:   serial,
+		GenTime:        time.Now().UTC().Truncate(time.Second),
+		Nonce:          req.Nonce,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256(info)
+	contentType, err := asn1.Marshal(oidTSTInfo)
+	if err != nil {
+		return nil, err
+	}
+	messageDigest, err := asn1.Marshal(digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	certHash := sha256.Sum256(t.cert.Raw)
+	signingCert, err := asn1.Marshal(signingCertificateV2{Certs: []essCertIDv2{{CertHash: certHash[:]}}})
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, err := attributes(
+		attribute{Type: oidContentType, Values: []asn1.RawValue{{FullBytes: contentType}}},
+		attribute{Type: oidDigest, Values: []asn1.RawValue{{FullBytes: messageDigest}}},
+		attribute{Type: oidSigningCertV2, Values: []asn1.RawValue{{FullBytes: signingCert}}},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	//signed attributes are signed as a SET instead of their implicitly tagged encoding
+	set, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: attrs})
+	if err != nil {
+		return nil, err
+	}
+	setDigest := sha256.Sum256(set)
+	sig, err := t.key.Sign(rand.Reader, setDigest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	sd, err := asn1.Marshal(signedData{
+		Version:          3,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: oidSHA256}},
+		EncapContentInfo: encapContentInfo{ContentType: oidTSTInfo, Content: info},
+		//the certificate is always included so tokens can be verified with only the root
+		Certificates: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: t.cert.Raw},
+		SignerInfos: []signerInfo{{
+			Version:            1,
+			SID:                issuerAndSerial{Issuer: asn1.RawValue{FullBytes: t.cert.RawIssuer}, Serial: t.cert.SerialNumber},
+			DigestAlgorithm:    pkix.AlgorithmIdentifier{Algorithm: oidSHA256},
+			SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrs},
+			SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256},
+			Signature:          sig,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd},
+	})
+}
+
+//reject writes a rejection response with the given failure info to w
+func reject(w http.ResponseWriter, failInfo int) {
+	fail := asn1.BitString{Bytes: []byte{0x80 >> uint(failInfo)}, BitLength: failInfo + 1}
+	buf, err := asn1.Marshal(response{Status: status{Status: statusRejection, FailInfo: fail}})
+	if err != nil {
+		log.Println("Error encoding response:", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/timestamp-reply")
+	w.Write(buf)
+}
+
+//ServeHTTP handles RFC 3161 timestamp requests
+func (t *TSA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<16))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	req := &request{}
+	if _, err = asn1.Unmarshal(body, req); err != nil {
+		reject(w, failBadRequest)
+		return
+	}
+	if !req.MessageImprint.HashAlgorithm.Algorithm.Equal(oidSHA256) || len(req.MessageImprint.HashedMessage) != sha256.Size {
+		reject(w, failBadAlg)
+		return
+	}
+
+	token, err := t.Sign(req)
+	if err != nil {
+		log.Println("Error signing timestamp:", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	buf, err := asn1.Marshal(response{Status: status{Status: statusGranted}, Token: asn1.RawValue{FullBytes: token}})
+	if err != nil {
+		log.Println("Error encoding response:", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/timestamp-reply")
+	w.Write(buf)
+}
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:3161", "address to listen on")
+	certPath := flag.String("cert", "tsa.pem", "file to write the TSA's certificate to")
+	flag.Parse()
+
+	tsa, err := NewTSA()
+	if err != nil {
+		log.Fatalln("Error creating TSA:", err)
+	}
+
+	err = ioutil.WriteFile(*certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tsa.cert.Raw}), 0644)
+	if err != nil {
+		log.Fatalln("Error writing certificate:", err)
+	}
+
+	log.Printf("Listening on %s; certificate written to %s\n", *listen, *certPath)
+	log.Println(http.ListenAndServe(*listen, tsa))
+}
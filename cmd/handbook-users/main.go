@@ -0,0 +1,193 @@
+//Command handbook-users manages the local users file used when handbook runs without LDAP.
+//It uses the same HANDBOOK_LOCALUSERSFILE environment variable as the handbook server.
+//Passwords are read from the first line of standard input.
+//
+//Usage:
+//
+//	handbook-users add [-employeeid id] [-first name] [-last name] [-admin] [-role role] [-campuses campus,campus] <username>
+//	handbook-users remove <username>
+//	handbook-users list
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/korylprince/handbook/api"
+)
+
+//Config represents options given in the environment
+type Config struct {
+	LocalUsersFile string //required
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage:")
+	fmt.Fprintln(os.Stderr, "  handbook-users add [-employeeid id] [-first name] [-last name] [-admin] [-role role] [-campuses campus,campus] <username>")
+	fmt.Fprintln(os.Stderr, "  handbook-users remove <username>")
+	fmt.Fprintln(os.Stderr, "  handbook-users list")
+	os.Exit(2)
+}
+
+//readUsers returns the users in the file at path, or no users if it doesn't exist
+func readUsers(path string) []*api.LocalUser {
+	users, err := api.ReadLocalUsers(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		log.Fatalln("Error reading users file:", err)
+	}
+	return users
+}
+
+//readPassword returns the first line of standard input
+func readPassword() string {
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprint(os.Stderr, "Password: ")
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		log.Fatalln("Error reading password:", err)
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
+func add(path string, args []string) {
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	employeeID := fs.String("employeeid", "", "employee ID")
+	first := fs.String("first", "", "first name")
+	last := fs.String("last", "", "last name")
+	admin := fs.Bool("admin", false, "allow admin logins")
+	role := fs.String("role", "", "admin role: viewer, manager, or superadmin; default: superadmin")
+	campuses := fs.String("campuses", "", "comma separated list of campuses a campus-scoped admin can see")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+
+	u := &api.LocalUser{
+		Username:   fs.Arg(0),
+		EmployeeID: *employeeID,
+		FirstName:  *first,
+		LastName:   *last,
+		Admin:      *admin,
+		Role:       api.Role(*role),
+	}
+	for _, c := range strings.Split(*campuses, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			u.Campuses = append(u.Campuses, c)
+		}
+	}
+	if (u.Role != "" || u.Campuses != nil) && !u.Admin {
+		log.Fatalln("-role and -campuses require -admin")
+	}
+	if u.Role != "" && !u.Role.Allows(api.RoleViewer) {
+		log.Fatalln("Invalid role:", u.Role)
+	}
+
+	password := readPassword()
+	if password == "" {
+		log.Fatalln("Password can't be empty")
+	}
+
+	var err error
+	u.Hash, err = api.HashPassword(password)
+	if err != nil {
+		log.Fatalln("Error hashing password:", err)
+	}
+
+	//replace the user if it already exists
+	users := readUsers(path)
+	replaced := false
+	for i, existing := range users {
+		if strings.EqualFold(existing.Username, u.Username) {
+			users[i] = u
+			replaced = true
+		}
+	}
+	if !replaced {
+		users = append(users, u)
+	}
+
+	if err = api.WriteLocalUsers(path, users); err != nil {
+		log.Fatalln("Error writing users file:", err)
+	}
+
+	if replaced {
+		log.Println("Updated", u.Username)
+	} else {
+		log.Println("Added", u.Username)
+	}
+}
+
+func remove(path string, args []string) {
+	if len(args) != 1 {
+		usage()
+	}
+
+	users := readUsers(path)
+	var kept []*api.LocalUser
+	for _, u := range users {
+		if !strings.EqualFold(u.Username, args[0]) {
+			kept = append(kept, u)
+		}
+	}
+	if len(kept) == len(users) {
+		log.Fatalln("No such user:", args[0])
+	}
+
+	if err := api.WriteLocalUsers(path, kept); err != nil {
+		log.Fatalln("Error writing users file:", err)
+	}
+
+	log.Println("Removed", args[0])
+}
+
+func list(path string) {
+	for _, u := range readUsers(path) {
+		admin := ""
+		if u.Admin {
+			role := u.Role
+			if role == "" {
+				role = api.RoleSuperadmin
+			}
+			admin = " admin:" + string(role)
+			if len(u.Campuses) > 0 {
+				admin += " campuses:" + strings.Join(u.Campuses, ",")
+			}
+		}
+		fmt.Printf("%s\t%s\t%s %s%s\n", u.Username, u.EmployeeID, u.FirstName, u.LastName, admin)
+	}
+}
+
+func main() {
+	config := &Config{}
+	err := envconfig.Process("HANDBOOK", config)
+	if err != nil {
+		log.Fatalln("Error reading configuration from environment:", err)
+	}
+	if config.LocalUsersFile == "" {
+		log.Fatalln("HANDBOOK_LOCALUSERSFILE must be configured")
+	}
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	switch os.Args[1] {
+	case "add":
+		add(config.LocalUsersFile, os.Args[2:])
+	case "remove":
+		remove(config.LocalUsersFile, os.Args[2:])
+	case "list":
+		list(config.LocalUsersFile)
+	default:
+		usage()
+	}
+}
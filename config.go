@@ -1,25 +1,114 @@
 package main
 
 import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
 	"log"
+	"net"
 	"strings"
 
 	"github.com/kelseyhightower/envconfig"
-	"github.com/korylprince/go-ad-auth"
+	"github.com/korylprince/handbook/api"
 )
 
 //Config represents options given in the environment
 type Config struct {
-	LDAPServer     string //required
-	LDAPPort       int    //default: 389
-	LDAPBaseDN     string //required
-	LDAPGroup      string //optional
-	LDAPAdminGroup string //optional
-	LDAPSecurity   string //default: none
-	ldapSecurity   auth.SecurityType
+	LocalUsersFile string //path to a users file managed with handbook-users; if set, it's used instead of LDAP
 
-	SessionDuration      int //in minutes; default: 5
-	AdminSessionDuration int //in minutes; default: 60
+	LDAPServer       string //comma separated list of host or host:port in order of preference; required unless LocalUsersFile is set
+	LDAPPort         int    //default: 389
+	LDAPBaseDN       string //required unless LocalUsersFile is set
+	LDAPGroup        string //distinguished name; optional
+	LDAPAdminGroup   string //distinguished name of superadmins; optional
+	LDAPManagerGroup string //distinguished name; optional; requires LDAPAdminGroup
+	LDAPViewerGroup  string //distinguished name; optional; requires LDAPAdminGroup
+	LDAPSecurity     string //none, tls, or starttls; default: none
+	LDAPCACerts      string //path to a PEM file of certificate authorities trusted for tls or starttls; default: system roots
+	LDAPBindDN       string //service account DN users are looked up with; optional
+	LDAPBindPassword string //required if LDAPBindDN is set
+	LDAPPoolSize     int    //maximum idle connections; default: 4
+	LDAPTimeout      int    //in seconds; default: 10
+	ldapServers      []string
+	ldapSecurity     api.LDAPSecurity
+	ldapRootCAs      *x509.CertPool
+
+	LDAPEmployeeIDAttribute string //default: employeeID
+	LDAPFirstNameAttribute  string //default: givenName
+	LDAPLastNameAttribute   string //default: sn
+	LDAPUPNSuffix           string //suffix usernames are bound with, e.g. district.org; default: the domain of LDAPBaseDN
+	LDAPDomains             string //comma separated list of other domains users may type after their username
+	ldapDomains             []string
+
+	LDAPCampusAdminGroups string //semicolon separated list of group:campus,campus mappings for campus-scoped admins, where group is a distinguished name; requires LDAPAdminGroup
+	ldapCampusAdminGroups map[string][]string
+	ldapRoleGroups        map[string]api.Role
+
+	OIDCIssuer          string //issuer URL of an OpenID Connect identity provider; optional, OIDC logins are disabled if empty
+	OIDCClientID        string //required if OIDCIssuer is set
+	OIDCClientSecret    string //optional for public clients
+	OIDCRedirectURL     string //full URL of /api/1.0/oidc/callback; required if OIDCIssuer is set
+	OIDCEmployeeIDClaim string //default: employee_id
+	OIDCUsernameClaim   string //default: preferred_username
+	OIDCGroupsClaim     string //default: groups; admin groups are matched against this claim
+	OIDCGroup           string //optional
+	OIDCTimeout         int    //in seconds; default: 10
+
+	SAMLEntityID            string //service provider entity ID; optional, SAML logins are disabled if empty
+	SAMLACSURL              string //full URL of /api/1.0/saml/acs; required if SAMLEntityID is set
+	SAMLIdPEntityID         string //required if SAMLEntityID is set
+	SAMLIdPSSOURL           string //identity provider single sign-on URL for the HTTP-Redirect binding; required if SAMLEntityID is set
+	SAMLIdPCerts            string //path to a PEM file of identity provider signing certificates; required if SAMLEntityID is set
+	SAMLEmployeeIDAttribute string //default: employeeID
+	SAMLUsernameAttribute   string //default: the assertion's NameID
+	SAMLFirstNameAttribute  string //default: givenName
+	SAMLLastNameAttribute   string //default: sn
+	SAMLGroupsAttribute     string //default: groups; admin groups are matched against this attribute
+	SAMLGroup               string //optional
+	samlIdPCerts            []*x509.Certificate
+
+	MagicLinkURL      string //full URL of the client app to send login links to; optional, magic link logins are disabled if empty
+	MagicLinkDuration int    //in minutes; default: 15
+	SMTPAddr          string //host:port of the SMTP server to send login links through; required if MagicLinkURL is set
+	SMTPFrom          string //required if MagicLinkURL is set
+	SMTPUsername      string //optional
+	SMTPPassword      string //optional
+
+	TOTPKey      string //base64 encoded 32 byte AES key admin TOTP secrets are encrypted with, e.g. from `head -c 32 /dev/urandom | base64`; optional, TOTP is disabled if empty
+	TOTPIssuer   string //name authenticator apps show; default: Employee Handbook
+	TOTPRequired bool   //if true, admins must enroll in TOTP before they can log in
+	totpKey      []byte
+
+	LoginAttempts   int //failed password logins per username before backoff; default: 5; -1 disables login throttling
+	LoginIPAttempts int //failed password logins per IP address before backoff; default: 50
+	LoginMaxBlock   int //longest block in minutes; default: 15
+
+	TrustedProxies string //comma separated list of IP addresses or CIDR networks of reverse proxies whose X-Forwarded-For header is used; default: none
+	trustedProxies []*net.IPNet
+
+	StepUp bool //if true, staff must re-enter their password, sign in again with OIDC or SAML, or open an emailed link when they sign
+
+	SessionStore         string //memory, sql, redis, or token; default: memory. sql, redis, and token share sessions between replicas
+	SessionDuration      int    //in minutes; default: 5
+	AdminSessionDuration int    //in minutes; default: 60
+
+	SessionKey     string //base64 encoded 32 byte Ed25519 seed session tokens are signed with; required if SessionStore is token
+	SessionOldKeys string //comma separated list of base64 encoded Ed25519 public keys from rotated SessionKeys
+	sessionKey     []byte
+	sessionOldKeys []ed25519.PublicKey
+
+	RedisAddr     string //host:port of the Redis server; required if SessionStore is redis
+	RedisPassword string //optional
+	RedisDB       int    //database number; default: 0
+	RedisTLS      bool   //if true, connections to the Redis server use TLS
+	RedisCACerts  string //path to a PEM file of certificate authorities trusted for RedisTLS; default: system roots
+	RedisPrefix   string //prefix of session keys; default: handbook:session:
+	RedisPoolSize int    //maximum idle connections; default: 4
+	RedisTimeout  int    //in seconds; default: 10
+	redisRootCAs  *x509.CertPool
 
 	SQLDriver string //required
 	SQLDSN    string //required
@@ -29,6 +118,16 @@ type Config struct {
 	StaffDBExclusions     string //comma separated list of EmployeeIDs, without spaces
 	StaffDBTypeExclusions string //comma separated list of EmployeeCodes, without spaces
 
+	ReceiptKey     string //base64 encoded 32 byte Ed25519 seed, e.g. from `head -c 32 /dev/urandom | base64`; optional, receipts aren't issued if empty
+	ReceiptOldKeys string //comma separated list of base64 encoded Ed25519 public keys from rotated ReceiptKeys
+	receiptKey     []byte
+	receiptOldKeys []ed25519.PublicKey
+
+	TSAURL     string //url of an RFC 3161 timestamp authority; optional, signatures aren't timestamped if empty
+	TSACerts   string //path to a PEM file of trusted timestamp authority root certificates; default: system roots
+	TSATimeout int    //in seconds; default: 10
+	tsaRoots   *x509.CertPool
+
 	ListenAddr string //addr format used for net.Dial; required
 	Prefix     string //url prefix to mount api to without trailing slash
 
@@ -48,25 +147,206 @@ func init() {
 	if err != nil {
 		log.Fatalln("Error reading configuration from environment:", err)
 	}
-	checkEmpty(config.LDAPServer, "LDAPSERVER")
+	if config.LocalUsersFile != "" && config.LDAPServer != "" {
+		log.Fatalln("Only one of HANDBOOK_LOCALUSERSFILE and HANDBOOK_LDAPSERVER can be configured")
+	}
+
+	if config.LocalUsersFile == "" {
+		checkEmpty(config.LDAPServer, "LDAPSERVER")
+		checkEmpty(config.LDAPBaseDN, "LDAPBASEDN")
+	}
+
+	for _, s := range strings.Split(config.LDAPServer, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			config.ldapServers = append(config.ldapServers, s)
+		}
+	}
 
 	if config.LDAPPort == 0 {
 		config.LDAPPort = 389
 	}
 
-	checkEmpty(config.LDAPBaseDN, "LDAPBASEDN")
-
 	switch strings.ToLower(config.LDAPSecurity) {
 	case "", "none":
-		config.ldapSecurity = auth.SecurityNone
+		config.ldapSecurity = api.LDAPSecurityNone
 	case "tls":
-		config.ldapSecurity = auth.SecurityTLS
+		config.ldapSecurity = api.LDAPSecurityTLS
 	case "starttls":
-		config.ldapSecurity = auth.SecurityStartTLS
+		config.ldapSecurity = api.LDAPSecurityStartTLS
 	default:
 		log.Fatalln("Invalid HANDBOOK_LDAPSECURITY:", config.LDAPSecurity)
 	}
 
+	if config.LDAPCACerts != "" {
+		buf, err := ioutil.ReadFile(config.LDAPCACerts)
+		if err != nil {
+			log.Fatalln("Error reading HANDBOOK_LDAPCACERTS:", err)
+		}
+		config.ldapRootCAs = x509.NewCertPool()
+		if !config.ldapRootCAs.AppendCertsFromPEM(buf) {
+			log.Fatalln("Invalid HANDBOOK_LDAPCACERTS: no certificates found")
+		}
+	}
+
+	if config.LDAPBindDN != "" {
+		checkEmpty(config.LDAPBindPassword, "LDAPBINDPASSWORD")
+	}
+
+	if config.LDAPPoolSize == 0 {
+		config.LDAPPoolSize = 4
+	}
+
+	if config.LDAPTimeout == 0 {
+		config.LDAPTimeout = 10
+	}
+
+	for _, d := range strings.Split(config.LDAPDomains, ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			config.ldapDomains = append(config.ldapDomains, d)
+		}
+	}
+
+	if config.LDAPManagerGroup != "" || config.LDAPViewerGroup != "" || config.LDAPCampusAdminGroups != "" {
+		checkEmpty(config.LDAPAdminGroup, "LDAPADMINGROUP")
+	}
+
+	config.ldapRoleGroups = make(map[string]api.Role)
+	if config.LDAPManagerGroup != "" {
+		config.ldapRoleGroups[config.LDAPManagerGroup] = api.RoleManager
+	}
+	if config.LDAPViewerGroup != "" {
+		config.ldapRoleGroups[config.LDAPViewerGroup] = api.RoleViewer
+	}
+
+	if config.LDAPCampusAdminGroups != "" {
+		config.ldapCampusAdminGroups = make(map[string][]string)
+	}
+
+	for _, m := range strings.Split(config.LDAPCampusAdminGroups, ";") {
+		if strings.TrimSpace(m) == "" {
+			continue
+		}
+		i := strings.Index(m, ":")
+		if i == -1 {
+			log.Fatalln("Invalid HANDBOOK_LDAPCAMPUSADMINGROUPS mapping:", m)
+		}
+		group := strings.TrimSpace(m[:i])
+		for _, campus := range strings.Split(m[i+1:], ",") {
+			if campus = strings.TrimSpace(campus); campus != "" {
+				config.ldapCampusAdminGroups[group] = append(config.ldapCampusAdminGroups[group], campus)
+			}
+		}
+		if group == "" || len(config.ldapCampusAdminGroups[group]) == 0 {
+			log.Fatalln("Invalid HANDBOOK_LDAPCAMPUSADMINGROUPS mapping:", m)
+		}
+	}
+
+	//LDAP groups are matched by distinguished name, so a group with the same name elsewhere in the directory doesn't match
+	if config.LocalUsersFile == "" {
+		groups := []string{config.LDAPGroup, config.LDAPAdminGroup, config.LDAPManagerGroup, config.LDAPViewerGroup}
+		for group := range config.ldapCampusAdminGroups {
+			groups = append(groups, group)
+		}
+		for _, group := range groups {
+			if group != "" && !api.IsDN(group) {
+				log.Fatalln("Invalid LDAP group: must be a distinguished name, e.g. CN=Staff,OU=Groups,DC=example,DC=com:", group)
+			}
+		}
+	}
+
+	if config.OIDCIssuer != "" {
+		checkEmpty(config.OIDCClientID, "OIDCCLIENTID")
+		checkEmpty(config.OIDCRedirectURL, "OIDCREDIRECTURL")
+	}
+
+	if config.OIDCTimeout == 0 {
+		config.OIDCTimeout = 10
+	}
+
+	if config.SAMLEntityID != "" {
+		checkEmpty(config.SAMLACSURL, "SAMLACSURL")
+		checkEmpty(config.SAMLIdPEntityID, "SAMLIDPENTITYID")
+		checkEmpty(config.SAMLIdPSSOURL, "SAMLIDPSSOURL")
+		checkEmpty(config.SAMLIdPCerts, "SAMLIDPCERTS")
+
+		buf, err := ioutil.ReadFile(config.SAMLIdPCerts)
+		if err != nil {
+			log.Fatalln("Error reading HANDBOOK_SAMLIDPCERTS:", err)
+		}
+		for block, rest := pem.Decode(buf); block != nil; block, rest = pem.Decode(rest) {
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				log.Fatalln("Invalid HANDBOOK_SAMLIDPCERTS:", err)
+			}
+			config.samlIdPCerts = append(config.samlIdPCerts, cert)
+		}
+		if len(config.samlIdPCerts) == 0 {
+			log.Fatalln("Invalid HANDBOOK_SAMLIDPCERTS: no certificates found")
+		}
+	}
+
+	if config.MagicLinkURL != "" {
+		checkEmpty(config.SMTPAddr, "SMTPADDR")
+		checkEmpty(config.SMTPFrom, "SMTPFROM")
+	}
+
+	if config.MagicLinkDuration == 0 {
+		config.MagicLinkDuration = 15
+	}
+
+	if config.LoginAttempts == 0 {
+		config.LoginAttempts = 5
+	}
+
+	if config.LoginIPAttempts == 0 {
+		config.LoginIPAttempts = 50
+	}
+
+	if config.LoginMaxBlock == 0 {
+		config.LoginMaxBlock = 15
+	}
+
+	for _, p := range strings.Split(config.TrustedProxies, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				log.Fatalln("Invalid HANDBOOK_TRUSTEDPROXIES address:", p)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				bits = 8 * net.IPv4len
+			}
+			p = fmt.Sprintf("%s/%d", p, bits)
+		}
+		_, network, err := net.ParseCIDR(p)
+		if err != nil {
+			log.Fatalln("Invalid HANDBOOK_TRUSTEDPROXIES network:", err)
+		}
+		config.trustedProxies = append(config.trustedProxies, network)
+	}
+
+	switch strings.ToLower(config.SessionStore) {
+	case "", "memory":
+		config.SessionStore = "memory"
+	case "sql":
+		config.SessionStore = "sql"
+	case "redis":
+		config.SessionStore = "redis"
+		checkEmpty(config.RedisAddr, "REDISADDR")
+	case "token":
+		config.SessionStore = "token"
+		checkEmpty(config.SessionKey, "SESSIONKEY")
+	default:
+		log.Fatalln("Invalid HANDBOOK_SESSIONSTORE:", config.SessionStore)
+	}
+
 	if config.SessionDuration == 0 {
 		config.SessionDuration = 5
 	}
@@ -75,6 +355,48 @@ func init() {
 		config.AdminSessionDuration = 60
 	}
 
+	if config.SessionKey != "" {
+		key, err := base64.StdEncoding.DecodeString(config.SessionKey)
+		if err != nil || len(key) != ed25519.SeedSize {
+			log.Fatalln("Invalid HANDBOOK_SESSIONKEY: must be 32 base64 encoded bytes")
+		}
+		config.sessionKey = key
+	}
+
+	for _, k := range strings.Split(config.SessionOldKeys, ",") {
+		if k == "" {
+			continue
+		}
+		pub, err := base64.StdEncoding.DecodeString(k)
+		if err != nil || len(pub) != ed25519.PublicKeySize {
+			log.Fatalln("Invalid HANDBOOK_SESSIONOLDKEYS key:", k)
+		}
+		config.sessionOldKeys = append(config.sessionOldKeys, ed25519.PublicKey(pub))
+	}
+
+	if config.RedisCACerts != "" {
+		buf, err := ioutil.ReadFile(config.RedisCACerts)
+		if err != nil {
+			log.Fatalln("Error reading HANDBOOK_REDISCACERTS:", err)
+		}
+		config.redisRootCAs = x509.NewCertPool()
+		if !config.redisRootCAs.AppendCertsFromPEM(buf) {
+			log.Fatalln("Invalid HANDBOOK_REDISCACERTS: no certificates found")
+		}
+	}
+
+	if config.RedisPrefix == "" {
+		config.RedisPrefix = "handbook:session:"
+	}
+
+	if config.RedisPoolSize == 0 {
+		config.RedisPoolSize = 4
+	}
+
+	if config.RedisTimeout == 0 {
+		config.RedisTimeout = 10
+	}
+
 	checkEmpty(config.SQLDriver, "SQLDRIVER")
 	checkEmpty(config.SQLDSN, "SQLDSN")
 
@@ -85,5 +407,56 @@ func init() {
 		log.Fatalln("mysql DSN must contain \"?parseTime=true\"")
 	}
 
+	//transactions must take the write lock when they begin, or concurrent audit log appends fail instead of waiting
+	if config.SQLDriver == "sqlite3" && !strings.Contains(config.SQLDSN, "_txlock=immediate") {
+		log.Fatalln("sqlite3 DSN must contain \"_txlock=immediate\"")
+	}
+
+	if config.ReceiptKey != "" {
+		config.receiptKey, err = base64.StdEncoding.DecodeString(config.ReceiptKey)
+		if err != nil || len(config.receiptKey) != ed25519.SeedSize {
+			log.Fatalln("Invalid HANDBOOK_RECEIPTKEY: must be 32 base64 encoded bytes")
+		}
+	}
+
+	if config.TOTPKey != "" {
+		config.totpKey, err = base64.StdEncoding.DecodeString(config.TOTPKey)
+		if err != nil || len(config.totpKey) != 32 {
+			log.Fatalln("Invalid HANDBOOK_TOTPKEY: must be 32 base64 encoded bytes")
+		}
+	} else if config.TOTPRequired {
+		log.Fatalln("HANDBOOK_TOTPREQUIRED requires HANDBOOK_TOTPKEY")
+	}
+
+	if config.TOTPIssuer == "" {
+		config.TOTPIssuer = "Employee Handbook"
+	}
+
+	for _, k := range strings.Split(config.ReceiptOldKeys, ",") {
+		if k == "" {
+			continue
+		}
+		pub, err := base64.StdEncoding.DecodeString(k)
+		if err != nil || len(pub) != ed25519.PublicKeySize {
+			log.Fatalln("Invalid HANDBOOK_RECEIPTOLDKEYS key:", k)
+		}
+		config.receiptOldKeys = append(config.receiptOldKeys, ed25519.PublicKey(pub))
+	}
+
+	if config.TSATimeout == 0 {
+		config.TSATimeout = 10
+	}
+
+	if config.TSACerts != "" {
+		buf, err := ioutil.ReadFile(config.TSACerts)
+		if err != nil {
+			log.Fatalln("Error reading HANDBOOK_TSACERTS:", err)
+		}
+		config.tsaRoots = x509.NewCertPool()
+		if !config.tsaRoots.AppendCertsFromPEM(buf) {
+			log.Fatalln("Invalid HANDBOOK_TSACERTS: no certificates found")
+		}
+	}
+
 	checkEmpty(config.ListenAddr, "LISTENADDR")
 }
@@ -1,10 +1,10 @@
 package main
 
 import (
-	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"strings"
 )
 
 type indexHandler struct {
@@ -25,22 +25,48 @@ func (h indexHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 }
 
 type forwardedHandler struct {
-	chain http.Handler
+	chain   http.Handler
+	proxies []*net.IPNet
+}
+
+//ForwardedHandler replaces the Remote Address with the client address in the X-Forwarded-For header
+//if the request came from one of the given trusted proxies. Otherwise the header is ignored, since the client could have set it.
+//The header is read from the last address, skipping addresses of trusted proxies,
+//since earlier addresses can be set by the client.
+func ForwardedHandler(h http.Handler, proxies []*net.IPNet) http.Handler {
+	return forwardedHandler{chain: h, proxies: proxies}
 }
 
-//ForwardedHandler replaces the Remote Address with the X-Forwarded-For header if it exists
-func ForwardedHandler(h http.Handler) http.Handler {
-	return forwardedHandler{h}
+//trusted returns whether or not ip is a trusted proxy
+func (h forwardedHandler) trusted(ip net.IP) bool {
+	for _, p := range h.proxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
 }
 
 func (h forwardedHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
-	_, port, err := net.SplitHostPort(r.RemoteAddr)
+	host, port, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
 		log.Panicln("Error parsing Remote Address:", err)
 	}
 
-	if ip := r.Header.Get("X-Forwarded-For"); ip != "" {
-		r.RemoteAddr = fmt.Sprintf("%s:%s", ip, port)
+	ip := net.ParseIP(host)
+	if values := r.Header["X-Forwarded-For"]; len(values) > 0 && ip != nil && h.trusted(ip) {
+		list := strings.Split(strings.Join(values, ","), ",")
+		for i := len(list) - 1; i >= 0; i-- {
+			next := net.ParseIP(strings.TrimSpace(list[i]))
+			if next == nil {
+				break
+			}
+			ip = next
+			if !h.trusted(ip) {
+				break
+			}
+		}
+		r.RemoteAddr = net.JoinHostPort(ip.String(), port)
 	}
 
 	h.chain.ServeHTTP(rw, r)
@@ -3,6 +3,8 @@ package main
 //go:generate go-bindata-assetfs -o bindata_assetfs.go static/...
 
 import (
+	"crypto/tls"
+	"encoding/base64"
 	"log"
 	"net/http"
 	"os"
@@ -13,7 +15,6 @@ import (
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
-	"github.com/korylprince/go-ad-auth"
 	"github.com/korylprince/handbook/api"
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -26,16 +27,14 @@ func middleware(h http.Handler) http.Handler {
 		handlers.CompressHandler(
 			http.StripPrefix(config.Prefix,
 				IndexHandler(
-					ForwardedHandler(h)))))
+					ForwardedHandler(h, config.trustedProxies)))))
 }
 
 func main() {
-	ldapConfig := &auth.Config{
-		Server:   config.LDAPServer,
-		Port:     config.LDAPPort,
-		BaseDN:   config.LDAPBaseDN,
-		Security: config.ldapSecurity,
-		Debug:    config.Debug,
+	adminGroups := &api.AdminGroups{
+		Admin:    config.LDAPAdminGroup,
+		Roles:    config.ldapRoleGroups,
+		Campuses: config.ldapCampusAdminGroups,
 	}
 
 	db, err := api.NewSQLDB(config.SQLDriver, config.SQLDSN)
@@ -47,16 +46,147 @@ func main() {
 		log.Panicln("Error creating SkywardDB:", err)
 	}
 
+	var a api.Auth
+	if config.LocalUsersFile != "" {
+		a, err = api.NewLocalAuth(config.LocalUsersFile)
+		if err != nil {
+			log.Panicln("Error creating LocalAuth:", err)
+		}
+	} else {
+		a = api.NewLDAPAuth(&api.LDAPConfig{
+			Group:               config.LDAPGroup,
+			Admins:              adminGroups,
+			EmployeeIDAttribute: config.LDAPEmployeeIDAttribute,
+			FirstNameAttribute:  config.LDAPFirstNameAttribute,
+			LastNameAttribute:   config.LDAPLastNameAttribute,
+			UPNSuffix:           config.LDAPUPNSuffix,
+			Domains:             config.ldapDomains,
+			Servers:             config.ldapServers,
+			Port:                config.LDAPPort,
+			BaseDN:              config.LDAPBaseDN,
+			Security:            config.ldapSecurity,
+			RootCAs:             config.ldapRootCAs,
+			BindDN:              config.LDAPBindDN,
+			BindPassword:        config.LDAPBindPassword,
+			PoolSize:            config.LDAPPoolSize,
+			Timeout:             time.Duration(config.LDAPTimeout) * time.Second,
+			Debug:               config.Debug,
+		})
+	}
+
+	var sessionStore api.SessionStore
+	switch config.SessionStore {
+	case "sql":
+		sessionStore = api.NewSQLSessionStore(db, time.Duration(config.SessionDuration)*time.Minute,
+			time.Duration(config.AdminSessionDuration)*time.Minute)
+	case "redis":
+		redisConfig := &api.RedisConfig{
+			Addr:     config.RedisAddr,
+			Password: config.RedisPassword,
+			DB:       config.RedisDB,
+			PoolSize: config.RedisPoolSize,
+			Timeout:  time.Duration(config.RedisTimeout) * time.Second,
+		}
+		if config.RedisTLS {
+			redisConfig.TLS = &tls.Config{RootCAs: config.redisRootCAs}
+		}
+		sessionStore = api.NewRedisSessionStore(redisConfig, config.RedisPrefix, time.Duration(config.SessionDuration)*time.Minute,
+			time.Duration(config.AdminSessionDuration)*time.Minute)
+	case "token":
+		tokens, err := api.NewTokenSessionStore(db, config.sessionKey, config.sessionOldKeys,
+			time.Duration(config.SessionDuration)*time.Minute, time.Duration(config.AdminSessionDuration)*time.Minute)
+		if err != nil {
+			log.Panicln("Error creating TokenSessionStore:", err)
+		}
+		pub := tokens.PublicKey()
+		log.Printf("Signing session tokens with key %s, public key %s\n", api.KeyID(pub), base64.StdEncoding.EncodeToString(pub))
+		sessionStore = tokens
+	default:
+		sessionStore = api.NewMemorySessionStore(time.Duration(config.SessionDuration)*time.Minute,
+			time.Duration(config.AdminSessionDuration)*time.Minute)
+	}
+
+	//login state is shared through the database whenever sessions are, so any replica can continue a login
+	var state api.StateStore
+	if config.SessionStore == "memory" {
+		state = api.NewMemoryStateStore()
+	} else {
+		state = api.NewSQLStateStore(db)
+	}
+
 	c := &api.Context{
-		Auth:    api.NewLDAPAuth(config.LDAPGroup, config.LDAPAdminGroup, ldapConfig),
-		DB:      db,
-		StaffDB: staffDB,
-		SessionStore: api.NewMemorySessionStore(time.Duration(config.SessionDuration)*time.Minute,
-			time.Duration(config.AdminSessionDuration)*time.Minute),
+		Auth:         a,
+		DB:           db,
+		StaffDB:      staffDB,
+		SessionStore: sessionStore,
+		StepUp:       config.StepUp,
+	}
+
+	if config.receiptKey != nil {
+		c.Receipts, err = api.NewReceiptSigner(config.receiptKey, config.receiptOldKeys)
+		if err != nil {
+			log.Panicln("Error creating ReceiptSigner:", err)
+		}
+	}
+
+	if config.OIDCIssuer != "" {
+		c.OIDC = api.NewOIDCAuth(&api.OIDCConfig{
+			Issuer:          config.OIDCIssuer,
+			ClientID:        config.OIDCClientID,
+			ClientSecret:    config.OIDCClientSecret,
+			RedirectURL:     config.OIDCRedirectURL,
+			EmployeeIDClaim: config.OIDCEmployeeIDClaim,
+			UsernameClaim:   config.OIDCUsernameClaim,
+			GroupsClaim:     config.OIDCGroupsClaim,
+			Group:           config.OIDCGroup,
+			Admins:          adminGroups,
+			Timeout:         time.Duration(config.OIDCTimeout) * time.Second,
+		}, state)
+	}
+
+	if config.SAMLEntityID != "" {
+		c.SAML = api.NewSAMLAuth(&api.SAMLConfig{
+			EntityID:            config.SAMLEntityID,
+			ACSURL:              config.SAMLACSURL,
+			IdPEntityID:         config.SAMLIdPEntityID,
+			IdPSSOURL:           config.SAMLIdPSSOURL,
+			IdPCertificates:     config.samlIdPCerts,
+			EmployeeIDAttribute: config.SAMLEmployeeIDAttribute,
+			UsernameAttribute:   config.SAMLUsernameAttribute,
+			FirstNameAttribute:  config.SAMLFirstNameAttribute,
+			LastNameAttribute:   config.SAMLLastNameAttribute,
+			GroupsAttribute:     config.SAMLGroupsAttribute,
+			Group:               config.SAMLGroup,
+			Admins:              adminGroups,
+		}, state)
+	}
+
+	if config.MagicLinkURL != "" {
+		c.MagicLinks = api.NewMagicLinkAuth(staffDB,
+			api.NewSMTPMailer(config.SMTPAddr, config.SMTPFrom, config.SMTPUsername, config.SMTPPassword),
+			state, config.MagicLinkURL, time.Duration(config.MagicLinkDuration)*time.Minute)
+	}
+
+	if config.totpKey != nil {
+		c.TOTP, err = api.NewTOTPAuth(db, state, config.totpKey, config.TOTPIssuer, config.TOTPRequired)
+		if err != nil {
+			log.Panicln("Error creating TOTPAuth:", err)
+		}
+	}
+
+	if config.LoginAttempts > 0 {
+		c.Throttle = api.NewLoginThrottle(state, config.LoginAttempts, config.LoginIPAttempts, time.Duration(config.LoginMaxBlock)*time.Minute)
+	}
+
+	if config.TSAURL != "" {
+		c.Timestamps = api.NewTimestampClient(config.TSAURL, time.Duration(config.TSATimeout)*time.Second, config.tsaRoots)
 	}
 
 	r := mux.NewRouter()
 
+	//handbook
+	r.Handle("/images/handbook.pdf", api.HandbookHandler(c)).Methods("GET")
+
 	//static
 	for _, route := range static {
 		r.PathPrefix(route).Handler(http.FileServer(assetFS())).Methods("GET")
@@ -68,8 +198,53 @@ func main() {
 	//api
 	r.Handle("/api/1.0/auth", api.AuthHandler(c)).Methods("POST")
 	r.Handle("/api/1.0/admin/auth", api.AuthAdminHandler(c)).Methods("POST")
+	r.Handle("/api/1.0/logout", api.LogoutHandler(c)).Methods("POST")
+	r.Handle("/api/1.0/admin/auth/totp", api.TOTPLoginHandler(c)).Methods("POST")
+	r.Handle("/api/1.0/admin/auth/totp/enroll", api.TOTPPendingEnrollHandler(c)).Methods("POST")
+	r.Handle("/api/1.0/oidc", api.OIDCConfigHandler(c)).Methods("GET")
+	r.Handle("/api/1.0/oidc/login", api.OIDCLoginHandler(c)).Methods("GET")
+	r.Handle("/api/1.0/oidc/callback", api.OIDCCallbackHandler(c)).Methods("GET")
+	r.Handle("/api/1.0/oidc/session", api.OIDCSessionHandler(c)).Methods("POST")
+	r.Handle("/api/1.0/saml", api.SAMLConfigHandler(c)).Methods("GET")
+	r.Handle("/api/1.0/saml/metadata", api.SAMLMetadataHandler(c)).Methods("GET")
+	r.Handle("/api/1.0/saml/login", api.SAMLLoginHandler(c)).Methods("GET")
+	r.Handle("/api/1.0/saml/acs", api.SAMLACSHandler(c)).Methods("POST")
+	r.Handle("/api/1.0/saml/session", api.SAMLSessionHandler(c)).Methods("POST")
+	r.Handle("/api/1.0/magiclink", api.MagicLinkConfigHandler(c)).Methods("GET")
+	r.Handle("/api/1.0/magiclink", api.MagicLinkHandler(c)).Methods("POST")
+	r.Handle("/api/1.0/magiclink/session", api.MagicLinkSessionHandler(c)).Methods("POST")
 	r.Handle("/api/1.0/submit", api.SubmitHandler(c)).Methods("POST")
+	r.Handle("/api/1.0/stepup", api.StepUpConfigHandler(c)).Methods("GET")
+	r.Handle("/api/1.0/stepup/email", api.StepUpEmailHandler(c)).Methods("POST")
+	r.Handle("/api/1.0/handbook", api.ViewHandler(c)).Methods("GET")
+	r.Handle("/api/1.0/receipt", api.ReceiptPDFHandler(c)).Methods("GET")
+	r.Handle("/api/1.0/campuses", api.CampusesHandler(c)).Methods("GET")
+	r.Handle("/api/1.0/me", api.MeHandler(c)).Methods("GET")
+	r.Handle("/api/1.0/verify", api.VerifyHandler(c)).Methods("POST")
+	r.Handle("/api/1.0/verify/key", api.ReceiptKeyHandler(c)).Methods("GET")
 	r.Handle("/api/1.0/admin/list", api.ListHandler(c)).Methods("GET")
+	r.Handle("/api/1.0/admin/receipt", api.AdminReceiptPDFHandler(c)).Methods("GET")
+	r.Handle("/api/1.0/admin/certificate", api.CertificateHandler(c)).Methods("GET")
+	r.Handle("/api/1.0/admin/certificates", api.CertificateArchiveHandler(c)).Methods("GET")
+	r.Handle("/api/1.0/admin/timestamp", api.TimestampHandler(c)).Methods("GET")
+	r.Handle("/api/1.0/admin/audit", api.AuditHandler(c)).Methods("GET")
+	r.Handle("/api/1.0/admin/campuses", api.CampusListHandler(c)).Methods("GET")
+	r.Handle("/api/1.0/admin/campuses", api.CampusCreateHandler(c)).Methods("POST")
+	r.Handle("/api/1.0/admin/campuses/update", api.CampusUpdateHandler(c)).Methods("POST")
+	r.Handle("/api/1.0/admin/campuses/delete", api.CampusDeleteHandler(c)).Methods("POST")
+	r.Handle("/api/1.0/admin/campuses/mismatches", api.MismatchHandler(c)).Methods("GET")
+	r.Handle("/api/1.0/admin/documents", api.DocumentListHandler(c)).Methods("GET")
+	r.Handle("/api/1.0/admin/documents", api.DocumentUploadHandler(c)).Methods("POST")
+	r.Handle("/api/1.0/admin/documents/file", api.DocumentFileHandler(c)).Methods("GET")
+	r.Handle("/api/1.0/admin/documents/publish", api.DocumentPublishHandler(c)).Methods("POST")
+	r.Handle("/api/1.0/admin/throttle", api.ThrottleListHandler(c)).Methods("GET")
+	r.Handle("/api/1.0/admin/throttle/clear", api.ThrottleClearHandler(c)).Methods("POST")
+	r.Handle("/api/1.0/admin/totp", api.TOTPStatusHandler(c)).Methods("GET")
+	r.Handle("/api/1.0/admin/totp/enroll", api.TOTPEnrollHandler(c)).Methods("POST")
+	r.Handle("/api/1.0/admin/totp/confirm", api.TOTPConfirmHandler(c)).Methods("POST")
+	r.Handle("/api/1.0/admin/totp/recovery", api.TOTPRecoveryHandler(c)).Methods("POST")
+	r.Handle("/api/1.0/admin/totp/disable", api.TOTPDisableHandler(c)).Methods("POST")
+	r.Handle("/api/1.0/admin/totp/reset", api.TOTPResetHandler(c)).Methods("POST")
 
 	r.PathPrefix("/api").Handler(http.HandlerFunc(api.NotFoundHandler))
 
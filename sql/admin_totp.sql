@@ -0,0 +1,7 @@
+CREATE TABLE admin_totp (
+    username VARCHAR(255) PRIMARY KEY,
+    secret VARCHAR(255),
+    recovery_codes TEXT,
+    last_counter BIGINT,
+    time DATETIME
+);
@@ -0,0 +1,14 @@
+CREATE TABLE audit_log (
+    seq BIGINT PRIMARY KEY,
+    time DATETIME,
+    action VARCHAR(40),
+    data TEXT,
+    prev_hash VARCHAR(64),
+    hash VARCHAR(64)
+);
+
+CREATE TABLE audit_lock (
+    id INT PRIMARY KEY,
+    n BIGINT
+);
+INSERT INTO audit_lock(id, n) VALUES(1, 0);
@@ -0,0 +1,24 @@
+CREATE TABLE campuses (
+    name VARCHAR(255) PRIMARY KEY,
+    position INT,
+    active BOOLEAN
+);
+INSERT INTO campuses(name, position, active) VALUES
+    ('Alternative Education Center', 1, TRUE),
+    ('Early Childhood School', 2, TRUE),
+    ('Primary School', 3, TRUE),
+    ('Elementary School', 4, TRUE),
+    ('Intermediate School', 5, TRUE),
+    ('Middle School', 6, TRUE),
+    ('High School', 7, TRUE),
+    ('Central Office', 8, TRUE),
+    ('Maintenance', 9, TRUE),
+    ('Teaching and Learning', 10, TRUE),
+    ('Technology', 11, TRUE),
+    ('Transportation', 12, TRUE);
+
+CREATE TABLE campus_buildings (
+    building VARCHAR(255) PRIMARY KEY,
+    campus VARCHAR(255)
+);
+CREATE INDEX campus_buildings_campus ON campus_buildings(campus);
@@ -0,0 +1,10 @@
+CREATE TABLE documents (
+    version VARCHAR(20) PRIMARY KEY,
+    title VARCHAR(255),
+    filename VARCHAR(255),
+    content MEDIUMBLOB,
+    hash VARCHAR(64),
+    active BOOLEAN,
+    created DATETIME
+);
+CREATE INDEX documents_active ON documents(active);
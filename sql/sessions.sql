@@ -0,0 +1,27 @@
+CREATE TABLE sessions (
+    id VARCHAR(64) PRIMARY KEY,
+    data TEXT,
+    expires DATETIME
+);
+CREATE INDEX sessions_expires ON sessions(expires);
+
+CREATE TABLE leases (
+    name VARCHAR(64) PRIMARY KEY,
+    owner VARCHAR(64),
+    expires DATETIME
+);
+
+CREATE TABLE revoked_sessions (
+    id VARCHAR(64) PRIMARY KEY,
+    expires DATETIME
+);
+CREATE INDEX revoked_sessions_expires ON revoked_sessions(expires);
+
+CREATE TABLE login_state (
+    kind VARCHAR(32),
+    id VARCHAR(64),
+    data TEXT,
+    expires DATETIME,
+    PRIMARY KEY(kind, id)
+);
+CREATE INDEX login_state_expires ON login_state(expires);
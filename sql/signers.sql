@@ -1,12 +1,20 @@
 CREATE TABLE signers (
-    employee_id VARCHAR(10) PRIMARY KEY,
+    employee_id VARCHAR(10),
+    version VARCHAR(20),
+    document_hash VARCHAR(64),
     username VARCHAR(255),
     firstname VARCHAR(255),
     lastname VARCHAR(255),
-    campus VARCHAR(20),
+    campus VARCHAR(255),
     headers TEXT,
-    time DATETIME
+    ip VARCHAR(45),
+    view_time DATETIME,
+    reauth VARCHAR(16),
+    time DATETIME,
+    timestamp_token BLOB,
+    PRIMARY KEY (employee_id, version)
 );
+CREATE INDEX signers_version ON signers(version);
 CREATE INDEX signers_username ON signers(username);
 CREATE INDEX signers_firstname ON signers(firstname);
 CREATE INDEX signers_lastname ON signers(lastname);
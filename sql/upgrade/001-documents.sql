@@ -0,0 +1,17 @@
+-- Upgrades a MySQL signers table from before document versions.
+-- Existing signatures are assigned to the version named below; change it to match the handbook they signed.
+SET @legacy_version = '2025-2026';
+
+CREATE TABLE documents (
+    version VARCHAR(20) PRIMARY KEY,
+    title VARCHAR(255),
+    active BOOLEAN,
+    created DATETIME
+);
+CREATE INDEX documents_active ON documents(active);
+INSERT INTO documents(version, title, active, created) VALUES(@legacy_version, 'Handbook', TRUE, NOW());
+
+ALTER TABLE signers ADD COLUMN version VARCHAR(20) NOT NULL DEFAULT '' AFTER employee_id;
+UPDATE signers SET version=@legacy_version;
+ALTER TABLE signers DROP PRIMARY KEY, ADD PRIMARY KEY (employee_id, version);
+CREATE INDEX signers_version ON signers(version);
@@ -0,0 +1,4 @@
+-- Upgrades a MySQL documents table to store handbook files.
+-- Documents created before this upgrade have no file; upload and publish a new version through the admin API.
+ALTER TABLE documents ADD COLUMN filename VARCHAR(255) NOT NULL DEFAULT '' AFTER title;
+ALTER TABLE documents ADD COLUMN content MEDIUMBLOB AFTER filename;
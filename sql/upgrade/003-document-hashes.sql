@@ -0,0 +1,6 @@
+-- Upgrades a MySQL database to store SHA-256 hashes of handbook files with documents and signatures.
+ALTER TABLE documents ADD COLUMN hash VARCHAR(64) NOT NULL DEFAULT '' AFTER content;
+UPDATE documents SET hash=LOWER(SHA2(content, 256)) WHERE content IS NOT NULL;
+
+ALTER TABLE signers ADD COLUMN document_hash VARCHAR(64) NOT NULL DEFAULT '' AFTER version;
+UPDATE signers INNER JOIN documents ON signers.version = documents.version SET signers.document_hash = documents.hash;
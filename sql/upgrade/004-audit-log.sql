@@ -0,0 +1,10 @@
+-- Adds the hash-chained audit log to a MySQL database.
+-- Signatures from before the upgrade are not in the log.
+CREATE TABLE audit_log (
+    seq BIGINT PRIMARY KEY,
+    time DATETIME,
+    action VARCHAR(40),
+    data TEXT,
+    prev_hash VARCHAR(64),
+    hash VARCHAR(64)
+);
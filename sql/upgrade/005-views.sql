@@ -0,0 +1,10 @@
+-- Upgrades a MySQL database to record handbook views before signing.
+-- Signatures from before the upgrade have a NULL view_time.
+CREATE TABLE views (
+    employee_id VARCHAR(10),
+    version VARCHAR(20),
+    time DATETIME
+);
+CREATE INDEX views_employee_id_version ON views(employee_id, version);
+
+ALTER TABLE signers ADD COLUMN view_time DATETIME AFTER headers;
@@ -0,0 +1,3 @@
+-- Upgrades a MySQL database to store RFC 3161 timestamp tokens with signatures.
+-- Signatures from before the upgrade have a NULL timestamp_token.
+ALTER TABLE signers ADD COLUMN timestamp_token BLOB AFTER time;
@@ -0,0 +1,22 @@
+-- Upgrades a MySQL database to store the campus catalog instead of hardcoding it.
+-- Also widens signers.campus, which was too short for some campus names.
+CREATE TABLE campuses (
+    name VARCHAR(255) PRIMARY KEY,
+    position INT,
+    active BOOLEAN
+);
+INSERT INTO campuses(name, position, active) VALUES
+    ('Alternative Education Center', 1, TRUE),
+    ('Early Childhood School', 2, TRUE),
+    ('Primary School', 3, TRUE),
+    ('Elementary School', 4, TRUE),
+    ('Intermediate School', 5, TRUE),
+    ('Middle School', 6, TRUE),
+    ('High School', 7, TRUE),
+    ('Central Office', 8, TRUE),
+    ('Maintenance', 9, TRUE),
+    ('Teaching and Learning', 10, TRUE),
+    ('Technology', 11, TRUE),
+    ('Transportation', 12, TRUE);
+
+ALTER TABLE signers MODIFY COLUMN campus VARCHAR(255);
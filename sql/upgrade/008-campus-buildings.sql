@@ -0,0 +1,6 @@
+-- Upgrades a MySQL database to map staff database buildings to campuses.
+CREATE TABLE campus_buildings (
+    building VARCHAR(255) PRIMARY KEY,
+    campus VARCHAR(255)
+);
+CREATE INDEX campus_buildings_campus ON campus_buildings(campus);
@@ -0,0 +1,9 @@
+-- Upgrades a MySQL database to store admin TOTP enrollments.
+-- Secrets are encrypted with HANDBOOK_TOTPKEY and recovery codes are stored as SHA-256 hashes.
+CREATE TABLE admin_totp (
+    username VARCHAR(255) PRIMARY KEY,
+    secret VARCHAR(255),
+    recovery_codes TEXT,
+    last_counter BIGINT,
+    time DATETIME
+);
@@ -0,0 +1,3 @@
+-- Upgrades a MySQL database to record how signers re-confirmed their identity.
+-- Signatures from before the upgrade have a NULL reauth.
+ALTER TABLE signers ADD COLUMN reauth VARCHAR(16) AFTER view_time;
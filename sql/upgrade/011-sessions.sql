@@ -0,0 +1,14 @@
+-- Upgrades a MySQL database to store sessions for HANDBOOK_SESSIONSTORE=sql.
+-- Session IDs are stored as SHA-256 hashes. The leases table picks the replica that removes expired sessions.
+CREATE TABLE sessions (
+    id VARCHAR(64) PRIMARY KEY,
+    data TEXT,
+    expires DATETIME
+);
+CREATE INDEX sessions_expires ON sessions(expires);
+
+CREATE TABLE leases (
+    name VARCHAR(64) PRIMARY KEY,
+    owner VARCHAR(64),
+    expires DATETIME
+);
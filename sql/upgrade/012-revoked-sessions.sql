@@ -0,0 +1,7 @@
+-- Upgrades a MySQL database to store revoked session tokens for HANDBOOK_SESSIONSTORE=token.
+-- Token IDs are kept until the tokens expire.
+CREATE TABLE revoked_sessions (
+    id VARCHAR(64) PRIMARY KEY,
+    expires DATETIME
+);
+CREATE INDEX revoked_sessions_expires ON revoked_sessions(expires);
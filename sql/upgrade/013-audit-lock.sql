@@ -0,0 +1,7 @@
+-- Upgrades a MySQL database so replicas sharing it append to the audit log one at a time.
+-- Every audited change locks the single audit_lock row until it commits.
+CREATE TABLE audit_lock (
+    id INT PRIMARY KEY,
+    n BIGINT
+);
+INSERT INTO audit_lock(id, n) VALUES(1, 0);
@@ -0,0 +1,10 @@
+-- Upgrades a MySQL database to share login state between replicas when HANDBOOK_SESSIONSTORE isn't memory:
+-- pending single sign-on and TOTP logins, emailed sign-in links, and login throttling. Keys are stored as SHA-256 hashes.
+CREATE TABLE login_state (
+    kind VARCHAR(32),
+    id VARCHAR(64),
+    data TEXT,
+    expires DATETIME,
+    PRIMARY KEY(kind, id)
+);
+CREATE INDEX login_state_expires ON login_state(expires);
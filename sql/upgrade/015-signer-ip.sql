@@ -0,0 +1,3 @@
+-- Upgrades a MySQL database to record the client IP address signatures were submitted from.
+-- Signatures from before the upgrade have a NULL ip.
+ALTER TABLE signers ADD COLUMN ip VARCHAR(45) AFTER headers;
@@ -0,0 +1,6 @@
+CREATE TABLE views (
+    employee_id VARCHAR(10),
+    version VARCHAR(20),
+    time DATETIME
+);
+CREATE INDEX views_employee_id_version ON views(employee_id, version);
@@ -14,6 +14,24 @@ app.config(["$routeProvider", function($routeProvider) {
         }).when("/admin/list", {
             templateUrl: "views/list.html",
             controller: "listController",
+        }).when("/admin/totp", {
+            templateUrl: "views/totp.html",
+            controller: "totpController",
+        }).when("/oidc/:token", {
+            templateUrl: "views/login.html",
+            controller: "ssoController",
+        }).when("/admin/oidc/:token", {
+            templateUrl: "views/login.html",
+            controller: "ssoController",
+        }).when("/saml/:token", {
+            templateUrl: "views/login.html",
+            controller: "ssoController",
+        }).when("/admin/saml/:token", {
+            templateUrl: "views/login.html",
+            controller: "ssoController",
+        }).when("/magic/:token", {
+            templateUrl: "views/login.html",
+            controller: "ssoController",
         }).when("/done", {
             templateUrl: "views/done.html",
             controller: "doneController",
@@ -25,7 +43,7 @@ app.config(["$mdThemingProvider", function($mdThemingProvider) {
         .primaryPalette("blue");
 }]);
 
-app.factory("session", ["$cookies", function($cookies) {
+app.factory("session", ["$cookies", "$http", function($cookies, $http) {
     return { 
         setID: function(id) {
             $cookies.sessionID = id;
@@ -34,6 +52,16 @@ app.factory("session", ["$cookies", function($cookies) {
             return $cookies.sessionID || "";
         },
         deleteID : function() {
+            // end the session on the server too, so the ID can't be reused
+            if ($cookies.sessionID) {
+                $http({
+                    method: "POST",
+                    url: "api/1.0/logout",
+                    headers: {
+                        "X-Session-Key": $cookies.sessionID,
+                    },
+                });
+            }
             delete $cookies.sessionID;
         }
     };
@@ -46,7 +74,41 @@ app.factory("alert", function() {
     };
 });
 
-app.controller("loginController", ["$scope", "$http", "$location", "session", "alert", function($scope, $http, $location, session, alert) {
+app.factory("download", ["$http", "$window", "$document", function($http, $window, $document) {
+    // download fetches url with the given session and saves it as filename
+    return function(url, sessionID, filename) {
+        return $http({
+            method: "GET",
+            url: url,
+            responseType: "blob",
+            headers: {
+                "X-Session-Key": sessionID,
+            },
+        }).success(function(data) {
+            var a = $document[0].createElement("a");
+            a.href = $window.URL.createObjectURL(data);
+            a.download = filename;
+            $document[0].body.appendChild(a);
+            a.click();
+            $document[0].body.removeChild(a);
+        });
+    };
+}]);
+
+app.factory("receipt", function() {
+    return {
+        value: "",
+    };
+});
+
+// pendingLogin holds a single sign-on admin login waiting for a TOTP code
+app.factory("pendingLogin", function() {
+    return {
+        value: null,
+    };
+});
+
+app.controller("loginController", ["$scope", "$http", "$location", "session", "alert", "pendingLogin", function($scope, $http, $location, session, alert, pendingLogin) {
     $scope.submit = function(login) {
         $scope.alert.hidden = true;
 
@@ -66,41 +128,158 @@ app.controller("loginController", ["$scope", "$http", "$location", "session", "a
                 console.log("Login error: ", status, data);
                 return;
             }
-            if (data.SessionID == null || data.SessionID == "") {
-                $scope.alert.hidden = false;
-                $scope.alert.message = "Something bad happened: " + angular.toJson(data);
-                console.log("Login error: ", status, data);
-                return;
-            }
-            if (data.Completed && !$scope.admin) {
-                $location.path("/done");
+            if (data.PendingID) {
+                $scope.pending = data;
+                if (data.TOTPEnroll) {
+                    $scope.enroll();
+                }
                 return;
             }
-            session.setID(data.SessionID);
-            if ($scope.admin) {
-                $location.path("/admin/list");
-                return;
-            } else {
-                $location.path("/form");
-            }
+            $scope.finish(data, status);
+        }).error($scope.loginError);
+    };
 
-        }).error(function(data, status) {
+    // finish stores the session from a successful login and moves on
+    $scope.finish = function(data, status) {
+        if (data.SessionID == null || data.SessionID == "") {
             $scope.alert.hidden = false;
-            if (status == 401) {
-                $scope.alert.message = "Bad username or password";
-            } else {
-                $scope.alert.message = "Something bad happened: " + angular.toJson(data);
-            }
+            $scope.alert.message = "Something bad happened: " + angular.toJson(data);
             console.log("Login error: ", status, data);
+            return;
+        }
+        session.setID(data.SessionID);
+        if (data.RecoveryCodes) {
+            $scope.recoveryCodes = data.RecoveryCodes;
+            return;
+        }
+        if (data.Completed && !$scope.admin) {
+            $location.path("/done");
+            return;
+        }
+        if ($scope.admin) {
+            $location.path("/admin/list");
+            return;
+        } else {
+            $location.path("/form");
+        }
+    };
+
+    $scope.loginError = function(data, status, headers) {
+        $scope.alert.hidden = false;
+        if (status == 401 && $scope.pending) {
+            $scope.alert.message = "Invalid code";
+        } else if (status == 401) {
+            $scope.alert.message = "Bad username or password";
+        } else if (status == 429) {
+            $scope.alert.message = "Too many failed logins. Try again in " + headers("Retry-After") + " seconds.";
+        } else {
+            $scope.alert.message = "Something bad happened: " + angular.toJson(data);
+        }
+        console.log("Login error: ", status, data);
+    };
+
+    // enroll fetches a new authenticator secret for a pending admin login that must enroll
+    $scope.enroll = function() {
+        $http({
+            method: "POST",
+            url: "api/1.0/admin/auth/totp/enroll",
+            data: {PendingID: $scope.pending.PendingID},
+            headers: {
+                "Accept": "application/json",
+            },
+        }).success(function(data) {
+            $scope.enrollment = data;
+        }).error($scope.loginError);
+    };
+
+    $scope.submitCode = function(code) {
+        $scope.alert.hidden = true;
+
+        $http({
+            method: "POST",
+            url: "api/1.0/admin/auth/totp",
+            data: {PendingID: $scope.pending.PendingID, Code: code.Code},
+            headers: {
+                "Accept": "application/json",
+            },
+        }).success(function(data, status) {
+            $scope.finish(data, status);
+        }).error(function(data, status, headers) {
+            $scope.loginError(data, status, headers);
+            code.Code = "";
         });
     };
 
+    $scope.continue = function() {
+        $location.path("/admin/list");
+    };
+
+    $scope.pending = pendingLogin.value;
+    pendingLogin.value = null;
+    $scope.enrollment = null;
+    $scope.recoveryCodes = null;
+    $scope.code = {};
+
     $scope.login = {};
 
     $scope.admin = ($location.path().indexOf("admin") > -1);
 
     $scope.alert = alert;
 
+    if ($scope.pending && $scope.pending.TOTPEnroll) {
+        $scope.enroll();
+    }
+
+    $scope.oidc = false;
+    $http.get("api/1.0/oidc").success(function(data) {
+        $scope.oidc = data.Enabled;
+    });
+
+    $scope.saml = false;
+    $http.get("api/1.0/saml").success(function(data) {
+        $scope.saml = data.Enabled;
+    });
+
+    $scope.magic = false;
+    $http.get("api/1.0/magiclink").success(function(data) {
+        $scope.magic = data.Enabled;
+    });
+
+    $scope.link = {};
+    $scope.linkSent = false;
+    $scope.sendLink = function(link) {
+        $scope.alert.hidden = true;
+        $http({
+            method: "POST",
+            url: "api/1.0/magiclink",
+            data: link,
+            headers: {
+                "Accept": "application/json",
+            },
+        }).success(function() {
+            $scope.linkSent = true;
+        }).error(function(data, status, headers) {
+            $scope.alert.hidden = false;
+            if (status == 429) {
+                $scope.alert.message = "Too many sign-in links requested. Try again in " + headers("Retry-After") + " seconds.";
+            } else {
+                $scope.alert.message = "Something bad happened: " + angular.toJson(data);
+            }
+            console.log("Magic link error: ", status, data);
+        });
+    };
+
+    var error = $location.search().error;
+    if (error) {
+        $scope.alert.hidden = false;
+        if (error == "unauthorized") {
+            $scope.alert.message = "You aren't allowed to sign in here";
+        } else {
+            $scope.alert.message = "Single sign-on failed. Please try again.";
+        }
+        $location.search("error", null);
+    }
+
     if (session.getID() != "") {
         if ($scope.admin) {
             $location.path("/admin/list");
@@ -111,7 +290,53 @@ app.controller("loginController", ["$scope", "$http", "$location", "session", "a
     }
 }]);
 
-app.controller("formController", ["$scope", "$http", "$location", "$window", "session", "alert", function($scope, $http, $location, $window, session, alert) {
+app.controller("ssoController", ["$scope", "$http", "$location", "$routeParams", "session", "alert", "pendingLogin", function($scope, $http, $location, $routeParams, session, alert, pendingLogin) {
+    $scope.admin = ($location.path().indexOf("admin") > -1);
+
+    var provider = "oidc";
+    if ($location.path().indexOf("/saml/") > -1) {
+        provider = "saml";
+    } else if ($location.path().indexOf("/magic/") > -1) {
+        provider = "magiclink";
+    }
+
+    $scope.alert = alert;
+
+    $http({
+        method: "POST",
+        url: "api/1.0/" + provider + "/session",
+        data: {Token: $routeParams.token},
+        headers: {
+            "Accept": "application/json",
+        },
+    }).success(function(data) {
+        if (data.PendingID) {
+            // the login page asks for the TOTP code
+            pendingLogin.value = data;
+            $location.url("/admin/login");
+            return;
+        }
+        session.setID(data.SessionID);
+        if ($scope.admin) {
+            $location.url("/admin/list");
+        } else if (data.Completed) {
+            $location.url("/done");
+        } else {
+            $location.url("/form");
+        }
+    }).error(function(data, status) {
+        $scope.alert.hidden = false;
+        if (provider == "magiclink" && status == 401) {
+            $scope.alert.message = "This sign-in link has expired or was already used. Please request a new one.";
+        } else {
+            $scope.alert.message = "Single sign-on failed. Please try again.";
+        }
+        console.log("Single sign-on error: ", status, data);
+        $location.url($scope.admin ? "/admin/login" : "/login");
+    });
+}]);
+
+app.controller("formController", ["$scope", "$http", "$location", "$window", "session", "alert", "receipt", function($scope, $http, $location, $window, session, alert, receipt) {
 
     $scope.logout = function(expired) {
         if (expired) {
@@ -123,8 +348,67 @@ app.controller("formController", ["$scope", "$http", "$location", "$window", "se
     };
 
     $scope.open_handbook = function() {
-        $scope.data.clicked = true;
-        $window.open("images/handbook.pdf", "BISD Handbook", "toolbar=no");
+        // open window before request so it isn't blocked as a popup
+        var win = $window.open("", "BISD Handbook", "toolbar=no");
+
+        $http({
+            method: "GET",
+            url: "api/1.0/handbook",
+            responseType: "blob",
+            headers: {
+                "X-Session-Key": $scope.sessionID,
+            },
+        }).success(function(data, status) {
+            $scope.data.clicked = true;
+            win.location.href = $window.URL.createObjectURL(data);
+        }).error(function(data, status) {
+            win.close();
+            if (status == 401) {
+                $scope.logout(true);
+            } else {
+                $scope.alert.hidden = false;
+                $scope.alert.message = "Unable to open handbook. Please try again.";
+            }
+            console.log("Handbook error: ", status, data);
+        });
+    };
+
+    $scope.fetch_campuses = function() {
+        $http({
+            method: "GET",
+            url: "api/1.0/campuses",
+            headers: {
+                "Accept": "application/json",
+            },
+        }).success(function(data, status) {
+            $scope.campuses = data.Campuses || [];
+            $scope.fetch_me();
+        }).error(function(data, status) {
+            $scope.alert.hidden = false;
+            $scope.alert.message = "Unable to load campuses. Please refresh the page.";
+            console.log("Campuses error: ", status, data);
+        });
+    };
+
+    // pre-select the campus from the staff database
+    $scope.fetch_me = function() {
+        $http({
+            method: "GET",
+            url: "api/1.0/me",
+            headers: {
+                "Accept": "application/json",
+                "X-Session-Key": $scope.sessionID,
+            },
+        }).success(function(data, status) {
+            if (data.Campus && !$scope.data.Campus) {
+                $scope.data.Campus = data.Campus;
+            }
+        }).error(function(data, status) {
+            if (status == 401) {
+                $scope.logout(true);
+            }
+            console.log("Me error: ", status, data);
+        });
     };
 
     $scope.submit = function(data) {
@@ -133,7 +417,7 @@ app.controller("formController", ["$scope", "$http", "$location", "$window", "se
         $http({
             method: "POST",
             url: "api/1.0/submit",
-            data: {Campus: data.Campus, Agree: data.Agree},
+            data: {Campus: data.Campus, Agree: data.Agree, Passwd: data.Passwd, ReauthToken: $scope.reauthToken},
             headers: {
                 "Accept": "application/json",
                 "X-Session-Key": $scope.sessionID,
@@ -151,19 +435,71 @@ app.controller("formController", ["$scope", "$http", "$location", "$window", "se
                 console.log("Submit error: ", status, data);
                 return;
             }
+            receipt.value = data.Receipt || "";
             $location.path("/done");
 
-        }).error(function(data, status) {
+        }).error(function(data, status, headers) {
             if (status == 401) {
                 $scope.logout(true);
+            } else if (status == 403 && $scope.reauthToken) {
+                $scope.reauthToken = "";
+                $scope.alert.hidden = false;
+                $scope.alert.message = "Your confirmation expired. Please confirm again.";
+            } else if (status == 403) {
+                $scope.alert.hidden = false;
+                $scope.alert.message = "Incorrect password";
+            } else if (status == 429) {
+                $scope.alert.hidden = false;
+                $scope.alert.message = "Too many failed attempts. Try again in " + headers("Retry-After") + " seconds.";
             } else {
                 $scope.alert.hidden = false;
                 $scope.alert.message = "Something bad happened: " + angular.toJson(data);
             }
+            $scope.data.Passwd = "";
             console.log("Submit error: ", status, data);
         });
     };
 
+    $scope.fetch_stepup = function() {
+        $http.get("api/1.0/stepup").success(function(data) {
+            $scope.stepup = data;
+        });
+    };
+
+    // reauth signs in again with the given single sign-on backend, keeping the form's progress for when it returns
+    $scope.reauth = function(data, backend) {
+        $window.sessionStorage.setItem("form", angular.toJson({Campus: data.Campus, clicked: data.clicked}));
+        $window.location.href = "api/1.0/" + backend + "/login?reauth=true";
+    };
+
+    // reauth_email emails a link that returns to the form confirmed
+    $scope.reauth_email = function() {
+        $scope.alert.hidden = true;
+
+        $http({
+            method: "POST",
+            url: "api/1.0/stepup/email",
+            headers: {
+                "Accept": "application/json",
+                "X-Session-Key": $scope.sessionID,
+            },
+        }).success(function(data, status) {
+            $scope.emailSent = true;
+        }).error(function(data, status) {
+            if (status == 401) {
+                $scope.logout(true);
+                return;
+            }
+            $scope.alert.hidden = false;
+            if (status == 409) {
+                $scope.alert.message = "A link can't be emailed to you right now. Check your email for a recent link, or confirm another way.";
+            } else {
+                $scope.alert.message = "Something bad happened: " + angular.toJson(data);
+            }
+            console.log("Step-up email error: ", status, data);
+        });
+    };
+
     // setup data
     $scope.sessionID = session.getID();
 
@@ -174,13 +510,39 @@ app.controller("formController", ["$scope", "$http", "$location", "$window", "se
         clicked: false,
     };
 
+    $scope.campuses = [];
+
+    $scope.stepup = {Enabled: false};
+    $scope.reauthToken = "";
+    $scope.emailSent = false;
+
     // check for login
     if ($scope.sessionID == "") {
         $scope.logout();
+        return;
+    }
+
+    // returning from single sign-on or an emailed link re-authentication
+    var search = $location.search();
+    if (search.reauth || search.error) {
+        var saved = angular.fromJson($window.sessionStorage.getItem("form") || "{}");
+        $window.sessionStorage.removeItem("form");
+        $scope.data.Campus = saved.Campus;
+        $scope.data.clicked = saved.clicked || false;
+        if (search.reauth) {
+            $scope.reauthToken = search.reauth;
+        } else {
+            $scope.alert.hidden = false;
+            $scope.alert.message = "Single sign-on confirmation failed. Please try again.";
+        }
+        $location.search({});
     }
+
+    $scope.fetch_stepup();
+    $scope.fetch_campuses();
 }]);
 
-app.controller("listController", ["$scope", "$http", "$location", "session", "alert", function($scope, $http, $location, session, alert) {
+app.controller("listController", ["$scope", "$http", "$location", "session", "alert", "download", function($scope, $http, $location, session, alert, download) {
 
     $scope.logout = function(expired) {
         if (expired) {
@@ -214,12 +576,22 @@ app.controller("listController", ["$scope", "$http", "$location", "session", "al
                 console.log("Fetch error: ", status, data);
                 return;
             }
+            $scope.version = data.Version;
             $scope.ajaxList = data.List;
             angular.forEach($scope.ajaxList, function(val, key) {
                 $scope.ajaxList[key].Time = new Date(val.Time); 
             });
             $scope.displayList = [].concat($scope.ajaxList);
 
+            // campuses with signatures, for downloading certificates
+            var campuses = {};
+            angular.forEach($scope.ajaxList, function(val) {
+                if (val.SignTime) {
+                    campuses[val.Location] = true;
+                }
+            });
+            $scope.campuses = Object.keys(campuses).sort();
+
         }).error(function(data, status) {
             if (status == 401) {
                 $scope.logout(true);
@@ -232,6 +604,45 @@ app.controller("listController", ["$scope", "$http", "$location", "session", "al
         });
     };
 
+    $scope.download_receipt = function(item) {
+        download("api/1.0/admin/receipt?employee_id=" + encodeURIComponent(item.EmployeeID) + "&version=" + encodeURIComponent($scope.version),
+            $scope.sessionID, "receipt-" + item.EmployeeID + ".pdf").error(function(data, status) {
+            if (status == 401) {
+                $scope.logout(true);
+                return;
+            }
+            $scope.alert.hidden = false;
+            $scope.alert.message = "Unable to download receipt";
+            console.log("Receipt error: ", status, data);
+        });
+    };
+
+    $scope.download_certificate = function(item) {
+        download("api/1.0/admin/certificate?employee_id=" + encodeURIComponent(item.EmployeeID) + "&version=" + encodeURIComponent($scope.version),
+            $scope.sessionID, "certificate-" + item.EmployeeID + ".pdf").error(function(data, status) {
+            if (status == 401) {
+                $scope.logout(true);
+                return;
+            }
+            $scope.alert.hidden = false;
+            $scope.alert.message = "Unable to download certificate";
+            console.log("Certificate error: ", status, data);
+        });
+    };
+
+    $scope.download_certificates = function(campus) {
+        download("api/1.0/admin/certificates?campus=" + encodeURIComponent(campus) + "&version=" + encodeURIComponent($scope.version),
+            $scope.sessionID, "certificates-" + campus + ".zip").error(function(data, status) {
+            if (status == 401) {
+                $scope.logout(true);
+                return;
+            }
+            $scope.alert.hidden = false;
+            $scope.alert.message = "Unable to download certificates";
+            console.log("Certificates error: ", status, data);
+        });
+    };
+
     // setup data
     $scope.sessionID = session.getID();
 
@@ -241,10 +652,123 @@ app.controller("listController", ["$scope", "$http", "$location", "session", "al
     $scope.displayList = [];
     $scope.ajaxList = [];
 
+    $scope.campuses = [];
+
     $scope.filter = {
         search: "",
+        campus: "",
+    };
+
+    // check for login
+    if ($scope.sessionID == "") {
+        $scope.logout();
+        return;
+    }
+
+    $scope.fetch();
+}]);
+
+app.controller("totpController", ["$scope", "$http", "$location", "session", "alert", function($scope, $http, $location, session, alert) {
+
+    $scope.logout = function(expired) {
+        if (expired) {
+            $scope.alert.hidden = false;
+            $scope.alert.message = "Your session expired. Please log in again";
+        }
+        session.deleteID();
+        $location.path("/admin/login");
+    };
+
+    // post sends data to the given TOTP endpoint with the admin session
+    $scope.post = function(path, data) {
+        $scope.alert.hidden = true;
+        return $http({
+            method: "POST",
+            url: "api/1.0/admin/totp" + path,
+            data: data,
+            headers: {
+                "Accept": "application/json",
+                "X-Session-Key": $scope.sessionID,
+            },
+        }).error(function(data, status) {
+            if (status == 401) {
+                $scope.logout(true);
+                return;
+            }
+            $scope.alert.hidden = false;
+            if (status == 403) {
+                $scope.alert.message = "Invalid code";
+            } else {
+                $scope.alert.message = "Something bad happened: " + angular.toJson(data);
+            }
+            console.log("TOTP error: ", status, data);
+        });
+    };
+
+    $scope.fetch = function() {
+        $http({
+            method: "GET",
+            url: "api/1.0/admin/totp",
+            headers: {
+                "Accept": "application/json",
+                "X-Session-Key": $scope.sessionID,
+            },
+        }).success(function(data) {
+            $scope.status = data;
+        }).error(function(data, status) {
+            if (status == 401) {
+                $scope.logout(true);
+                return;
+            }
+            $scope.alert.hidden = false;
+            $scope.alert.message = "Something bad happened: " + angular.toJson(data);
+            console.log("TOTP error: ", status, data);
+        });
     };
 
+    $scope.enroll = function() {
+        $scope.recoveryCodes = null;
+        $scope.post("/enroll", {}).success(function(data) {
+            $scope.enrollment = data;
+        });
+    };
+
+    $scope.confirm = function(code) {
+        $scope.post("/confirm", code).success(function(data) {
+            $scope.enrollment = null;
+            $scope.recoveryCodes = data.RecoveryCodes;
+            $scope.fetch();
+        });
+        $scope.code = {};
+    };
+
+    $scope.regenerate = function(code) {
+        $scope.post("/recovery", code).success(function(data) {
+            $scope.recoveryCodes = data.RecoveryCodes;
+            $scope.fetch();
+        });
+        $scope.code = {};
+    };
+
+    $scope.disable = function(code) {
+        $scope.post("/disable", code).success(function() {
+            $scope.recoveryCodes = null;
+            $scope.fetch();
+        });
+        $scope.code = {};
+    };
+
+    // setup data
+    $scope.sessionID = session.getID();
+
+    $scope.alert = alert;
+    $scope.alert.hidden = true;
+
+    $scope.status = null;
+    $scope.enrollment = null;
+    $scope.recoveryCodes = null;
+    $scope.code = {};
+
     // check for login
     if ($scope.sessionID == "") {
         $scope.logout();
@@ -254,7 +778,18 @@ app.controller("listController", ["$scope", "$http", "$location", "session", "al
     $scope.fetch();
 }]);
 
-app.controller("doneController", ["$scope", "$location", "$window", "session", function($scope, $location, $window, session) {
+app.controller("doneController", ["$scope", "$location", "$window", "session", "receipt", "download", function($scope, $location, $window, session, receipt, download) {
+    $scope.receipt = receipt;
+
+    $scope.download_receipt = function() {
+        download("api/1.0/receipt", session.getID(), "handbook-receipt.pdf").error(function(data, status) {
+            console.log("Receipt error: ", status, data);
+            if (status == 401) {
+                $scope.logout();
+            }
+        });
+    };
+
     $scope.open_handbook = function() {
         $window.open("images/handbook.pdf", "BISD Handbook", "toolbar=no");
     };